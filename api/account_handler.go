@@ -0,0 +1,239 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/internal"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+)
+
+type AccountHandler struct {
+	players   *repository.Player
+	twoFactor *services.TwoFactor
+	guard     *services.LoginGuard
+	audits    *repository.Audit
+}
+
+func NewAccountHandler(players *repository.Player, twoFactor *services.TwoFactor, guard *services.LoginGuard,
+	audits *repository.Audit) *AccountHandler {
+	return &AccountHandler{
+		players:   players,
+		twoFactor: twoFactor,
+		guard:     guard,
+		audits:    audits,
+	}
+}
+
+// ChangePassword sets a new password and revokes all other sessions, the caller gets a fresh token
+func (accountHandler *AccountHandler) ChangePassword(context *gin.Context) {
+	var passwordChange model.PasswordChangeRequest
+	err := context.BindJSON(&passwordChange)
+	if err != nil {
+		logrus.Errorf("Unable to bind password change: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	player := accountHandler.authenticatedPlayer(context, passwordChange.CurrentPassword)
+	if player == nil {
+		return
+	}
+
+	err = internal.ValidatePlayerPassword(passwordChange.NewPassword)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	salt, err := internal.GenerateRandomSalt()
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to change password"})
+		return
+	}
+	hashed, err := internal.HashPassword(passwordChange.NewPassword, salt)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to change password"})
+		return
+	}
+
+	err = accountHandler.players.ChangePassword(player.ID, hashed, salt, services.RevocationTime())
+	if err != nil {
+		logrus.Errorf("Unable to change password for %s: %v", player.Username, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to change password"})
+		return
+	}
+
+	recordAudit(accountHandler.audits, context, player.Username, model.AuditPasswordChanged, player.Username, nil)
+	recordAudit(accountHandler.audits, context, player.Username, model.AuditTokenRevoked, player.Username,
+		gin.H{"reason": "password_changed"})
+
+	accountHandler.respondWithToken(context, player.Username)
+}
+
+// ChangeUsername renames the player, challenges and transactions follow the new name. Old tokens are revoked.
+func (accountHandler *AccountHandler) ChangeUsername(context *gin.Context) {
+	var usernameChange model.UsernameChangeRequest
+	err := context.BindJSON(&usernameChange)
+	if err != nil {
+		logrus.Errorf("Unable to bind username change: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	player := accountHandler.authenticatedPlayer(context, usernameChange.Password)
+	if player == nil {
+		return
+	}
+
+	// Admin rights and the house come with the name, so neither may be handed on or taken over by a rename
+	if internal.IsAdminUsername(player.Username) || player.Username == config.Settings.HouseUsername {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this username cannot be changed"})
+		return
+	}
+
+	err = internal.ValidatePlayerUsername(usernameChange.NewUsername)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = internal.ValidateUnprivilegedUsername(usernameChange.NewUsername)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	exists, err := accountHandler.players.Exists(usernameChange.NewUsername)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to change username"})
+		return
+	}
+	if exists {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "username is already taken"})
+		return
+	}
+
+	err = accountHandler.players.RenamePlayer(player.ID, usernameChange.NewUsername, services.RevocationTime())
+	if err != nil {
+		logrus.Errorf("Unable to rename %s: %v", player.Username, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to change username"})
+		return
+	}
+
+	recordAudit(accountHandler.audits, context, player.Username, model.AuditUsernameChanged, usernameChange.NewUsername,
+		gin.H{"previous_username": player.Username})
+	recordAudit(accountHandler.audits, context, usernameChange.NewUsername, model.AuditTokenRevoked,
+		usernameChange.NewUsername, gin.H{"reason": "username_changed"})
+
+	accountHandler.respondWithToken(context, usernameChange.NewUsername)
+}
+
+// Close pays out the balance, declines pending challenges and anonymises the account, financial records are kept
+func (accountHandler *AccountHandler) Close(context *gin.Context) {
+	var closeRequest model.AccountCloseRequest
+	err := context.BindJSON(&closeRequest)
+	if err != nil {
+		logrus.Errorf("Unable to bind account closure: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	player := accountHandler.authenticatedPlayer(context, closeRequest.Password)
+	if player == nil {
+		return
+	}
+
+	// Closing pays out the whole balance, so it needs the same second factor as a withdrawal
+	if player.TOTPEnabled && !verifyTwoFactorCode(context, accountHandler.twoFactor, accountHandler.guard,
+		accountHandler.audits, player, closeRequest.TOTPCode) {
+		return
+	}
+
+	closure, err := accountHandler.players.ClosePlayerAccount(player.ID)
+	if errors.Is(err, repository.ErrAccountInPlay) || errors.Is(err, repository.ErrAccountQueued) {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Unable to close account of %s: %v", player.Username, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to close account"})
+		return
+	}
+
+	for _, challengeID := range closure.DeclinedChallenges {
+		recordAudit(accountHandler.audits, context, player.Username, model.AuditChallengeDeclined, challengeID,
+			gin.H{"reason": "account_closed"})
+	}
+	if closure.Payout > 0 {
+		recordAudit(accountHandler.audits, context, player.Username, model.AuditFundsWithdrawn, player.Username,
+			gin.H{"amount": closure.Payout, "reason": "account_closed"})
+	}
+	recordAudit(accountHandler.audits, context, player.Username, model.AuditAccountClosed, closure.AnonymisedUsername, nil)
+	recordAudit(accountHandler.audits, context, player.Username, model.AuditTokenRevoked, closure.AnonymisedUsername,
+		gin.H{"reason": "account_closed"})
+
+	context.JSON(http.StatusOK, closure)
+}
+
+// authenticatedPlayer loads the logged-in player and checks the password again before sensitive changes.
+// Wrong passwords count as failed logins, so a stolen session cannot be used to guess the password.
+func (accountHandler *AccountHandler) authenticatedPlayer(context *gin.Context, password string) *model.Player {
+	userName := services.GetSubjectFromContext(context)
+	player, err := accountHandler.players.FindPlayerWithDetails(userName)
+	if err != nil || player == nil {
+		logrus.Errorf("Unable to find player %s", userName)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to find player"})
+		return nil
+	}
+
+	ip := context.ClientIP()
+	if !checkRetryAfter(context, accountHandler.guard, player.Username, ip) {
+		return nil
+	}
+
+	matching, err := internal.IsPasswordMatching(player.Username, password, player.Salt, player.Password)
+	if err != nil || !matching {
+		if err == nil {
+			recordLoginFailure(context, accountHandler.guard, accountHandler.audits, player.Username, ip)
+		}
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "password mismatch"})
+		return nil
+	}
+
+	return player
+}
+
+func (accountHandler *AccountHandler) respondWithToken(context *gin.Context, username string) {
+	token, err := services.GenerateJWT(username)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to create token"})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{
+		"token": token,
+	})
+}
+
+// ChangeChallengeSettings sets whether only friends can challenge the player
+func (accountHandler *AccountHandler) ChangeChallengeSettings(context *gin.Context) {
+	var settings model.ChallengeSettingsRequest
+	err := context.BindJSON(&settings)
+	if err != nil {
+		logrus.Errorf("Unable to bind challenge settings: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = accountHandler.players.SetFriendsOnlyChallenges(services.GetPlayerIDFromContext(context), *settings.FriendsOnly)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to change challenge settings"})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"friends_only": *settings.FriendsOnly})
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+)
+
+type AuditHandler struct {
+	audits *repository.Audit
+}
+
+func NewAuditHandler(audits *repository.Audit) *AuditHandler {
+	return &AuditHandler{
+		audits: audits,
+	}
+}
+
+// Query returns audit events filtered by actor, action, subject, ip and time range
+func (auditHandler *AuditHandler) Query(context *gin.Context) {
+	var filter model.AuditEventFilter
+	err := context.ShouldBindQuery(&filter)
+	if err != nil {
+		logrus.Errorf("Unable to bind audit filter: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	admin := context.GetString(services.SubjectKey)
+	recordAudit(auditHandler.audits, context, admin, model.AuditAdminAction, "", gin.H{
+		"operation": "audit_query",
+		"filter":    filter,
+	})
+
+	events, err := auditHandler.audits.Query(filter)
+	if err != nil {
+		logrus.Error("Unable to query audit events")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+
+	context.JSON(http.StatusOK, events)
+}
+
+// Verify walks the audit hash chain and reports the first broken entry, if any
+func (auditHandler *AuditHandler) Verify(context *gin.Context) {
+	result, err := auditHandler.audits.Verify()
+	if err != nil {
+		logrus.Error("Unable to verify audit log")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+
+	context.JSON(http.StatusOK, result)
+}
+
+// recordAudit appends an event to the audit log. Failing to audit must not fail the request
+// that is being audited, so errors are only logged.
+func recordAudit(audits *repository.Audit, context *gin.Context, actor, action, subject string, details gin.H) {
+	encodedDetails, err := json.Marshal(details)
+	if err != nil {
+		logrus.Errorf("Unable to encode audit details for %s: %v", action, err)
+		encodedDetails = []byte("{}")
+	}
+
+	err = audits.Append(&model.AuditEvent{
+		Actor:   actor,
+		Action:  action,
+		Subject: subject,
+		IP:      context.ClientIP(),
+		Details: string(encodedDetails),
+	})
+	if err != nil {
+		logrus.Errorf("Unable to record audit event %s for %s: %v", action, actor, err)
+	}
+}
@@ -1,29 +1,52 @@
 package api
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"io"
 	"main/config"
 	"main/model"
 	"main/repository"
 	"main/services"
 	"net/http"
+	"strconv"
 )
 
 type ChallengeHandler struct {
 	challenges   *repository.Challenger
 	players      *repository.Player
 	transactions *repository.Transaction
+	wallets      *repository.Wallet
+	audits       *repository.Audit
+	friends      *repository.Friend
+	limits       *services.ResponsibleGaming
+	fairness     *services.Fairness
+	// houseID is the account the rake is paid to
+	houseID int
 }
 
 func NewChallengeHandler(challengeRepository *repository.Challenger,
 	playerRepository *repository.Player,
-	transactions *repository.Transaction) *ChallengeHandler {
+	transactions *repository.Transaction,
+	wallets *repository.Wallet,
+	audits *repository.Audit,
+	friends *repository.Friend,
+	limits *services.ResponsibleGaming,
+	fairness *services.Fairness,
+	houseID int) *ChallengeHandler {
 	return &ChallengeHandler{
 		challenges:   challengeRepository,
 		players:      playerRepository,
 		transactions: transactions,
+		wallets:      wallets,
+		audits:       audits,
+		friends:      friends,
+		limits:       limits,
+		fairness:     fairness,
+		houseID:      houseID,
 	}
 }
 
@@ -50,54 +73,190 @@ func (challengeHandler *ChallengeHandler) Create(context *gin.Context) {
 		return
 	}
 
-	exists, err := challengeHandler.players.Exists(challengeRequest.Opponent)
-	if !exists || err != nil {
+	if challengeRequest.RuleSet == "" {
+		challengeRequest.RuleSet = model.RuleSetClassic
+	}
+	if !model.IsRuleSet(challengeRequest.RuleSet) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown rule set"})
+		return
+	}
+
+	opponentID, err := challengeHandler.players.FindPlayerID(challengeRequest.Opponent)
+	if opponentID == 0 || err != nil {
 		context.AbortWithStatusJSON(http.StatusNotFound, "Opponent does not exist")
 		return
 	}
 
-	// Check if enough balance is available
-	balance, err := challengeHandler.players.GetPlayerBalance(challenger)
+	challengeHandler.placeChallenge(context, opponentID, challengeRequest)
+}
+
+// placeChallenge checks that the caller may challenge the opponent and creates the challenge holding the bet
+func (challengeHandler *ChallengeHandler) placeChallenge(context *gin.Context, opponentID int, challengeRequest model.ChallengeRequest) {
+	challenger := services.GetSubjectFromContext(context)
+	challengerID := services.GetPlayerIDFromContext(context)
+
+	stake := challengeRequest.Bet
+	if !isCurrency(stake.Currency) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown currency"})
+		return
+	}
+
+	// The house only plays through /house/games, where it moves right away
+	if challengeRequest.Opponent == config.Settings.HouseUsername {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "The house cannot be challenged, play it through /house/games")
+		return
+	}
+
+	if !challengeHandler.mayChallenge(context, challengerID, opponentID) {
+		return
+	}
+
+	if !mayBet(context, challengeHandler.limits, challengerID, limitedStake(stake)) {
+		return
+	}
+
+	// Don't let a single player flood another one with challenges
+	if config.Settings.MaxPendingChallengesPerOpponent > 0 {
+		pending, err := challengeHandler.challenges.CountPendingChallengesBetween(challengerID, opponentID)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if pending >= config.Settings.MaxPendingChallengesPerOpponent {
+			logrus.Warningf("%s has too many pending challenges against %s", challenger, challengeRequest.Opponent)
+			context.AbortWithStatusJSON(http.StatusTooManyRequests, "Too many pending challenges against this opponent")
+			return
+		}
+	}
+
+	// Check if enough balance is available in the currency of the bet, bets of other open challenges are held
+	balance, err := challengeHandler.wallets.GetAvailableBalance(challengerID, stake.Currency)
 	if err != nil {
 		logrus.Error("Unable to get player balance err")
 		context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	if challengeRequest.Bet < config.Settings.MinimumBet {
+	if stake.Amount < config.Settings.MinimumBet {
 		logrus.Error("Bet too low")
 		context.AbortWithStatusJSON(http.StatusBadRequest, "Bet amount is too low")
 		return
 	}
 
-	if balance < challengeRequest.Bet {
+	if balance.Amount < stake.Amount {
 		logrus.Error("Attempting to bet with too low balance")
 		context.AbortWithStatusJSON(http.StatusBadRequest, "Not enough balance to place bet")
 		return
 	}
 
-	err = challengeHandler.players.SubtractPlayerBalance(challenger, challengeRequest.Bet)
-	if err != nil {
-		logrus.Error("Failed to subtract balance")
-		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to take funds")
+	// The bet is held until the challenge is settled, declined or expires
+	challengeId, err := challengeHandler.challenges.PlaceChallenge(challengerID, opponentID, challengeRequest)
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Not enough balance to place bet")
 		return
 	}
-
-	_ = challengeHandler.transactions.AddTransaction(-challengeRequest.Bet, model.ReasonBet, challenger)
-
-	challengeId, err := challengeHandler.challenges.CreateChallenge(
-		challenger, challengeRequest.Opponent, challengeRequest.Choice, challengeRequest.Bet)
 	if err != nil {
-		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		logrus.Errorf("Unable to place challenge: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to hold funds")
 		return
 	}
 
+	recordAudit(challengeHandler.audits, context, challenger, model.AuditChallengeCreated, strconv.Itoa(challengeId),
+		gin.H{"opponent": challengeRequest.Opponent, "bet": stake, "rule_set": challengeRequest.RuleSet})
+
 	context.JSON(http.StatusCreated, gin.H{
 		"ChallengeId": challengeId,
 	})
 
 }
 
+// Rematch challenges the other player of a settled challenge again with the same rule set and bet,
+// optionally doubled. Without a choice the move is picked by a strategy, by default the caller's last move.
+func (challengeHandler *ChallengeHandler) Rematch(context *gin.Context) {
+	var rematchRequest model.RematchRequest
+	// The body is optional
+	err := context.ShouldBindJSON(&rematchRequest)
+	if err != nil && !errors.Is(err, io.EOF) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	challenge, ok := challengeHandler.findChallenge(context, context.Param("id"))
+	if !ok {
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	if challenge.ChallengerID != playerID && challenge.OpponentID != playerID {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed to rematch challenge"})
+		return
+	}
+	if challenge.State != model.ChallengeSettled || challenge.TournamentID != 0 {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "only settled challenges can be rematched"})
+		return
+	}
+
+	opponentID, opponent := challenge.OpponentID, challenge.Opponent
+	if challenge.OpponentID == playerID {
+		opponentID, opponent = challenge.ChallengerID, challenge.Challenger
+	}
+
+	request := model.ChallengeRequest{
+		Opponent: opponent,
+		Choice:   rematchRequest.Choice,
+		Bet:      challenge.Bet,
+		RuleSet:  challenge.RuleSet,
+	}
+	if rematchRequest.DoubleBet {
+		request.Bet.Amount *= 2
+	}
+
+	if request.Choice == 0 {
+		strategy := rematchRequest.Strategy
+		if strategy == "" {
+			strategy = model.StrategyRepeatLast
+		}
+		if !model.IsMoveStrategy(strategy) || strategy == model.StrategyFixed {
+			context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "strategy must be random, repeat_last or beat_last"})
+			return
+		}
+		if !challengeHandler.pickMove(context, &request, strategy, 0, playerID, opponentID) {
+			return
+		}
+	} else if isValidChoice(request.Choice) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Invalid choice")
+		return
+	}
+
+	challengeHandler.placeChallenge(context, opponentID, request)
+}
+
+// pickMove sets the choice of the request to the move the strategy plays against the latest game between the two
+// players. A move left to chance is drawn from the player's seed pair, the request keeps the pair and the nonce.
+func (challengeHandler *ChallengeHandler) pickMove(context *gin.Context, request *model.ChallengeRequest, strategy string,
+	fixed int, playerID int, opponentID int) bool {
+	own, theirs, err := challengeHandler.challenges.GetLastMoves(playerID, opponentID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to pick a move")
+		return false
+	}
+
+	request.Choice = model.PickMove(strategy, fixed, own, theirs)
+	if request.Choice != 0 {
+		return true
+	}
+
+	// A nonce drawn for a challenge that is refused later is skipped, the seed pair never reuses it
+	seed, choice, err := challengeHandler.fairness.NextMove(playerID)
+	if err != nil {
+		logrus.Errorf("Unable to draw a move: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to pick a move")
+		return false
+	}
+	request.Choice, request.SeedID, request.Nonce = choice, seed.ID, seed.Nonce
+	return true
+}
+
 func (challengeHandler *ChallengeHandler) Settle(context *gin.Context) {
 	var challengeSettleRequest model.ChallengeSettleRequest
 	err := context.BindJSON(&challengeSettleRequest)
@@ -107,17 +266,18 @@ func (challengeHandler *ChallengeHandler) Settle(context *gin.Context) {
 		return
 	}
 	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
 
 	// Find challenge
 	challenge, err := challengeHandler.challenges.GetChallengeByID(challengeSettleRequest.ChallengeId)
 	if err != nil {
-		logrus.Error("Unable to get challenge err: %s", err.Error())
+		logrus.Errorf("Unable to get challenge err: %s", err.Error())
 		context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Check if challenge belongs to the player trying to resolve it
-	if challenge.Opponent != userName {
+	if challenge.OpponentID != playerID {
 		logrus.Error("Attempting to resolve challenge that belongs to another player, aborting")
 		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed to settle challenge"})
 		return
@@ -129,6 +289,12 @@ func (challengeHandler *ChallengeHandler) Settle(context *gin.Context) {
 		return
 	}
 
+	if challenge.TournamentID != 0 {
+		logrus.Error("Attempting to settle a tournament challenge directly")
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "tournament challenges are played through the tournament"})
+		return
+	}
+
 	// Validate choice
 	if isValidChoice(challengeSettleRequest.Choice) {
 		logrus.Error("Invalid choice")
@@ -136,74 +302,81 @@ func (challengeHandler *ChallengeHandler) Settle(context *gin.Context) {
 		return
 	}
 
-	// Get balance
-	currentBalance, err := challengeHandler.players.GetPlayerBalance(userName)
-	if err != nil {
-		logrus.Error("Unable to get player balance")
-		context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	// Make sure there's enough balance
-	if currentBalance < challenge.Bet {
-		logrus.Errorf("Unable to accept challenge, not enough funds")
-		context.AbortWithStatusJSON(http.StatusBadRequest, "Not enough funds")
-		return
-	}
-
-	// Subtract funds before proceeding
-	err = challengeHandler.players.SubtractPlayerBalance(userName, challenge.Bet)
-	if err != nil {
-		logrus.Errorf("Unable to update player balance")
-		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to settle challenge, try again"})
+	// Accepting stakes the same bet, so the opponent's limits apply
+	if !mayBet(context, challengeHandler.limits, playerID, limitedStake(challenge.Bet)) {
 		return
 	}
 
-	_ = challengeHandler.transactions.AddTransaction(-challenge.Bet, model.ReasonBet, userName)
+	challengeID, _ := strconv.Atoi(challenge.ChallengeId)
 
 	// Opponent's choice
 	challengerChoice := challenge.Choice
 	// Current player's choice
 	opponentChoice := challengeSettleRequest.Choice
 
-	winner := determineWinner(challengerChoice, opponentChoice)
+	winner := model.DetermineWinner(challengerChoice, opponentChoice)
 
-	// Restore the subtracted money to the challenger
+	// On a draw both players get their bet back, otherwise the winner takes both bets less the rake
+	settlement := model.ChallengeSettlement{
+		ChallengerID:   challenge.ChallengerID,
+		OpponentID:     playerID,
+		Choice:         challengerChoice,
+		OpponentChoice: opponentChoice,
+		RuleSet:        challenge.RuleSet,
+		Bet:            challenge.Bet,
+	}
 	challengeWinner := ""
-	message := ""
-	if winner == "draw" {
-		err = challengeHandler.players.AddPlayerBalance(challenge.Challenger, challenge.Bet)
-		_ = challengeHandler.transactions.AddTransaction(challenge.Bet, model.ReasonRefund, challenge.Challenger)
-		message = fmt.Sprintf("Draw both players picked :%s ", model.ChoiceToString(opponentChoice))
-	} else if winner == "opponent" {
-		err = challengeHandler.players.AddPlayerBalance(userName, challenge.Bet)
+	switch winner {
+	case "opponent":
+		settlement.WinnerID = playerID
 		challengeWinner = userName
-		_ = challengeHandler.transactions.AddTransaction(challenge.Bet, model.ReasonWin, challengeWinner)
-		message = fmt.Sprintf("Winner :%s with %s against %s", challengeWinner, model.ChoiceToString(challengerChoice), model.ChoiceToString(opponentChoice))
-	} else if winner == "challenger" {
-		// Gets his initial deposit and his opponent's money
-		err = challengeHandler.players.AddPlayerBalance(challenge.Challenger, challenge.Bet*2)
+	case "challenger":
+		settlement.WinnerID = challenge.ChallengerID
 		challengeWinner = challenge.Challenger
-		_ = challengeHandler.transactions.AddTransaction(challenge.Bet*2, model.ReasonWin, challengeWinner)
+	}
+	message := fmt.Sprintf("Draw both players picked :%s ", model.ChoiceToString(opponentChoice))
+	if settlement.WinnerID != 0 {
+		settlement.Rake = challengeRake(challenge)
 		message = fmt.Sprintf("Winner :%s with %s against %s", challengeWinner, model.ChoiceToString(challengerChoice), model.ChoiceToString(opponentChoice))
 	}
 
+	// Both bets, the payout and the new state are booked together, the opponent's balance is checked on the way
+	err = challengeHandler.challenges.SettleChallenge(challengeID, settlement, challengeHandler.houseID)
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		logrus.Errorf("Unable to accept challenge, not enough funds")
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Not enough funds")
+		return
+	}
+	if errors.Is(err, repository.ErrChallengeChanged) {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		logrus.Errorf("Unable to update player balance")
+		logrus.Errorf("Unable to settle challenge %d: %v", challengeID, err)
 		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to settle challenge, try again"})
 		return
 	}
 
-	err = challengeHandler.challenges.UpdateChallenge(model.ChallengeSettled, challengeWinner, challenge.ChallengeId)
+	challengerScore := 0.5
+	switch winner {
+	case "challenger":
+		challengerScore = 1
+	case "opponent":
+		challengerScore = 0
+	}
+	err = challengeHandler.players.UpdateRatings(challenge.ChallengerID, playerID, challengerScore)
 	if err != nil {
-		logrus.Errorf("Unable to update challenge: %s", challenge.ChallengeId)
-		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to update challenge")
-		return
+		logrus.Errorf("Unable to update ratings for challenge %s: %v", challenge.ChallengeId, err)
 	}
 
+	recordAudit(challengeHandler.audits, context, userName, model.AuditChallengeSettled, challenge.ChallengeId,
+		gin.H{"challenger": challenge.Challenger, "bet": challenge.Bet, "winner": challengeWinner,
+			"rake": settlement.Rake})
+
 	context.JSON(http.StatusOK, model.ChallengeResponse{
 		Winner:    winner,
-		WinAmount: challenge.Bet,
+		WinAmount: model.Money{Currency: challenge.Bet.Currency, Amount: challenge.Bet.Amount - settlement.Rake},
+		Rake:      model.Money{Currency: challenge.Bet.Currency, Amount: settlement.Rake},
 		Message:   message,
 	})
 }
@@ -217,52 +390,235 @@ func (challengeHandler *ChallengeHandler) Decline(context *gin.Context) {
 		return
 	}
 	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
 
 	// Find challenge
 	challenge, err := challengeHandler.challenges.GetChallengeByID(challengeDeclineRequest.ChallengeId)
 	if err != nil {
-		logrus.Error("Unable to get challenge err: %s", err.Error())
+		logrus.Errorf("Unable to get challenge err: %s", err.Error())
 		context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	// Check if challenge was initiated by one of the two players
-	if challenge.Opponent != userName || challenge.Challenger != userName {
+	if challenge.OpponentID != playerID && challenge.ChallengerID != playerID {
 		logrus.Error("Challenger does not belong to player")
 		context.AbortWithStatusJSON(http.StatusForbidden, "Challenger does not belong to player")
 		return
 	}
 
-	// Can only decline pending challenges
-	if challenge.State != model.ChallengePending {
+	// Can only decline open challenges, a countered challenge can be declined instead of replying
+	if challenge.State != model.ChallengePending && challenge.State != model.ChallengeCountered {
 		logrus.Error("Challenger is already settled")
 		context.AbortWithStatusJSON(http.StatusForbidden, "Challenger is already settled")
 		return
 	}
 
-	err = challengeHandler.challenges.UpdateChallenge(model.ChallengeDeclined, "", challenge.ChallengeId)
+	// Tournament matches are decided by a move or by their deadline
+	if challenge.TournamentID != 0 {
+		logrus.Error("Attempting to decline a tournament challenge")
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Tournament challenges cannot be declined")
+		return
+	}
+
+	// The challenger's held bet is released, nothing was taken so nothing is refunded
+	challengeID, _ := strconv.Atoi(challenge.ChallengeId)
+	err = challengeHandler.challenges.DeclineChallenge(challengeID)
+	if errors.Is(err, repository.ErrChallengeChanged) {
+		context.AbortWithStatusJSON(http.StatusConflict, "Challenge is already settled")
+		return
+	}
 	if err != nil {
-		logrus.Error("Failed to decline challenge, refund challenger manually")
+		logrus.Errorf("Failed to decline challenge %d: %v", challengeID, err)
 		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to decline challenge, try again")
 		return
 	}
 
-	// Try to return funds to the original challenger
-	err = challengeHandler.players.AddPlayerBalance(challenge.Challenger, challenge.Bet)
+	recordAudit(challengeHandler.audits, context, userName, model.AuditChallengeDeclined, challenge.ChallengeId,
+		gin.H{"challenger": challenge.Challenger, "opponent": challenge.Opponent, "released": challenge.Bet})
+
+	context.JSON(http.StatusOK, "Successfully declined challenge")
+
+}
+
+// Counter proposes a different bet to the challenger instead of settling or declining
+func (challengeHandler *ChallengeHandler) Counter(context *gin.Context) {
+	var counterRequest model.ChallengeCounterRequest
+	err := context.BindJSON(&counterRequest)
 	if err != nil {
-		logrus.Error("Failed to refund challenger, please update manually")
-		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to refund challenger")
+		logrus.Error("Unable to bind counter-offer request body")
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
 		return
 	}
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
 
-	context.JSON(http.StatusOK, "Successfully declined challenge")
+	challenge, ok := challengeHandler.findChallenge(context, counterRequest.ChallengeId)
+	if !ok {
+		return
+	}
 
+	if challenge.OpponentID != playerID {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only the opponent can counter a challenge"})
+		return
+	}
+	if challenge.TournamentID != 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "tournament challenges have no bet"})
+		return
+	}
+	if challenge.State != model.ChallengePending {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "only pending challenges can be countered"})
+		return
+	}
+	if counterRequest.Bet.Currency != challenge.Bet.Currency {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "the bet must be in " + challenge.Bet.Currency})
+		return
+	}
+	if counterRequest.Bet.Amount < config.Settings.MinimumBet {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Bet amount is too low")
+		return
+	}
+	if counterRequest.Bet == challenge.Bet {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "settle the challenge to play at its bet"})
+		return
+	}
+
+	// The opponent has to be allowed to place and able to cover the bet they propose
+	if !mayBet(context, challengeHandler.limits, playerID, limitedStake(counterRequest.Bet)) {
+		return
+	}
+	balance, err := challengeHandler.wallets.GetAvailableBalance(playerID, counterRequest.Bet.Currency)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if balance.Amount < counterRequest.Bet.Amount {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Not enough funds")
+		return
+	}
+
+	challengeID, _ := strconv.Atoi(challenge.ChallengeId)
+	err = challengeHandler.challenges.CounterChallenge(challengeID, playerID, counterRequest.Bet.Amount,
+		config.Settings.MaxCounterOffers)
+	if err != nil {
+		abortWithNegotiationError(context, err, "Failed to counter challenge, try again")
+		return
+	}
+
+	recordAudit(challengeHandler.audits, context, userName, model.AuditChallengeCountered, challenge.ChallengeId,
+		gin.H{"challenger": challenge.Challenger, "bet": challenge.Bet, "counter_bet": counterRequest.Bet})
+
+	context.JSON(http.StatusOK, "Successfully countered challenge")
+}
+
+// AcceptCounter plays the challenge at the opponent's bet, the challenger's held bet is raised or lowered to it
+func (challengeHandler *ChallengeHandler) AcceptCounter(context *gin.Context) {
+	challenge, ok := challengeHandler.bindCounterReply(context)
+	if !ok {
+		return
+	}
+	userName := services.GetSubjectFromContext(context)
+
+	// The held bet already counts against the challenger's limits, only a raise adds to it, but a self-excluded
+	// challenger cannot take up any counter-offer
+	raise := model.Money{Currency: challenge.Bet.Currency}
+	if challenge.CounterBet != nil && challenge.CounterBet.Amount > challenge.Bet.Amount {
+		raise.Amount = challenge.CounterBet.Amount - challenge.Bet.Amount
+	}
+	if !mayBet(context, challengeHandler.limits, challenge.ChallengerID, limitedStake(raise)) {
+		return
+	}
+
+	challengeID, _ := strconv.Atoi(challenge.ChallengeId)
+	bet, err := challengeHandler.challenges.AcceptCounter(challengeID, challenge.ChallengerID)
+	if err != nil {
+		abortWithNegotiationError(context, err, "Failed to accept counter-offer, try again")
+		return
+	}
+
+	recordAudit(challengeHandler.audits, context, userName, model.AuditCounterAccepted, challenge.ChallengeId,
+		gin.H{"opponent": challenge.Opponent, "previous_bet": challenge.Bet, "bet": bet})
+
+	context.JSON(http.StatusOK, "Successfully accepted counter-offer")
+}
+
+// RejectCounter keeps the challenge at its bet, the opponent can settle, decline or counter again
+func (challengeHandler *ChallengeHandler) RejectCounter(context *gin.Context) {
+	challenge, ok := challengeHandler.bindCounterReply(context)
+	if !ok {
+		return
+	}
+	userName := services.GetSubjectFromContext(context)
+
+	challengeID, _ := strconv.Atoi(challenge.ChallengeId)
+	err := challengeHandler.challenges.RejectCounter(challengeID, challenge.ChallengerID)
+	if err != nil {
+		abortWithNegotiationError(context, err, "Failed to reject counter-offer, try again")
+		return
+	}
+
+	recordAudit(challengeHandler.audits, context, userName, model.AuditCounterRejected, challenge.ChallengeId,
+		gin.H{"opponent": challenge.Opponent, "bet": challenge.Bet, "counter_bet": challenge.CounterBet})
+
+	context.JSON(http.StatusOK, "Successfully rejected counter-offer")
+}
+
+// bindCounterReply reads a reply to a counter-offer and makes sure it comes from the challenger of a countered challenge
+func (challengeHandler *ChallengeHandler) bindCounterReply(context *gin.Context) (*model.Challenge, bool) {
+	var replyRequest model.ChallengeCounterReplyRequest
+	err := context.BindJSON(&replyRequest)
+	if err != nil {
+		logrus.Error("Unable to bind counter-offer reply body")
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	challenge, ok := challengeHandler.findChallenge(context, replyRequest.ChallengeId)
+	if !ok {
+		return nil, false
+	}
+	if challenge.ChallengerID != services.GetPlayerIDFromContext(context) {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "only the challenger can reply to a counter-offer"})
+		return nil, false
+	}
+	if challenge.State != model.ChallengeCountered {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "challenge has no open counter-offer"})
+		return nil, false
+	}
+	return challenge, true
+}
+
+func (challengeHandler *ChallengeHandler) findChallenge(context *gin.Context, challengeID string) (*model.Challenge, bool) {
+	challenge, err := challengeHandler.challenges.GetChallengeByID(challengeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+		return nil, false
+	}
+	if err != nil {
+		logrus.Errorf("Unable to get challenge err: %s", err.Error())
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve challenge")
+		return nil, false
+	}
+	return challenge, true
+}
+
+// abortWithNegotiationError answers the known negotiation errors with their message and anything else with fallback
+func abortWithNegotiationError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Not enough funds")
+	case errors.Is(err, repository.ErrChallengeChanged), errors.Is(err, repository.ErrTooManyCounters):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
 }
 
 // GetPendingChallenges Retrieves the pending challenges for a user
 func (challengeHandler *ChallengeHandler) GetPendingChallenges(context *gin.Context) {
-	userName := services.GetSubjectFromContext(context)
-	pendingChallenges, err := challengeHandler.challenges.GetPendingChallenges(userName)
+	playerID := services.GetPlayerIDFromContext(context)
+	pendingChallenges, err := challengeHandler.challenges.GetPendingChallenges(playerID)
 	if err != nil {
 		logrus.Error("Failed to retrieve pending challenges")
 		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve pending challenges")
@@ -273,34 +629,175 @@ func (challengeHandler *ChallengeHandler) GetPendingChallenges(context *gin.Cont
 
 }
 
-// Only the opponent resolves the challenge
-func determineWinner(challengerChoice int, opponentChoice int) string {
-	if challengerChoice == opponentChoice {
-		return "draw"
+// GetChallenges returns a page of the challenges the player sent or received, newest first.
+// Filters: state (repeatable), role (sent or received), opponent, from, to. Pass next_cursor as cursor for the next page.
+func (challengeHandler *ChallengeHandler) GetChallenges(context *gin.Context) {
+	var filter model.ChallengeFilter
+	err := context.ShouldBindQuery(&filter)
+	if err != nil {
+		logrus.Errorf("Unable to bind challenge filter: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
 	}
 
-	switch challengerChoice {
-	case model.ChoiceRock:
-		if opponentChoice == model.ChoiceScissors {
-			return "challenger"
-		} else {
-			return "opponent"
-		}
-	case model.ChoicePaper:
-		if opponentChoice == model.ChoiceRock {
-			return "challenger"
-		} else {
-			return "opponent"
-		}
-	case model.ChoiceScissors:
-		if opponentChoice == model.ChoicePaper {
-			return "challenger"
-		} else {
-			return "opponent"
+	err = validateChallengeFilter(filter)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	page, err := challengeHandler.challenges.GetChallengesPage(playerID, filter)
+	if err != nil {
+		logrus.Error("Failed to retrieve challenges")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve challenges")
+		return
+	}
+
+	context.JSON(http.StatusOK, page)
+}
+
+// GetChallenge returns a single challenge with its moves and transactions, only to its two participants.
+// The opponent's move and the challenger's move to the opponent are only shown once the challenge is settled.
+func (challengeHandler *ChallengeHandler) GetChallenge(context *gin.Context) {
+	challengeID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid challenge id"})
+		return
+	}
+
+	challenge, err := challengeHandler.challenges.GetChallengeByID(strconv.Itoa(challengeID))
+	if errors.Is(err, sql.ErrNoRows) {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "challenge not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Unable to get challenge err: %s", err.Error())
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve challenge")
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	if challenge.ChallengerID != playerID && challenge.OpponentID != playerID {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed to view challenge"})
+		return
+	}
+
+	transactions, err := challengeHandler.transactions.GetChallengeTransactions(challengeID)
+	if err != nil {
+		logrus.Error("Failed to retrieve challenge transactions")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve challenge")
+		return
+	}
+
+	negotiation, err := challengeHandler.challenges.GetNegotiation(challengeID)
+	if err != nil {
+		logrus.Error("Failed to retrieve challenge negotiation")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve challenge")
+		return
+	}
+
+	detail := model.ChallengeDetail{
+		ChallengeSummary: model.ChallengeSummary{
+			ChallengeId:  challenge.ChallengeId,
+			ChallengerID: challenge.ChallengerID,
+			OpponentID:   challenge.OpponentID,
+			Role:         model.ChallengeRoleReceived,
+			Challenger:   challenge.Challenger,
+			Opponent:     challenge.Opponent,
+			Bet:          challenge.Bet,
+			RuleSet:      challenge.RuleSet,
+			State:        challenge.State,
+			TimeCreated:  challenge.TimeCreated,
+			Winner:       challenge.Winner,
+			CounterBet:   challenge.CounterBet,
+		},
+		Transactions: transactions,
+		Negotiation:  negotiation,
+	}
+	if challenge.ChallengerID == playerID {
+		detail.Role = model.ChallengeRoleSent
+	}
+	if !challenge.TimeSettled.IsZero() {
+		detail.TimeSettled = &challenge.TimeSettled
+	}
+	if challenge.State == model.ChallengeSettled || challenge.ChallengerID == playerID {
+		detail.ChallengerChoice = model.ChoiceToString(challenge.Choice)
+	}
+	if challenge.State == model.ChallengeSettled {
+		detail.OpponentChoice = model.ChoiceToString(challenge.OpponentChoice)
+	}
+
+	context.JSON(http.StatusOK, detail)
+}
+
+func validateChallengeFilter(filter model.ChallengeFilter) error {
+	for _, state := range filter.States {
+		if !model.IsChallengeState(state) {
+			return fmt.Errorf("unknown state: %s", state)
 		}
 	}
 
-	return ""
+	if filter.Role != "" && filter.Role != model.ChallengeRoleSent && filter.Role != model.ChallengeRoleReceived {
+		return fmt.Errorf("role must be %s or %s", model.ChallengeRoleSent, model.ChallengeRoleReceived)
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return fmt.Errorf("from must be before to")
+	}
+
+	return nil
+}
+
+// mayChallenge checks the block list in both directions and the opponent's friends only setting
+func (challengeHandler *ChallengeHandler) mayChallenge(context *gin.Context, challengerID int, opponentID int) bool {
+	blocked, err := challengeHandler.friends.IsBlocked(challengerID, opponentID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to check opponent")
+		return false
+	}
+	if blocked {
+		context.AbortWithStatusJSON(http.StatusForbidden, "Not allowed to challenge this player")
+		return false
+	}
+
+	friendsOnly, err := challengeHandler.players.AcceptsChallengesFromFriendsOnly(opponentID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to check opponent")
+		return false
+	}
+	if !friendsOnly {
+		return true
+	}
+
+	friends, err := challengeHandler.friends.AreFriends(challengerID, opponentID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "Unable to check opponent")
+		return false
+	}
+	if !friends {
+		context.AbortWithStatusJSON(http.StatusForbidden, "Opponent only accepts challenges from friends")
+		return false
+	}
+
+	return true
+}
+
+// limitedStake is what a bet counts against the responsible gaming limits, which are set in play coins.
+// Bets in other currencies only face the self-exclusion.
+func limitedStake(stake model.Money) int {
+	if stake.Currency != model.CurrencyCoin {
+		return 0
+	}
+	return stake.Amount
+}
+
+// challengeRake is the house's cut of a won challenge, only play coin pots are raked
+func challengeRake(challenge *model.Challenge) int {
+	if challenge.Bet.Currency != model.CurrencyCoin {
+		return 0
+	}
+	return services.Rake(challenge.RuleSet, challenge.Bet.Amount)
 }
 
 func isValidChoice(choice int) bool {
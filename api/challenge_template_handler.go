@@ -0,0 +1,173 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+type ChallengeTemplateHandler struct {
+	templates  *repository.ChallengeTemplate
+	players    *repository.Player
+	challenges *ChallengeHandler
+}
+
+func NewChallengeTemplateHandler(templates *repository.ChallengeTemplate, players *repository.Player,
+	challenges *ChallengeHandler) *ChallengeTemplateHandler {
+	return &ChallengeTemplateHandler{
+		templates:  templates,
+		players:    players,
+		challenges: challenges,
+	}
+}
+
+// GetTemplates lists the caller's templates
+func (templateHandler *ChallengeTemplateHandler) GetTemplates(context *gin.Context) {
+	templates, err := templateHandler.templates.GetTemplates(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve templates"})
+		return
+	}
+
+	context.JSON(http.StatusOK, templates)
+}
+
+// Create saves a challenge to fire later
+func (templateHandler *ChallengeTemplateHandler) Create(context *gin.Context) {
+	var request model.ChallengeTemplateRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind challenge template request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.RuleSet == "" {
+		request.RuleSet = model.RuleSetClassic
+	}
+	if message := validateChallengeTemplate(request); message != "" {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	opponentID, err := templateHandler.players.FindPlayerID(request.Opponent)
+	if opponentID == 0 || err != nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, "Opponent does not exist")
+		return
+	}
+	if opponentID == playerID {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot challenge yourself"})
+		return
+	}
+
+	count, err := templateHandler.templates.CountTemplates(playerID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to save template"})
+		return
+	}
+	if count >= config.Settings.MaxChallengeTemplates {
+		context.AbortWithStatusJSON(http.StatusConflict,
+			gin.H{"error": fmt.Sprintf("at most %d templates can be saved", config.Settings.MaxChallengeTemplates)})
+		return
+	}
+
+	template := model.ChallengeTemplate{
+		Name:       request.Name,
+		OpponentID: opponentID,
+		Opponent:   request.Opponent,
+		Bet:        request.Bet,
+		RuleSet:    request.RuleSet,
+		Strategy:   request.Strategy,
+	}
+	if request.Strategy == model.StrategyFixed {
+		template.Choice = request.Choice
+	}
+	err = templateHandler.templates.CreateTemplate(playerID, &template)
+	if errors.Is(err, repository.ErrTemplateNameTaken) {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to save template"})
+		return
+	}
+
+	context.JSON(http.StatusCreated, template)
+}
+
+// Delete removes one of the caller's templates
+func (templateHandler *ChallengeTemplateHandler) Delete(context *gin.Context) {
+	templateID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	deleted, err := templateHandler.templates.DeleteTemplate(services.GetPlayerIDFromContext(context), templateID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete template"})
+		return
+	}
+	if !deleted {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+
+	context.JSON(http.StatusOK, "Successfully deleted template")
+}
+
+// Fire creates the challenge a template describes, the move is picked by the template's strategy
+func (templateHandler *ChallengeTemplateHandler) Fire(context *gin.Context) {
+	templateID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	template, err := templateHandler.templates.GetTemplate(playerID, templateID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve template"})
+		return
+	}
+	if template == nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+
+	request := model.ChallengeRequest{
+		Opponent: template.Opponent,
+		Bet:      model.Coins(template.Bet),
+		RuleSet:  template.RuleSet,
+	}
+	if !templateHandler.challenges.pickMove(context, &request, template.Strategy, template.Choice, playerID, template.OpponentID) {
+		return
+	}
+
+	templateHandler.challenges.placeChallenge(context, template.OpponentID, request)
+}
+
+// validateChallengeTemplate returns what is wrong with the request, or an empty string
+func validateChallengeTemplate(request model.ChallengeTemplateRequest) string {
+	if request.Bet < config.Settings.MinimumBet {
+		return "bet amount is too low"
+	}
+	if !model.IsRuleSet(request.RuleSet) {
+		return "unknown rule set"
+	}
+	if !model.IsMoveStrategy(request.Strategy) {
+		return "strategy must be fixed, random, repeat_last or beat_last"
+	}
+	if request.Strategy == model.StrategyFixed && isValidChoice(request.Choice) {
+		return "the fixed strategy needs a valid choice"
+	}
+	return ""
+}
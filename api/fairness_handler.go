@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"main/internal"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+type FairnessHandler struct {
+	fairness *services.Fairness
+	seeds    *repository.Fairness
+	games    *repository.House
+	audits   *repository.Audit
+}
+
+func NewFairnessHandler(fairness *services.Fairness, seeds *repository.Fairness, games *repository.House,
+	audits *repository.Audit) *FairnessHandler {
+	return &FairnessHandler{
+		fairness: fairness,
+		seeds:    seeds,
+		games:    games,
+		audits:   audits,
+	}
+}
+
+// GetSeed returns the caller's seed pair in use: the hash of the secret server seed, the client seed and the next nonce
+func (fairnessHandler *FairnessHandler) GetSeed(context *gin.Context) {
+	seed, err := fairnessHandler.fairness.Seed(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		logrus.Errorf("Failed to retrieve seed pair: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve seed pair"})
+		return
+	}
+
+	context.JSON(http.StatusOK, seed)
+}
+
+// GetSeeds lists the caller's seed pairs, with the server seeds of the revealed ones
+func (fairnessHandler *FairnessHandler) GetSeeds(context *gin.Context) {
+	seeds, err := fairnessHandler.seeds.GetSeeds(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve seed pairs"})
+		return
+	}
+
+	context.JSON(http.StatusOK, seeds)
+}
+
+// Rotate reveals the caller's server seed and commits to a new one with the given client seed
+func (fairnessHandler *FairnessHandler) Rotate(context *gin.Context) {
+	var request model.FairnessRotateRequest
+	// The body is optional
+	err := context.ShouldBindJSON(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(request.ClientSeed) > 255 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "client_seed cannot be longer than 255 characters"})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	rotation, err := fairnessHandler.fairness.Rotate(services.GetPlayerIDFromContext(context), request.ClientSeed)
+	if err != nil {
+		logrus.Errorf("Failed to rotate seed pair: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate seed pair"})
+		return
+	}
+
+	recordAudit(fairnessHandler.audits, context, userName, model.AuditSeedRotated, strconv.Itoa(rotation.Active.ID),
+		gin.H{"revealed": rotation.Revealed.ID, "server_seed_hash": rotation.Active.ServerSeedHash})
+
+	context.JSON(http.StatusOK, rotation)
+}
+
+// Verify recomputes a move from a revealed server seed, the client seed and the nonce, optionally checking the
+// server seed against its commitment and the move against one of the caller's house games or drawn challenge moves
+func (fairnessHandler *FairnessHandler) Verify(context *gin.Context) {
+	var request model.FairnessVerifyRequest
+	if err := context.ShouldBindQuery(&request); err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Game != 0 && request.Challenge != 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "verify either a game or a challenge"})
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	recordedMove := ""
+	if request.Game != 0 {
+		game, err := fairnessHandler.games.GetHouseGame(playerID, request.Game)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve house game"})
+			return
+		}
+		if game == nil {
+			context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "house game not found"})
+			return
+		}
+
+		recordedMove = game.HouseMove
+		useRecordedDraw(&request, game.ServerSeedHash, game.ClientSeed, game.Nonce)
+	}
+	if request.Challenge != 0 {
+		seed, choice, err := fairnessHandler.seeds.GetChallengeDraw(playerID, request.Challenge)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve challenge"})
+			return
+		}
+		if seed == nil {
+			context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no drawn move for this challenge"})
+			return
+		}
+
+		recordedMove = model.ChoiceToString(choice)
+		useRecordedDraw(&request, seed.ServerSeedHash, seed.ClientSeed, seed.Nonce)
+	}
+	if request.ClientSeed == "" || request.Nonce == nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "client_seed and nonce are required without a game or challenge"})
+		return
+	}
+
+	move, committed := internal.VerifyFairMove(request.ServerSeed, request.ServerSeedHash, request.ClientSeed, *request.Nonce)
+	verification := model.FairnessVerification{
+		ServerSeedHash: internal.HashServerSeed(request.ServerSeed),
+		ClientSeed:     request.ClientSeed,
+		Nonce:          *request.Nonce,
+		Move:           model.ChoiceToString(move),
+		RecordedMove:   recordedMove,
+	}
+	if request.ServerSeedHash != "" {
+		verification.HashMatches = &committed
+	}
+	if recordedMove != "" {
+		matches := recordedMove == verification.Move
+		verification.Matches = &matches
+	}
+
+	context.JSON(http.StatusOK, verification)
+}
+
+// useRecordedDraw fills in the commitment, client seed and nonce of a recorded move the request leaves out
+func useRecordedDraw(request *model.FairnessVerifyRequest, serverSeedHash string, clientSeed string, nonce int) {
+	if request.ServerSeedHash == "" {
+		request.ServerSeedHash = serverSeedHash
+	}
+	if request.ClientSeed == "" {
+		request.ClientSeed = clientSeed
+	}
+	if request.Nonce == nil {
+		request.Nonce = &nonce
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+// minimumFreeForAllPlayers keeps free-for-all rounds apart from regular challenges
+const minimumFreeForAllPlayers = 3
+
+type FreeForAllHandler struct {
+	rounds  *repository.FreeForAll
+	service *services.FreeForAllRounds
+	limits  *services.ResponsibleGaming
+	audits  *repository.Audit
+}
+
+func NewFreeForAllHandler(rounds *repository.FreeForAll, service *services.FreeForAllRounds, limits *services.ResponsibleGaming,
+	audits *repository.Audit) *FreeForAllHandler {
+	return &FreeForAllHandler{
+		rounds:  rounds,
+		service: service,
+		limits:  limits,
+		audits:  audits,
+	}
+}
+
+// Create opens a round with the creator's stake and move
+func (freeForAllHandler *FreeForAllHandler) Create(context *gin.Context) {
+	var request model.FreeForAllRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind free-for-all request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.MinPlayers == 0 {
+		request.MinPlayers = minimumFreeForAllPlayers
+	}
+	if request.Rule == "" {
+		request.Rule = config.Settings.FreeForAllDefaultRule
+	}
+	if message := validateFreeForAll(request); message != "" {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	if !mayBet(context, freeForAllHandler.limits, playerID, request.Stake) {
+		return
+	}
+
+	round, err := freeForAllHandler.service.Create(playerID, request)
+	if err != nil {
+		abortWithFreeForAllError(context, err, "Failed to create round")
+		return
+	}
+	round.Creator = userName
+
+	recordAudit(freeForAllHandler.audits, context, userName, model.AuditFreeForAllCreated, strconv.Itoa(round.ID),
+		gin.H{"stake": round.Stake, "min_players": round.MinPlayers, "max_players": round.MaxPlayers, "rule": round.Rule})
+
+	context.JSON(http.StatusCreated, round)
+}
+
+// GetRounds lists the rounds, newest first, optionally only those in the given state
+func (freeForAllHandler *FreeForAllHandler) GetRounds(context *gin.Context) {
+	state := context.Query("state")
+	if state != "" && !model.IsFreeForAllState(state) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown state " + state})
+		return
+	}
+
+	rounds, err := freeForAllHandler.rounds.GetFreeForAlls(state)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rounds"})
+		return
+	}
+
+	context.JSON(http.StatusOK, rounds)
+}
+
+// GetRound returns a round with its participants, the moves of others are only shown once it is played
+func (freeForAllHandler *FreeForAllHandler) GetRound(context *gin.Context) {
+	roundID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid round id"})
+		return
+	}
+
+	round, err := freeForAllHandler.rounds.GetFreeForAll(roundID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve round"})
+		return
+	}
+	if round == nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": repository.ErrFreeForAllNotFound.Error()})
+		return
+	}
+
+	if round.State == model.FreeForAllOpen {
+		playerID := services.GetPlayerIDFromContext(context)
+		for i := range round.Participants {
+			if round.Participants[i].PlayerID != playerID {
+				round.Participants[i].Move = ""
+			}
+		}
+	}
+
+	context.JSON(http.StatusOK, round)
+}
+
+// Join holds the stake and adds the player's move to the round
+func (freeForAllHandler *FreeForAllHandler) Join(context *gin.Context) {
+	roundID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid round id"})
+		return
+	}
+
+	var request model.FreeForAllJoinRequest
+	err = context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind free-for-all join request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if isValidChoice(request.Choice) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Invalid choice")
+		return
+	}
+
+	round, err := freeForAllHandler.rounds.GetFreeForAll(roundID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve round"})
+		return
+	}
+	if round == nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": repository.ErrFreeForAllNotFound.Error()})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	if !mayBet(context, freeForAllHandler.limits, playerID, round.Stake) {
+		return
+	}
+
+	err = freeForAllHandler.service.Join(playerID, roundID, request.Choice)
+	if err != nil {
+		abortWithFreeForAllError(context, err, "Failed to join round")
+		return
+	}
+
+	recordAudit(freeForAllHandler.audits, context, userName, model.AuditFreeForAllJoined, strconv.Itoa(roundID), nil)
+
+	context.JSON(http.StatusOK, "Successfully joined round")
+}
+
+// abortWithFreeForAllError answers the known round errors with their message and anything else with fallback
+func abortWithFreeForAllError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrFreeForAllNotFound):
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrFreeForAllClosed), errors.Is(err, repository.ErrFreeForAllFull),
+		errors.Is(err, repository.ErrAlreadyInRound):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// validateFreeForAll returns what is wrong with the request, or an empty string
+func validateFreeForAll(request model.FreeForAllRequest) string {
+	if isValidChoice(request.Choice) {
+		return "invalid choice"
+	}
+	if request.Stake < config.Settings.MinimumBet {
+		return "stake is too low"
+	}
+	if !model.IsFreeForAllRule(request.Rule) {
+		return "rule must be classic or beats_most"
+	}
+	if request.MinPlayers < minimumFreeForAllPlayers || request.MaxPlayers < request.MinPlayers {
+		return fmt.Sprintf("rounds need at least %d players and max_players cannot be below min_players", minimumFreeForAllPlayers)
+	}
+	if request.MaxPlayers > config.Settings.FreeForAllMaxPlayers {
+		return fmt.Sprintf("max_players cannot be above %d", config.Settings.FreeForAllMaxPlayers)
+	}
+	if request.DeadlineMinutes <= 0 || request.DeadlineMinutes > config.Settings.FreeForAllMaxDeadlineMinutes {
+		return fmt.Sprintf("deadline_minutes must be between 1 and %d", config.Settings.FreeForAllMaxDeadlineMinutes)
+	}
+	return ""
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+type FriendHandler struct {
+	friends *repository.Friend
+	players *repository.Player
+	audits  *repository.Audit
+}
+
+func NewFriendHandler(friends *repository.Friend, players *repository.Player, audits *repository.Audit) *FriendHandler {
+	return &FriendHandler{
+		friends: friends,
+		players: players,
+		audits:  audits,
+	}
+}
+
+// GetFriends lists the player's friends and pending friend requests in both directions
+func (friendHandler *FriendHandler) GetFriends(context *gin.Context) {
+	friends, err := friendHandler.friends.GetFriends(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve friends"})
+		return
+	}
+
+	context.JSON(http.StatusOK, friends)
+}
+
+// RequestFriendship sends a friend request, or accepts the one the other player already sent
+func (friendHandler *FriendHandler) RequestFriendship(context *gin.Context) {
+	playerID, otherID, ok := friendHandler.bindOtherPlayer(context)
+	if !ok {
+		return
+	}
+
+	blocked, err := friendHandler.friends.IsBlocked(playerID, otherID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send friend request"})
+		return
+	}
+	if blocked {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not allowed to befriend this player"})
+		return
+	}
+
+	state, err := friendHandler.friends.RequestFriendship(playerID, otherID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to send friend request"})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"state": state})
+}
+
+// AcceptFriendship accepts a friend request the other player sent
+func (friendHandler *FriendHandler) AcceptFriendship(context *gin.Context) {
+	playerID, otherID, ok := friendHandler.bindOtherPlayer(context)
+	if !ok {
+		return
+	}
+
+	accepted, err := friendHandler.friends.AcceptFriendship(playerID, otherID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept friend request"})
+		return
+	}
+	if !accepted {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "No pending friend request from this player"})
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"state": model.FriendAccepted})
+}
+
+// RemoveFriendship removes a friend, or cancels or declines a friend request
+func (friendHandler *FriendHandler) RemoveFriendship(context *gin.Context) {
+	playerID, otherID, ok := friendHandler.otherPlayer(context, context.Param("username"))
+	if !ok {
+		return
+	}
+
+	removed, err := friendHandler.friends.RemoveFriendship(playerID, otherID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove friend"})
+		return
+	}
+	if !removed {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not friends with this player"})
+		return
+	}
+
+	context.JSON(http.StatusOK, "Successfully removed friend")
+}
+
+// GetBlockedPlayers lists the player's block list
+func (friendHandler *FriendHandler) GetBlockedPlayers(context *gin.Context) {
+	blocked, err := friendHandler.friends.GetBlockedPlayers(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blocked players"})
+		return
+	}
+
+	context.JSON(http.StatusOK, blocked)
+}
+
+// Block blocks a player. Pending challenges they sent are declined and their bets released.
+func (friendHandler *FriendHandler) Block(context *gin.Context) {
+	var request model.FriendRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind block request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	playerID, blockedID, ok := friendHandler.otherPlayer(context, request.Username)
+	if !ok {
+		return
+	}
+
+	declined, err := friendHandler.friends.Block(playerID, blockedID)
+	if err != nil {
+		logrus.Errorf("Unable to block %s: %v", request.Username, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to block player"})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	declinedChallenges := []string{}
+	for _, challengeID := range declined {
+		declinedChallenges = append(declinedChallenges, strconv.Itoa(challengeID))
+		recordAudit(friendHandler.audits, context, userName, model.AuditChallengeDeclined, strconv.Itoa(challengeID),
+			gin.H{"challenger": request.Username, "opponent": userName, "reason": "blocked"})
+	}
+	recordAudit(friendHandler.audits, context, userName, model.AuditPlayerBlocked, request.Username,
+		gin.H{"declined_challenges": declinedChallenges})
+
+	context.JSON(http.StatusOK, gin.H{"declined_challenges": declinedChallenges})
+}
+
+// Unblock takes a player off the block list
+func (friendHandler *FriendHandler) Unblock(context *gin.Context) {
+	playerID, blockedID, ok := friendHandler.otherPlayer(context, context.Param("username"))
+	if !ok {
+		return
+	}
+
+	removed, err := friendHandler.friends.Unblock(playerID, blockedID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock player"})
+		return
+	}
+	if !removed {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Player is not blocked"})
+		return
+	}
+
+	context.JSON(http.StatusOK, "Successfully unblocked player")
+}
+
+func (friendHandler *FriendHandler) bindOtherPlayer(context *gin.Context) (int, int, bool) {
+	var request model.FriendRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind friend request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return 0, 0, false
+	}
+
+	return friendHandler.otherPlayer(context, request.Username)
+}
+
+// otherPlayer resolves the player the request is about, aborting when it is unknown or the caller themselves
+func (friendHandler *FriendHandler) otherPlayer(context *gin.Context, username string) (int, int, bool) {
+	playerID := services.GetPlayerIDFromContext(context)
+	otherID, err := friendHandler.players.FindPlayerID(username)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to find player"})
+		return 0, 0, false
+	}
+	if otherID == 0 {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+		return 0, 0, false
+	}
+	if otherID == playerID {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Cannot do this with yourself"})
+		return 0, 0, false
+	}
+
+	return playerID, otherID, true
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+type HouseHandler struct {
+	games   *repository.House
+	service *services.HouseGames
+	limits  *services.ResponsibleGaming
+	audits  *repository.Audit
+}
+
+func NewHouseHandler(games *repository.House, service *services.HouseGames, limits *services.ResponsibleGaming,
+	audits *repository.Audit) *HouseHandler {
+	return &HouseHandler{
+		games:   games,
+		service: service,
+		limits:  limits,
+		audits:  audits,
+	}
+}
+
+// Play bets against the house, the house moves right away
+func (houseHandler *HouseHandler) Play(context *gin.Context) {
+	var request model.HouseGameRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind house game request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if isValidChoice(request.Choice) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Invalid choice")
+		return
+	}
+	if request.Bet < config.Settings.MinimumBet {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Bet amount is too low")
+		return
+	}
+	if request.Bet > config.Settings.HouseMaxBet {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("the house takes bets up to %d", config.Settings.HouseMaxBet)})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	if !mayBet(context, houseHandler.limits, playerID, request.Bet) {
+		return
+	}
+
+	game, err := houseHandler.service.Play(playerID, request)
+	if err != nil {
+		abortWithHouseError(context, err, "Failed to play against the house")
+		return
+	}
+
+	recordAudit(houseHandler.audits, context, userName, model.AuditHouseGamePlayed, strconv.Itoa(game.ID),
+		gin.H{"bet": game.Bet, "result": game.Result, "payout": game.Payout})
+
+	context.JSON(http.StatusCreated, game)
+}
+
+// GetGames lists the caller's latest games against the house with their seeds
+func (houseHandler *HouseHandler) GetGames(context *gin.Context) {
+	games, err := houseHandler.games.GetHouseGames(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve house games"})
+		return
+	}
+
+	context.JSON(http.StatusOK, games)
+}
+
+// Report returns the bankroll and the house's profit and loss, optionally between from and to
+func (houseHandler *HouseHandler) Report(context *gin.Context) {
+	var request model.HouseReportRequest
+	if err := context.ShouldBindQuery(&request); err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := houseHandler.games.GetHouseReport(houseHandler.service.HouseID(), request)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to build house report"})
+		return
+	}
+
+	context.JSON(http.StatusOK, report)
+}
+
+// Revenue returns the rake collected from settled challenges, optionally between from and to
+func (houseHandler *HouseHandler) Revenue(context *gin.Context) {
+	var request model.HouseReportRequest
+	if err := context.ShouldBindQuery(&request); err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := houseHandler.games.GetRevenueReport(houseHandler.service.HouseID(), request)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to build revenue report"})
+		return
+	}
+
+	context.JSON(http.StatusOK, report)
+}
+
+// Fund adds to the bankroll or, with a negative amount, takes from it
+func (houseHandler *HouseHandler) Fund(context *gin.Context) {
+	var request model.HouseFundsRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind house funds request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bankroll, err := houseHandler.games.FundHouse(houseHandler.service.HouseID(), request.Amount)
+	if err != nil {
+		abortWithHouseError(context, err, "Failed to fund the house")
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(houseHandler.audits, context, admin, model.AuditHouseFunded, config.Settings.HouseUsername,
+		gin.H{"amount": request.Amount, "bankroll": bankroll})
+
+	context.JSON(http.StatusOK, gin.H{"bankroll": bankroll})
+}
+
+// abortWithHouseError answers the known house game errors with their message and anything else with fallback
+func abortWithHouseError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrHouseBankroll):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+)
+
+type LockoutHandler struct {
+	guard  *services.LoginGuard
+	audits *repository.Audit
+}
+
+func NewLockoutHandler(guard *services.LoginGuard, audits *repository.Audit) *LockoutHandler {
+	return &LockoutHandler{
+		guard:  guard,
+		audits: audits,
+	}
+}
+
+// GetLockouts lists the usernames and ips that are currently locked out
+func (lockoutHandler *LockoutHandler) GetLockouts(context *gin.Context) {
+	lockouts, err := lockoutHandler.guard.ActiveLockouts()
+	if err != nil {
+		logrus.Error("Unable to get lockouts")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lockouts"})
+		return
+	}
+
+	context.JSON(http.StatusOK, lockouts)
+}
+
+// Unlock lifts the lockout of a username and/or an ip before it expires
+func (lockoutHandler *LockoutHandler) Unlock(context *gin.Context) {
+	var unlockRequest model.LoginUnlockRequest
+	err := context.BindJSON(&unlockRequest)
+	if err != nil {
+		logrus.Errorf("Unable to bind unlock request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if unlockRequest.Username == "" && unlockRequest.IP == "" {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "username or ip is required"})
+		return
+	}
+
+	err = lockoutHandler.guard.Unlock(unlockRequest.Username, unlockRequest.IP)
+	if err != nil {
+		logrus.Error("Unable to unlock login")
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock"})
+		return
+	}
+
+	admin := context.GetString(services.SubjectKey)
+	recordAudit(lockoutHandler.audits, context, admin, model.AuditLoginUnlocked, unlockRequest.Username,
+		gin.H{"ip": unlockRequest.IP})
+
+	context.JSON(http.StatusOK, "Successfully unlocked")
+}
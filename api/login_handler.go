@@ -2,21 +2,52 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"main/internal"
 	"main/model"
 	"main/repository"
 	"main/services"
+	"math"
 	"net/http"
+	"strings"
 )
 
+var errLoginMismatch = errors.New("username or password mismatch")
+
 type LoginHandler struct {
 	playerRepository *repository.Player
+	audits           *repository.Audit
+	guard            *services.LoginGuard
+	twoFactor        *services.TwoFactor
+
+	// Unknown usernames are checked against this player so that they take as long to reject as known ones
+	dummyPlayer model.Player
 }
 
-func NewLoginHandler(playerRepository *repository.Player) *LoginHandler {
-	return &LoginHandler{playerRepository: playerRepository}
+func NewLoginHandler(playerRepository *repository.Player, audits *repository.Audit,
+	guard *services.LoginGuard, twoFactor *services.TwoFactor) *LoginHandler {
+	salt, err := internal.GenerateRandomSalt()
+	if err != nil {
+		panic(err)
+	}
+	password, err := internal.GenerateRandomSalt()
+	if err != nil {
+		panic(err)
+	}
+	hashed, err := internal.HashPassword(password, salt)
+	if err != nil {
+		panic(err)
+	}
+
+	return &LoginHandler{
+		playerRepository: playerRepository,
+		audits:           audits,
+		guard:            guard,
+		twoFactor:        twoFactor,
+		dummyPlayer:      model.Player{Salt: salt, Password: hashed},
+	}
 }
 
 // Handle attempts to log users in with username and password
@@ -29,64 +60,185 @@ func (loginHandler *LoginHandler) Handle(context *gin.Context) {
 		return
 	}
 
-	err, statusCode := loginHandler.checkLoginData(loginData)
+	ip := context.ClientIP()
+	if !checkRetryAfter(context, loginHandler.guard, loginData.Username, ip) {
+		return
+	}
+
+	player, err, statusCode := loginHandler.checkLoginData(loginData)
 	if err != nil {
+		recordAudit(loginHandler.audits, context, loginData.Username, model.AuditLoginFailed, loginData.Username,
+			gin.H{"reason": err.Error()})
+		if statusCode != http.StatusInternalServerError {
+			recordLoginFailure(context, loginHandler.guard, loginHandler.audits, loginData.Username, ip)
+		}
 		context.AbortWithStatusJSON(statusCode, err.Error())
 		return
 	}
 
+	// The password alone is not enough, hand out a token that can only be exchanged with the second factor
+	if player.TOTPEnabled {
+		preAuthToken, err := services.GeneratePreAuthJWT(player.Username)
+		if err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, "unable to create token")
+			return
+		}
+
+		context.JSON(http.StatusAccepted, gin.H{
+			"two_factor_required": true,
+			"pre_auth_token":      preAuthToken,
+		})
+		return
+	}
+
+	loginHandler.completeLogin(context, player.Username, "password")
+}
+
+// VerifySecondFactor exchanges a pre-auth token and a two factor or recovery code for a full token
+func (loginHandler *LoginHandler) VerifySecondFactor(context *gin.Context) {
+	var twoFactorLogin model.TwoFactorLoginRequest
+	err := context.BindJSON(&twoFactorLogin)
+	if err != nil {
+		logrus.Errorf("Unable to bind JSON err: %s", err.Error())
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	username, issuedAt, err := services.ParsePreAuthToken(twoFactorLogin.PreAuthToken)
+	if err != nil || username == "" {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, "invalid or expired pre-auth token")
+		return
+	}
+
+	// A password change, rename or closure since the password was checked cancels the pending login
+	_, validAfter, found, err := loginHandler.playerRepository.GetSession(username)
+	if err != nil {
+		logrus.Errorf("Unable to check pre-auth token validity: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "unable to log in, try again")
+		return
+	}
+	if !found || issuedAt.Before(validAfter) {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, "invalid or expired pre-auth token")
+		return
+	}
+
+	ip := context.ClientIP()
+	if !checkRetryAfter(context, loginHandler.guard, username, ip) {
+		return
+	}
+
+	player, err := loginHandler.playerRepository.FindPlayerWithDetails(username)
+	if err != nil || player == nil || !player.TOTPEnabled {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, "invalid or expired pre-auth token")
+		return
+	}
+
+	method := "totp"
+	switch {
+	case twoFactorLogin.Code != "":
+		err = loginHandler.twoFactor.VerifyCode(player, twoFactorLogin.Code)
+	case twoFactorLogin.RecoveryCode != "":
+		method = "recovery_code"
+		err = loginHandler.twoFactor.VerifyRecoveryCode(player, twoFactorLogin.RecoveryCode)
+	default:
+		context.AbortWithStatusJSON(http.StatusBadRequest, "code or recovery_code is required")
+		return
+	}
+
+	if err != nil {
+		recordAudit(loginHandler.audits, context, username, model.AuditLoginFailed, username,
+			gin.H{"reason": err.Error(), "method": method})
+		if errors.Is(err, services.ErrTwoFactorInvalidCode) {
+			recordLoginFailure(context, loginHandler.guard, loginHandler.audits, username, ip)
+		}
+		abortWithTwoFactorError(context, err)
+		return
+	}
+
+	loginHandler.completeLogin(context, username, method)
+}
+
+// checkRetryAfter rejects the request if the username or ip has to back off after failed logins or second factor codes.
+// Backed off and locked out usernames get the same answer whether they exist or not.
+func checkRetryAfter(context *gin.Context, guard *services.LoginGuard, username, ip string) bool {
+	retryAfter, err := guard.RetryAfter(username, ip)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, "unable to log in, try again")
+		return false
+	}
+	if retryAfter > 0 {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		logrus.Warningf("Rejected login for %s from %s, retry in %ds", username, ip, seconds)
+		context.Header("Retry-After", fmt.Sprint(seconds))
+		context.AbortWithStatusJSON(http.StatusTooManyRequests, "too many failed login attempts, try again later")
+		return false
+	}
+
+	return true
+}
+
+func (loginHandler *LoginHandler) completeLogin(context *gin.Context, username, method string) {
+	err := loginHandler.guard.RecordSuccess(username)
+	if err != nil {
+		logrus.Errorf("Unable to reset failed logins for %s: %v", username, err)
+	}
+
 	// Create JWT for the user and return it
-	token, err := services.GenerateJWT(loginData.Username)
+	token, err := services.GenerateJWT(username)
 	if err != nil {
 		context.AbortWithStatusJSON(http.StatusInternalServerError, errors.New("unable to create token"))
 		return
 	}
-	logrus.Infof("Created a new token for username: %s", loginData.Username)
+	logrus.Infof("Created a new token for username: %s", username)
+	recordAudit(loginHandler.audits, context, username, model.AuditLoginSucceeded, username, gin.H{"method": method})
 
 	context.JSON(http.StatusCreated, gin.H{
 		"token": token,
 	})
-
 }
 
-// Check if token is valid and not expired
-// if expired, check credentials
-// if credentials are correct, create a new token and add it to the token repo
-// else throw error
-func (loginHandler *LoginHandler) checkLoginData(loginData model.PlayerLoginRequest) (error, int) {
-	err := internal.ValidatePlayerUsername(loginData.Username)
+// recordLoginFailure counts a wrong password or second factor code against the username and the ip and audits
+// the lockouts it causes
+func recordLoginFailure(context *gin.Context, guard *services.LoginGuard, audits *repository.Audit, username, ip string) {
+	locked, err := guard.RecordFailure(username, ip)
 	if err != nil {
-		logrus.Warning("Logging in user was not successful, validation failed")
-		return err, http.StatusBadRequest
+		logrus.Errorf("Unable to record failed login for %s: %v", username, err)
+		return
 	}
 
-	exists, err := loginHandler.playerRepository.Exists(loginData.Username)
-	if err != nil {
-		logrus.Error(err)
-		return err, http.StatusInternalServerError
+	if len(locked) > 0 {
+		recordAudit(audits, context, username, model.AuditLoginLocked, username,
+			gin.H{"locked": strings.Join(locked, ",")})
 	}
-	if !exists {
-		logrus.Errorf("Unable to find player by username: %s", loginData.Username)
-		return errors.New("username or password mismatch"), http.StatusUnauthorized
+}
+
+// Check if credentials are correct
+// the password is hashed even for unknown usernames, so the response time does not reveal which usernames exist
+func (loginHandler *LoginHandler) checkLoginData(loginData model.PlayerLoginRequest) (*model.Player, error, int) {
+	err := internal.ValidatePlayerUsername(loginData.Username)
+	if err != nil {
+		logrus.Warning("Logging in user was not successful, validation failed")
+		return nil, err, http.StatusBadRequest
 	}
 
 	playerDetails, err := loginHandler.playerRepository.FindPlayerWithDetails(loginData.Username)
 	if err != nil {
 		logrus.Error(err)
-		return err, http.StatusInternalServerError
+		return nil, err, http.StatusInternalServerError
 	}
-	matching, err := internal.IsPasswordMatching(loginData.Username, loginData.Password,
-		playerDetails.Salt, playerDetails.Password)
-	if err != nil {
-		logrus.Errorf("Error when checking player password: %s", err.Error())
-		return err, http.StatusUnauthorized
+
+	candidate := &loginHandler.dummyPlayer
+	if playerDetails != nil {
+		candidate = playerDetails
 	}
 
-	if !matching {
+	matching, err := internal.IsPasswordMatching(loginData.Username, loginData.Password,
+		candidate.Salt, candidate.Password)
+	if err != nil || !matching || playerDetails == nil {
 		logrus.Warning("Username or password mismatch")
-		return errors.New("username or password mismatch"), http.StatusUnauthorized
+		return nil, errLoginMismatch, http.StatusUnauthorized
 	}
 
-	logrus.Infof("Successfully logged in user: %s", loginData.Username)
-	return nil, http.StatusOK
+	logrus.Infof("Successfully checked password of user: %s", loginData.Username)
+	return playerDetails, nil, http.StatusOK
 }
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+)
+
+type MatchmakingHandler struct {
+	matchmaker *services.Matchmaker
+	limits     *services.ResponsibleGaming
+	audits     *repository.Audit
+}
+
+func NewMatchmakingHandler(matchmaker *services.Matchmaker, limits *services.ResponsibleGaming, audits *repository.Audit) *MatchmakingHandler {
+	return &MatchmakingHandler{
+		matchmaker: matchmaker,
+		limits:     limits,
+		audits:     audits,
+	}
+}
+
+// Join puts the player in the matchmaking queue, the bet is held until the game is played or the ticket ends
+func (matchmakingHandler *MatchmakingHandler) Join(context *gin.Context) {
+	var request model.MatchmakingJoinRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind matchmaking request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.RuleSet == "" {
+		request.RuleSet = model.RuleSetClassic
+	}
+	if !model.IsRuleSet(request.RuleSet) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown rule set"})
+		return
+	}
+	if isValidChoice(request.Choice) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Invalid choice")
+		return
+	}
+	if request.Bet.Amount < config.Settings.MinimumBet {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Bet amount is too low")
+		return
+	}
+	if request.Bet.Currency != model.CurrencyCoin {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "the queue only takes " + model.CurrencyCoin})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	if !mayBet(context, matchmakingHandler.limits, playerID, request.Bet.Amount) {
+		return
+	}
+
+	status, err := matchmakingHandler.matchmaker.Join(playerID, userName, request)
+	switch {
+	case errors.Is(err, services.ErrAlreadyQueued):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrInsufficientBalance):
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logrus.Errorf("Unable to queue %s: %v", userName, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to join the queue"})
+		return
+	}
+
+	recordAudit(matchmakingHandler.audits, context, userName, model.AuditMatchmakingJoined, userName,
+		gin.H{"bet": request.Bet, "rule_set": request.RuleSet})
+
+	context.JSON(http.StatusAccepted, status)
+}
+
+// Leave takes the player out of the queue and refunds the bet
+func (matchmakingHandler *MatchmakingHandler) Leave(context *gin.Context) {
+	userName := services.GetSubjectFromContext(context)
+	left, err := matchmakingHandler.matchmaker.Leave(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		logrus.Errorf("Unable to take %s out of the queue: %v", userName, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to leave the queue"})
+		return
+	}
+	if !left {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not in the queue"})
+		return
+	}
+
+	recordAudit(matchmakingHandler.audits, context, userName, model.AuditMatchmakingLeft, userName, nil)
+
+	context.JSON(http.StatusOK, "Successfully left the queue")
+}
+
+// Status tells whether the player is queued, or how their last ticket ended
+func (matchmakingHandler *MatchmakingHandler) Status(context *gin.Context) {
+	context.JSON(http.StatusOK, matchmakingHandler.matchmaker.Status(services.GetPlayerIDFromContext(context)))
+}
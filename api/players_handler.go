@@ -1,33 +1,84 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"main/config"
 	"main/model"
 	"main/repository"
 	"main/services"
 	"net/http"
+	"time"
 )
 
 type PlayersHandler struct {
 	players      *repository.Player
 	transactions *repository.Transaction
+	audits       *repository.Audit
+	twoFactor    *services.TwoFactor
+	guard        *services.LoginGuard
+	limits       *services.ResponsibleGaming
+	promotions   *services.Promotions
 }
 
-func NewFindPlayersHandler(players *repository.Player, transactions *repository.Transaction) *PlayersHandler {
-	return &PlayersHandler{players: players, transactions: transactions}
+func NewFindPlayersHandler(players *repository.Player, transactions *repository.Transaction,
+	audits *repository.Audit, twoFactor *services.TwoFactor, guard *services.LoginGuard,
+	limits *services.ResponsibleGaming, promotions *services.Promotions) *PlayersHandler {
+	return &PlayersHandler{players: players, transactions: transactions, audits: audits, twoFactor: twoFactor, guard: guard,
+		limits: limits, promotions: promotions}
 }
 
-func (playersHandler *PlayersHandler) GetAllPlayers(context *gin.Context) {
-	usernames, err := playersHandler.players.GetAllPlayerUsernames()
+// SearchPlayers returns a page of players whose username starts with or resembles q.
+// Sorted by username unless sort is rating or activity, pass next_cursor as cursor for the next page.
+func (playersHandler *PlayersHandler) SearchPlayers(context *gin.Context) {
+	var request model.PlayerSearchRequest
+	err := context.ShouldBindQuery(&request)
 	if err != nil {
-		logrus.Errorf("Unable to get players err: %s", err.Error())
+		logrus.Errorf("Unable to bind player search: %v", err)
 		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
 		return
 	}
 
-	context.JSON(http.StatusFound, usernames)
+	if request.Sort != "" && request.Sort != model.PlayerSortUsername &&
+		request.Sort != model.PlayerSortRating && request.Sort != model.PlayerSortActivity {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("sort must be %s, %s or %s",
+			model.PlayerSortUsername, model.PlayerSortRating, model.PlayerSortActivity)})
+		return
+	}
+
+	page, err := playersHandler.players.SearchPlayers(services.GetPlayerIDFromContext(context), request)
+	if err != nil {
+		logrus.Errorf("Unable to search players err: %s", err.Error())
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to search players"})
+		return
+	}
+
+	for i := range page.Players {
+		page.Players[i].Online = isOnline(page.Players[i].LastSeen)
+	}
+
+	context.JSON(http.StatusOK, page)
+}
+
+// GetPlayerProfile returns the public profile of a player
+func (playersHandler *PlayersHandler) GetPlayerProfile(context *gin.Context) {
+	profile, err := playersHandler.players.GetPlayerProfile(services.GetPlayerIDFromContext(context),
+		context.Param("username"))
+	if err != nil {
+		logrus.Errorf("Unable to get player profile err: %s", err.Error())
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve player"})
+		return
+	}
+	if profile == nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+		return
+	}
+
+	profile.Online = isOnline(profile.LastSeen)
+
+	context.JSON(http.StatusOK, profile)
 }
 
 func (playersHandler *PlayersHandler) TransferFunds(context *gin.Context) {
@@ -39,32 +90,80 @@ func (playersHandler *PlayersHandler) TransferFunds(context *gin.Context) {
 		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
 		return
 	}
+	amount := transactionRequest.Amount.Amount
+	if amount <= 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if transactionRequest.Amount.Currency != model.CurrencyCoin {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "funds are only moved in " + model.CurrencyCoin})
+		return
+	}
 	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+
+	// Withdrawals need a fresh second factor when the player has it enabled
+	if transactionRequest.Reason == model.ReasonWithdrawal && !playersHandler.verifyWithdrawalCode(context, userName, transactionRequest.TOTPCode) {
+		return
+	}
 
+	// Coins locked by bonuses stay in the account until the bonuses are wagered
+	if transactionRequest.Reason == model.ReasonWithdrawal &&
+		!mayWithdraw(context, playersHandler.promotions, playerID, amount) {
+		return
+	}
+
+	// Deposits count against the deposit limits and are blocked during a self-exclusion
+	if transactionRequest.Reason == model.ReasonDeposit {
+		if err = playersHandler.limits.CheckDeposit(playerID, amount); err != nil {
+			abortWithLimitError(context, err, "Unable to check limits")
+			return
+		}
+	}
+
+	// The balance and its transaction are written together, so the ledger always adds up to the balance
 	switch transactionRequest.Reason {
 	case model.ReasonDeposit:
-		err = playersHandler.players.SubtractPlayerBalance(userName, transactionRequest.Amount)
+		err = playersHandler.transactions.Credit(transactionRequest.Amount, model.ReasonDeposit, playerID)
 	case model.ReasonWithdrawal:
-		err = playersHandler.players.SubtractPlayerBalance(userName, transactionRequest.Amount)
+		err = playersHandler.transactions.Debit(transactionRequest.Amount, model.ReasonWithdrawal, playerID)
 	default:
 		logrus.Error("Wrong reason for funds transfer")
 		context.AbortWithStatusJSON(http.StatusBadRequest, "Wrong reason for funds transfer")
 		return
 	}
 
-	if err != nil {
-		logrus.Error("Unable to transfer funds")
+	if errors.Is(err, repository.ErrInsufficientBalance) {
 		context.AbortWithStatusJSON(http.StatusBadRequest,
 			gin.H{"message": "Unable to transfer funds", "error": err.Error()})
 		return
 	}
+	if err != nil {
+		logrus.Errorf("Unable to transfer funds: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to transfer funds"})
+		return
+	}
 
 	if transactionRequest.Reason == model.ReasonDeposit {
-		_ = playersHandler.transactions.AddTransaction(transactionRequest.Amount, model.ReasonDeposit, userName)
+		recordAudit(playersHandler.audits, context, userName, model.AuditFundsDeposited, userName,
+			gin.H{"amount": transactionRequest.Amount})
+	} else {
+		recordAudit(playersHandler.audits, context, userName, model.AuditFundsWithdrawn, userName,
+			gin.H{"amount": transactionRequest.Amount})
 	}
-	_ = playersHandler.transactions.AddTransaction(-transactionRequest.Amount, model.ReasonWithdrawal, userName)
 
 	context.JSON(http.StatusOK, fmt.Sprintf("Transaction %s is successful", transactionRequest.Reason))
 	return
 
 }
+
+func (playersHandler *PlayersHandler) verifyWithdrawalCode(context *gin.Context, userName string, code string) bool {
+	return requireTwoFactorCode(context, playersHandler.players, playersHandler.twoFactor, playersHandler.guard,
+		playersHandler.audits, userName, code, "withdrawals")
+}
+
+// isOnline tells whether a player was seen within the online window
+func isOnline(lastSeen *time.Time) bool {
+	window := time.Duration(config.Settings.OnlineWindowMinutes) * time.Minute
+	return lastSeen != nil && time.Since(*lastSeen) < window
+}
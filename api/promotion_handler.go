@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+type PromotionHandler struct {
+	promotions *repository.Promotion
+	service    *services.Promotions
+	audits     *repository.Audit
+}
+
+func NewPromotionHandler(promotions *repository.Promotion, service *services.Promotions,
+	audits *repository.Audit) *PromotionHandler {
+	return &PromotionHandler{
+		promotions: promotions,
+		service:    service,
+		audits:     audits,
+	}
+}
+
+// GetBonuses returns the caller's bonuses with how much of their balance is locked until they are wagered
+func (promotionHandler *PromotionHandler) GetBonuses(context *gin.Context) {
+	status, err := promotionHandler.service.Status(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		logrus.Errorf("Unable to fetch bonuses: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve bonuses"})
+		return
+	}
+
+	context.JSON(http.StatusOK, status)
+}
+
+// RedeemPromoCode credits the bonus of a running promo campaign, every code can be redeemed once per player
+func (promotionHandler *PromotionHandler) RedeemPromoCode(context *gin.Context) {
+	var request model.PromoCodeRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind promo code: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	bonus, err := promotionHandler.promotions.RedeemPromoCode(services.GetPlayerIDFromContext(context), request.Code)
+	if err != nil {
+		abortWithPromotionError(context, err, "Failed to redeem promo code")
+		return
+	}
+
+	recordAudit(promotionHandler.audits, context, userName, model.AuditBonusCredited, userName,
+		gin.H{"campaign": bonus.Campaign, "source": bonus.Source, "amount": bonus.Amount})
+
+	context.JSON(http.StatusCreated, bonus)
+}
+
+// GetReferrals returns the caller's referral code and the players who registered with it
+func (promotionHandler *PromotionHandler) GetReferrals(context *gin.Context) {
+	summary, err := promotionHandler.promotions.GetReferrals(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		logrus.Errorf("Unable to fetch referrals: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve referrals"})
+		return
+	}
+
+	context.JSON(http.StatusOK, summary)
+}
+
+// CreateCampaign defines a sign-up, referral or promo campaign
+func (promotionHandler *PromotionHandler) CreateCampaign(context *gin.Context) {
+	var request model.CampaignRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind campaign: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !model.IsCampaignKind(request.Kind) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown campaign kind"})
+		return
+	}
+	if (request.Kind == model.CampaignPromo) != (request.Code != "") {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "promo campaigns and only they need a code"})
+		return
+	}
+	if request.Amount <= 0 || request.RefereeAmount < 0 || request.BonusDays <= 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "amounts and bonus days must be positive"})
+		return
+	}
+	if request.WageringMultiplier < 0 || request.RequiredGames < 0 || request.MaxRedemptions < 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "values cannot be negative"})
+		return
+	}
+	if request.EndsAt != nil && (request.StartsAt != nil && !request.EndsAt.After(*request.StartsAt)) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "campaign must end after it starts"})
+		return
+	}
+
+	campaign, err := promotionHandler.promotions.CreateCampaign(request)
+	if err != nil {
+		abortWithPromotionError(context, err, "Failed to create campaign")
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(promotionHandler.audits, context, admin, model.AuditAdminAction, strconv.Itoa(campaign.ID), gin.H{
+		"operation": "campaign_created",
+		"name":      campaign.Name,
+		"kind":      campaign.Kind,
+		"amount":    campaign.Amount,
+	})
+
+	context.JSON(http.StatusCreated, campaign)
+}
+
+// GetCampaigns lists every campaign with the bonuses it credited
+func (promotionHandler *PromotionHandler) GetCampaigns(context *gin.Context) {
+	campaigns, err := promotionHandler.promotions.GetCampaigns()
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve campaigns"})
+		return
+	}
+
+	context.JSON(http.StatusOK, campaigns)
+}
+
+// EndCampaign stops a campaign, the bonuses it credited stay and pending referrals are still rewarded
+func (promotionHandler *PromotionHandler) EndCampaign(context *gin.Context) {
+	campaignID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid campaign id"})
+		return
+	}
+
+	if err = promotionHandler.promotions.EndCampaign(campaignID); err != nil {
+		abortWithPromotionError(context, err, "Failed to end campaign")
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(promotionHandler.audits, context, admin, model.AuditAdminAction, strconv.Itoa(campaignID),
+		gin.H{"operation": "campaign_ended"})
+
+	context.JSON(http.StatusOK, "Campaign ended")
+}
+
+// mayWithdraw aborts the request when taking amount play coins out of the player's account would touch coins
+// locked by bonuses that still have to be wagered
+func mayWithdraw(context *gin.Context, promotions *services.Promotions, playerID int, amount int) bool {
+	withdrawable, err := promotions.Withdrawable(playerID)
+	if err != nil {
+		logrus.Errorf("Unable to check bonuses: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to check bonuses"})
+		return false
+	}
+	if amount > withdrawable {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "funds are locked by bonuses until they are wagered",
+			"code": "bonus_locked", "withdrawable": withdrawable})
+		return false
+	}
+	return true
+}
+
+// abortWithPromotionError answers the known promotion errors with their message and anything else with fallback
+func abortWithPromotionError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrCampaignNotFound), errors.Is(err, repository.ErrPromoCodeInvalid):
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrPromoCodeTaken), errors.Is(err, repository.ErrPromoCodeRedeemed),
+		errors.Is(err, repository.ErrPromoCodeExhausted):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
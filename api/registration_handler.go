@@ -7,19 +7,23 @@ import (
 	"main/config"
 	"main/model"
 	"main/repository"
+	"main/services"
 	"net/http"
 )
 
 type RegistrationHandler struct {
-	players      *repository.Player
-	transactions *repository.Transaction
+	players    *repository.Player
+	audits     *repository.Audit
+	promotions *services.Promotions
 }
 
 func NewRegistrationHandler(playerRepository *repository.Player,
-	transactionRepository *repository.Transaction) *RegistrationHandler {
+	auditRepository *repository.Audit,
+	promotions *services.Promotions) *RegistrationHandler {
 	return &RegistrationHandler{
-		players:      playerRepository,
-		transactions: transactionRepository,
+		players:    playerRepository,
+		audits:     auditRepository,
+		promotions: promotions,
 	}
 }
 
@@ -33,12 +37,16 @@ func (regHandler *RegistrationHandler) Handle(context *gin.Context) {
 		return
 	}
 
-	if registration.Deposit < config.Settings.MinimumDeposit {
+	if registration.Deposit.Amount < config.Settings.MinimumDeposit {
 		logrus.Error("Deposit below minimum")
 		context.AbortWithStatusJSON(http.StatusBadRequest,
 			fmt.Sprintf("Deposit must be at least %d", config.Settings.MinimumDeposit))
 		return
 	}
+	if registration.Deposit.Currency != model.CurrencyCoin {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Deposit must be in "+model.CurrencyCoin)
+		return
+	}
 
 	player, err := regHandler.players.RegisterPlayer(&registration)
 	if err != nil {
@@ -48,10 +56,28 @@ func (regHandler *RegistrationHandler) Handle(context *gin.Context) {
 	}
 
 	logrus.Infof("Registered player with username %s", registration.Username)
+	recordAudit(regHandler.audits, context, registration.Username, model.AuditRegistration, registration.Username, nil)
+
+	recordAudit(regHandler.audits, context, registration.Username, model.AuditFundsDeposited, registration.Username,
+		gin.H{"amount": registration.Deposit, "source": "registration"})
 
-	err = regHandler.transactions.AddTransaction(registration.Deposit, model.ReasonDeposit, registration.Username)
+	// The registration goes through even when the promotions fail, a referral code matching no one is ignored
+	if registration.ReferralCode != "" {
+		referred, err := regHandler.promotions.Refer(player.ID, registration.ReferralCode)
+		if err != nil {
+			logrus.Errorf("Unable to record referral of %s: %v", registration.Username, err)
+		} else if !referred {
+			logrus.Infof("Referral code %s of %s does not apply", registration.ReferralCode, registration.Username)
+		}
+	}
+
+	bonus, err := regHandler.promotions.CreditSignupBonus(player.ID)
 	if err != nil {
-		logrus.Error("Failed to log transaction for deposit")
+		logrus.Errorf("Unable to credit sign-up bonus of %s: %v", registration.Username, err)
+	}
+	if bonus != nil {
+		recordAudit(regHandler.audits, context, registration.Username, model.AuditBonusCredited, registration.Username,
+			gin.H{"campaign": bonus.Campaign, "source": bonus.Source, "amount": bonus.Amount})
 	}
 
 	context.JSON(http.StatusCreated, gin.H{"Message": fmt.Sprintf("Player with username: %s registered.", player.Username)})
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"time"
+)
+
+type ResponsibleGamingHandler struct {
+	limits *services.ResponsibleGaming
+	audits *repository.Audit
+}
+
+func NewResponsibleGamingHandler(limits *services.ResponsibleGaming, audits *repository.Audit) *ResponsibleGamingHandler {
+	return &ResponsibleGamingHandler{
+		limits: limits,
+		audits: audits,
+	}
+}
+
+// GetLimits returns the caller's limits, how much of them is used and their self-exclusion
+func (responsibleGamingHandler *ResponsibleGamingHandler) GetLimits(context *gin.Context) {
+	status, err := responsibleGamingHandler.limits.Status(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		logrus.Errorf("Failed to retrieve limits: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve limits"})
+		return
+	}
+
+	context.JSON(http.StatusOK, status)
+}
+
+// SetLimit sets one of the caller's limits, loosening it only applies after the cooling-off period
+func (responsibleGamingHandler *ResponsibleGamingHandler) SetLimit(context *gin.Context) {
+	var request model.LimitRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind limit request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if !model.IsLimitKind(request.Kind) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "kind must be deposit, bet or loss"})
+		return
+	}
+	if !model.IsLimitPeriod(request.Period) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "period must be daily, weekly or monthly"})
+		return
+	}
+	if request.Amount < 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "amount cannot be negative"})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	if err = responsibleGamingHandler.limits.SetLimit(playerID, request); err != nil {
+		logrus.Errorf("Failed to set limit: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to set limit"})
+		return
+	}
+
+	recordAudit(responsibleGamingHandler.audits, context, userName, model.AuditLimitChanged, userName,
+		gin.H{"kind": request.Kind, "period": request.Period, "amount": request.Amount})
+
+	responsibleGamingHandler.GetLimits(context)
+}
+
+// Exclude blocks the caller from betting and depositing for the given number of days, it cannot be undone
+func (responsibleGamingHandler *ResponsibleGamingHandler) Exclude(context *gin.Context) {
+	var request model.SelfExclusionRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind self-exclusion request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if request.Days <= 0 || request.Days > config.Settings.MaxSelfExclusionDays {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("days must be between 1 and %d", config.Settings.MaxSelfExclusionDays)})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	excludedUntil, err := responsibleGamingHandler.limits.Exclude(services.GetPlayerIDFromContext(context), request.Days)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to exclude player"})
+		return
+	}
+
+	recordAudit(responsibleGamingHandler.audits, context, userName, model.AuditSelfExcluded, userName,
+		gin.H{"days": request.Days, "excluded_until": excludedUntil.Format(time.RFC3339)})
+
+	context.JSON(http.StatusOK, gin.H{"excluded_until": excludedUntil})
+}
+
+// mayBet aborts the request when the player is self-excluded or staking amount would go over one of their limits
+func mayBet(context *gin.Context, limits *services.ResponsibleGaming, playerID int, amount int) bool {
+	if err := limits.CheckBet(playerID, amount); err != nil {
+		abortWithLimitError(context, err, "Unable to check limits")
+		return false
+	}
+	return true
+}
+
+// abortWithLimitError answers a self-exclusion or an exceeded limit with 403 and a code telling them apart,
+// e.g. self_excluded or loss_limit_exceeded, and anything else with fallback
+func abortWithLimitError(context *gin.Context, err error, fallback string) {
+	var limitError *services.LimitError
+	switch {
+	case errors.As(err, &limitError):
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": limitError.Code(),
+			"limit": limitError.Limit, "used": limitError.Used})
+	case errors.Is(err, services.ErrSelfExcluded):
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "self_excluded"})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -12,15 +12,47 @@ import (
 )
 
 type Dependencies struct {
-	PlayerRepository      *repository.Player
-	ChallengeRepository   *repository.Challenger
-	TransactionRepository *repository.Transaction
+	PlayerRepository         *repository.Player
+	ChallengeRepository      *repository.Challenger
+	TransactionRepository    *repository.Transaction
+	AuditRepository          *repository.Audit
+	LoginAttemptRepository   *repository.LoginAttempt
+	RecoveryCodeRepository   *repository.RecoveryCode
+	FriendRepository         *repository.Friend
+	MatchmakingRepository    *repository.MatchmakingTicket
+	TournamentRepository     *repository.Tournament
+	FreeForAllRepository     *repository.FreeForAll
+	TemplateRepository       *repository.ChallengeTemplate
+	HouseRepository          *repository.House
+	FairnessRepository       *repository.Fairness
+	LimitRepository          *repository.Limit
+	TransferRepository       *repository.Transfer
+	WalletRepository         *repository.Wallet
+	ReconciliationRepository *repository.Reconciliation
+	PromotionRepository      *repository.Promotion
+	HoldRepository           *repository.Hold
+	RateLimitStore           services.RateLimitStore
 
 	RegistrationHandler *RegistrationHandler
 	LoginHandler        *LoginHandler
 	PlayersHandler      *PlayersHandler
 	ChallengeHandler    *ChallengeHandler
 	TransactionHandler  *TransactionHandler
+	AuditHandler        *AuditHandler
+	LockoutHandler      *LockoutHandler
+	TwoFactorHandler    *TwoFactorHandler
+	AccountHandler      *AccountHandler
+	FriendHandler       *FriendHandler
+	MatchmakingHandler  *MatchmakingHandler
+	TournamentHandler   *TournamentHandler
+	FreeForAllHandler   *FreeForAllHandler
+	TemplateHandler     *ChallengeTemplateHandler
+	HouseHandler        *HouseHandler
+	FairnessHandler     *FairnessHandler
+	LimitsHandler       *ResponsibleGamingHandler
+	TransferHandler     *TransferHandler
+	WalletHandler       *WalletHandler
+	PromotionHandler    *PromotionHandler
 }
 
 var dependencies *Dependencies
@@ -38,27 +70,186 @@ func StartServer() {
 		})
 	})
 
+	rateLimits := dependencies.RateLimitStore
+
+	public := router.Group("/")
+	public.Use(services.RateLimit(rateLimits, "public"))
+
 	authorized := router.Group("/")
-	authorized.Use(services.AuthenticateUser)
+	authorized.Use(services.AuthenticateUser(dependencies.PlayerRepository), services.RateLimit(rateLimits, "authorized"))
 
 	// Register new players
-	router.POST("/registration", dependencies.RegistrationHandler.Handle)
+	public.POST("/registration", dependencies.RegistrationHandler.Handle)
 	// Try to log in a player
-	router.POST("/login", dependencies.LoginHandler.Handle)
-	// Find available players
-	authorized.GET("/players", dependencies.PlayersHandler.GetAllPlayers)
+	public.POST("/login", dependencies.LoginHandler.Handle)
+	// Finish a login with the second factor
+	public.POST("/login/2fa", dependencies.LoginHandler.VerifySecondFactor)
+	// Search players
+	authorized.GET("/players", dependencies.PlayersHandler.SearchPlayers)
+	// Public profile of a player
+	authorized.GET("/players/:username", dependencies.PlayersHandler.GetPlayerProfile)
 	// Deposit or withdraw
 	authorized.POST("/funds", dependencies.PlayersHandler.TransferFunds)
 	// Challenger player
-	authorized.POST("/challenge", dependencies.ChallengeHandler.Create)
+	authorized.POST("/challenge", services.RateLimit(rateLimits, "challenge"), dependencies.ChallengeHandler.Create)
 	// Settle challenge
 	authorized.POST("/challenge/settle", dependencies.ChallengeHandler.Settle)
 	// Decline challenge
 	authorized.POST("/challenge/decline", dependencies.ChallengeHandler.Decline)
+	// Counter a challenge with a different bet
+	authorized.POST("/challenge/counter", dependencies.ChallengeHandler.Counter)
+	// Accept the opponent's counter-offer
+	authorized.POST("/challenge/counter/accept", dependencies.ChallengeHandler.AcceptCounter)
+	// Reject the opponent's counter-offer
+	authorized.POST("/challenge/counter/reject", dependencies.ChallengeHandler.RejectCounter)
 	// Get pending challenges
 	authorized.GET("/challenge/pending", dependencies.ChallengeHandler.GetPendingChallenges)
+	// Get sent and received challenges in every state
+	authorized.GET("/challenges", dependencies.ChallengeHandler.GetChallenges)
+	// Get a single challenge with both moves and its transactions
+	authorized.GET("/challenges/:id", dependencies.ChallengeHandler.GetChallenge)
+	// Challenge the other player of a settled challenge again
+	authorized.POST("/challenges/:id/rematch", services.RateLimit(rateLimits, "challenge"), dependencies.ChallengeHandler.Rematch)
+	// List saved challenge templates
+	authorized.GET("/challenge-templates", dependencies.TemplateHandler.GetTemplates)
+	// Save a challenge template
+	authorized.POST("/challenge-templates", dependencies.TemplateHandler.Create)
+	// Delete a challenge template
+	authorized.DELETE("/challenge-templates/:id", dependencies.TemplateHandler.Delete)
+	// Challenge with a saved template
+	authorized.POST("/challenge-templates/:id/fire", services.RateLimit(rateLimits, "challenge"), dependencies.TemplateHandler.Fire)
 	// Get pending transactions
-	authorized.GET("/transactions", dependencies.TransactionHandler.GetTransactionsByUsername)
+	authorized.GET("/transactions", dependencies.TransactionHandler.GetTransactions)
+	// Download a statement of the player's transactions
+	authorized.GET("/transactions/export", dependencies.TransactionHandler.Export)
+	// Start enabling two factor authentication
+	authorized.POST("/2fa/enroll", dependencies.TwoFactorHandler.Enroll)
+	// Enable two factor authentication with a first code
+	authorized.POST("/2fa/confirm", dependencies.TwoFactorHandler.Confirm)
+	// Disable two factor authentication
+	authorized.POST("/2fa/disable", dependencies.TwoFactorHandler.Disable)
+	// Change password, revokes other sessions
+	authorized.PUT("/account/password", dependencies.AccountHandler.ChangePassword)
+	// Change username
+	authorized.PUT("/account/username", dependencies.AccountHandler.ChangeUsername)
+	// Close account
+	authorized.DELETE("/account", dependencies.AccountHandler.Close)
+	// Allow challenges from friends only or from everyone
+	authorized.PUT("/account/challenge-settings", dependencies.AccountHandler.ChangeChallengeSettings)
+	// List friends and friend requests
+	authorized.GET("/friends", dependencies.FriendHandler.GetFriends)
+	// Send a friend request
+	authorized.POST("/friends/requests", dependencies.FriendHandler.RequestFriendship)
+	// Accept a friend request
+	authorized.POST("/friends/accept", dependencies.FriendHandler.AcceptFriendship)
+	// Remove a friend, cancel or decline a friend request
+	authorized.DELETE("/friends/:username", dependencies.FriendHandler.RemoveFriendship)
+	// List blocked players
+	authorized.GET("/blocks", dependencies.FriendHandler.GetBlockedPlayers)
+	// Block a player
+	authorized.POST("/blocks", dependencies.FriendHandler.Block)
+	// Unblock a player
+	authorized.DELETE("/blocks/:username", dependencies.FriendHandler.Unblock)
+	// Queue for a quick game
+	authorized.POST("/matchmaking/queue", services.RateLimit(rateLimits, "challenge"), dependencies.MatchmakingHandler.Join)
+	// Leave the queue
+	authorized.DELETE("/matchmaking/queue", dependencies.MatchmakingHandler.Leave)
+	// Queue status or the last game
+	authorized.GET("/matchmaking/queue", dependencies.MatchmakingHandler.Status)
+	// List tournaments
+	authorized.GET("/tournaments", dependencies.TournamentHandler.GetTournaments)
+	// Tournament with standings and matches
+	authorized.GET("/tournaments/:id", dependencies.TournamentHandler.GetTournament)
+	// Register for a tournament, holds the entry fee
+	authorized.POST("/tournaments/:id/join", dependencies.TournamentHandler.Join)
+	// Unregister before the start, refunds the entry fee
+	authorized.POST("/tournaments/:id/leave", dependencies.TournamentHandler.Leave)
+	// Play a move in a tournament match
+	authorized.POST("/tournaments/:id/matches/:match/move", dependencies.TournamentHandler.Move)
+	// Open a free-for-all round
+	authorized.POST("/ffa", services.RateLimit(rateLimits, "challenge"), dependencies.FreeForAllHandler.Create)
+	// List free-for-all rounds
+	authorized.GET("/ffa", dependencies.FreeForAllHandler.GetRounds)
+	// Free-for-all round with its participants
+	authorized.GET("/ffa/:id", dependencies.FreeForAllHandler.GetRound)
+	// Join a free-for-all round with a move
+	authorized.POST("/ffa/:id/join", dependencies.FreeForAllHandler.Join)
+	// Bet against the house
+	authorized.POST("/house/games", services.RateLimit(rateLimits, "challenge"), dependencies.HouseHandler.Play)
+	// Latest games against the house with their seeds
+	authorized.GET("/house/games", dependencies.HouseHandler.GetGames)
+	// Seed pair in use, the server seed only as its hash
+	authorized.GET("/fairness/seed", dependencies.FairnessHandler.GetSeed)
+	// Every seed pair, revealed server seeds included
+	authorized.GET("/fairness/seeds", dependencies.FairnessHandler.GetSeeds)
+	// Reveal the server seed and commit to a new one
+	authorized.POST("/fairness/rotate", dependencies.FairnessHandler.Rotate)
+	// Recompute a move from revealed seeds
+	authorized.GET("/fairness/verify", dependencies.FairnessHandler.Verify)
+	// Deposit, bet and loss limits and self-exclusion
+	authorized.GET("/limits", dependencies.LimitsHandler.GetLimits)
+	// Set a limit, loosening it waits for the cooling-off period
+	authorized.PUT("/limits", dependencies.LimitsHandler.SetLimit)
+	// Exclude oneself from betting and depositing for a number of days
+	authorized.POST("/self-exclusion", dependencies.LimitsHandler.Exclude)
+	// Send coins to another player
+	authorized.POST("/transfers", services.RateLimit(rateLimits, "transfer"), dependencies.TransferHandler.Send)
+	// Transfers sent and received
+	authorized.GET("/transfers", dependencies.TransferHandler.GetTransfers)
+	// Confirm a large transfer
+	authorized.POST("/transfers/:id/confirm", services.RateLimit(rateLimits, "transfer"), dependencies.TransferHandler.Confirm)
+	// Cancel a transfer waiting for confirmation
+	authorized.DELETE("/transfers/:id", dependencies.TransferHandler.Cancel)
+	// Currencies players can hold
+	authorized.GET("/currencies", dependencies.WalletHandler.GetCurrencies)
+	// Balance in every currency
+	authorized.GET("/wallets", dependencies.WalletHandler.GetWallets)
+	// Balance in one currency with the bets held by open challenges
+	authorized.GET("/balance", dependencies.WalletHandler.GetBalance)
+	// Convert between currencies at the admin's rules
+	authorized.POST("/wallets/convert", dependencies.WalletHandler.Convert)
+	// Bonuses and the balance they lock until wagered
+	authorized.GET("/bonuses", dependencies.PromotionHandler.GetBonuses)
+	// Redeem a promo code for a bonus
+	authorized.POST("/promotions/redeem", services.RateLimit(rateLimits, "promo"), dependencies.PromotionHandler.RedeemPromoCode)
+	// Referral code and the players who registered with it
+	authorized.GET("/referrals", dependencies.PromotionHandler.GetReferrals)
+
+	admin := authorized.Group("/admin")
+	admin.Use(services.AuthorizeAdmin, services.RateLimit(rateLimits, "admin"))
+
+	// Query the audit log
+	admin.GET("/audit", dependencies.AuditHandler.Query)
+	// Walk the audit hash chain
+	admin.GET("/audit/verify", dependencies.AuditHandler.Verify)
+	// List locked out usernames and ips
+	admin.GET("/lockouts", dependencies.LockoutHandler.GetLockouts)
+	// Lift a login lockout
+	admin.POST("/lockouts/unlock", dependencies.LockoutHandler.Unlock)
+	// Download a statement of one player or of all players
+	admin.GET("/transactions/export", dependencies.TransactionHandler.ExportForAdmin)
+	// Open a tournament for registration
+	admin.POST("/tournaments", dependencies.TournamentHandler.Create)
+	// Seed the players and start the first round
+	admin.POST("/tournaments/:id/start", dependencies.TournamentHandler.Start)
+	// Cancel a tournament and refund the entry fees
+	admin.POST("/tournaments/:id/cancel", dependencies.TournamentHandler.Cancel)
+	// House bankroll and profit and loss
+	admin.GET("/house/report", dependencies.HouseHandler.Report)
+	// Add to or take from the house bankroll
+	admin.POST("/house/funds", dependencies.HouseHandler.Fund)
+	// Rake collected from settled challenges
+	admin.GET("/revenue", dependencies.HouseHandler.Revenue)
+	// Rules converting one currency into another
+	admin.GET("/conversion-rules", dependencies.WalletHandler.GetConversionRules)
+	// Create, change or disable a conversion rule
+	admin.PUT("/conversion-rules", dependencies.WalletHandler.SetConversionRule)
+	// Campaigns with the bonuses they credited
+	admin.GET("/campaigns", dependencies.PromotionHandler.GetCampaigns)
+	// Define a sign-up, referral or promo campaign
+	admin.POST("/campaigns", dependencies.PromotionHandler.CreateCampaign)
+	// Stop a campaign
+	admin.POST("/campaigns/:id/end", dependencies.PromotionHandler.EndCampaign)
 
 	err := router.Run(fmt.Sprintf(":%s", config.Settings.ServerPort))
 	if err != nil {
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"main/model"
+	"strconv"
+	"time"
+)
+
+// statementWriter streams an account statement, entries are written as they come from the database
+type statementWriter interface {
+	ContentType() string
+	Opening(balance int) error
+	Entry(entry model.StatementEntry) error
+	Closing(balance int) error
+}
+
+func newStatementWriter(format string, writer io.Writer, account string, from, to time.Time) statementWriter {
+	if format == model.StatementFormatJSON {
+		return &jsonStatementWriter{writer: writer, account: account, from: from, to: to}
+	}
+	return &csvStatementWriter{writer: csv.NewWriter(writer), from: from, to: to}
+}
+
+// csvStatementWriter writes one row per record, the first column tells opening, transaction and closing rows apart
+type csvStatementWriter struct {
+	writer   *csv.Writer
+	from, to time.Time
+}
+
+func (statement *csvStatementWriter) ContentType() string {
+	return "text/csv"
+}
+
+func (statement *csvStatementWriter) Opening(balance int) error {
+	err := statement.writer.Write([]string{"record", "timestamp", "transaction_id", "username", "reason", "amount",
+		"balance", "challenge_id", "challenge_state", "challenger", "opponent"})
+	if err != nil {
+		return err
+	}
+	return statement.write([]string{"opening", formatStatementTime(statement.from), "", "", "", "",
+		strconv.Itoa(balance), "", "", "", ""})
+}
+
+func (statement *csvStatementWriter) Entry(entry model.StatementEntry) error {
+	challengeID := ""
+	if entry.ChallengeID != nil {
+		challengeID = strconv.Itoa(*entry.ChallengeID)
+	}
+	return statement.write([]string{"transaction", formatStatementTime(entry.Timestamp), strconv.Itoa(entry.TransactionID),
+		entry.Username, entry.Reason, strconv.Itoa(entry.Amount), strconv.Itoa(entry.Balance), challengeID,
+		entry.ChallengeState, entry.Challenger, entry.Opponent})
+}
+
+func (statement *csvStatementWriter) Closing(balance int) error {
+	return statement.write([]string{"closing", formatStatementTime(statement.to), "", "", "", "",
+		strconv.Itoa(balance), "", "", "", ""})
+}
+
+func (statement *csvStatementWriter) write(record []string) error {
+	err := statement.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	statement.writer.Flush()
+	return statement.writer.Error()
+}
+
+// jsonStatementWriter writes a single object, the transactions array is streamed element by element
+type jsonStatementWriter struct {
+	writer   io.Writer
+	account  string
+	from, to time.Time
+	entries  int
+}
+
+func (statement *jsonStatementWriter) ContentType() string {
+	return "application/json"
+}
+
+func (statement *jsonStatementWriter) Opening(balance int) error {
+	account, err := json.Marshal(statement.account)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(statement.writer, `{"account":%s,"from":"%s","to":"%s","opening_balance":%d,"transactions":[`,
+		account, formatStatementTime(statement.from), formatStatementTime(statement.to), balance)
+	return err
+}
+
+func (statement *jsonStatementWriter) Entry(entry model.StatementEntry) error {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if statement.entries > 0 {
+		if _, err = io.WriteString(statement.writer, ","); err != nil {
+			return err
+		}
+	}
+	statement.entries++
+	_, err = statement.writer.Write(encoded)
+	return err
+}
+
+func (statement *jsonStatementWriter) Closing(balance int) error {
+	_, err := fmt.Fprintf(statement.writer, `],"closing_balance":%d}`, balance)
+	return err
+}
+
+func formatStatementTime(timestamp time.Time) string {
+	return timestamp.UTC().Format(time.RFC3339)
+}
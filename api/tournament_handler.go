@@ -0,0 +1,297 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+)
+
+type TournamentHandler struct {
+	tournaments *repository.Tournament
+	service     *services.Tournaments
+	limits      *services.ResponsibleGaming
+	audits      *repository.Audit
+}
+
+func NewTournamentHandler(tournaments *repository.Tournament, service *services.Tournaments, limits *services.ResponsibleGaming,
+	audits *repository.Audit) *TournamentHandler {
+	return &TournamentHandler{
+		tournaments: tournaments,
+		service:     service,
+		limits:      limits,
+		audits:      audits,
+	}
+}
+
+// Create opens a new tournament for registration
+func (tournamentHandler *TournamentHandler) Create(context *gin.Context) {
+	var request model.TournamentRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind tournament request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.RuleSet == "" {
+		request.RuleSet = model.RuleSetClassic
+	}
+	if len(request.PrizeSplit) == 0 {
+		request.PrizeSplit = config.Settings.TournamentPrizeSplit
+	}
+	if message := validateTournament(request); message != "" {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	tournament := model.Tournament{
+		Name:                 request.Name,
+		Format:               request.Format,
+		RuleSet:              request.RuleSet,
+		EntryFee:             request.EntryFee,
+		MaxPlayers:           request.MaxPlayers,
+		MatchDeadlineMinutes: request.MatchDeadlineMinutes,
+		PrizeSplit:           request.PrizeSplit,
+	}
+	err = tournamentHandler.tournaments.CreateTournament(&tournament)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tournament"})
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(tournamentHandler.audits, context, admin, model.AuditTournamentCreated, strconv.Itoa(tournament.ID),
+		gin.H{"name": tournament.Name, "format": tournament.Format, "entry_fee": tournament.EntryFee,
+			"max_players": tournament.MaxPlayers, "prize_split": tournament.PrizeSplit})
+
+	context.JSON(http.StatusCreated, tournament)
+}
+
+// Start closes the registration and creates the first round
+func (tournamentHandler *TournamentHandler) Start(context *gin.Context) {
+	tournamentID, ok := tournamentIDParam(context)
+	if !ok {
+		return
+	}
+
+	err := tournamentHandler.service.Start(tournamentID)
+	if err != nil {
+		abortWithTournamentError(context, err, "Failed to start tournament")
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(tournamentHandler.audits, context, admin, model.AuditTournamentStarted, strconv.Itoa(tournamentID), nil)
+
+	tournamentHandler.respondWithDetail(context, tournamentID)
+}
+
+// Cancel ends the tournament without a winner and refunds every entry fee
+func (tournamentHandler *TournamentHandler) Cancel(context *gin.Context) {
+	tournamentID, ok := tournamentIDParam(context)
+	if !ok {
+		return
+	}
+
+	err := tournamentHandler.tournaments.CancelTournament(tournamentID)
+	if err != nil {
+		abortWithTournamentError(context, err, "Failed to cancel tournament")
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(tournamentHandler.audits, context, admin, model.AuditTournamentEnded, strconv.Itoa(tournamentID),
+		gin.H{"state": model.TournamentCancelled})
+
+	context.JSON(http.StatusOK, "Successfully cancelled tournament, entry fees are refunded")
+}
+
+// GetTournaments lists the tournaments, newest first, optionally only those in the given state
+func (tournamentHandler *TournamentHandler) GetTournaments(context *gin.Context) {
+	state := context.Query("state")
+	if state != "" && !model.IsTournamentState(state) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown state " + state})
+		return
+	}
+
+	tournaments, err := tournamentHandler.tournaments.GetTournaments(state)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tournaments"})
+		return
+	}
+
+	context.JSON(http.StatusOK, tournaments)
+}
+
+// GetTournament returns the tournament with its standings and matches
+func (tournamentHandler *TournamentHandler) GetTournament(context *gin.Context) {
+	tournamentID, ok := tournamentIDParam(context)
+	if !ok {
+		return
+	}
+
+	tournamentHandler.respondWithDetail(context, tournamentID)
+}
+
+// Join registers the player and holds the entry fee
+func (tournamentHandler *TournamentHandler) Join(context *gin.Context) {
+	tournamentID, ok := tournamentIDParam(context)
+	if !ok {
+		return
+	}
+
+	tournament, err := tournamentHandler.tournaments.GetTournament(tournamentID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tournament"})
+		return
+	}
+	if tournament == nil {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": repository.ErrTournamentNotFound.Error()})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	if !mayBet(context, tournamentHandler.limits, playerID, tournament.EntryFee) {
+		return
+	}
+
+	err = tournamentHandler.tournaments.JoinTournament(tournamentID, playerID)
+	if err != nil {
+		abortWithTournamentError(context, err, "Failed to join tournament")
+		return
+	}
+
+	recordAudit(tournamentHandler.audits, context, userName, model.AuditTournamentJoined, strconv.Itoa(tournamentID), nil)
+
+	context.JSON(http.StatusOK, "Successfully joined tournament")
+}
+
+// Leave unregisters the player before the start and refunds the entry fee
+func (tournamentHandler *TournamentHandler) Leave(context *gin.Context) {
+	tournamentID, ok := tournamentIDParam(context)
+	if !ok {
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	err := tournamentHandler.tournaments.LeaveTournament(tournamentID, services.GetPlayerIDFromContext(context))
+	if err != nil {
+		abortWithTournamentError(context, err, "Failed to leave tournament")
+		return
+	}
+
+	recordAudit(tournamentHandler.audits, context, userName, model.AuditTournamentLeft, strconv.Itoa(tournamentID), nil)
+
+	context.JSON(http.StatusOK, "Successfully left tournament")
+}
+
+// Move plays the player's move in one of their matches
+func (tournamentHandler *TournamentHandler) Move(context *gin.Context) {
+	tournamentID, ok := tournamentIDParam(context)
+	if !ok {
+		return
+	}
+	matchID, err := strconv.Atoi(context.Param("match"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid match id"})
+		return
+	}
+
+	var request model.TournamentMoveRequest
+	err = context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind tournament move: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if isValidChoice(request.Choice) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, "Invalid choice")
+		return
+	}
+
+	response, err := tournamentHandler.service.Move(services.GetPlayerIDFromContext(context), tournamentID, matchID,
+		request.Choice)
+	if err != nil {
+		abortWithTournamentError(context, err, "Failed to play the move, try again")
+		return
+	}
+
+	context.JSON(http.StatusOK, response)
+}
+
+func (tournamentHandler *TournamentHandler) respondWithDetail(context *gin.Context, tournamentID int) {
+	detail, err := tournamentHandler.service.Detail(tournamentID)
+	if err != nil {
+		abortWithTournamentError(context, err, "Failed to retrieve tournament")
+		return
+	}
+
+	context.JSON(http.StatusOK, detail)
+}
+
+func tournamentIDParam(context *gin.Context) (int, bool) {
+	tournamentID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid tournament id"})
+		return 0, false
+	}
+	return tournamentID, true
+}
+
+// abortWithTournamentError answers the known tournament errors with their message and anything else with fallback
+func abortWithTournamentError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrTournamentNotFound), errors.Is(err, services.ErrMatchNotFound):
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotInMatch):
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrInsufficientBalance), errors.Is(err, services.ErrNotEnoughPlayers):
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrTournamentNotOpen), errors.Is(err, repository.ErrTournamentFull),
+		errors.Is(err, repository.ErrAlreadyRegistered), errors.Is(err, repository.ErrNotRegistered),
+		errors.Is(err, repository.ErrTournamentNotCancellable), errors.Is(err, services.ErrMatchDecided),
+		errors.Is(err, services.ErrAlreadyMoved), errors.Is(err, services.ErrTournamentNotActive):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// validateTournament returns what is wrong with the request, or an empty string
+func validateTournament(request model.TournamentRequest) string {
+	if !model.IsTournamentFormat(request.Format) {
+		return "format must be single_elimination or round_robin"
+	}
+	if !model.IsRuleSet(request.RuleSet) {
+		return "unknown rule set"
+	}
+	if request.EntryFee < 0 {
+		return "entry_fee cannot be negative"
+	}
+	if request.MaxPlayers < 2 {
+		return "max_players must be at least 2"
+	}
+	if request.MatchDeadlineMinutes <= 0 {
+		return "match_deadline_minutes must be positive"
+	}
+
+	total := 0
+	for _, share := range request.PrizeSplit {
+		if share <= 0 {
+			return "prize_split shares must be positive"
+		}
+		total += share
+	}
+	if total != 100 {
+		return "prize_split must add up to 100"
+	}
+	return ""
+}
@@ -1,31 +1,211 @@
 package api
 
 import (
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"main/model"
 	"main/repository"
 	"main/services"
 	"net/http"
+	"time"
 )
 
+// systemStatementAccount names the account of a statement covering every player
+const systemStatementAccount = "all"
+
 type TransactionHandler struct {
 	transactions *repository.Transaction
+	players      *repository.Player
+	audits       *repository.Audit
 }
 
-func NewTransactionHandler(transactions *repository.Transaction) *TransactionHandler {
+func NewTransactionHandler(transactions *repository.Transaction, players *repository.Player, audits *repository.Audit) *TransactionHandler {
 	return &TransactionHandler{
 		transactions: transactions,
+		players:      players,
+		audits:       audits,
 	}
 }
 
-func (transactionHandler *TransactionHandler) GetTransactionsByUsername(context *gin.Context) {
-	userName := services.GetSubjectFromContext(context)
-	userTransactions, err := transactionHandler.transactions.GetTransactionsByUsername(userName)
+// GetTransactions returns a page of the player's transactions, newest first unless sort=asc.
+// Filters: currency, reason (repeatable), min_amount, max_amount, from, to. Pass next_cursor as cursor for the next page.
+func (transactionHandler *TransactionHandler) GetTransactions(context *gin.Context) {
+	var filter model.TransactionFilter
+	err := context.ShouldBindQuery(&filter)
+	if err != nil {
+		logrus.Errorf("Unable to bind transaction filter: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = validateTransactionFilter(filter)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	page, err := transactionHandler.transactions.GetTransactionsPage(playerID, filter)
 	if err != nil {
 		logrus.Error("Unable to get transactions for user")
 		context.AbortWithStatusJSON(http.StatusInternalServerError, "Failed to retrieve transactions")
 		return
 	}
 
-	context.JSON(http.StatusOK, userTransactions)
+	context.JSON(http.StatusOK, page)
+}
+
+// Export streams the player's statement for a period as csv (default) or json.
+// The period defaults to the current month, to defaults to now, and the currency to play coins.
+func (transactionHandler *TransactionHandler) Export(context *gin.Context) {
+	request, ok := bindStatementRequest(context)
+	if !ok {
+		return
+	}
+
+	transactionHandler.streamStatement(context, services.GetPlayerIDFromContext(context),
+		services.GetSubjectFromContext(context), request)
+}
+
+// ExportForAdmin streams the statement of the player given by username, or of all players when it is empty
+func (transactionHandler *TransactionHandler) ExportForAdmin(context *gin.Context) {
+	request, ok := bindStatementRequest(context)
+	if !ok {
+		return
+	}
+
+	playerID := 0
+	account := systemStatementAccount
+	if request.Username != "" {
+		id, err := transactionHandler.players.FindPlayerID(request.Username)
+		if err != nil {
+			logrus.Errorf("Unable to find player %s: %v", request.Username, err)
+			context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to export statement"})
+			return
+		}
+		if id == 0 {
+			context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+			return
+		}
+		playerID = id
+		account = request.Username
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(transactionHandler.audits, context, admin, model.AuditAdminAction, account, gin.H{
+		"operation": "statement_export",
+		"format":    request.Format,
+		"from":      request.From,
+		"to":        request.To,
+	})
+
+	transactionHandler.streamStatement(context, playerID, account, request)
+}
+
+func (transactionHandler *TransactionHandler) streamStatement(context *gin.Context, playerID int, account string,
+	request model.StatementRequest) {
+	opening, err := transactionHandler.transactions.GetBalanceBefore(playerID, request.Currency, request.From)
+	if err != nil {
+		logrus.Errorf("Unable to get opening balance of %s", account)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to export statement"})
+		return
+	}
+
+	statement := newStatementWriter(request.Format, context.Writer, account, request.From, request.To)
+	context.Header("Content-Type", statement.ContentType())
+	context.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="statement-%s-%s.%s"`,
+		account, request.From.UTC().Format("2006-01-02"), request.Format))
+	context.Status(http.StatusOK)
+
+	// The status is sent with the first write, past that point errors can only cut the statement short
+	if err = statement.Opening(opening); err != nil {
+		logrus.Errorf("Unable to write statement of %s: %v", account, err)
+		return
+	}
+
+	closing := opening
+	err = transactionHandler.transactions.StreamStatement(playerID, request.Currency, request.From, request.To, opening,
+		func(entry model.StatementEntry) error {
+			closing = entry.Balance
+			if err := statement.Entry(entry); err != nil {
+				return err
+			}
+			context.Writer.Flush()
+			return nil
+		})
+	if err != nil {
+		logrus.Errorf("Unable to stream statement of %s: %v", account, err)
+		return
+	}
+
+	if err = statement.Closing(closing); err != nil {
+		logrus.Errorf("Unable to write statement of %s: %v", account, err)
+	}
+}
+
+func bindStatementRequest(context *gin.Context) (model.StatementRequest, bool) {
+	var request model.StatementRequest
+	err := context.ShouldBindQuery(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind statement request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return request, false
+	}
+
+	if request.Format == "" {
+		request.Format = model.StatementFormatCSV
+	}
+	if request.Format != model.StatementFormatCSV && request.Format != model.StatementFormatJSON {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format must be %s or %s",
+			model.StatementFormatCSV, model.StatementFormatJSON)})
+		return request, false
+	}
+
+	if request.Currency == "" {
+		request.Currency = model.CurrencyCoin
+	}
+	if !isCurrency(request.Currency) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown currency: " + request.Currency})
+		return request, false
+	}
+
+	if request.To.IsZero() {
+		request.To = time.Now().UTC()
+	}
+	if request.From.IsZero() {
+		request.From = time.Date(request.To.Year(), request.To.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	if !request.From.Before(request.To) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return request, false
+	}
+
+	return request, true
+}
+
+func validateTransactionFilter(filter model.TransactionFilter) error {
+	if filter.Currency != "" && !isCurrency(filter.Currency) {
+		return fmt.Errorf("unknown currency: %s", filter.Currency)
+	}
+
+	for _, reason := range filter.Reasons {
+		if !model.IsTransactionReason(reason) {
+			return fmt.Errorf("unknown reason: %s", reason)
+		}
+	}
+
+	if filter.Sort != "" && filter.Sort != model.SortAscending && filter.Sort != model.SortDescending {
+		return fmt.Errorf("sort must be %s or %s", model.SortAscending, model.SortDescending)
+	}
+
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		return fmt.Errorf("min_amount is greater than max_amount")
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return fmt.Errorf("from must be before to")
+	}
+
+	return nil
 }
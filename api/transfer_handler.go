@@ -0,0 +1,251 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"io"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxTransferMemoLength keeps memos to a short note
+const maxTransferMemoLength = 140
+
+type TransferHandler struct {
+	transfers  *repository.Transfer
+	players    *repository.Player
+	friends    *repository.Friend
+	audits     *repository.Audit
+	promotions *services.Promotions
+	twoFactor  *services.TwoFactor
+	guard      *services.LoginGuard
+}
+
+func NewTransferHandler(transfers *repository.Transfer, players *repository.Player, friends *repository.Friend,
+	audits *repository.Audit, promotions *services.Promotions, twoFactor *services.TwoFactor,
+	guard *services.LoginGuard) *TransferHandler {
+	return &TransferHandler{
+		transfers:  transfers,
+		players:    players,
+		friends:    friends,
+		audits:     audits,
+		promotions: promotions,
+		twoFactor:  twoFactor,
+		guard:      guard,
+	}
+}
+
+// Send transfers coins to another player, amounts from transfer_confirmation_threshold on wait for a confirmation
+func (transferHandler *TransferHandler) Send(context *gin.Context) {
+	var request model.TransferRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind transfer request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.Amount.Amount <= 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if request.Amount.Currency != model.CurrencyCoin {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "transfers are only made in " + model.CurrencyCoin})
+		return
+	}
+	if config.Settings.TransferMaxAmount > 0 && request.Amount.Amount > config.Settings.TransferMaxAmount {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("transfers are limited to %d", config.Settings.TransferMaxAmount)})
+		return
+	}
+	if len(request.Memo) > maxTransferMemoLength {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("memo cannot be longer than %d characters", maxTransferMemoLength)})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+	recipientID, ok := transferHandler.findRecipient(context, playerID, userName, request.Recipient)
+	if !ok {
+		return
+	}
+	if !mayWithdraw(context, transferHandler.promotions, playerID, request.Amount.Amount) {
+		return
+	}
+
+	transfer := &model.Transfer{
+		SenderID:    playerID,
+		Sender:      userName,
+		RecipientID: recipientID,
+		Recipient:   request.Recipient,
+		Amount:      request.Amount,
+		Memo:        request.Memo,
+	}
+
+	// Coins only leave the account once a pending transfer is confirmed, the second factor is asked for then
+	threshold := config.Settings.TransferConfirmationThreshold
+	pending := threshold > 0 && request.Amount.Amount >= threshold
+	if !pending && !transferHandler.verifyTransferCode(context, userName, request.TOTPCode) {
+		return
+	}
+
+	if pending {
+		expiresAt := time.Now().Add(time.Duration(config.Settings.TransferConfirmationMinutes) * time.Minute)
+		transfer.ExpiresAt = &expiresAt
+		if err = transferHandler.transfers.CreatePendingTransfer(transfer); err != nil {
+			context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transfer"})
+			return
+		}
+
+		recordAudit(transferHandler.audits, context, userName, model.AuditTransferRequested, strconv.Itoa(transfer.ID),
+			gin.H{"recipient": transfer.Recipient, "amount": transfer.Amount})
+
+		context.JSON(http.StatusAccepted, transfer)
+		return
+	}
+
+	if err = transferHandler.transfers.SendTransfer(transfer, config.Settings.TransferDailyLimit); err != nil {
+		abortWithTransferError(context, err, "Failed to transfer funds")
+		return
+	}
+
+	recordAudit(transferHandler.audits, context, userName, model.AuditTransferSent, strconv.Itoa(transfer.ID),
+		gin.H{"recipient": transfer.Recipient, "amount": transfer.Amount})
+
+	context.JSON(http.StatusCreated, transfer)
+}
+
+// Confirm completes a pending transfer of the caller
+func (transferHandler *TransferHandler) Confirm(context *gin.Context) {
+	transferID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid transfer id"})
+		return
+	}
+
+	var confirmRequest model.TransferConfirmRequest
+	err = context.ShouldBindJSON(&confirmRequest)
+	if err != nil && !errors.Is(err, io.EOF) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	playerID := services.GetPlayerIDFromContext(context)
+
+	// Bonuses credited since the transfer was requested may lock the coins it would send
+	pending, err := transferHandler.transfers.GetPendingTransfer(transferID, playerID)
+	if err != nil {
+		abortWithTransferError(context, err, "Failed to confirm transfer")
+		return
+	}
+	if !mayWithdraw(context, transferHandler.promotions, playerID, pending.Amount.Amount) {
+		return
+	}
+	if !transferHandler.verifyTransferCode(context, userName, confirmRequest.TOTPCode) {
+		return
+	}
+
+	transfer, err := transferHandler.transfers.ConfirmTransfer(transferID, playerID, config.Settings.TransferDailyLimit)
+	if err != nil {
+		abortWithTransferError(context, err, "Failed to confirm transfer")
+		return
+	}
+
+	recordAudit(transferHandler.audits, context, userName, model.AuditTransferSent, strconv.Itoa(transfer.ID),
+		gin.H{"recipient_id": transfer.RecipientID, "amount": transfer.Amount, "confirmed": true})
+
+	context.JSON(http.StatusOK, "Transfer confirmed")
+}
+
+// Cancel drops a pending transfer of the caller
+func (transferHandler *TransferHandler) Cancel(context *gin.Context) {
+	transferID, err := strconv.Atoi(context.Param("id"))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid transfer id"})
+		return
+	}
+
+	userName := services.GetSubjectFromContext(context)
+	err = transferHandler.transfers.CancelTransfer(transferID, services.GetPlayerIDFromContext(context))
+	if err != nil {
+		abortWithTransferError(context, err, "Failed to cancel transfer")
+		return
+	}
+
+	recordAudit(transferHandler.audits, context, userName, model.AuditTransferCancelled, strconv.Itoa(transferID), nil)
+
+	context.JSON(http.StatusOK, "Transfer cancelled")
+}
+
+// GetTransfers lists the transfers the caller sent and the completed ones they received
+func (transferHandler *TransferHandler) GetTransfers(context *gin.Context) {
+	transfers, err := transferHandler.transfers.GetTransfers(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transfers"})
+		return
+	}
+
+	context.JSON(http.StatusOK, transfers)
+}
+
+// verifyTransferCode asks for the same fresh second factor as a withdrawal, a stolen session cannot send the balance
+// to another account instead
+func (transferHandler *TransferHandler) verifyTransferCode(context *gin.Context, userName string, code string) bool {
+	return requireTwoFactorCode(context, transferHandler.players, transferHandler.twoFactor, transferHandler.guard,
+		transferHandler.audits, userName, code, "transfers")
+}
+
+// findRecipient resolves the recipient, who has to be another open account that does not block or is not blocked by
+// the sender. The house does not take transfers.
+func (transferHandler *TransferHandler) findRecipient(context *gin.Context, playerID int, userName string, recipient string) (int, bool) {
+	if recipient == userName {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot transfer to yourself"})
+		return 0, false
+	}
+
+	recipientID, err := transferHandler.players.FindPlayerID(recipient)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to check recipient"})
+		return 0, false
+	}
+	if recipientID == 0 || recipient == config.Settings.HouseUsername {
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "recipient does not exist"})
+		return 0, false
+	}
+
+	blocked, err := transferHandler.friends.IsBlocked(playerID, recipientID)
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to check recipient"})
+		return 0, false
+	}
+	if blocked {
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed to transfer to this player"})
+		return 0, false
+	}
+
+	return recipientID, true
+}
+
+// abortWithTransferError answers the known transfer errors with their message and anything else with fallback
+func abortWithTransferError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrTransferNotFound):
+		context.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrInsufficientBalance), errors.Is(err, repository.ErrTransferLimit):
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrTransferNotPending):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
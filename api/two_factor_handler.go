@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+)
+
+type TwoFactorHandler struct {
+	players   *repository.Player
+	twoFactor *services.TwoFactor
+	guard     *services.LoginGuard
+	audits    *repository.Audit
+}
+
+func NewTwoFactorHandler(players *repository.Player, twoFactor *services.TwoFactor, guard *services.LoginGuard,
+	audits *repository.Audit) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		players:   players,
+		twoFactor: twoFactor,
+		guard:     guard,
+		audits:    audits,
+	}
+}
+
+// Enroll starts enabling two factor authentication and returns the secret and otpauth uri for the authenticator app
+func (twoFactorHandler *TwoFactorHandler) Enroll(context *gin.Context) {
+	player := twoFactorHandler.currentPlayer(context)
+	if player == nil {
+		return
+	}
+
+	enrollment, err := twoFactorHandler.twoFactor.Enroll(player)
+	if err != nil {
+		abortWithTwoFactorError(context, err)
+		return
+	}
+
+	context.JSON(http.StatusCreated, enrollment)
+}
+
+// Confirm enables two factor authentication with a first code and returns the recovery codes, they are only shown once
+func (twoFactorHandler *TwoFactorHandler) Confirm(context *gin.Context) {
+	var codeRequest model.TwoFactorCodeRequest
+	err := context.BindJSON(&codeRequest)
+	if err != nil {
+		logrus.Errorf("Unable to bind two factor request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	player := twoFactorHandler.currentPlayer(context)
+	if player == nil {
+		return
+	}
+
+	codes, err := twoFactorHandler.twoFactor.Confirm(player, codeRequest.Code)
+	if err != nil {
+		abortWithTwoFactorError(context, err)
+		return
+	}
+
+	recordAudit(twoFactorHandler.audits, context, player.Username, model.AuditTwoFactorEnabled, player.Username, nil)
+
+	context.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// Disable turns two factor authentication off, it requires a current code
+func (twoFactorHandler *TwoFactorHandler) Disable(context *gin.Context) {
+	var codeRequest model.TwoFactorCodeRequest
+	err := context.BindJSON(&codeRequest)
+	if err != nil {
+		logrus.Errorf("Unable to bind two factor request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	player := twoFactorHandler.currentPlayer(context)
+	if player == nil {
+		return
+	}
+
+	if !player.TOTPEnabled {
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "two factor authentication is not enabled"})
+		return
+	}
+
+	ip := context.ClientIP()
+	if !checkRetryAfter(context, twoFactorHandler.guard, player.Username, ip) {
+		return
+	}
+
+	err = twoFactorHandler.twoFactor.Disable(player, codeRequest.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorInvalidCode) {
+			recordLoginFailure(context, twoFactorHandler.guard, twoFactorHandler.audits, player.Username, ip)
+		}
+		abortWithTwoFactorError(context, err)
+		return
+	}
+
+	recordAudit(twoFactorHandler.audits, context, player.Username, model.AuditTwoFactorDisabled, player.Username, nil)
+
+	context.JSON(http.StatusOK, "Two factor authentication disabled")
+}
+
+func (twoFactorHandler *TwoFactorHandler) currentPlayer(context *gin.Context) *model.Player {
+	userName := services.GetSubjectFromContext(context)
+	player, err := twoFactorHandler.players.FindPlayerWithDetails(userName)
+	if err != nil || player == nil {
+		logrus.Errorf("Unable to find player %s", userName)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to find player"})
+		return nil
+	}
+
+	return player
+}
+
+// requireTwoFactorCode asks players with two factor authentication enabled for a fresh code before coins leave their
+// account, e.g. for withdrawals or transfers. Players without it pass.
+func requireTwoFactorCode(context *gin.Context, players *repository.Player, twoFactor *services.TwoFactor,
+	guard *services.LoginGuard, audits *repository.Audit, userName string, code string, purpose string) bool {
+	player, err := players.FindPlayerWithDetails(userName)
+	if err != nil || player == nil {
+		logrus.Errorf("Unable to find player %s", userName)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "unable to find player"})
+		return false
+	}
+
+	if !player.TOTPEnabled {
+		return true
+	}
+
+	if code == "" {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "two factor code required for " + purpose})
+		return false
+	}
+
+	return verifyTwoFactorCode(context, twoFactor, guard, audits, player, code)
+}
+
+// verifyTwoFactorCode checks the code guarding a sensitive action of a logged-in player. Wrong codes count as failed
+// logins, so a stolen session cannot guess its way through, and locked out players are refused before the code is checked.
+func verifyTwoFactorCode(context *gin.Context, twoFactor *services.TwoFactor, guard *services.LoginGuard,
+	audits *repository.Audit, player *model.Player, code string) bool {
+	ip := context.ClientIP()
+	if !checkRetryAfter(context, guard, player.Username, ip) {
+		return false
+	}
+
+	err := twoFactor.VerifyCode(player, code)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorInvalidCode) {
+			recordLoginFailure(context, guard, audits, player.Username, ip)
+		}
+		abortWithTwoFactorError(context, err)
+		return false
+	}
+
+	return true
+}
+
+func abortWithTwoFactorError(context *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled), errors.Is(err, services.ErrTwoFactorNotEnrolled):
+		context.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTwoFactorInvalidCode):
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("Two factor operation failed: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "two factor operation failed"})
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"main/services"
+	"net/http"
+)
+
+type WalletHandler struct {
+	wallets    *repository.Wallet
+	holds      *repository.Hold
+	promotions *services.Promotions
+	audits     *repository.Audit
+}
+
+func NewWalletHandler(wallets *repository.Wallet, holds *repository.Hold, promotions *services.Promotions,
+	audits *repository.Audit) *WalletHandler {
+	return &WalletHandler{
+		wallets:    wallets,
+		holds:      holds,
+		promotions: promotions,
+		audits:     audits,
+	}
+}
+
+// GetCurrencies lists the currencies players can hold
+func (walletHandler *WalletHandler) GetCurrencies(context *gin.Context) {
+	context.JSON(http.StatusOK, config.Settings.Currencies)
+}
+
+// GetWallets returns the player's balance in every currency, currencies they never held show up empty
+func (walletHandler *WalletHandler) GetWallets(context *gin.Context) {
+	balances, err := walletHandler.wallets.GetBalances(services.GetPlayerIDFromContext(context))
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve wallets"})
+		return
+	}
+
+	wallets := []model.Wallet{}
+	for _, currency := range config.Settings.Currencies {
+		wallet := model.Wallet{Name: currency.Name, Decimals: currency.Decimals,
+			Balance: model.Money{Currency: currency.Code}}
+		for _, balance := range balances {
+			if balance.Currency == currency.Code {
+				wallet.Balance = balance
+			}
+		}
+		wallets = append(wallets, wallet)
+	}
+
+	context.JSON(http.StatusOK, wallets)
+}
+
+// GetBalance returns the player's balance in the currency, play coins by default, split into the bets held by
+// open challenges and what is available
+func (walletHandler *WalletHandler) GetBalance(context *gin.Context) {
+	currency := context.Query("currency")
+	if currency == "" {
+		currency = model.CurrencyCoin
+	}
+	if !isCurrency(currency) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown currency"})
+		return
+	}
+
+	balance, err := walletHandler.holds.GetBalance(services.GetPlayerIDFromContext(context), currency)
+	if err != nil {
+		logrus.Errorf("Unable to fetch balance: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve balance"})
+		return
+	}
+
+	context.JSON(http.StatusOK, balance)
+}
+
+// Convert exchanges an amount of one currency into another at the rule an admin set up for the pair
+func (walletHandler *WalletHandler) Convert(context *gin.Context) {
+	var request model.ConversionRequest
+	err := context.BindJSON(&request)
+	if err != nil {
+		logrus.Errorf("Unable to bind conversion request: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !isCurrency(request.Amount.Currency) || !isCurrency(request.To) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown currency"})
+		return
+	}
+	if request.Amount.Currency == request.To {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot convert a currency into itself"})
+		return
+	}
+	if request.Amount.Amount <= 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+
+	playerID := services.GetPlayerIDFromContext(context)
+	// Bonus coins cannot be converted away before they are wagered
+	if request.Amount.Currency == model.CurrencyCoin &&
+		!mayWithdraw(context, walletHandler.promotions, playerID, request.Amount.Amount) {
+		return
+	}
+
+	conversion, err := walletHandler.wallets.Convert(playerID, request.Amount, request.To)
+	if err != nil {
+		abortWithConversionError(context, err, "Failed to convert")
+		return
+	}
+
+	recordAudit(walletHandler.audits, context, services.GetSubjectFromContext(context), model.AuditCurrencyConverted,
+		request.Amount.Currency, gin.H{"debited": conversion.Debited.String(), "credited": conversion.Credited.String()})
+
+	context.JSON(http.StatusOK, conversion)
+}
+
+// GetConversionRules lists the conversion rules for admins, disabled ones included
+func (walletHandler *WalletHandler) GetConversionRules(context *gin.Context) {
+	rules, err := walletHandler.wallets.GetConversionRules()
+	if err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve conversion rules"})
+		return
+	}
+
+	context.JSON(http.StatusOK, rules)
+}
+
+// SetConversionRule creates or replaces the rule converting one currency into another, disabling it stops conversions
+func (walletHandler *WalletHandler) SetConversionRule(context *gin.Context) {
+	var rule model.ConversionRule
+	err := context.BindJSON(&rule)
+	if err != nil {
+		logrus.Errorf("Unable to bind conversion rule: %v", err)
+		context.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !isCurrency(rule.From) || !isCurrency(rule.To) {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown currency"})
+		return
+	}
+	if rule.From == rule.To {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot convert a currency into itself"})
+		return
+	}
+	if rule.Numerator <= 0 || rule.Denominator <= 0 || rule.MinAmount < 0 {
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "the rate must be positive"})
+		return
+	}
+
+	if err = walletHandler.wallets.SetConversionRule(rule); err != nil {
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to save conversion rule"})
+		return
+	}
+
+	admin := services.GetSubjectFromContext(context)
+	recordAudit(walletHandler.audits, context, admin, model.AuditAdminAction, rule.From+"/"+rule.To, gin.H{
+		"operation":   "conversion_rule",
+		"numerator":   rule.Numerator,
+		"denominator": rule.Denominator,
+		"min_amount":  rule.MinAmount,
+		"enabled":     rule.Enabled,
+	})
+
+	context.JSON(http.StatusOK, rule)
+}
+
+// abortWithConversionError answers the known conversion errors with their message and anything else with fallback
+func abortWithConversionError(context *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, repository.ErrConversionNotAllowed):
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrConversionTooSmall), errors.Is(err, repository.ErrInsufficientBalance):
+		context.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logrus.Errorf("%s: %v", fallback, err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// isCurrency tells whether the code is one of the configured currencies
+func isCurrency(code string) bool {
+	for _, currency := range config.Settings.Currencies {
+		if currency.Code == code {
+			return true
+		}
+	}
+	return false
+}
@@ -7,19 +7,99 @@ import (
 	"path/filepath"
 )
 
+// RateLimit configures a token bucket, it holds up to Burst requests and refills at RequestsPerMinute
+type RateLimit struct {
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	Burst             int     `json:"burst"`
+}
+
+// RakeTier is the commission on pots of at least MinPot, BasisPoints of the pot kept between Min and Max.
+// A Max of 0 leaves it uncapped.
+type RakeTier struct {
+	MinPot      int `json:"min_pot"`
+	BasisPoints int `json:"basis_points"`
+	Min         int `json:"min"`
+	Max         int `json:"max"`
+}
+
+// Currency is a currency players can hold wallets in, amounts are kept in minor units and Decimals tells
+// clients where to put the point
+type Currency struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Decimals int    `json:"decimals"`
+}
+
 type Config struct {
-	DBUser                string `json:"db_user"`
-	DBPass                string `json:"db_pass"`
-	DBPort                int    `json:"db_port"`
-	DBName                string `json:"db_name"`
-	ServerPort            string `json:"server_port"`
-	MinimumDeposit        int    `json:"minimum_deposit"`
-	MinimumBet            int    `json:"minimum_bet"`
-	MinimumPasswordLength int    `json:"minimum_password_length"`
-	MinimumNameLength     int    `json:"minimum_name_length"`
-	MaximumNameLength     int    `json:"maximum_name_length"`
-	SecretKey             string `json:"secret_key"`
-	MaxTokenLifeMinutes   int    `json:"max_token_life_minutes"`
+	DBUser                string   `json:"db_user"`
+	DBPass                string   `json:"db_pass"`
+	DBPort                int      `json:"db_port"`
+	DBName                string   `json:"db_name"`
+	ServerPort            string   `json:"server_port"`
+	MinimumDeposit        int      `json:"minimum_deposit"`
+	MinimumBet            int      `json:"minimum_bet"`
+	MinimumPasswordLength int      `json:"minimum_password_length"`
+	MinimumNameLength     int      `json:"minimum_name_length"`
+	MaximumNameLength     int      `json:"maximum_name_length"`
+	SecretKey             string   `json:"secret_key"`
+	MaxTokenLifeMinutes   int      `json:"max_token_life_minutes"`
+	AdminUsernames        []string `json:"admin_usernames"`
+
+	LoginMaxFailures          int `json:"login_max_failures"`
+	LoginMaxFailuresPerIP     int `json:"login_max_failures_per_ip"`
+	LoginFailureWindowMinutes int `json:"login_failure_window_minutes"`
+	LoginLockoutMinutes       int `json:"login_lockout_minutes"`
+	LoginBackoffBaseSeconds   int `json:"login_backoff_base_seconds"`
+	LoginBackoffMaxSeconds    int `json:"login_backoff_max_seconds"`
+
+	RateLimits                      map[string]RateLimit `json:"rate_limits"`
+	MaxPendingChallengesPerOpponent int                  `json:"max_pending_challenges_per_opponent"`
+
+	PreAuthTokenLifeMinutes int    `json:"pre_auth_token_life_minutes"`
+	TOTPIssuer              string `json:"totp_issuer"`
+	RecoveryCodeCount       int    `json:"recovery_code_count"`
+
+	ChallengeExpiryMinutes int `json:"challenge_expiry_minutes"`
+
+	OnlineWindowMinutes int `json:"online_window_minutes"`
+
+	MatchmakingRatingWindow      int `json:"matchmaking_rating_window"`
+	MatchmakingWindowGrowth      int `json:"matchmaking_window_growth"`
+	MatchmakingWindowStepSeconds int `json:"matchmaking_window_step_seconds"`
+	MatchmakingMaxRatingWindow   int `json:"matchmaking_max_rating_window"`
+	MatchmakingTimeoutSeconds    int `json:"matchmaking_timeout_seconds"`
+
+	TournamentPrizeSplit []int `json:"tournament_prize_split"`
+
+	FreeForAllMaxPlayers         int    `json:"ffa_max_players"`
+	FreeForAllMaxDeadlineMinutes int    `json:"ffa_max_deadline_minutes"`
+	FreeForAllDefaultRule        string `json:"ffa_default_rule"`
+
+	MaxCounterOffers int `json:"max_counter_offers"`
+
+	MaxChallengeTemplates int `json:"max_challenge_templates"`
+
+	HouseUsername        string `json:"house_username"`
+	HouseEdgeBasisPoints int    `json:"house_edge_basis_points"`
+	HouseMaxBet          int    `json:"house_max_bet"`
+	HouseMinBankroll     int    `json:"house_min_bankroll"`
+	HouseMaxWinPercent   int    `json:"house_max_win_percent"`
+
+	Rake          []RakeTier            `json:"rake"`
+	RakeByRuleSet map[string][]RakeTier `json:"rake_by_rule_set"`
+
+	LimitCoolingOffHours int `json:"limit_cooling_off_hours"`
+	MaxSelfExclusionDays int `json:"max_self_exclusion_days"`
+
+	TransferMaxAmount             int `json:"transfer_max_amount"`
+	TransferDailyLimit            int `json:"transfer_daily_limit"`
+	TransferConfirmationThreshold int `json:"transfer_confirmation_threshold"`
+	TransferConfirmationMinutes   int `json:"transfer_confirmation_minutes"`
+
+	Currencies []Currency `json:"currencies"`
+
+	ReconciliationIntervalMinutes int  `json:"reconciliation_interval_minutes"`
+	ReconciliationAutoFix         bool `json:"reconciliation_auto_fix"`
 }
 
 const configPath = "/config/config.json"
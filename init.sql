@@ -18,16 +18,24 @@ ALTER TABLE player OWNER TO postgres;
 -- Create table 'challenge'
 CREATE TABLE IF NOT EXISTS challenge (
                                          challenge_id SERIAL PRIMARY KEY,
-                                         challenger VARCHAR(255) NOT NULL,
-                                         opponent VARCHAR(255) NOT NULL,
+                                         challenger_id INTEGER NOT NULL REFERENCES player (id),
+                                         opponent_id INTEGER NOT NULL REFERENCES player (id),
                                          choice INTEGER NOT NULL,
                                          bet INTEGER NOT NULL,
                                          state VARCHAR(50) NOT NULL,
                                          time_created TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
                                          time_settled TIMESTAMP,
-                                         winner VARCHAR
+                                         winner_id INTEGER REFERENCES player (id),
+                                         opponent_choice INTEGER
 );
 
+-- Challenge history is paged per player in time order, from both sides
+CREATE INDEX IF NOT EXISTS challenge_challenger_time_idx ON challenge (challenger_id, time_created, challenge_id);
+CREATE INDEX IF NOT EXISTS challenge_opponent_time_idx ON challenge (opponent_id, time_created, challenge_id);
+CREATE INDEX IF NOT EXISTS challenge_winner_idx ON challenge (winner_id);
+-- Speeds up the per opponent cap on pending challenges
+CREATE INDEX IF NOT EXISTS challenge_pending_pair_idx ON challenge (challenger_id, opponent_id) WHERE state = 'pending';
+
 -- Alter table 'challenge' owner to 'postgres'
 ALTER TABLE challenge OWNER TO postgres;
 
@@ -37,8 +45,472 @@ CREATE TABLE IF NOT EXISTS transaction (
                                            timestamp TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
                                            amount INTEGER NOT NULL,
                                            reason TEXT NOT NULL,
-                                           username VARCHAR(15) NOT NULL
+                                           player_id INTEGER NOT NULL REFERENCES player (id),
+                                           challenge_id INTEGER REFERENCES challenge (challenge_id)
 );
 
+-- Transaction history is paged per player in time order
+CREATE INDEX IF NOT EXISTS transaction_player_time_idx ON transaction (player_id, timestamp, id);
+CREATE INDEX IF NOT EXISTS transaction_player_reason_idx ON transaction (player_id, reason);
+CREATE INDEX IF NOT EXISTS transaction_challenge_idx ON transaction (challenge_id);
+
 -- Alter table 'transaction' owner to 'postgres'
 ALTER TABLE transaction OWNER TO postgres;
+
+-- Create table 'audit_event', entries are hash chained through prev_hash
+CREATE TABLE IF NOT EXISTS audit_event (
+                                           id BIGINT PRIMARY KEY,
+                                           timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+                                           actor VARCHAR(255) NOT NULL,
+                                           action VARCHAR(50) NOT NULL,
+                                           subject VARCHAR(255) NOT NULL,
+                                           ip VARCHAR(45) NOT NULL,
+                                           details TEXT NOT NULL,
+                                           prev_hash VARCHAR(64) NOT NULL,
+                                           hash VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_event_actor_idx ON audit_event (actor);
+CREATE INDEX IF NOT EXISTS audit_event_action_idx ON audit_event (action);
+CREATE INDEX IF NOT EXISTS audit_event_timestamp_idx ON audit_event (timestamp);
+
+-- Audit entries can only ever be appended
+CREATE OR REPLACE FUNCTION audit_event_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_event_append_only ON audit_event;
+CREATE TRIGGER audit_event_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_event
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_event_append_only();
+
+-- Alter table 'audit_event' owner to 'postgres'
+ALTER TABLE audit_event OWNER TO postgres;
+
+-- Create table 'login_attempt', failed logins per username or ip
+CREATE TABLE IF NOT EXISTS login_attempt (
+                                             key_type VARCHAR(20) NOT NULL,
+                                             key VARCHAR(255) NOT NULL,
+                                             failures INTEGER NOT NULL,
+                                             last_failure TIMESTAMP WITH TIME ZONE NOT NULL,
+                                             blocked_until TIMESTAMP WITH TIME ZONE,
+                                             locked_until TIMESTAMP WITH TIME ZONE,
+                                             PRIMARY KEY (key_type, key)
+);
+
+-- Alter table 'login_attempt' owner to 'postgres'
+ALTER TABLE login_attempt OWNER TO postgres;
+
+-- Two factor authentication
+ALTER TABLE player ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE player ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE player ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Create table 'recovery_code', hashed one-time codes replacing a two factor code
+CREATE TABLE IF NOT EXISTS recovery_code (
+                                             id SERIAL PRIMARY KEY,
+                                             player_id INTEGER NOT NULL REFERENCES player (id) ON DELETE CASCADE,
+                                             code_hash VARCHAR(255) NOT NULL,
+                                             salt VARCHAR(255) NOT NULL,
+                                             used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS recovery_code_player_idx ON recovery_code (player_id);
+
+-- Alter table 'recovery_code' owner to 'postgres'
+ALTER TABLE recovery_code OWNER TO postgres;
+
+-- Session revocation and account closure
+ALTER TABLE player ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMP WITH TIME ZONE;
+ALTER TABLE player ADD COLUMN IF NOT EXISTS closed_at TIMESTAMP WITH TIME ZONE;
+
+-- Player search and public profiles
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+ALTER TABLE player ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE player ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE player ADD COLUMN IF NOT EXISTS rating INTEGER NOT NULL DEFAULT 1000;
+CREATE INDEX IF NOT EXISTS player_username_trgm_idx ON player USING GIN (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS player_rating_idx ON player (rating, id);
+CREATE INDEX IF NOT EXISTS player_last_seen_idx ON player ((COALESCE(last_seen_at, 'epoch'::timestamptz)), id);
+
+-- Create table 'friendship', a request from requester to addressee that is pending until accepted
+CREATE TABLE IF NOT EXISTS friendship (
+                                          requester_id INTEGER NOT NULL REFERENCES player (id),
+                                          addressee_id INTEGER NOT NULL REFERENCES player (id),
+                                          created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                          accepted_at TIMESTAMP WITH TIME ZONE,
+                                          PRIMARY KEY (requester_id, addressee_id),
+                                          CHECK (requester_id <> addressee_id)
+);
+
+-- Only one friendship per pair, whoever asked first
+CREATE UNIQUE INDEX IF NOT EXISTS friendship_pair_idx ON friendship (LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id));
+CREATE INDEX IF NOT EXISTS friendship_addressee_idx ON friendship (addressee_id);
+
+-- Alter table 'friendship' owner to 'postgres'
+ALTER TABLE friendship OWNER TO postgres;
+
+-- Create table 'player_block'
+CREATE TABLE IF NOT EXISTS player_block (
+                                            blocker_id INTEGER NOT NULL REFERENCES player (id),
+                                            blocked_id INTEGER NOT NULL REFERENCES player (id),
+                                            created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                            PRIMARY KEY (blocker_id, blocked_id),
+                                            CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX IF NOT EXISTS player_block_blocked_idx ON player_block (blocked_id);
+
+-- Alter table 'player_block' owner to 'postgres'
+ALTER TABLE player_block OWNER TO postgres;
+
+ALTER TABLE player ADD COLUMN IF NOT EXISTS friends_only_challenges BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Create table 'matchmaking_ticket', the matchmaking queue is kept in memory and written through to here
+CREATE TABLE IF NOT EXISTS matchmaking_ticket (
+                                                  player_id INTEGER PRIMARY KEY REFERENCES player (id),
+                                                  bet INTEGER NOT NULL,
+                                                  choice INTEGER NOT NULL,
+                                                  rule_set VARCHAR(50) NOT NULL,
+                                                  rating INTEGER NOT NULL,
+                                                  joined_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                                                  hold_transaction_id INTEGER NOT NULL
+);
+
+-- Alter table 'matchmaking_ticket' owner to 'postgres'
+ALTER TABLE matchmaking_ticket OWNER TO postgres;
+
+-- Create table 'tournament', prize_split holds the percentage of the pot paid to each place
+CREATE TABLE IF NOT EXISTS tournament (
+                                          id SERIAL PRIMARY KEY,
+                                          name VARCHAR(100) NOT NULL,
+                                          format VARCHAR(50) NOT NULL,
+                                          rule_set VARCHAR(50) NOT NULL,
+                                          entry_fee INTEGER NOT NULL,
+                                          max_players INTEGER NOT NULL,
+                                          match_deadline_minutes INTEGER NOT NULL,
+                                          prize_split INTEGER[] NOT NULL,
+                                          state VARCHAR(50) NOT NULL,
+                                          round INTEGER NOT NULL DEFAULT 0,
+                                          created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                          started_at TIMESTAMP WITH TIME ZONE,
+                                          finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Alter table 'tournament' owner to 'postgres'
+ALTER TABLE tournament OWNER TO postgres;
+
+-- Create table 'tournament_player', seeds are handed out on start and places and prizes on finish
+CREATE TABLE IF NOT EXISTS tournament_player (
+                                                 tournament_id INTEGER NOT NULL REFERENCES tournament (id),
+                                                 player_id INTEGER NOT NULL REFERENCES player (id),
+                                                 seed INTEGER,
+                                                 place INTEGER,
+                                                 prize INTEGER NOT NULL DEFAULT 0,
+                                                 joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                                 PRIMARY KEY (tournament_id, player_id)
+);
+
+-- Alter table 'tournament_player' owner to 'postgres'
+ALTER TABLE tournament_player OWNER TO postgres;
+
+-- Create table 'tournament_match', challenge_id is the current attempt of the match
+CREATE TABLE IF NOT EXISTS tournament_match (
+                                                id SERIAL PRIMARY KEY,
+                                                tournament_id INTEGER NOT NULL REFERENCES tournament (id),
+                                                round INTEGER NOT NULL,
+                                                position INTEGER NOT NULL,
+                                                player_a_id INTEGER NOT NULL REFERENCES player (id),
+                                                player_b_id INTEGER REFERENCES player (id),
+                                                challenge_id INTEGER REFERENCES challenge (challenge_id),
+                                                winner_id INTEGER REFERENCES player (id),
+                                                state VARCHAR(50) NOT NULL,
+                                                deadline TIMESTAMP WITH TIME ZONE,
+                                                UNIQUE (tournament_id, round, position)
+);
+
+CREATE INDEX IF NOT EXISTS tournament_match_pending_idx ON tournament_match (deadline) WHERE state = 'pending';
+
+-- Alter table 'tournament_match' owner to 'postgres'
+ALTER TABLE tournament_match OWNER TO postgres;
+
+-- Tournament matches are played as challenges without a bet
+ALTER TABLE challenge ADD COLUMN IF NOT EXISTS tournament_id INTEGER REFERENCES tournament (id);
+
+-- Create table 'ffa_round', a free-for-all round between three or more players with the same stake
+CREATE TABLE IF NOT EXISTS ffa_round (
+                                         id SERIAL PRIMARY KEY,
+                                         creator_id INTEGER NOT NULL REFERENCES player (id),
+                                         stake INTEGER NOT NULL,
+                                         min_players INTEGER NOT NULL,
+                                         max_players INTEGER NOT NULL,
+                                         rule VARCHAR(50) NOT NULL,
+                                         state VARCHAR(50) NOT NULL,
+                                         deadline TIMESTAMP WITH TIME ZONE NOT NULL,
+                                         created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                         settled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS ffa_round_open_idx ON ffa_round (deadline) WHERE state = 'open';
+
+-- Alter table 'ffa_round' owner to 'postgres'
+ALTER TABLE ffa_round OWNER TO postgres;
+
+-- Create table 'ffa_participant', the move of every player of a round with their result and payout once it is played
+CREATE TABLE IF NOT EXISTS ffa_participant (
+                                               round_id INTEGER NOT NULL REFERENCES ffa_round (id),
+                                               player_id INTEGER NOT NULL REFERENCES player (id),
+                                               choice INTEGER NOT NULL,
+                                               result VARCHAR(50),
+                                               payout INTEGER NOT NULL DEFAULT 0,
+                                               joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                               PRIMARY KEY (round_id, player_id)
+);
+
+-- Alter table 'ffa_participant' owner to 'postgres'
+ALTER TABLE ffa_participant OWNER TO postgres;
+
+-- The bet the opponent countered with while the challenge waits for the challenger's reply
+ALTER TABLE challenge ADD COLUMN IF NOT EXISTS counter_bet INTEGER;
+
+-- Create table 'challenge_offer', the negotiation history of a challenge's bet
+CREATE TABLE IF NOT EXISTS challenge_offer (
+                                               id SERIAL PRIMARY KEY,
+                                               challenge_id INTEGER NOT NULL REFERENCES challenge (challenge_id),
+                                               player_id INTEGER NOT NULL REFERENCES player (id),
+                                               action VARCHAR(50) NOT NULL,
+                                               bet INTEGER NOT NULL,
+                                               previous_bet INTEGER NOT NULL,
+                                               created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS challenge_offer_challenge_idx ON challenge_offer (challenge_id, id);
+
+-- Alter table 'challenge_offer' owner to 'postgres'
+ALTER TABLE challenge_offer OWNER TO postgres;
+
+-- The rule set a challenge is played by
+ALTER TABLE challenge ADD COLUMN IF NOT EXISTS rule_set VARCHAR(50) NOT NULL DEFAULT 'classic';
+
+-- Create table 'challenge_template', a saved challenge the player can fire with one call
+CREATE TABLE IF NOT EXISTS challenge_template (
+                                                  id SERIAL PRIMARY KEY,
+                                                  player_id INTEGER NOT NULL REFERENCES player (id),
+                                                  name VARCHAR(100) NOT NULL,
+                                                  opponent_id INTEGER NOT NULL REFERENCES player (id),
+                                                  bet INTEGER NOT NULL,
+                                                  rule_set VARCHAR(50) NOT NULL,
+                                                  strategy VARCHAR(50) NOT NULL,
+                                                  choice INTEGER,
+                                                  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                                  UNIQUE (player_id, name)
+);
+
+-- Alter table 'challenge_template' owner to 'postgres'
+ALTER TABLE challenge_template OWNER TO postgres;
+
+-- Create table 'house_game', a bet against the house with the seeds its move was derived from
+CREATE TABLE IF NOT EXISTS house_game (
+                                          id SERIAL PRIMARY KEY,
+                                          player_id INTEGER NOT NULL REFERENCES player (id),
+                                          bet INTEGER NOT NULL,
+                                          choice INTEGER NOT NULL,
+                                          house_choice INTEGER NOT NULL,
+                                          result VARCHAR(50) NOT NULL,
+                                          payout INTEGER NOT NULL,
+                                          server_seed VARCHAR(64) NOT NULL,
+                                          server_seed_hash VARCHAR(64) NOT NULL,
+                                          client_seed VARCHAR(255) NOT NULL,
+                                          nonce INTEGER NOT NULL,
+                                          created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS house_game_player_idx ON house_game (player_id, created_at);
+CREATE INDEX IF NOT EXISTS house_game_created_idx ON house_game (created_at);
+
+-- Alter table 'house_game' owner to 'postgres'
+ALTER TABLE house_game OWNER TO postgres;
+
+-- Create table 'fairness_seed', a player's seed pair the server's moves are drawn from
+CREATE TABLE IF NOT EXISTS fairness_seed (
+                                             id SERIAL PRIMARY KEY,
+                                             player_id INTEGER NOT NULL REFERENCES player (id),
+                                             server_seed VARCHAR(64) NOT NULL,
+                                             server_seed_hash VARCHAR(64) NOT NULL,
+                                             client_seed VARCHAR(255) NOT NULL,
+                                             nonce INTEGER NOT NULL DEFAULT 0,
+                                             created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                             revealed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- A player has a single seed pair in use
+CREATE UNIQUE INDEX IF NOT EXISTS fairness_seed_active_idx ON fairness_seed (player_id) WHERE revealed_at IS NULL;
+
+-- Alter table 'fairness_seed' owner to 'postgres'
+ALTER TABLE fairness_seed OWNER TO postgres;
+
+-- House games draw from the player's seed pair, the server seed is only kept for games played with a one-off seed
+ALTER TABLE house_game ADD COLUMN IF NOT EXISTS seed_id INTEGER REFERENCES fairness_seed (id);
+ALTER TABLE house_game ALTER COLUMN server_seed DROP NOT NULL;
+
+-- Create table 'player_limit', the deposit, bet and loss limits a player set themselves. A looser limit
+-- is pending until pending_from, when it replaces amount.
+CREATE TABLE IF NOT EXISTS player_limit (
+                                            player_id INTEGER NOT NULL REFERENCES player (id),
+                                            kind VARCHAR(50) NOT NULL,
+                                            period VARCHAR(50) NOT NULL,
+                                            amount INTEGER NOT NULL DEFAULT 0,
+                                            pending_amount INTEGER,
+                                            pending_from TIMESTAMP WITH TIME ZONE,
+                                            updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                            PRIMARY KEY (player_id, kind, period)
+);
+
+-- Alter table 'player_limit' owner to 'postgres'
+ALTER TABLE player_limit OWNER TO postgres;
+
+-- Self-exclusion blocks bets and deposits until then
+ALTER TABLE player ADD COLUMN IF NOT EXISTS excluded_until TIMESTAMP WITH TIME ZONE;
+
+-- Limits are summed up from the player's transactions of a kind within the window
+CREATE INDEX IF NOT EXISTS transaction_player_reason_time_idx ON transaction (player_id, reason, timestamp);
+
+-- Create table 'transfer', coins sent from one player to another. Both sides are recorded as transactions
+-- once the transfer completes, large transfers are pending until the sender confirms them.
+CREATE TABLE IF NOT EXISTS transfer (
+                                        id SERIAL PRIMARY KEY,
+                                        sender_id INTEGER NOT NULL REFERENCES player (id),
+                                        recipient_id INTEGER NOT NULL REFERENCES player (id),
+                                        amount INTEGER NOT NULL,
+                                        memo VARCHAR(140) NOT NULL DEFAULT '',
+                                        state VARCHAR(50) NOT NULL,
+                                        created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                        expires_at TIMESTAMP WITH TIME ZONE,
+                                        completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS transfer_sender_idx ON transfer (sender_id, created_at);
+CREATE INDEX IF NOT EXISTS transfer_recipient_idx ON transfer (recipient_id, created_at);
+
+-- Alter table 'transfer' owner to 'postgres'
+ALTER TABLE transfer OWNER TO postgres;
+
+-- Transactions and challenges are in a single currency, play coins unless stated otherwise
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS currency VARCHAR(10) NOT NULL DEFAULT 'COIN';
+ALTER TABLE challenge ADD COLUMN IF NOT EXISTS currency VARCHAR(10) NOT NULL DEFAULT 'COIN';
+
+-- Create table 'wallet', a player's balance in a currency other than play coins, which stay in player.balance
+CREATE TABLE IF NOT EXISTS wallet (
+                                      player_id INTEGER NOT NULL REFERENCES player (id),
+                                      currency VARCHAR(10) NOT NULL,
+                                      balance INTEGER NOT NULL DEFAULT 0 CHECK (balance >= 0),
+                                      updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                      PRIMARY KEY (player_id, currency)
+);
+
+-- Alter table 'wallet' owner to 'postgres'
+ALTER TABLE wallet OWNER TO postgres;
+
+-- Create table 'conversion_rule', converting from_currency into to_currency at numerator/denominator
+CREATE TABLE IF NOT EXISTS conversion_rule (
+                                               from_currency VARCHAR(10) NOT NULL,
+                                               to_currency VARCHAR(10) NOT NULL,
+                                               numerator INTEGER NOT NULL CHECK (numerator > 0),
+                                               denominator INTEGER NOT NULL CHECK (denominator > 0),
+                                               min_amount INTEGER NOT NULL DEFAULT 0,
+                                               enabled BOOLEAN NOT NULL DEFAULT TRUE,
+                                               updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                               PRIMARY KEY (from_currency, to_currency)
+);
+
+-- Alter table 'conversion_rule' owner to 'postgres'
+ALTER TABLE conversion_rule OWNER TO postgres;
+
+-- Players hand out their referral code to the players they refer, it is created the first time they ask for it
+ALTER TABLE player ADD COLUMN IF NOT EXISTS referral_code VARCHAR(16) UNIQUE;
+
+-- Create table 'campaign', a sign-up, referral or promo campaign crediting bonuses that have to be wagered
+CREATE TABLE IF NOT EXISTS campaign (
+                                        id SERIAL PRIMARY KEY,
+                                        name VARCHAR(100) NOT NULL,
+                                        kind VARCHAR(20) NOT NULL,
+                                        code VARCHAR(50) UNIQUE,
+                                        amount INTEGER NOT NULL CHECK (amount > 0),
+                                        referee_amount INTEGER NOT NULL DEFAULT 0,
+                                        wagering_multiplier INTEGER NOT NULL DEFAULT 0,
+                                        bonus_days INTEGER NOT NULL CHECK (bonus_days > 0),
+                                        required_games INTEGER NOT NULL DEFAULT 0,
+                                        max_redemptions INTEGER NOT NULL DEFAULT 0,
+                                        starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                        ends_at TIMESTAMP WITH TIME ZONE,
+                                        created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Alter table 'campaign' owner to 'postgres'
+ALTER TABLE campaign OWNER TO postgres;
+
+-- Create table 'bonus', play coins a campaign credited that are locked until wagering_required is staked
+CREATE TABLE IF NOT EXISTS bonus (
+                                     id SERIAL PRIMARY KEY,
+                                     player_id INTEGER NOT NULL REFERENCES player (id),
+                                     campaign_id INTEGER NOT NULL REFERENCES campaign (id),
+                                     source VARCHAR(20) NOT NULL,
+                                     amount INTEGER NOT NULL CHECK (amount > 0),
+                                     wagering_required INTEGER NOT NULL DEFAULT 0,
+                                     state VARCHAR(20) NOT NULL,
+                                     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                     expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                                     closed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS bonus_player_state_idx ON bonus (player_id, state);
+-- Sign-up and promo bonuses are credited once per player, a referrer is rewarded for every referee
+CREATE UNIQUE INDEX IF NOT EXISTS bonus_campaign_player_idx ON bonus (campaign_id, player_id) WHERE source <> 'referral';
+
+-- Alter table 'bonus' owner to 'postgres'
+ALTER TABLE bonus OWNER TO postgres;
+
+-- Create table 'referral', a player who registered with the referral code of another player
+CREATE TABLE IF NOT EXISTS referral (
+                                        id SERIAL PRIMARY KEY,
+                                        referrer_id INTEGER NOT NULL REFERENCES player (id),
+                                        referee_id INTEGER NOT NULL UNIQUE REFERENCES player (id),
+                                        campaign_id INTEGER NOT NULL REFERENCES campaign (id),
+                                        state VARCHAR(20) NOT NULL,
+                                        created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                        rewarded_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS referral_referrer_idx ON referral (referrer_id, created_at);
+
+-- Alter table 'referral' owner to 'postgres'
+ALTER TABLE referral OWNER TO postgres;
+
+-- The part of a balance held by open challenges, it cannot be bet, sent or withdrawn
+ALTER TABLE player ADD COLUMN IF NOT EXISTS held INTEGER NOT NULL DEFAULT 0 CHECK (held >= 0);
+ALTER TABLE wallet ADD COLUMN IF NOT EXISTS held INTEGER NOT NULL DEFAULT 0 CHECK (held >= 0);
+
+-- Create table 'hold', the challenger's bet set aside until the challenge is settled, declined or expires
+CREATE TABLE IF NOT EXISTS hold (
+                                    id SERIAL PRIMARY KEY,
+                                    player_id INTEGER NOT NULL REFERENCES player (id),
+                                    challenge_id INTEGER NOT NULL REFERENCES challenge (challenge_id),
+                                    currency VARCHAR(10) NOT NULL,
+                                    amount INTEGER NOT NULL CHECK (amount >= 0),
+                                    state VARCHAR(20) NOT NULL,
+                                    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                                    closed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- A challenge holds a single bet at a time
+CREATE UNIQUE INDEX IF NOT EXISTS hold_challenge_idx ON hold (challenge_id) WHERE state = 'held';
+CREATE INDEX IF NOT EXISTS hold_player_state_idx ON hold (player_id, state, created_at);
+
+-- Alter table 'hold' owner to 'postgres'
+ALTER TABLE hold OWNER TO postgres;
+
+-- Moves of challenges left to chance are drawn from the challenger's seed pair with the nonce
+ALTER TABLE challenge ADD COLUMN IF NOT EXISTS seed_id INTEGER REFERENCES fairness_seed (id);
+ALTER TABLE challenge ADD COLUMN IF NOT EXISTS nonce INTEGER;
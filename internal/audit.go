@@ -0,0 +1,27 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"main/model"
+	"time"
+)
+
+// HashAuditEvent computes the chained hash of an audit event, the previous hash is part of the input
+// so changing or removing any earlier entry invalidates every hash after it
+func HashAuditEvent(event *model.AuditEvent) string {
+	hasher := sha256.New()
+	_, _ = fmt.Fprintf(hasher, "%s|%d|%s|%s|%s|%s|%s|%s",
+		event.PrevHash,
+		event.ID,
+		event.Timestamp.UTC().Format(time.RFC3339Nano),
+		event.Actor,
+		event.Action,
+		event.Subject,
+		event.IP,
+		event.Details,
+	)
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
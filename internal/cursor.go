@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// EncodeCursor turns the position of the last returned row into an opaque pagination cursor
+func EncodeCursor(position any) (string, error) {
+	encoded, err := json.Marshal(position)
+	if err != nil {
+		return "", fmt.Errorf("error encoding cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// DecodeCursor reads a cursor created by EncodeCursor back into position
+func DecodeCursor(cursor string, position any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	err = json.Unmarshal(decoded, position)
+	if err != nil {
+		return fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"main/model"
+	"strings"
+)
+
+// GenerateServerSeed returns a random secret seed, only its hash is shown until it is revealed
+func GenerateServerSeed() (string, error) {
+	seed := make([]byte, 32)
+
+	_, err := rand.Read(seed)
+	if err != nil {
+		return "", fmt.Errorf("error generating server seed: %w", err)
+	}
+
+	return hex.EncodeToString(seed), nil
+}
+
+// HashServerSeed is the commitment to a server seed, publishing it before play proves the seed was not picked afterwards
+func HashServerSeed(serverSeed string) string {
+	hash := sha256.Sum256([]byte(serverSeed))
+	return hex.EncodeToString(hash[:])
+}
+
+// FairRoll derives a number below n from the seeds and the nonce. The HMAC-SHA256 of "clientSeed:nonce" keyed
+// with the server seed is read as 4 byte big endian numbers, the first one below the largest multiple of n is
+// taken modulo n so that every outcome is equally likely.
+func FairRoll(serverSeed string, clientSeed string, nonce int, n int) int {
+	mac := hmac.New(sha256.New, []byte(serverSeed))
+	_, _ = fmt.Fprintf(mac, "%s:%d", clientSeed, nonce)
+	digest := mac.Sum(nil)
+
+	limit := uint64(1<<32) - uint64(1<<32)%uint64(n)
+	value := uint64(0)
+	for offset := 0; offset+4 <= len(digest); offset += 4 {
+		value = uint64(binary.BigEndian.Uint32(digest[offset : offset+4]))
+		if value < limit {
+			break
+		}
+	}
+
+	return int(value % uint64(n))
+}
+
+// FairMove is the move the server plays for the seeds and the nonce
+func FairMove(serverSeed string, clientSeed string, nonce int) int {
+	return model.ChoiceRock + FairRoll(serverSeed, clientSeed, nonce, model.ChoiceScissors)
+}
+
+// VerifyFairMove recomputes a move from revealed seeds. committed tells whether the server seed matches the hash
+// that was published before the move was drawn, an empty hash is never matched.
+func VerifyFairMove(serverSeed string, serverSeedHash string, clientSeed string, nonce int) (move int, committed bool) {
+	computedHash := HashServerSeed(serverSeed)
+	committed = serverSeedHash != "" && strings.EqualFold(computedHash, serverSeedHash)
+	return FairMove(serverSeed, clientSeed, nonce), committed
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"main/model"
+	"strings"
+	"testing"
+)
+
+const (
+	testServerSeed = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	testClientSeed = "player-chosen"
+)
+
+func TestFairRollKnownSeeds(t *testing.T) {
+	// Recomputed independently from HMAC-SHA256 of "player-chosen:nonce" keyed with the server seed
+	tests := []struct {
+		nonce int
+		n     int
+		roll  int
+	}{
+		{0, 3, 0},
+		{1, 3, 2},
+		{4, 3, 1},
+		{0, 6, 3},
+		{3, 6, 5},
+		{0, 1000, 769},
+		{2, 1000, 967},
+		// The first number of the digest is above the largest multiple of n and skipped, the second one is taken
+		{1, 1<<31 + 1, 1016183235},
+	}
+
+	for _, test := range tests {
+		roll := FairRoll(testServerSeed, testClientSeed, test.nonce, test.n)
+		if roll != test.roll {
+			t.Errorf("FairRoll(nonce %d, n %d) = %d, want %d", test.nonce, test.n, roll, test.roll)
+		}
+	}
+}
+
+func TestFairRollBounds(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 7, 1000, 1<<31 + 1, 1 << 32} {
+		for nonce := 0; nonce < 200; nonce++ {
+			roll := FairRoll(testServerSeed, testClientSeed, nonce, n)
+			if roll < 0 || roll >= n {
+				t.Fatalf("FairRoll(nonce %d, n %d) = %d, out of [0, %d)", nonce, n, roll, n)
+			}
+		}
+	}
+}
+
+func TestFairRollDependsOnEverySeed(t *testing.T) {
+	// A single changed input should change some of a run of rolls
+	differs := func(roll func(nonce int) int) bool {
+		for nonce := 0; nonce < 20; nonce++ {
+			if roll(nonce) != FairRoll(testServerSeed, testClientSeed, nonce, 1000) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !differs(func(nonce int) int { return FairRoll("other", testClientSeed, nonce, 1000) }) {
+		t.Error("FairRoll ignores the server seed")
+	}
+	if !differs(func(nonce int) int { return FairRoll(testServerSeed, "other", nonce, 1000) }) {
+		t.Error("FairRoll ignores the client seed")
+	}
+	if !differs(func(nonce int) int { return FairRoll(testServerSeed, testClientSeed, nonce+1, 1000) }) {
+		t.Error("FairRoll ignores the nonce")
+	}
+}
+
+func TestFairMove(t *testing.T) {
+	tests := []struct {
+		nonce int
+		move  int
+	}{
+		{0, model.ChoiceRock},
+		{1, model.ChoiceScissors},
+		{2, model.ChoiceRock},
+		{3, model.ChoiceScissors},
+		{4, model.ChoicePaper},
+	}
+
+	for _, test := range tests {
+		move := FairMove(testServerSeed, testClientSeed, test.nonce)
+		if move != test.move {
+			t.Errorf("FairMove(nonce %d) = %d, want %d", test.nonce, move, test.move)
+		}
+	}
+}
+
+func TestVerifyFairMove(t *testing.T) {
+	hash := HashServerSeed(testServerSeed)
+
+	tests := []struct {
+		name      string
+		hash      string
+		committed bool
+	}{
+		{"matching hash", hash, true},
+		{"upper case hash", strings.ToUpper(hash), true},
+		{"other hash", HashServerSeed("other"), false},
+		{"no hash", "", false},
+	}
+
+	for _, test := range tests {
+		move, committed := VerifyFairMove(testServerSeed, test.hash, testClientSeed, 1)
+		if move != model.ChoiceScissors {
+			t.Errorf("%s: VerifyFairMove move = %d, want %d", test.name, move, model.ChoiceScissors)
+		}
+		if committed != test.committed {
+			t.Errorf("%s: VerifyFairMove committed = %v, want %v", test.name, committed, test.committed)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// GenerateReferralCode returns a random code of 8 letters and digits a player hands out to the players they refer
+func GenerateReferralCode() (string, error) {
+	raw := make([]byte, 5)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", fmt.Errorf("error generating referral code: %w", err)
+	}
+	return totpEncoding.EncodeToString(raw), nil
+}
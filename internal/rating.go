@@ -0,0 +1,13 @@
+package internal
+
+import "math"
+
+// ratingKFactor is the most a single game can move a rating, players start at 1000
+const ratingKFactor = 32
+
+// EloRatings returns both ratings after a game, scoreA is 1 when player a won, 0.5 on a draw and 0 when a lost
+func EloRatings(ratingA int, ratingB int, scoreA float64) (int, int) {
+	expectedA := 1 / (1 + math.Pow(10, float64(ratingB-ratingA)/400))
+	change := int(math.Round(ratingKFactor * (scoreA - expectedA)))
+	return ratingA + change, ratingB - change
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890", base32 encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8 digit codes, the last 6 digits are the 6 digit code
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", test.unix, err)
+		}
+		if code != test.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowerCaseSecret(t *testing.T) {
+	code, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || code != "287082" {
+		t.Errorf("TOTPCode = %s, %v, want 287082", code, err)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	// The code of step 1, which runs from 30 to 59 seconds
+	const code = "287082"
+
+	tests := []struct {
+		name  string
+		unix  int64
+		match bool
+	}{
+		{"step before", 0, true},
+		{"last second of the step before", 29, true},
+		{"same step", 45, true},
+		{"step after", 60, true},
+		{"last second of the step after", 89, true},
+		{"two steps after", 90, false},
+		{"far in the future", 1111111109, false},
+	}
+
+	for _, test := range tests {
+		step, ok := MatchTOTP(rfc6238Secret, code, time.Unix(test.unix, 0))
+		if ok != test.match {
+			t.Errorf("%s: MatchTOTP = %v, want %v", test.name, ok, test.match)
+			continue
+		}
+		if ok && step != 1 {
+			t.Errorf("%s: MatchTOTP step = %d, want 1", test.name, step)
+		}
+	}
+}
+
+func TestMatchTOTPWrongCode(t *testing.T) {
+	if _, ok := MatchTOTP(rfc6238Secret, "000000", time.Unix(59, 0)); ok {
+		t.Error("MatchTOTP accepted a wrong code")
+	}
+	if _, ok := MatchTOTP(rfc6238Secret, "", time.Unix(59, 0)); ok {
+		t.Error("MatchTOTP accepted an empty code")
+	}
+}
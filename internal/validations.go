@@ -1,15 +1,24 @@
 package internal
 
 import (
+	"crypto/subtle"
 	"errors"
 	"github.com/sirupsen/logrus"
 	"main/config"
+	"main/model"
+	"strings"
 )
 
-func ValidateMinimumPlayerDeposit(deposit int) error {
+func ValidateMinimumPlayerDeposit(deposit model.Money) error {
+	// ... the deposit is in play coins
+	if deposit.Currency != model.CurrencyCoin {
+		logrus.Errorf("Invalid deposit currency for player: %s", deposit.Currency)
+		return errors.New("deposit must be in " + model.CurrencyCoin)
+	}
+
 	// ... user balance is above minimum
-	if valid := deposit > config.Settings.MinimumDeposit; !valid {
-		logrus.Errorf("Invalid balance for player: %d , must be %d or more", deposit, config.Settings.MinimumDeposit)
+	if valid := deposit.Amount > config.Settings.MinimumDeposit; !valid {
+		logrus.Errorf("Invalid balance for player: %d , must be %d or more", deposit.Amount, config.Settings.MinimumDeposit)
 		return errors.New("balance is invalid")
 	}
 
@@ -23,6 +32,18 @@ func ValidatePlayerUsername(username string) error {
 		return errors.New("username is too short")
 	}
 
+	// ... username does not look like a closed account
+	if strings.HasPrefix(username, model.DeletedUsernamePrefix) {
+		logrus.Errorf("Username %s uses the reserved prefix %s", username, model.DeletedUsernamePrefix)
+		return errors.New("username is reserved")
+	}
+
+	// ... username is not the house account
+	if strings.EqualFold(username, config.Settings.HouseUsername) {
+		logrus.Errorf("Username %s is the house account", username)
+		return errors.New("username is reserved")
+	}
+
 	// ... username is not too long
 	if valid := len(username) < config.Settings.MaximumNameLength; !valid {
 		logrus.Errorf("Username is too long, %s, needs to be at less than %d characaters long", username, config.Settings.MinimumNameLength)
@@ -32,6 +53,32 @@ func ValidatePlayerUsername(username string) error {
 	return nil
 }
 
+// ValidateUnprivilegedUsername refuses the configured admin usernames to registrations and renames. Admin rights come
+// with the name, so an admin account has to be registered before its name is added to admin_usernames.
+func ValidateUnprivilegedUsername(username string) error {
+	if IsAdminUsername(username) {
+		logrus.Errorf("Username %s is a configured admin username", username)
+		return errors.New("username is reserved")
+	}
+
+	return nil
+}
+
+// IsAdminUsername tells whether the username is listed in admin_usernames, ignoring case for lookalike names
+func IsAdminUsername(username string) bool {
+	if username == "" {
+		return false
+	}
+
+	for _, admin := range config.Settings.AdminUsernames {
+		if strings.EqualFold(admin, username) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func ValidatePlayerPassword(password string) error {
 	if valid := len(password) > config.Settings.MinimumPasswordLength; !valid {
 		logrus.Errorf("Password is too short: %s", password)
@@ -49,8 +96,8 @@ func IsPasswordMatching(username, password, salt, hashedPassword string) (bool,
 		return false, err
 	}
 
-	// Compare both passwords
-	if inputPassHash == hashedPassword {
+	// Compare both passwords in constant time
+	if subtle.ConstantTimeCompare([]byte(inputPassHash), []byte(hashedPassword)) == 1 {
 		logrus.Infof("Password matches for username %s", username)
 		return true, nil
 	}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"flag"
 	"fmt"
 	_ "github.com/lib/pq" // PostgreSQL driver
 	"main/api"
 	"main/config"
 	"main/repository"
+	"main/services"
+	"os"
+	"time"
 )
 
 func main() {
@@ -14,23 +19,145 @@ func main() {
 	db := createDBConnection(config.Settings)
 	defer db.Close()
 
+	// Maintenance commands run instead of the server, e.g. `go run . verify-audit`
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(db, os.Args[1], os.Args[2:]))
+	}
+
 	// Inject dependencies
 	var dependencies api.Dependencies
 	dependencies.PlayerRepository = repository.NewPlayerRepository(db)
 	dependencies.ChallengeRepository = repository.NewChallengeRepository(db)
 	dependencies.TransactionRepository = repository.NewTransactionRepository(db)
+	dependencies.AuditRepository = repository.NewAuditRepository(db)
+	dependencies.LoginAttemptRepository = repository.NewLoginAttemptRepository(db)
+	dependencies.RecoveryCodeRepository = repository.NewRecoveryCodeRepository(db)
+	dependencies.FriendRepository = repository.NewFriendRepository(db)
+	dependencies.MatchmakingRepository = repository.NewMatchmakingTicketRepository(db)
+	dependencies.TournamentRepository = repository.NewTournamentRepository(db)
+	dependencies.FreeForAllRepository = repository.NewFreeForAllRepository(db)
+	dependencies.TemplateRepository = repository.NewChallengeTemplateRepository(db)
+	dependencies.HouseRepository = repository.NewHouseRepository(db)
+	dependencies.FairnessRepository = repository.NewFairnessRepository(db)
+	dependencies.LimitRepository = repository.NewLimitRepository(db)
+	dependencies.TransferRepository = repository.NewTransferRepository(db)
+	dependencies.WalletRepository = repository.NewWalletRepository(db)
+	dependencies.ReconciliationRepository = repository.NewReconciliationRepository(db)
+	dependencies.PromotionRepository = repository.NewPromotionRepository(db)
+	dependencies.HoldRepository = repository.NewHoldRepository(db)
+
+	dependencies.RateLimitStore = services.NewMemoryRateLimitStore()
+
+	// The house plays house games and collects the rake
+	houseID, err := dependencies.PlayerRepository.EnsureSystemAccount(config.Settings.HouseUsername)
+	if err != nil {
+		panic(err)
+	}
+
+	limits := services.NewResponsibleGaming(dependencies.LimitRepository)
+	loginGuard := services.NewLoginGuard(dependencies.LoginAttemptRepository)
+	twoFactor := services.NewTwoFactor(dependencies.PlayerRepository, dependencies.RecoveryCodeRepository)
+	matchmaker := services.NewMatchmaker(dependencies.MatchmakingRepository, dependencies.PlayerRepository,
+		dependencies.ChallengeRepository, dependencies.TransactionRepository, dependencies.FriendRepository, dependencies.AuditRepository, houseID)
+	tournaments := services.NewTournaments(dependencies.TournamentRepository, dependencies.ChallengeRepository,
+		dependencies.PlayerRepository, dependencies.AuditRepository)
+	freeForAll := services.NewFreeForAllRounds(dependencies.FreeForAllRepository, dependencies.AuditRepository)
+
+	fairness := services.NewFairness(dependencies.FairnessRepository)
+	houseGames := services.NewHouseGames(dependencies.HouseRepository, fairness, houseID)
+	promotions := services.NewPromotions(dependencies.PromotionRepository, dependencies.HoldRepository, dependencies.AuditRepository)
+
+	dependencies.RegistrationHandler = api.NewRegistrationHandler(dependencies.PlayerRepository, dependencies.AuditRepository,
+		promotions)
+	dependencies.LoginHandler = api.NewLoginHandler(dependencies.PlayerRepository, dependencies.AuditRepository, loginGuard, twoFactor)
+	dependencies.PlayersHandler = api.NewFindPlayersHandler(dependencies.PlayerRepository, dependencies.TransactionRepository, dependencies.AuditRepository, twoFactor, loginGuard, limits,
+		promotions)
+	dependencies.ChallengeHandler = api.NewChallengeHandler(dependencies.ChallengeRepository, dependencies.PlayerRepository, dependencies.TransactionRepository,
+		dependencies.WalletRepository, dependencies.AuditRepository, dependencies.FriendRepository, limits, fairness, houseID)
+	dependencies.TransactionHandler = api.NewTransactionHandler(dependencies.TransactionRepository, dependencies.PlayerRepository, dependencies.AuditRepository)
+	dependencies.AuditHandler = api.NewAuditHandler(dependencies.AuditRepository)
+	dependencies.LockoutHandler = api.NewLockoutHandler(loginGuard, dependencies.AuditRepository)
+	dependencies.TwoFactorHandler = api.NewTwoFactorHandler(dependencies.PlayerRepository, twoFactor, loginGuard, dependencies.AuditRepository)
+	dependencies.AccountHandler = api.NewAccountHandler(dependencies.PlayerRepository, twoFactor, loginGuard, dependencies.AuditRepository)
+	dependencies.FriendHandler = api.NewFriendHandler(dependencies.FriendRepository, dependencies.PlayerRepository, dependencies.AuditRepository)
+	dependencies.MatchmakingHandler = api.NewMatchmakingHandler(matchmaker, limits, dependencies.AuditRepository)
+	dependencies.TournamentHandler = api.NewTournamentHandler(dependencies.TournamentRepository, tournaments, limits, dependencies.AuditRepository)
+	dependencies.FreeForAllHandler = api.NewFreeForAllHandler(dependencies.FreeForAllRepository, freeForAll, limits, dependencies.AuditRepository)
+	dependencies.TemplateHandler = api.NewChallengeTemplateHandler(dependencies.TemplateRepository, dependencies.PlayerRepository,
+		dependencies.ChallengeHandler)
+	dependencies.HouseHandler = api.NewHouseHandler(dependencies.HouseRepository, houseGames, limits, dependencies.AuditRepository)
+	dependencies.FairnessHandler = api.NewFairnessHandler(fairness, dependencies.FairnessRepository, dependencies.HouseRepository,
+		dependencies.AuditRepository)
+	dependencies.LimitsHandler = api.NewResponsibleGamingHandler(limits, dependencies.AuditRepository)
+	dependencies.TransferHandler = api.NewTransferHandler(dependencies.TransferRepository, dependencies.PlayerRepository,
+		dependencies.FriendRepository, dependencies.AuditRepository, promotions, twoFactor, loginGuard)
+	dependencies.WalletHandler = api.NewWalletHandler(dependencies.WalletRepository, dependencies.HoldRepository, promotions,
+		dependencies.AuditRepository)
+	dependencies.PromotionHandler = api.NewPromotionHandler(dependencies.PromotionRepository, promotions, dependencies.AuditRepository)
 
-	dependencies.RegistrationHandler = api.NewRegistrationHandler(dependencies.PlayerRepository, dependencies.TransactionRepository)
-	dependencies.LoginHandler = api.NewLoginHandler(dependencies.PlayerRepository)
-	dependencies.PlayersHandler = api.NewFindPlayersHandler(dependencies.PlayerRepository, dependencies.TransactionRepository)
-	dependencies.ChallengeHandler = api.NewChallengeHandler(dependencies.ChallengeRepository, dependencies.PlayerRepository, dependencies.TransactionRepository)
-	dependencies.TransactionHandler = api.NewTransactionHandler(dependencies.TransactionRepository)
+	if config.Settings.ChallengeExpiryMinutes > 0 {
+		go services.ExpireChallenges(dependencies.ChallengeRepository,
+			time.Duration(config.Settings.ChallengeExpiryMinutes)*time.Minute)
+	}
+
+	if config.Settings.ReconciliationIntervalMinutes > 0 {
+		reconciler := services.NewReconciler(dependencies.ReconciliationRepository, dependencies.AuditRepository)
+		go reconciler.Run(time.Duration(config.Settings.ReconciliationIntervalMinutes)*time.Minute,
+			config.Settings.ReconciliationAutoFix)
+	}
+
+	go matchmaker.Run()
+	go tournaments.Run()
+	go freeForAll.Run()
+	go promotions.Run()
 
 	api.LoadServerDependencies(&dependencies)
 
 	api.StartServer()
 }
 
+// runCommand executes a maintenance command with its arguments and returns the process exit code
+func runCommand(db *sql.DB, command string, args []string) int {
+	switch command {
+	case "verify-audit":
+		result, err := repository.NewAuditRepository(db).Verify()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to verify audit log: %v\n", err)
+			return 2
+		}
+
+		output, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(output))
+		if !result.Valid {
+			return 1
+		}
+		return 0
+	case "reconcile":
+		flags := flag.NewFlagSet(command, flag.ContinueOnError)
+		fix := flags.Bool("fix", false, "write adjustment entries for the balances that do not add up")
+		if err := flags.Parse(args); err != nil {
+			return 2
+		}
+
+		reconciler := services.NewReconciler(repository.NewReconciliationRepository(db), repository.NewAuditRepository(db))
+		report, err := reconciler.Reconcile(*fix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to reconcile balances: %v\n", err)
+			return 2
+		}
+
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+		if !report.Consistent {
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
+		return 2
+	}
+}
+
 func createDBConnection(config config.Config) *sql.DB {
 	connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=localhost port=5432 sslmode=disable",
 		config.DBUser, config.DBPass, config.DBName)
@@ -0,0 +1,61 @@
+-- 001_player_id_references.sql
+-- Challenges and transactions referenced players by username without foreign keys,
+-- this switches them to player ids. Run once against databases created before the change:
+--   psql -U postgres -d elysium -f migrations/001_player_id_references.sql
+
+BEGIN;
+
+-- challenge: challenger, opponent, winner -> challenger_id, opponent_id, winner_id
+ALTER TABLE challenge ADD COLUMN challenger_id INTEGER;
+ALTER TABLE challenge ADD COLUMN opponent_id INTEGER;
+ALTER TABLE challenge ADD COLUMN winner_id INTEGER;
+
+UPDATE challenge SET challenger_id = player.id FROM player WHERE player.username = challenge.challenger;
+UPDATE challenge SET opponent_id = player.id FROM player WHERE player.username = challenge.opponent;
+UPDATE challenge SET winner_id = player.id FROM player WHERE player.username = challenge.winner;
+
+-- transaction: username -> player_id
+ALTER TABLE transaction ADD COLUMN player_id INTEGER;
+
+UPDATE transaction SET player_id = player.id FROM player WHERE player.username = transaction.username;
+
+-- Refuse to continue with rows whose username does not resolve, they have to be fixed by hand
+DO $$
+DECLARE
+    orphaned_challenges INTEGER;
+    orphaned_transactions INTEGER;
+BEGIN
+    SELECT COUNT(*) INTO orphaned_challenges FROM challenge
+    WHERE challenger_id IS NULL OR opponent_id IS NULL
+       OR (winner_id IS NULL AND winner IS NOT NULL AND winner <> '');
+    SELECT COUNT(*) INTO orphaned_transactions FROM transaction WHERE player_id IS NULL;
+
+    IF orphaned_challenges > 0 OR orphaned_transactions > 0 THEN
+        RAISE EXCEPTION 'found % challenges and % transactions referencing unknown usernames',
+            orphaned_challenges, orphaned_transactions;
+    END IF;
+END $$;
+
+ALTER TABLE challenge ALTER COLUMN challenger_id SET NOT NULL;
+ALTER TABLE challenge ALTER COLUMN opponent_id SET NOT NULL;
+ALTER TABLE challenge ADD CONSTRAINT challenge_challenger_id_fkey FOREIGN KEY (challenger_id) REFERENCES player (id);
+ALTER TABLE challenge ADD CONSTRAINT challenge_opponent_id_fkey FOREIGN KEY (opponent_id) REFERENCES player (id);
+ALTER TABLE challenge ADD CONSTRAINT challenge_winner_id_fkey FOREIGN KEY (winner_id) REFERENCES player (id);
+
+DROP INDEX IF EXISTS challenge_pending_pair_idx;
+ALTER TABLE challenge DROP COLUMN challenger;
+ALTER TABLE challenge DROP COLUMN opponent;
+ALTER TABLE challenge DROP COLUMN winner;
+
+CREATE INDEX challenge_challenger_idx ON challenge (challenger_id);
+CREATE INDEX challenge_opponent_idx ON challenge (opponent_id);
+CREATE INDEX challenge_winner_idx ON challenge (winner_id);
+CREATE INDEX challenge_pending_pair_idx ON challenge (challenger_id, opponent_id) WHERE state = 'pending';
+
+ALTER TABLE transaction ALTER COLUMN player_id SET NOT NULL;
+ALTER TABLE transaction ADD CONSTRAINT transaction_player_id_fkey FOREIGN KEY (player_id) REFERENCES player (id);
+ALTER TABLE transaction DROP COLUMN username;
+
+CREATE INDEX transaction_player_idx ON transaction (player_id);
+
+COMMIT;
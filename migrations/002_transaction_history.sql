@@ -0,0 +1,14 @@
+-- 002_transaction_history.sql
+-- Links transactions to the challenge that caused them and adds the indexes for paging through
+-- a player's history. Older transactions cannot be linked and keep a NULL challenge_id.
+
+BEGIN;
+
+ALTER TABLE transaction ADD COLUMN challenge_id INTEGER REFERENCES challenge (challenge_id);
+
+DROP INDEX IF EXISTS transaction_player_idx;
+CREATE INDEX transaction_player_time_idx ON transaction (player_id, timestamp, id);
+CREATE INDEX transaction_player_reason_idx ON transaction (player_id, reason);
+CREATE INDEX transaction_challenge_idx ON transaction (challenge_id);
+
+COMMIT;
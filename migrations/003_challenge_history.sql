@@ -0,0 +1,14 @@
+-- 003_challenge_history.sql
+-- Keeps the opponent's move of settled challenges and adds the indexes for paging through
+-- a player's challenges. Challenges settled before this migration have no opponent move.
+
+BEGIN;
+
+ALTER TABLE challenge ADD COLUMN opponent_choice INTEGER;
+
+DROP INDEX IF EXISTS challenge_challenger_idx;
+DROP INDEX IF EXISTS challenge_opponent_idx;
+CREATE INDEX challenge_challenger_time_idx ON challenge (challenger_id, time_created, challenge_id);
+CREATE INDEX challenge_opponent_time_idx ON challenge (opponent_id, time_created, challenge_id);
+
+COMMIT;
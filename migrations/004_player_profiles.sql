@@ -0,0 +1,20 @@
+-- 004_player_profiles.sql
+-- Adds join date, last seen and rating to players and the indexes for searching them.
+-- Players registered before this migration get their first transaction as join date and start at the default rating.
+
+BEGIN;
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE player ADD COLUMN created_at TIMESTAMP WITH TIME ZONE;
+UPDATE player SET created_at = (SELECT MIN(timestamp) FROM transaction WHERE transaction.player_id = player.id);
+ALTER TABLE player ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP;
+
+ALTER TABLE player ADD COLUMN last_seen_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE player ADD COLUMN rating INTEGER NOT NULL DEFAULT 1000;
+
+CREATE INDEX player_username_trgm_idx ON player USING GIN (username gin_trgm_ops);
+CREATE INDEX player_rating_idx ON player (rating, id);
+CREATE INDEX player_last_seen_idx ON player ((COALESCE(last_seen_at, 'epoch'::timestamptz)), id);
+
+COMMIT;
@@ -0,0 +1,52 @@
+-- 005_tournaments.sql
+-- Adds tournaments, their players and matches, and links the challenges tournament matches are played with.
+
+BEGIN;
+
+CREATE TABLE tournament (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    format VARCHAR(50) NOT NULL,
+    rule_set VARCHAR(50) NOT NULL,
+    entry_fee INTEGER NOT NULL,
+    max_players INTEGER NOT NULL,
+    match_deadline_minutes INTEGER NOT NULL,
+    prize_split INTEGER[] NOT NULL,
+    state VARCHAR(50) NOT NULL,
+    round INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+ALTER TABLE tournament OWNER TO postgres;
+
+CREATE TABLE tournament_player (
+    tournament_id INTEGER NOT NULL REFERENCES tournament (id),
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    seed INTEGER,
+    place INTEGER,
+    prize INTEGER NOT NULL DEFAULT 0,
+    joined_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tournament_id, player_id)
+);
+ALTER TABLE tournament_player OWNER TO postgres;
+
+CREATE TABLE tournament_match (
+    id SERIAL PRIMARY KEY,
+    tournament_id INTEGER NOT NULL REFERENCES tournament (id),
+    round INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    player_a_id INTEGER NOT NULL REFERENCES player (id),
+    player_b_id INTEGER REFERENCES player (id),
+    challenge_id INTEGER REFERENCES challenge (challenge_id),
+    winner_id INTEGER REFERENCES player (id),
+    state VARCHAR(50) NOT NULL,
+    deadline TIMESTAMP WITH TIME ZONE,
+    UNIQUE (tournament_id, round, position)
+);
+CREATE INDEX tournament_match_pending_idx ON tournament_match (deadline) WHERE state = 'pending';
+ALTER TABLE tournament_match OWNER TO postgres;
+
+ALTER TABLE challenge ADD COLUMN tournament_id INTEGER REFERENCES tournament (id);
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- 006_challenge_negotiation.sql
+-- Lets the opponent counter a challenge with a different bet and keeps the history of the negotiation.
+
+BEGIN;
+
+ALTER TABLE challenge ADD COLUMN counter_bet INTEGER;
+
+CREATE TABLE challenge_offer (
+    id SERIAL PRIMARY KEY,
+    challenge_id INTEGER NOT NULL REFERENCES challenge (challenge_id),
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    action VARCHAR(50) NOT NULL,
+    bet INTEGER NOT NULL,
+    previous_bet INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX challenge_offer_challenge_idx ON challenge_offer (challenge_id, id);
+ALTER TABLE challenge_offer OWNER TO postgres;
+
+COMMIT;
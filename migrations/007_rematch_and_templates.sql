@@ -0,0 +1,23 @@
+-- 007_rematch_and_templates.sql
+-- Stores the rule set of every challenge so rematches keep it, and adds saved challenge templates.
+-- Challenges created before this migration were all classic.
+
+BEGIN;
+
+ALTER TABLE challenge ADD COLUMN rule_set VARCHAR(50) NOT NULL DEFAULT 'classic';
+
+CREATE TABLE challenge_template (
+    id SERIAL PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    name VARCHAR(100) NOT NULL,
+    opponent_id INTEGER NOT NULL REFERENCES player (id),
+    bet INTEGER NOT NULL,
+    rule_set VARCHAR(50) NOT NULL,
+    strategy VARCHAR(50) NOT NULL,
+    choice INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (player_id, name)
+);
+ALTER TABLE challenge_template OWNER TO postgres;
+
+COMMIT;
@@ -0,0 +1,41 @@
+-- 008_house_games_and_fairness.sql
+-- Adds games against the house and the seed pairs the house's moves are drawn from.
+-- Games played before seed pairs existed keep the one-off server seed they were played with.
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS house_game (
+    id SERIAL PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    bet INTEGER NOT NULL,
+    choice INTEGER NOT NULL,
+    house_choice INTEGER NOT NULL,
+    result VARCHAR(50) NOT NULL,
+    payout INTEGER NOT NULL,
+    server_seed VARCHAR(64),
+    server_seed_hash VARCHAR(64) NOT NULL,
+    client_seed VARCHAR(255) NOT NULL,
+    nonce INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS house_game_player_idx ON house_game (player_id, created_at);
+CREATE INDEX IF NOT EXISTS house_game_created_idx ON house_game (created_at);
+ALTER TABLE house_game OWNER TO postgres;
+
+CREATE TABLE fairness_seed (
+    id SERIAL PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    server_seed VARCHAR(64) NOT NULL,
+    server_seed_hash VARCHAR(64) NOT NULL,
+    client_seed VARCHAR(255) NOT NULL,
+    nonce INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revealed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX fairness_seed_active_idx ON fairness_seed (player_id) WHERE revealed_at IS NULL;
+ALTER TABLE fairness_seed OWNER TO postgres;
+
+ALTER TABLE house_game ADD COLUMN IF NOT EXISTS seed_id INTEGER REFERENCES fairness_seed (id);
+ALTER TABLE house_game ALTER COLUMN server_seed DROP NOT NULL;
+
+COMMIT;
@@ -0,0 +1,22 @@
+-- 009_responsible_gaming.sql
+-- Adds the deposit, bet and loss limits players set themselves and their self-exclusion.
+
+BEGIN;
+
+CREATE TABLE player_limit (
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    kind VARCHAR(50) NOT NULL,
+    period VARCHAR(50) NOT NULL,
+    amount INTEGER NOT NULL DEFAULT 0,
+    pending_amount INTEGER,
+    pending_from TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (player_id, kind, period)
+);
+ALTER TABLE player_limit OWNER TO postgres;
+
+ALTER TABLE player ADD COLUMN excluded_until TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX transaction_player_reason_time_idx ON transaction (player_id, reason, timestamp);
+
+COMMIT;
@@ -0,0 +1,31 @@
+-- 010_wallets_and_currencies.sql
+-- Adds the currency of transactions and challenges, wallets for currencies other than play coins
+-- and the rules converting between currencies. Existing rows are play coins.
+
+BEGIN;
+
+ALTER TABLE transaction ADD COLUMN currency VARCHAR(10) NOT NULL DEFAULT 'COIN';
+ALTER TABLE challenge ADD COLUMN currency VARCHAR(10) NOT NULL DEFAULT 'COIN';
+
+CREATE TABLE wallet (
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    currency VARCHAR(10) NOT NULL,
+    balance INTEGER NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (player_id, currency)
+);
+ALTER TABLE wallet OWNER TO postgres;
+
+CREATE TABLE conversion_rule (
+    from_currency VARCHAR(10) NOT NULL,
+    to_currency VARCHAR(10) NOT NULL,
+    numerator INTEGER NOT NULL CHECK (numerator > 0),
+    denominator INTEGER NOT NULL CHECK (denominator > 0),
+    min_amount INTEGER NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (from_currency, to_currency)
+);
+ALTER TABLE conversion_rule OWNER TO postgres;
+
+COMMIT;
@@ -0,0 +1,53 @@
+-- 011_promotions.sql
+-- Adds referral codes, campaigns, the bonuses they credit and referrals.
+
+BEGIN;
+
+ALTER TABLE player ADD COLUMN referral_code VARCHAR(16) UNIQUE;
+
+CREATE TABLE campaign (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    code VARCHAR(50) UNIQUE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    referee_amount INTEGER NOT NULL DEFAULT 0,
+    wagering_multiplier INTEGER NOT NULL DEFAULT 0,
+    bonus_days INTEGER NOT NULL CHECK (bonus_days > 0),
+    required_games INTEGER NOT NULL DEFAULT 0,
+    max_redemptions INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE campaign OWNER TO postgres;
+
+CREATE TABLE bonus (
+    id SERIAL PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    campaign_id INTEGER NOT NULL REFERENCES campaign (id),
+    source VARCHAR(20) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    wagering_required INTEGER NOT NULL DEFAULT 0,
+    state VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    closed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX bonus_player_state_idx ON bonus (player_id, state);
+CREATE UNIQUE INDEX bonus_campaign_player_idx ON bonus (campaign_id, player_id) WHERE source <> 'referral';
+ALTER TABLE bonus OWNER TO postgres;
+
+CREATE TABLE referral (
+    id SERIAL PRIMARY KEY,
+    referrer_id INTEGER NOT NULL REFERENCES player (id),
+    referee_id INTEGER NOT NULL UNIQUE REFERENCES player (id),
+    campaign_id INTEGER NOT NULL REFERENCES campaign (id),
+    state VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rewarded_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX referral_referrer_idx ON referral (referrer_id, created_at);
+ALTER TABLE referral OWNER TO postgres;
+
+COMMIT;
@@ -0,0 +1,42 @@
+-- 012_challenge_holds.sql
+-- Adds holds for the bets of open challenges and the held part of balances. The bets of challenges open at the
+-- time were taken when they were created, they are given back with a refund and held instead.
+
+BEGIN;
+
+ALTER TABLE player ADD COLUMN held INTEGER NOT NULL DEFAULT 0 CHECK (held >= 0);
+ALTER TABLE wallet ADD COLUMN held INTEGER NOT NULL DEFAULT 0 CHECK (held >= 0);
+
+CREATE TABLE hold (
+    id SERIAL PRIMARY KEY,
+    player_id INTEGER NOT NULL REFERENCES player (id),
+    challenge_id INTEGER NOT NULL REFERENCES challenge (challenge_id),
+    currency VARCHAR(10) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount >= 0),
+    state VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    closed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX hold_challenge_idx ON hold (challenge_id) WHERE state = 'held';
+CREATE INDEX hold_player_state_idx ON hold (player_id, state, created_at);
+ALTER TABLE hold OWNER TO postgres;
+
+INSERT INTO hold (player_id, challenge_id, currency, amount, state)
+SELECT challenger_id, challenge_id, currency, bet, 'held'
+FROM challenge
+WHERE state IN ('pending', 'countered') AND tournament_id IS NULL;
+
+INSERT INTO transaction (amount, currency, reason, player_id, challenge_id)
+SELECT amount, currency, 'refund', player_id, challenge_id
+FROM hold
+WHERE amount > 0;
+
+UPDATE player SET balance = player.balance + held.amount, held = held.amount
+FROM (SELECT player_id, SUM(amount) AS amount FROM hold WHERE currency = 'COIN' GROUP BY player_id) held
+WHERE player.id = held.player_id;
+
+UPDATE wallet SET balance = wallet.balance + held.amount, held = held.amount
+FROM (SELECT player_id, currency, SUM(amount) AS amount FROM hold WHERE currency <> 'COIN' GROUP BY player_id, currency) held
+WHERE wallet.player_id = held.player_id AND wallet.currency = held.currency;
+
+COMMIT;
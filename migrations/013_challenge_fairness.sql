@@ -0,0 +1,10 @@
+-- 013_challenge_fairness.sql
+-- Moves of challenges left to chance are drawn from the challenger's seed pair, the pair and nonce are kept so the
+-- move can be recomputed. Challenges created before were drawn without one and keep both empty.
+
+BEGIN;
+
+ALTER TABLE challenge ADD COLUMN seed_id INTEGER REFERENCES fairness_seed (id);
+ALTER TABLE challenge ADD COLUMN nonce INTEGER;
+
+COMMIT;
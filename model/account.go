@@ -0,0 +1,29 @@
+package model
+
+// DeletedUsernamePrefix starts the anonymised username of closed accounts, it cannot be registered
+const DeletedUsernamePrefix = "deleted_"
+
+// PasswordChangeRequest changes the password of the logged-in player
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// UsernameChangeRequest renames the logged-in player
+type UsernameChangeRequest struct {
+	NewUsername string `json:"new_username" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+}
+
+// AccountCloseRequest closes the logged-in player's account
+type AccountCloseRequest struct {
+	Password string `json:"password" binding:"required"`
+	TOTPCode string `json:"totp_code"`
+}
+
+// AccountClosure summarises what happened to a closed account
+type AccountClosure struct {
+	Payout             int      `json:"payout"`
+	DeclinedChallenges []string `json:"declined_challenges"`
+	AnonymisedUsername string   `json:"-"`
+}
@@ -0,0 +1,85 @@
+package model
+
+import "time"
+
+const (
+	AuditLoginSucceeded     = "login_succeeded"
+	AuditLoginFailed        = "login_failed"
+	AuditLoginLocked        = "login_locked"
+	AuditLoginUnlocked      = "login_unlocked"
+	AuditRegistration       = "registration"
+	AuditTwoFactorEnabled   = "two_factor_enabled"
+	AuditTwoFactorDisabled  = "two_factor_disabled"
+	AuditTokenRevoked       = "token_revoked"
+	AuditPasswordChanged    = "password_changed"
+	AuditUsernameChanged    = "username_changed"
+	AuditAccountClosed      = "account_closed"
+	AuditFundsDeposited     = "funds_deposited"
+	AuditFundsWithdrawn     = "funds_withdrawn"
+	AuditChallengeCreated   = "challenge_created"
+	AuditChallengeSettled   = "challenge_settled"
+	AuditChallengeDeclined  = "challenge_declined"
+	AuditChallengeCountered = "challenge_countered"
+	AuditCounterAccepted    = "counter_accepted"
+	AuditCounterRejected    = "counter_rejected"
+	AuditPlayerBlocked      = "player_blocked"
+	AuditMatchmakingJoined  = "matchmaking_joined"
+	AuditMatchmakingLeft    = "matchmaking_left"
+	AuditTournamentCreated  = "tournament_created"
+	AuditTournamentJoined   = "tournament_joined"
+	AuditTournamentLeft     = "tournament_left"
+	AuditTournamentStarted  = "tournament_started"
+	AuditTournamentForfeit  = "tournament_forfeit"
+	AuditTournamentEnded    = "tournament_ended"
+	AuditFreeForAllCreated  = "free_for_all_created"
+	AuditFreeForAllJoined   = "free_for_all_joined"
+	AuditFreeForAllSettled  = "free_for_all_settled"
+	AuditHouseGamePlayed    = "house_game_played"
+	AuditHouseFunded        = "house_funded"
+	AuditSeedRotated        = "seed_rotated"
+	AuditLimitChanged       = "limit_changed"
+	AuditSelfExcluded       = "self_excluded"
+	AuditTransferRequested  = "transfer_requested"
+	AuditTransferSent       = "transfer_sent"
+	AuditTransferCancelled  = "transfer_cancelled"
+	AuditCurrencyConverted  = "currency_converted"
+	AuditBalanceAdjusted    = "balance_adjusted"
+	AuditBonusCredited      = "bonus_credited"
+	AuditBonusExpired       = "bonus_expired"
+	AuditReferralRewarded   = "referral_rewarded"
+	AuditAdminAction        = "admin_action"
+)
+
+// AuditEvent is a single entry of the append-only audit log.
+// Every entry carries the hash of the previous one so that rewriting history breaks the chain.
+type AuditEvent struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Subject   string    `json:"subject"`
+	IP        string    `json:"ip"`
+	Details   string    `json:"details"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// AuditEventFilter narrows down audit log queries, zero values are ignored
+type AuditEventFilter struct {
+	Actor   string    `form:"actor"`
+	Action  string    `form:"action"`
+	Subject string    `form:"subject"`
+	IP      string    `form:"ip"`
+	From    time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To      time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	AfterID int64     `form:"after_id"`
+	Limit   int       `form:"limit"`
+}
+
+// AuditVerification is the outcome of walking the audit hash chain
+type AuditVerification struct {
+	Valid         bool   `json:"valid"`
+	CheckedEvents int64  `json:"checked_events"`
+	BrokenAtID    int64  `json:"broken_at_id,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+}
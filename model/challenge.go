@@ -8,37 +8,144 @@ const (
 	ChoiceScissors = 3
 )
 
+// A countered challenge waits for the challenger to accept or reject the opponent's bet,
+// like a pending one it is still open
 const (
-	ChallengePending  = "pending"
-	ChallengeSettled  = "settled"
-	ChallengeDeclined = "declined"
+	ChallengePending   = "pending"
+	ChallengeCountered = "countered"
+	ChallengeSettled   = "settled"
+	ChallengeDeclined  = "declined"
+	ChallengeExpired   = "expired"
 )
 
-// ChallengeRequest creates a challenge request
+// ChallengeStates lists every state a challenge can be in
+var ChallengeStates = []string{ChallengePending, ChallengeCountered, ChallengeSettled, ChallengeDeclined, ChallengeExpired}
+
+// OpenChallengeStates are the states in which the challenger's bet is held
+var OpenChallengeStates = []string{ChallengePending, ChallengeCountered}
+
+func IsChallengeState(state string) bool {
+	for _, known := range ChallengeStates {
+		if known == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Roles of a player in a challenge, seen from the player asking
+const (
+	ChallengeRoleSent     = "sent"
+	ChallengeRoleReceived = "received"
+)
+
+// ChallengeRequest creates a challenge request, the rule set defaults to classic and the currency of the bet
+// to play coins. Both players bet in that currency.
 type ChallengeRequest struct {
 	Opponent string `json:"opponent" binding:"required"`
 	Choice   int    `json:"choice" binding:"required"`
-	Bet      int    `json:"bet" binding:"required"`
+	Bet      Money  `json:"bet"`
+	RuleSet  string `json:"rule_set"`
+	// SeedID and Nonce are the seed pair and nonce a move left to chance was drawn with, 0 when the player chose it
+	SeedID int `json:"-"`
+	Nonce  int `json:"-"`
 }
 
 // Challenge takes a challenge request and adds it to the pending challenges
 type Challenge struct {
-	ChallengeId string `json:"challenge_id" binding:"required"`
-	Challenger  string `json:"challenger" binding:"required"`
+	ChallengeId  string `json:"challenge_id" binding:"required"`
+	ChallengerID int    `json:"-"`
+	OpponentID   int    `json:"-"`
+	Challenger   string `json:"challenger" binding:"required"`
 	ChallengeRequest
-	State       string    `json:"state" binding:"required"`
-	TimeCreated time.Time `json:"time_created" binding:"required"`
-	TimeSettled time.Time `json:"time_settled"`
-	Winner      string    `json:"winner"`
+	State          string    `json:"state" binding:"required"`
+	TimeCreated    time.Time `json:"time_created" binding:"required"`
+	TimeSettled    time.Time `json:"time_settled"`
+	WinnerID       int       `json:"-"`
+	Winner         string    `json:"winner"`
+	OpponentChoice int       `json:"-"`
+	TournamentID   int       `json:"-"`
+	CounterBet     *Money    `json:"counter_bet,omitempty"`
+}
+
+// ChallengeSettlement is the outcome of a game to book, a WinnerID of 0 is a draw and the Rake only applies to a win
+type ChallengeSettlement struct {
+	ChallengerID   int
+	OpponentID     int
+	Choice         int
+	OpponentChoice int
+	RuleSet        string
+	Bet            Money
+	WinnerID       int
+	Rake           int
 }
 
 type PendingChallenge struct {
 	ChallengeId string    `json:"challenge_id"`
 	Challenger  string    `json:"challenger" `
-	Bet         int       `json:"bet"`
+	Bet         Money     `json:"bet"`
 	TimeCreated time.Time `json:"time_created"`
 }
 
+// ChallengeFilter narrows down and pages through a player's challenges, zero values are ignored
+type ChallengeFilter struct {
+	States   []string  `form:"state"`
+	Role     string    `form:"role"`
+	Opponent string    `form:"opponent"`
+	From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor   string    `form:"cursor"`
+	Limit    int       `form:"limit"`
+}
+
+// ChallengeCursor is the position of the last challenge of a page
+type ChallengeCursor struct {
+	TimeCreated time.Time `json:"t"`
+	ID          int       `json:"id"`
+}
+
+// ChallengeSummary is a challenge as listed in a player's history, the moves are only part of the detail
+type ChallengeSummary struct {
+	ChallengeId  string     `json:"challenge_id"`
+	ChallengerID int        `json:"-"`
+	OpponentID   int        `json:"-"`
+	Role         string     `json:"role"`
+	Challenger   string     `json:"challenger"`
+	Opponent     string     `json:"opponent"`
+	Bet          Money      `json:"bet"`
+	RuleSet      string     `json:"rule_set"`
+	State        string     `json:"state"`
+	TimeCreated  time.Time  `json:"time_created"`
+	TimeSettled  *time.Time `json:"time_settled,omitempty"`
+	Winner       string     `json:"winner,omitempty"`
+	CounterBet   *Money     `json:"counter_bet,omitempty"`
+}
+
+// ChallengePage is one page of a player's challenges, NextCursor is empty on the last page
+type ChallengePage struct {
+	Challenges []ChallengeSummary `json:"challenges"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// ChallengeTransaction is a funds movement caused by a challenge, balances are left out
+// because the detail is shown to both participants
+type ChallengeTransaction struct {
+	ID        int       `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Username  string    `json:"username"`
+	Reason    string    `json:"reason"`
+	Amount    Money     `json:"amount"`
+}
+
+// ChallengeDetail is a single challenge with its moves and the transactions it caused
+type ChallengeDetail struct {
+	ChallengeSummary
+	ChallengerChoice string                 `json:"challenger_choice,omitempty"`
+	OpponentChoice   string                 `json:"opponent_choice,omitempty"`
+	Transactions     []ChallengeTransaction `json:"transactions"`
+	Negotiation      []ChallengeOffer       `json:"negotiation"`
+}
+
 type ChallengeDeclineRequest struct {
 	ChallengeId string `json:"challenge_id" binding:"required"`
 }
@@ -48,12 +155,81 @@ type ChallengeSettleRequest struct {
 	Choice      int    `json:"bet_choice"`
 }
 
+// Steps of a bet negotiation
+const (
+	OfferCounter  = "counter"
+	OfferAccepted = "accepted"
+	OfferRejected = "rejected"
+)
+
+// ChallengeCounterRequest proposes a different bet to the challenger
+type ChallengeCounterRequest struct {
+	ChallengeId string `json:"challenge_id" binding:"required"`
+	Bet         Money  `json:"bet"`
+}
+
+// ChallengeCounterReplyRequest accepts or rejects the opponent's counter-offer
+type ChallengeCounterReplyRequest struct {
+	ChallengeId string `json:"challenge_id" binding:"required"`
+}
+
+// ChallengeOffer is a step of the bet negotiation, PreviousBet is the bet held before it
+type ChallengeOffer struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Action      string    `json:"action"`
+	Bet         Money     `json:"bet"`
+	PreviousBet Money     `json:"previous_bet"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RematchRequest plays a settled challenge again with the caller as challenger. Without a choice the move
+// is picked by the strategy, which defaults to repeating the caller's move.
+type RematchRequest struct {
+	Choice    int    `json:"choice"`
+	Strategy  string `json:"strategy"`
+	DoubleBet bool   `json:"double_bet"`
+}
+
+// ChallengeResponse is the outcome of a settled challenge, the rake is already taken from WinAmount
 type ChallengeResponse struct {
 	Winner    string `json:"winner"`
-	WinAmount int    `json:"winAmount"`
+	WinAmount Money  `json:"winAmount"`
+	Rake      Money  `json:"rake"`
 	Message   string `json:"message"`
 }
 
+// DetermineWinner plays the challenger's move against the opponent's, it returns
+// "challenger", "opponent" or "draw"
+func DetermineWinner(challengerChoice int, opponentChoice int) string {
+	if challengerChoice == opponentChoice {
+		return "draw"
+	}
+
+	switch challengerChoice {
+	case ChoiceRock:
+		if opponentChoice == ChoiceScissors {
+			return "challenger"
+		} else {
+			return "opponent"
+		}
+	case ChoicePaper:
+		if opponentChoice == ChoiceRock {
+			return "challenger"
+		} else {
+			return "opponent"
+		}
+	case ChoiceScissors:
+		if opponentChoice == ChoicePaper {
+			return "challenger"
+		} else {
+			return "opponent"
+		}
+	}
+
+	return ""
+}
+
 func ChoiceToString(choice int) string {
 	switch choice {
 	case 1:
@@ -0,0 +1,65 @@
+package model
+
+import "time"
+
+// Strategies picking the move of a challenge fired from a template or a rematch.
+// The last moves are taken from the latest settled challenge between the two players.
+const (
+	StrategyFixed      = "fixed"
+	StrategyRandom     = "random"
+	StrategyRepeatLast = "repeat_last"
+	StrategyBeatLast   = "beat_last"
+)
+
+// MoveStrategies lists every strategy a move can be picked by
+var MoveStrategies = []string{StrategyFixed, StrategyRandom, StrategyRepeatLast, StrategyBeatLast}
+
+func IsMoveStrategy(strategy string) bool {
+	for _, known := range MoveStrategies {
+		if known == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// ChallengeTemplateRequest saves a challenge to fire later, Choice is only used by the fixed strategy
+type ChallengeTemplateRequest struct {
+	Name     string `json:"name" binding:"required"`
+	Opponent string `json:"opponent" binding:"required"`
+	Bet      int    `json:"bet" binding:"required"`
+	RuleSet  string `json:"rule_set"`
+	Strategy string `json:"strategy" binding:"required"`
+	Choice   int    `json:"choice"`
+}
+
+type ChallengeTemplate struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	OpponentID int       `json:"-"`
+	Opponent   string    `json:"opponent"`
+	Bet        int       `json:"bet"`
+	RuleSet    string    `json:"rule_set"`
+	Strategy   string    `json:"strategy"`
+	Choice     int       `json:"choice,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PickMove returns the move the strategy plays. lastOwn and lastTheirs are the moves of the latest game
+// between the players, 0 when they have not played yet. It returns 0 when the move is left to chance, it has to be
+// drawn from the player's seed pair then.
+func PickMove(strategy string, fixed int, lastOwn int, lastTheirs int) int {
+	switch {
+	case strategy == StrategyFixed:
+		return fixed
+	case strategy == StrategyRepeatLast && lastOwn != 0:
+		return lastOwn
+	case strategy == StrategyBeatLast && lastTheirs != 0:
+		for move, beaten := range beatenBy {
+			if beaten == lastTheirs {
+				return move
+			}
+		}
+	}
+	return 0
+}
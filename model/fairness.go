@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+// FairnessSeed is a player's seed pair. The server seed stays secret while the pair is in use, only its hash is
+// published. Nonce counts the outcomes drawn from the pair, the next one is drawn with the current value.
+// Rotating the pair reveals the server seed so every outcome drawn from it can be recomputed.
+type FairnessSeed struct {
+	ID             int        `json:"id"`
+	PlayerID       int        `json:"-"`
+	ServerSeed     string     `json:"server_seed,omitempty"`
+	ServerSeedHash string     `json:"server_seed_hash"`
+	ClientSeed     string     `json:"client_seed"`
+	Nonce          int        `json:"nonce"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RevealedAt     *time.Time `json:"revealed_at,omitempty"`
+}
+
+// FairnessRotateRequest starts a new seed pair, the client seed is random when left out
+type FairnessRotateRequest struct {
+	ClientSeed string `json:"client_seed"`
+}
+
+// FairnessRotation is the revealed seed pair and the one replacing it
+type FairnessRotation struct {
+	Revealed FairnessSeed `json:"revealed"`
+	Active   FairnessSeed `json:"active"`
+}
+
+// FairnessVerifyRequest recomputes an outcome from revealed seeds. ServerSeedHash is the commitment to check the
+// server seed against. Game compares with one of the caller's house games instead and Challenge with one of their
+// challenges whose move was drawn, its commitment, client seed and nonce are used unless they are given.
+type FairnessVerifyRequest struct {
+	ServerSeed     string `form:"server_seed" binding:"required"`
+	ServerSeedHash string `form:"server_seed_hash"`
+	ClientSeed     string `form:"client_seed"`
+	Nonce          *int   `form:"nonce"`
+	Game           int    `form:"game"`
+	Challenge      int    `form:"challenge"`
+}
+
+// FairnessVerification is the recomputed outcome. HashMatches is only set when there was a commitment to check
+// and Matches only when there was a recorded move to compare with.
+type FairnessVerification struct {
+	ServerSeedHash string `json:"server_seed_hash"`
+	HashMatches    *bool  `json:"hash_matches,omitempty"`
+	ClientSeed     string `json:"client_seed"`
+	Nonce          int    `json:"nonce"`
+	Move           string `json:"move"`
+	RecordedMove   string `json:"recorded_move,omitempty"`
+	Matches        *bool  `json:"matches,omitempty"`
+}
@@ -0,0 +1,146 @@
+package model
+
+import "time"
+
+const (
+	FreeForAllOpen      = "open"
+	FreeForAllSettled   = "settled"
+	FreeForAllDraw      = "draw"
+	FreeForAllCancelled = "cancelled"
+)
+
+// FreeForAllStates lists every state a free-for-all round can be in
+var FreeForAllStates = []string{FreeForAllOpen, FreeForAllSettled, FreeForAllDraw, FreeForAllCancelled}
+
+func IsFreeForAllState(state string) bool {
+	for _, known := range FreeForAllStates {
+		if known == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Rules deciding the winning moves of a free-for-all round.
+// Classic only has a winner when exactly two different moves were played.
+// Beats most lets the moves that beat the most other players win, so a round with all three moves can still be won.
+const (
+	FreeForAllRuleClassic   = "classic"
+	FreeForAllRuleBeatsMost = "beats_most"
+)
+
+// FreeForAllRules lists every rule a round can be played by
+var FreeForAllRules = []string{FreeForAllRuleClassic, FreeForAllRuleBeatsMost}
+
+func IsFreeForAllRule(rule string) bool {
+	for _, known := range FreeForAllRules {
+		if known == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Results of a participant, cancelled rounds refund everybody
+const (
+	FreeForAllResultWin      = "win"
+	FreeForAllResultLoss     = "loss"
+	FreeForAllResultDraw     = "draw"
+	FreeForAllResultRefunded = "refunded"
+)
+
+// FreeForAllRequest opens a round, the creator joins it with their move right away.
+// Rule defaults to ffa_default_rule.
+type FreeForAllRequest struct {
+	Stake           int    `json:"stake" binding:"required"`
+	Choice          int    `json:"choice" binding:"required"`
+	MinPlayers      int    `json:"min_players"`
+	MaxPlayers      int    `json:"max_players" binding:"required"`
+	DeadlineMinutes int    `json:"deadline_minutes" binding:"required"`
+	Rule            string `json:"rule"`
+}
+
+// FreeForAllJoinRequest joins a round with the player's move, it cannot be changed afterwards
+type FreeForAllJoinRequest struct {
+	Choice int `json:"choice" binding:"required"`
+}
+
+// FreeForAll is a round between three or more players with the same stake. It is played when it is full
+// or its deadline passes, whichever comes first.
+type FreeForAll struct {
+	ID           int                     `json:"id"`
+	Creator      string                  `json:"creator"`
+	Stake        int                     `json:"stake"`
+	MinPlayers   int                     `json:"min_players"`
+	MaxPlayers   int                     `json:"max_players"`
+	Rule         string                  `json:"rule"`
+	State        string                  `json:"state"`
+	Players      int                     `json:"players"`
+	Deadline     time.Time               `json:"deadline"`
+	CreatedAt    time.Time               `json:"created_at"`
+	SettledAt    *time.Time              `json:"settled_at,omitempty"`
+	Participants []FreeForAllParticipant `json:"participants,omitempty"`
+}
+
+// FreeForAllParticipant is a player of a round with their move, result and payout.
+// Moves of other players are hidden while the round is open.
+type FreeForAllParticipant struct {
+	PlayerID int       `json:"-"`
+	Username string    `json:"username"`
+	Choice   int       `json:"-"`
+	Move     string    `json:"move,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Payout   int       `json:"payout"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// beatenBy maps every move to the move it beats
+var beatenBy = map[int]int{
+	ChoiceRock:     ChoiceScissors,
+	ChoicePaper:    ChoiceRock,
+	ChoiceScissors: ChoicePaper,
+}
+
+// DetermineFreeForAllWinners plays all moves of a round against each other by the rule and returns
+// the winning moves, an empty result is a draw
+func DetermineFreeForAllWinners(choices []int, rule string) map[int]bool {
+	counts := map[int]int{}
+	for _, choice := range choices {
+		counts[choice]++
+	}
+
+	winners := map[int]bool{}
+	// Everybody picked the same move
+	if len(counts) < 2 {
+		return winners
+	}
+
+	switch rule {
+	case FreeForAllRuleClassic:
+		if len(counts) == 2 {
+			for choice := range counts {
+				if counts[beatenBy[choice]] > 0 {
+					winners[choice] = true
+				}
+			}
+		}
+	case FreeForAllRuleBeatsMost:
+		best := 0
+		for choice := range counts {
+			if beaten := counts[beatenBy[choice]]; beaten > best {
+				best = beaten
+			}
+		}
+		for choice := range counts {
+			if counts[beatenBy[choice]] == best {
+				winners[choice] = true
+			}
+		}
+		// Every move beats as many players as the others
+		if len(winners) == len(counts) {
+			return map[int]bool{}
+		}
+	}
+
+	return winners
+}
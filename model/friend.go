@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+// States of a friendship, seen from the player asking
+const (
+	FriendAccepted = "accepted"
+	FriendIncoming = "incoming"
+	FriendOutgoing = "outgoing"
+)
+
+// FriendRequest names the other player of a friend request, accept or block
+type FriendRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// Friend is a friendship or a friend request of the player asking
+type Friend struct {
+	Username string    `json:"username"`
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+}
+
+// BlockedPlayer is a player on the block list
+type BlockedPlayer struct {
+	Username string    `json:"username"`
+	Since    time.Time `json:"since"`
+}
+
+// ChallengeSettingsRequest changes who can challenge the logged-in player
+type ChallengeSettingsRequest struct {
+	FriendsOnly *bool `json:"friends_only" binding:"required"`
+}
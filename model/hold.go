@@ -0,0 +1,30 @@
+package model
+
+import "time"
+
+// A hold keeps the challenger's bet aside while the challenge is open. It is captured, and the bet recorded as a
+// transaction, when the challenge is settled and released without a transaction when it is declined or expires.
+const (
+	HoldActive   = "held"
+	HoldReleased = "released"
+	HoldCaptured = "captured"
+)
+
+// Hold is an amount set aside from a player's balance for an open challenge
+type Hold struct {
+	ID          int        `json:"id"`
+	ChallengeID int        `json:"challenge_id"`
+	Amount      Money      `json:"amount"`
+	State       string     `json:"state"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ClosedAt    *time.Time `json:"closed_at,omitempty"`
+}
+
+// BalanceSummary splits a player's balance in a currency into what is held by open challenges and what is
+// available to bet, send or withdraw, Holds are the active holds
+type BalanceSummary struct {
+	Balance   Money  `json:"balance"`
+	Held      Money  `json:"held"`
+	Available Money  `json:"available"`
+	Holds     []Hold `json:"holds"`
+}
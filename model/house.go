@@ -0,0 +1,78 @@
+package model
+
+import "time"
+
+// Results of a house game, seen from the player
+const (
+	HouseResultWin  = "win"
+	HouseResultLoss = "loss"
+	HouseResultDraw = "draw"
+)
+
+// HouseGameRequest bets against the house
+type HouseGameRequest struct {
+	Bet    int `json:"bet" binding:"required"`
+	Choice int `json:"choice" binding:"required"`
+}
+
+// HouseGame is a game against the house. The house's move is drawn from the player's seed pair,
+// so it can be recomputed once the server seed is revealed. Until then ServerSeed is empty.
+type HouseGame struct {
+	ID             int       `json:"id"`
+	PlayerID       int       `json:"-"`
+	SeedID         int       `json:"-"`
+	Bet            int       `json:"bet"`
+	Choice         int       `json:"-"`
+	HouseChoice    int       `json:"-"`
+	Move           string    `json:"move"`
+	HouseMove      string    `json:"house_move"`
+	Result         string    `json:"result"`
+	Payout         int       `json:"payout"`
+	ServerSeed     string    `json:"server_seed,omitempty"`
+	ServerSeedHash string    `json:"server_seed_hash"`
+	ClientSeed     string    `json:"client_seed"`
+	Nonce          int       `json:"nonce"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// HouseFundsRequest moves money in (positive) or out (negative) of the house bankroll
+type HouseFundsRequest struct {
+	Amount int `json:"amount" binding:"required"`
+}
+
+// HouseReportRequest limits the report to games played in [From, To), zero values are ignored
+type HouseReportRequest struct {
+	From time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To   time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+}
+
+// HouseReport is the bankroll and the house's profit and loss over a period, seen from the house
+type HouseReport struct {
+	Bankroll      int        `json:"bankroll"`
+	From          *time.Time `json:"from,omitempty"`
+	To            *time.Time `json:"to,omitempty"`
+	Games         int        `json:"games"`
+	PlayerWins    int        `json:"player_wins"`
+	PlayerLosses  int        `json:"player_losses"`
+	Draws         int        `json:"draws"`
+	Wagered       int        `json:"wagered"`
+	PaidOut       int        `json:"paid_out"`
+	ProfitAndLoss int        `json:"profit_and_loss"`
+}
+
+// RevenueReport sums up the rake the house collected over a period, per rule set and per day
+type RevenueReport struct {
+	From       *time.Time     `json:"from,omitempty"`
+	To         *time.Time     `json:"to,omitempty"`
+	Total      int            `json:"total"`
+	Challenges int            `json:"challenges"`
+	ByRuleSet  map[string]int `json:"by_rule_set"`
+	Days       []RevenueDay   `json:"days"`
+}
+
+// RevenueDay is the rake collected on a single day (UTC)
+type RevenueDay struct {
+	Day        string `json:"day"`
+	Amount     int    `json:"amount"`
+	Challenges int    `json:"challenges"`
+}
@@ -0,0 +1,71 @@
+package model
+
+import "time"
+
+// Kinds of responsible gaming limits. Deposits are summed up, bets are the stakes placed and losses are the stakes
+// placed less the winnings, refunds and prizes received, all over a rolling window.
+const (
+	LimitDeposit = "deposit"
+	LimitBet     = "bet"
+	LimitLoss    = "loss"
+)
+
+// LimitKinds lists every kind of limit a player can set
+var LimitKinds = []string{LimitDeposit, LimitBet, LimitLoss}
+
+func IsLimitKind(kind string) bool {
+	for _, known := range LimitKinds {
+		if known == kind {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	LimitDaily   = "daily"
+	LimitWeekly  = "weekly"
+	LimitMonthly = "monthly"
+)
+
+// LimitPeriods lists every window a limit can be set for
+var LimitPeriods = []string{LimitDaily, LimitWeekly, LimitMonthly}
+
+func IsLimitPeriod(period string) bool {
+	for _, known := range LimitPeriods {
+		if known == period {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitRequest sets a limit, an amount of 0 removes it. Lowering a limit applies right away,
+// raising or removing it only after the cooling-off period.
+type LimitRequest struct {
+	Kind   string `json:"kind" binding:"required"`
+	Period string `json:"period" binding:"required"`
+	Amount int    `json:"amount"`
+}
+
+// PlayerLimit is a limit in force, 0 when there is none, and a pending change waiting for the cooling-off period.
+// Used is how much of it the window has used up so far.
+type PlayerLimit struct {
+	Kind          string     `json:"kind"`
+	Period        string     `json:"period"`
+	Amount        int        `json:"amount"`
+	Used          int        `json:"used"`
+	PendingAmount *int       `json:"pending_amount,omitempty"`
+	PendingFrom   *time.Time `json:"pending_from,omitempty"`
+}
+
+// SelfExclusionRequest blocks the player from betting and depositing for the given number of days
+type SelfExclusionRequest struct {
+	Days int `json:"days" binding:"required"`
+}
+
+// ResponsibleGaming is a player's limits and self-exclusion
+type ResponsibleGaming struct {
+	Limits        []PlayerLimit `json:"limits"`
+	ExcludedUntil *time.Time    `json:"excluded_until,omitempty"`
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+const (
+	LoginAttemptByUsername = "username"
+	LoginAttemptByIP       = "ip"
+)
+
+// LoginAttempt tracks consecutive failed logins for a username or a client ip
+type LoginAttempt struct {
+	KeyType      string    `json:"key_type"`
+	Key          string    `json:"key"`
+	Failures     int       `json:"failures"`
+	LastFailure  time.Time `json:"last_failure"`
+	BlockedUntil time.Time `json:"blocked_until"`
+	LockedUntil  time.Time `json:"locked_until"`
+}
+
+// LoginUnlockRequest clears the failed attempts of a username and/or an ip
+type LoginUnlockRequest struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+// RuleSetClassic is plain rock paper scissors, the only rule set so far
+const RuleSetClassic = "classic"
+
+// RuleSets lists every rule set players can queue for
+var RuleSets = []string{RuleSetClassic}
+
+func IsRuleSet(ruleSet string) bool {
+	for _, known := range RuleSets {
+		if known == ruleSet {
+			return true
+		}
+	}
+	return false
+}
+
+// States of a player in matchmaking
+const (
+	MatchmakingIdle     = "idle"
+	MatchmakingQueued   = "queued"
+	MatchmakingMatched  = "matched"
+	MatchmakingTimedOut = "timed_out"
+)
+
+// MatchmakingJoinRequest queues the player for a quick game, the move is committed up front
+// because the game is played as soon as an opponent is found
+type MatchmakingJoinRequest struct {
+	Bet     Money  `json:"bet"`
+	Choice  int    `json:"choice" binding:"required"`
+	RuleSet string `json:"rule_set"`
+}
+
+// MatchmakingTicket is a player waiting in the queue, the bet is held from joining until the game or a refund
+type MatchmakingTicket struct {
+	PlayerID          int       `json:"-"`
+	Username          string    `json:"-"`
+	Bet               Money     `json:"bet"`
+	Choice            int       `json:"-"`
+	RuleSet           string    `json:"rule_set"`
+	Rating            int       `json:"rating"`
+	JoinedAt          time.Time `json:"joined_at"`
+	HoldTransactionID int       `json:"-"`
+}
+
+// MatchmakingStatus tells a player where they are in matchmaking. Once out of the queue
+// it describes how the last ticket ended until the player joins again.
+type MatchmakingStatus struct {
+	State        string             `json:"state"`
+	Ticket       *MatchmakingTicket `json:"ticket,omitempty"`
+	RatingWindow int                `json:"rating_window,omitempty"`
+	ChallengeID  int                `json:"challenge_id,omitempty"`
+	Opponent     string             `json:"opponent,omitempty"`
+	Winner       string             `json:"winner,omitempty"`
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CurrencyCoin is the play coin currency, its wallet is the player's balance. Every other currency is held
+// in a wallet of its own.
+const CurrencyCoin = "COIN"
+
+// ErrCurrencyMismatch is returned when amounts of different currencies are combined
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// Money is an amount in minor units of a currency, it is never a float. In JSON the amount is a string,
+// e.g. {"currency": "COIN", "amount": "150"}, so clients do not lose precision parsing it. The currency
+// defaults to play coins when it is left out.
+type Money struct {
+	Currency string
+	Amount   int
+}
+
+// Coins returns amount play coins
+func Coins(amount int) Money {
+	return Money{Currency: CurrencyCoin, Amount: amount}
+}
+
+func (money Money) IsZero() bool {
+	return money.Amount == 0
+}
+
+func (money Money) Add(other Money) (Money, error) {
+	if money.Currency != other.Currency {
+		return money, ErrCurrencyMismatch
+	}
+	return Money{Currency: money.Currency, Amount: money.Amount + other.Amount}, nil
+}
+
+func (money Money) Sub(other Money) (Money, error) {
+	if money.Currency != other.Currency {
+		return money, ErrCurrencyMismatch
+	}
+	return Money{Currency: money.Currency, Amount: money.Amount - other.Amount}, nil
+}
+
+func (money Money) Negate() Money {
+	return Money{Currency: money.Currency, Amount: -money.Amount}
+}
+
+func (money Money) String() string {
+	return fmt.Sprintf("%d %s", money.Amount, money.Currency)
+}
+
+type moneyJSON struct {
+	Currency string `json:"currency"`
+	Amount   string `json:"amount"`
+}
+
+func (money Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Currency: money.Currency, Amount: strconv.Itoa(money.Amount)})
+}
+
+// UnmarshalJSON reads the amount from a string of minor units, JSON numbers and anything that is not a whole
+// number are refused
+func (money *Money) UnmarshalJSON(data []byte) error {
+	var decoded moneyJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fmt.Errorf("amount must be a string of minor units: %v", err)
+	}
+
+	amount, err := strconv.Atoi(decoded.Amount)
+	if err != nil {
+		return fmt.Errorf("amount must be a whole number of minor units: %q", decoded.Amount)
+	}
+
+	money.Currency = decoded.Currency
+	if money.Currency == "" {
+		money.Currency = CurrencyCoin
+	}
+	money.Amount = amount
+	return nil
+}
+
+// Wallet is a player's balance in one currency, Decimals tells where to put the point in its amount
+type Wallet struct {
+	Name     string `json:"name"`
+	Decimals int    `json:"decimals"`
+	Balance  Money  `json:"balance"`
+}
+
+// ConversionRule lets players convert From into To at Numerator/Denominator, the converted amount is rounded
+// down and anything converting to nothing is refused
+type ConversionRule struct {
+	From        string    `json:"from" binding:"required"`
+	To          string    `json:"to" binding:"required"`
+	Numerator   int       `json:"numerator" binding:"required"`
+	Denominator int       `json:"denominator" binding:"required"`
+	MinAmount   int       `json:"min_amount"`
+	Enabled     bool      `json:"enabled"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Convert returns what amount of the rule's From currency is worth in its To currency
+func (rule ConversionRule) Convert(amount int) int {
+	return amount * rule.Numerator / rule.Denominator
+}
+
+// ConversionRequest converts Amount into the To currency
+type ConversionRequest struct {
+	Amount Money  `json:"amount"`
+	To     string `json:"to" binding:"required"`
+}
+
+// Conversion is the result of a conversion, both sides are recorded as transactions
+type Conversion struct {
+	Debited  Money `json:"debited"`
+	Credited Money `json:"credited"`
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMoneyMarshalJSON(t *testing.T) {
+	tests := []struct {
+		money Money
+		json  string
+	}{
+		{Coins(150), `{"currency":"COIN","amount":"150"}`},
+		{Coins(0), `{"currency":"COIN","amount":"0"}`},
+		{Coins(-25), `{"currency":"COIN","amount":"-25"}`},
+		{Money{Currency: "TICKET", Amount: 3}, `{"currency":"TICKET","amount":"3"}`},
+		// Beyond the 2^53 a float keeps exactly
+		{Coins(9007199254740993), `{"currency":"COIN","amount":"9007199254740993"}`},
+	}
+
+	for _, test := range tests {
+		encoded, err := json.Marshal(test.money)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", test.money, err)
+		}
+		if string(encoded) != test.json {
+			t.Errorf("Marshal(%v) = %s, want %s", test.money, encoded, test.json)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json  string
+		money Money
+	}{
+		{`{"currency":"COIN","amount":"150"}`, Coins(150)},
+		{`{"currency":"TICKET","amount":"3"}`, Money{Currency: "TICKET", Amount: 3}},
+		{`{"currency":"COIN","amount":"-25"}`, Coins(-25)},
+		{`{"currency":"COIN","amount":"9007199254740993"}`, Coins(9007199254740993)},
+		// The currency defaults to play coins
+		{`{"amount":"10"}`, Coins(10)},
+		{`{"currency":"","amount":"10"}`, Coins(10)},
+	}
+
+	for _, test := range tests {
+		var money Money
+		if err := json.Unmarshal([]byte(test.json), &money); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", test.json, err)
+		}
+		if money != test.money {
+			t.Errorf("Unmarshal(%s) = %v, want %v", test.json, money, test.money)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSONRefused(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"number", `{"currency":"COIN","amount":150}`},
+		{"float", `{"currency":"COIN","amount":1.5}`},
+		{"fraction", `{"currency":"COIN","amount":"1.5"}`},
+		{"exponent", `{"currency":"COIN","amount":"1e3"}`},
+		{"empty amount", `{"currency":"COIN","amount":""}`},
+		{"missing amount", `{"currency":"COIN"}`},
+		{"not a number", `{"currency":"COIN","amount":"ten"}`},
+		{"padded", `{"currency":"COIN","amount":" 10"}`},
+		{"null amount", `{"currency":"COIN","amount":null}`},
+		{"bare number", `150`},
+		{"bare string", `"150"`},
+	}
+
+	for _, test := range tests {
+		var money Money
+		if err := json.Unmarshal([]byte(test.json), &money); err == nil {
+			t.Errorf("%s: Unmarshal(%s) = %v, want an error", test.name, test.json, money)
+		}
+	}
+}
+
+func TestMoneyRoundTrip(t *testing.T) {
+	for _, money := range []Money{Coins(0), Coins(1), Coins(-1), Money{Currency: "TICKET", Amount: 42}} {
+		encoded, err := json.Marshal(money)
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", money, err)
+		}
+		var decoded Money
+		if err = json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", encoded, err)
+		}
+		if decoded != money {
+			t.Errorf("round trip of %v = %v", money, decoded)
+		}
+	}
+}
+
+func TestMoneyInStruct(t *testing.T) {
+	var request TransferRequest
+	err := json.Unmarshal([]byte(`{"recipient":"bob","amount":{"currency":"COIN","amount":"100"}}`), &request)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if request.Amount != Coins(100) {
+		t.Errorf("Amount = %v, want %v", request.Amount, Coins(100))
+	}
+
+	err = json.Unmarshal([]byte(`{"recipient":"bob","amount":100}`), &request)
+	if err == nil {
+		t.Error("Unmarshal accepted a plain number as amount")
+	}
+}
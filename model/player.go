@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // PlayerLoginRequest data that is used to attempt a player login
 type PlayerLoginRequest struct {
 	Username string `json:"username" binding:"required"`
@@ -8,16 +10,73 @@ type PlayerLoginRequest struct {
 
 // Player stores and manages all player data. The player registers with a port.
 type Player struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	Salt     string `json:"salt"`
-	Balance  int    `json:"balance"`
+	ID           int    `json:"id"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	Salt         string `json:"salt"`
+	Balance      Money  `json:"balance"`
+	TOTPSecret   string `json:"-"`
+	TOTPEnabled  bool   `json:"totp_enabled"`
+	TOTPLastStep int64  `json:"-"`
 }
 
 // PlayerRegistrationRequest data required to register a player
 type PlayerRegistrationRequest struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
-	Deposit  int    `json:"deposit" binding:"required"`
+	Deposit  Money  `json:"deposit"`
+	// ReferralCode is the code of the player who referred them, if any
+	ReferralCode string `json:"referral_code"`
+}
+
+const (
+	PlayerSortUsername = "username"
+	PlayerSortRating   = "rating"
+	PlayerSortActivity = "activity"
+)
+
+// PlayerSearchRequest searches open accounts by username, sorted by username unless sort says otherwise
+type PlayerSearchRequest struct {
+	Query  string `form:"q"`
+	Sort   string `form:"sort"`
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit"`
+}
+
+// PlayerCursor is the position of the last player of a page, only the fields of its sort are used
+type PlayerCursor struct {
+	Sort     string    `json:"s"`
+	Username string    `json:"u,omitempty"`
+	Rating   int       `json:"r,omitempty"`
+	LastSeen time.Time `json:"l,omitempty"`
+	ID       int       `json:"id"`
+}
+
+// PlayerSummary is a player as listed in search results
+type PlayerSummary struct {
+	ID       int        `json:"-"`
+	Username string     `json:"username"`
+	Rating   int        `json:"rating"`
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// PlayerPage is one page of search results, NextCursor is empty on the last page
+type PlayerPage struct {
+	Players    []PlayerSummary `json:"players"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// PlayerProfile is what anyone can see about a player, it never carries credentials or the balance
+type PlayerProfile struct {
+	Username    string     `json:"username"`
+	JoinedAt    *time.Time `json:"joined_at,omitempty"`
+	GamesPlayed int        `json:"games_played"`
+	Wins        int        `json:"wins"`
+	Losses      int        `json:"losses"`
+	Draws       int        `json:"draws"`
+	WinRate     float64    `json:"win_rate"`
+	Rating      int        `json:"rating"`
+	Online      bool       `json:"online"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
 }
@@ -0,0 +1,126 @@
+package model
+
+import "time"
+
+// Kinds of campaigns. A sign-up campaign credits every new player, a referral campaign rewards both the referrer
+// and the referee once the referee played enough games and a promo campaign is redeemed with its code.
+const (
+	CampaignSignup   = "signup"
+	CampaignReferral = "referral"
+	CampaignPromo    = "promo"
+)
+
+// CampaignKinds lists every kind of campaign
+var CampaignKinds = []string{CampaignSignup, CampaignReferral, CampaignPromo}
+
+func IsCampaignKind(kind string) bool {
+	for _, known := range CampaignKinds {
+		if known == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// A bonus is active until its stakes are wagered, when it is completed and becomes withdrawable. An active bonus
+// expires after its deadline and what is left of it is taken back, it is forfeited when the account is closed.
+const (
+	BonusActive    = "active"
+	BonusCompleted = "completed"
+	BonusExpired   = "expired"
+	BonusForfeited = "forfeited"
+)
+
+const (
+	ReferralPending  = "pending"
+	ReferralRewarded = "rewarded"
+)
+
+// CampaignRequest defines a campaign. Amount is the bonus of the player redeeming or signing up, in a referral
+// campaign it is the referrer's and RefereeAmount the referee's. Bonuses have to be staked WageringMultiplier
+// times within BonusDays. MaxRedemptions caps the bonuses a sign-up or promo campaign credits, 0 means no cap.
+// The campaign runs from StartsAt, by default now, until EndsAt, by default forever.
+type CampaignRequest struct {
+	Name               string     `json:"name" binding:"required"`
+	Kind               string     `json:"kind" binding:"required"`
+	Code               string     `json:"code"`
+	Amount             int        `json:"amount" binding:"required"`
+	RefereeAmount      int        `json:"referee_amount"`
+	WageringMultiplier int        `json:"wagering_multiplier"`
+	BonusDays          int        `json:"bonus_days" binding:"required"`
+	RequiredGames      int        `json:"required_games"`
+	MaxRedemptions     int        `json:"max_redemptions"`
+	StartsAt           *time.Time `json:"starts_at"`
+	EndsAt             *time.Time `json:"ends_at"`
+}
+
+// Campaign is a defined campaign, Redemptions counts the bonuses it credited. Ending it sets EndsAt to now.
+type Campaign struct {
+	ID                 int        `json:"id"`
+	Name               string     `json:"name"`
+	Kind               string     `json:"kind"`
+	Code               string     `json:"code,omitempty"`
+	Amount             int        `json:"amount"`
+	RefereeAmount      int        `json:"referee_amount,omitempty"`
+	WageringMultiplier int        `json:"wagering_multiplier"`
+	BonusDays          int        `json:"bonus_days"`
+	RequiredGames      int        `json:"required_games,omitempty"`
+	MaxRedemptions     int        `json:"max_redemptions,omitempty"`
+	StartsAt           time.Time  `json:"starts_at"`
+	EndsAt             *time.Time `json:"ends_at,omitempty"`
+	Redemptions        int        `json:"redemptions"`
+	CreatedAt          time.Time  `json:"created_at"`
+}
+
+// Bonus is play coins credited by a campaign, they cannot be withdrawn or sent before Wagered reaches
+// WageringRequired. Stakes count towards every active bonus from the time it was credited, stakes refunded
+// count back.
+type Bonus struct {
+	ID               int        `json:"id"`
+	PlayerID         int        `json:"-"`
+	CampaignID       int        `json:"campaign_id"`
+	Campaign         string     `json:"campaign"`
+	Source           string     `json:"source"`
+	Amount           int        `json:"amount"`
+	WageringRequired int        `json:"wagering_required"`
+	Wagered          int        `json:"wagered"`
+	State            string     `json:"state"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	ClosedAt         *time.Time `json:"closed_at,omitempty"`
+}
+
+// BonusStatus is a player's bonuses with what part of the balance they lock, bets held by open challenges
+// are neither locked nor withdrawable
+type BonusStatus struct {
+	Balance      int     `json:"balance"`
+	Held         int     `json:"held"`
+	Locked       int     `json:"locked"`
+	Withdrawable int     `json:"withdrawable"`
+	Bonuses      []Bonus `json:"bonuses"`
+}
+
+type PromoCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Referral is a player who registered with another player's referral code
+type Referral struct {
+	ID         int        `json:"-"`
+	ReferrerID int        `json:"-"`
+	Referrer   string     `json:"referrer,omitempty"`
+	RefereeID  int        `json:"-"`
+	Referee    string     `json:"referee"`
+	CampaignID int        `json:"-"`
+	State      string     `json:"state"`
+	Games      int        `json:"games"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RewardedAt *time.Time `json:"rewarded_at,omitempty"`
+}
+
+// ReferralSummary is a player's referral code and the players who registered with it
+type ReferralSummary struct {
+	Code          string     `json:"code"`
+	RequiredGames int        `json:"required_games,omitempty"`
+	Referrals     []Referral `json:"referrals"`
+}
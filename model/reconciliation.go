@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// BalanceDiscrepancy is a wallet whose balance does not add up to its transactions or whose held part does not
+// match the holds and the bets of the open challenges
+type BalanceDiscrepancy struct {
+	PlayerID int    `json:"player_id"`
+	Username string `json:"username"`
+	Currency string `json:"currency"`
+	// Balance is the recorded balance of the wallet, held bets included
+	Balance int `json:"balance"`
+	// Ledger sums up the transactions, held bets are only recorded once they are captured
+	Ledger int `json:"ledger"`
+	// Difference is Balance less Ledger, an adjustment of that amount makes the ledger add up
+	Difference int `json:"difference"`
+	// Held is the recorded held part of the balance
+	Held int `json:"held"`
+	// Holds sums up the active holds
+	Holds int `json:"holds"`
+	// PendingBets sums up the bets of the player's open challenges
+	PendingBets int  `json:"pending_bets"`
+	Adjusted    bool `json:"adjusted"`
+}
+
+// HoldsConsistent tells whether the held part of the balance, the holds and the bets of the open challenges agree
+func (discrepancy *BalanceDiscrepancy) HoldsConsistent() bool {
+	return discrepancy.Held == discrepancy.Holds && discrepancy.Holds == discrepancy.PendingBets
+}
+
+// ReconciliationReport is the outcome of checking every wallet, Fix tells whether adjustments were written
+type ReconciliationReport struct {
+	StartedAt     time.Time            `json:"started_at"`
+	FinishedAt    time.Time            `json:"finished_at"`
+	Fix           bool                 `json:"fix"`
+	Wallets       int                  `json:"wallets"`
+	Discrepancies []BalanceDiscrepancy `json:"discrepancies"`
+	// Consistent is true when every wallet adds up, after the adjustments when they were written, and every
+	// held balance matches its holds and pending bets
+	Consistent bool `json:"consistent"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+const (
+	StatementFormatCSV  = "csv"
+	StatementFormatJSON = "json"
+)
+
+// StatementRequest selects the period of an account statement, admins can also pick a player.
+// A statement covers a single currency, play coins unless currency says otherwise.
+type StatementRequest struct {
+	Format   string    `form:"format"`
+	Currency string    `form:"currency"`
+	From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Username string    `form:"username"`
+}
+
+// StatementEntry is one transaction of a statement together with the challenge it belongs to
+type StatementEntry struct {
+	TransactionID  int       `json:"transaction_id"`
+	Timestamp      time.Time `json:"timestamp"`
+	Username       string    `json:"username"`
+	Reason         string    `json:"reason"`
+	Amount         int       `json:"amount"`
+	Balance        int       `json:"balance"`
+	ChallengeID    *int      `json:"challenge_id,omitempty"`
+	ChallengeState string    `json:"challenge_state,omitempty"`
+	Challenger     string    `json:"challenger,omitempty"`
+	Opponent       string    `json:"opponent,omitempty"`
+}
@@ -0,0 +1,127 @@
+package model
+
+import "time"
+
+const (
+	TournamentSingleElimination = "single_elimination"
+	TournamentRoundRobin        = "round_robin"
+)
+
+// TournamentFormats lists every format a tournament can be played in
+var TournamentFormats = []string{TournamentSingleElimination, TournamentRoundRobin}
+
+func IsTournamentFormat(format string) bool {
+	for _, known := range TournamentFormats {
+		if known == format {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	TournamentRegistration = "registration"
+	TournamentRunning      = "running"
+	TournamentFinished     = "finished"
+	TournamentCancelled    = "cancelled"
+)
+
+// TournamentStates lists every state a tournament can be in
+var TournamentStates = []string{TournamentRegistration, TournamentRunning, TournamentFinished, TournamentCancelled}
+
+func IsTournamentState(state string) bool {
+	for _, known := range TournamentStates {
+		if known == state {
+			return true
+		}
+	}
+	return false
+}
+
+// States of a tournament match. A forfeit is decided by the deadline, a bye has no opponent.
+const (
+	MatchPending = "pending"
+	MatchPlayed  = "played"
+	MatchForfeit = "forfeit"
+	MatchBye     = "bye"
+)
+
+// TournamentRequest creates a tournament, PrizeSplit is the percentage of the pot per place
+// and defaults to tournament_prize_split
+type TournamentRequest struct {
+	Name                 string `json:"name" binding:"required"`
+	Format               string `json:"format" binding:"required"`
+	RuleSet              string `json:"rule_set"`
+	EntryFee             int    `json:"entry_fee"`
+	MaxPlayers           int    `json:"max_players" binding:"required"`
+	MatchDeadlineMinutes int    `json:"match_deadline_minutes" binding:"required"`
+	PrizeSplit           []int  `json:"prize_split"`
+}
+
+type Tournament struct {
+	ID                   int        `json:"id"`
+	Name                 string     `json:"name"`
+	Format               string     `json:"format"`
+	RuleSet              string     `json:"rule_set"`
+	EntryFee             int        `json:"entry_fee"`
+	MaxPlayers           int        `json:"max_players"`
+	MatchDeadlineMinutes int        `json:"match_deadline_minutes"`
+	PrizeSplit           []int      `json:"prize_split"`
+	State                string     `json:"state"`
+	Round                int        `json:"round"`
+	Players              int        `json:"players"`
+	CreatedAt            time.Time  `json:"created_at"`
+	StartedAt            *time.Time `json:"started_at,omitempty"`
+	FinishedAt           *time.Time `json:"finished_at,omitempty"`
+}
+
+// TournamentPlayer is a registered player and their standing, seeds are handed out by rating on start
+type TournamentPlayer struct {
+	PlayerID int    `json:"-"`
+	Username string `json:"username"`
+	Seed     int    `json:"seed,omitempty"`
+	Wins     int    `json:"wins"`
+	Losses   int    `json:"losses"`
+	Place    int    `json:"place,omitempty"`
+	Prize    int    `json:"prize,omitempty"`
+	// Reached is the last round the player took part in, it orders a single elimination bracket
+	Reached int `json:"-"`
+}
+
+// TournamentMatch is one pairing of a round, it is played as a challenge without a bet.
+// ChallengeID is the current attempt, a drawn challenge is replayed with a new one.
+type TournamentMatch struct {
+	ID           int        `json:"id"`
+	TournamentID int        `json:"-"`
+	Round        int        `json:"round"`
+	Position     int        `json:"position"`
+	PlayerAID    int        `json:"-"`
+	PlayerA      string     `json:"player_a"`
+	PlayerBID    int        `json:"-"`
+	PlayerB      string     `json:"player_b,omitempty"`
+	ChallengeID  *int       `json:"challenge_id,omitempty"`
+	WinnerID     int        `json:"-"`
+	Winner       string     `json:"winner,omitempty"`
+	State        string     `json:"state"`
+	Deadline     *time.Time `json:"deadline,omitempty"`
+}
+
+// TournamentDetail is a tournament with its standings and every match so far
+type TournamentDetail struct {
+	Tournament
+	Standings []TournamentPlayer `json:"standings"`
+	Matches   []TournamentMatch  `json:"matches"`
+}
+
+// TournamentMoveRequest plays the caller's move in a tournament match
+type TournamentMoveRequest struct {
+	Choice int `json:"choice" binding:"required"`
+}
+
+// TournamentMoveResponse tells the caller what their move did to the match
+type TournamentMoveResponse struct {
+	ChallengeID int    `json:"challenge_id"`
+	State       string `json:"state"`
+	Winner      string `json:"winner,omitempty"`
+	Message     string `json:"message"`
+}
@@ -5,22 +5,87 @@ import (
 )
 
 const (
-	ReasonDeposit    = "deposit"
-	ReasonWithdrawal = "withdrawal"
-	ReasonWin        = "win"
-	ReasonRefund     = "refund"
-	ReasonBet        = "bet"
+	ReasonDeposit     = "deposit"
+	ReasonWithdrawal  = "withdrawal"
+	ReasonWin         = "win"
+	ReasonRefund      = "refund"
+	ReasonBet         = "bet"
+	ReasonEntryFee    = "entry_fee"
+	ReasonPrize       = "prize"
+	ReasonHouseGame   = "house_game"
+	ReasonRake        = "rake"
+	ReasonTransferOut = "transfer_out"
+	ReasonTransferIn  = "transfer_in"
+	// Conversions between currencies debit one wallet and credit another
+	ReasonConversionOut = "conversion_out"
+	ReasonConversionIn  = "conversion_in"
+	// Adjustments are written by the reconciliation to make the ledger add up to the balance
+	ReasonAdjustment = "adjustment"
+	// Bonuses are credited by campaigns and what is left of them is taken back when they expire
+	ReasonBonus          = "bonus"
+	ReasonBonusForfeited = "bonus_forfeited"
 )
 
+// TransactionReasons lists every reason a transaction can be recorded with
+var TransactionReasons = []string{ReasonDeposit, ReasonWithdrawal, ReasonWin, ReasonRefund, ReasonBet,
+	ReasonEntryFee, ReasonPrize, ReasonHouseGame, ReasonRake, ReasonTransferOut, ReasonTransferIn,
+	ReasonConversionOut, ReasonConversionIn, ReasonAdjustment, ReasonBonus, ReasonBonusForfeited}
+
+func IsTransactionReason(reason string) bool {
+	for _, known := range TransactionReasons {
+		if known == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// Transaction moves Amount into the player's wallet of its currency, a debit is negative. RunningBalance is the
+// wallet's balance after it.
 type Transaction struct {
-	ID        int       `json:"id,omitempty"`
-	Timestamp time.Time `json:"timestamp"`
-	Amount    int       `json:"amount"`
-	Reason    string    `json:"reason"`
-	Username  string    `json:"username"`
+	ID             int       `json:"id,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+	Amount         Money     `json:"amount"`
+	Reason         string    `json:"reason"`
+	PlayerID       int       `json:"-"`
+	Username       string    `json:"username"`
+	ChallengeID    *int      `json:"challenge_id,omitempty"`
+	RunningBalance Money     `json:"running_balance"`
+}
+
+const (
+	SortAscending  = "asc"
+	SortDescending = "desc"
+)
+
+// TransactionFilter narrows down and pages through a player's transactions, zero values are ignored
+type TransactionFilter struct {
+	Currency  string    `form:"currency"`
+	Reasons   []string  `form:"reason"`
+	MinAmount *int      `form:"min_amount"`
+	MaxAmount *int      `form:"max_amount"`
+	From      time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Sort      string    `form:"sort"`
+	Cursor    string    `form:"cursor"`
+	Limit     int       `form:"limit"`
+}
+
+// TransactionCursor is the position of the last transaction of a page
+type TransactionCursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        int       `json:"id"`
+}
+
+// TransactionPage is one page of a player's transactions, NextCursor is empty on the last page
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor,omitempty"`
 }
 
 type TransactionRequest struct {
 	Reason string `json:"reason" binding:"required"`
-	Amount int    `json:"amount" binding:"required"`
+	Amount Money  `json:"amount"`
+	// TOTPCode is required for withdrawals when two factor authentication is enabled
+	TOTPCode string `json:"totp_code"`
 }
@@ -0,0 +1,42 @@
+package model
+
+import "time"
+
+// A transfer at or above transfer_confirmation_threshold waits for the sender to confirm it,
+// it expires when that does not happen in time. Nothing is moved before the transfer completes.
+const (
+	TransferPending   = "pending"
+	TransferCompleted = "completed"
+	TransferCancelled = "cancelled"
+	TransferExpired   = "expired"
+)
+
+// TransferRequest sends coins to another player
+type TransferRequest struct {
+	Recipient string `json:"recipient" binding:"required"`
+	Amount    Money  `json:"amount"`
+	Memo      string `json:"memo"`
+	// TOTPCode is required when two factor authentication is enabled and the transfer is sent right away
+	TOTPCode string `json:"totp_code"`
+}
+
+// TransferConfirmRequest confirms a pending transfer, the body is optional
+type TransferConfirmRequest struct {
+	// TOTPCode is required when two factor authentication is enabled
+	TOTPCode string `json:"totp_code"`
+}
+
+// Transfer is a payment between two players, seen from either side
+type Transfer struct {
+	ID          int        `json:"id"`
+	SenderID    int        `json:"-"`
+	Sender      string     `json:"sender"`
+	RecipientID int        `json:"-"`
+	Recipient   string     `json:"recipient"`
+	Amount      Money      `json:"amount"`
+	Memo        string     `json:"memo,omitempty"`
+	State       string     `json:"state"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,27 @@
+package model
+
+// TwoFactorEnrollment is returned when a player starts enabling two factor authentication
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TwoFactorCodeRequest carries a code from the player's authenticator app
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginRequest completes a login with the pre-auth token and either a code or a recovery code
+type TwoFactorLoginRequest struct {
+	PreAuthToken string `json:"pre_auth_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RecoveryCode is a hashed one-time code that can replace a two factor code
+type RecoveryCode struct {
+	ID       int
+	PlayerID int
+	Hash     string
+	Salt     string
+}
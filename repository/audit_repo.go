@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/internal"
+	"main/model"
+	"time"
+)
+
+const (
+	defaultAuditQueryLimit = 100
+	maximumAuditQueryLimit = 1000
+)
+
+type Audit struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *Audit {
+	return &Audit{
+		db: db,
+	}
+}
+
+// Append chains the event to the last entry of the log and stores it.
+// The table is locked for the duration of the insert so that two appends cannot share a predecessor.
+func (repository *Audit) Append(event *model.AuditEvent) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		logrus.Errorf("Error starting audit transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("LOCK TABLE audit_event IN EXCLUSIVE MODE")
+	if err != nil {
+		logrus.Errorf("Error locking audit log: %v", err)
+		return err
+	}
+
+	var lastID int64
+	var lastHash string
+	err = tx.QueryRow("SELECT id, hash FROM audit_event ORDER BY id DESC LIMIT 1").Scan(&lastID, &lastHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logrus.Errorf("Error fetching last audit event: %v", err)
+		return err
+	}
+
+	event.ID = lastID + 1
+	event.PrevHash = lastHash
+	// Postgres keeps microseconds, truncate so the stored value hashes the same when read back
+	event.Timestamp = time.Now().UTC().Truncate(time.Microsecond)
+	event.Hash = internal.HashAuditEvent(event)
+
+	_, err = tx.Exec(`
+		INSERT INTO audit_event (id, timestamp, actor, action, subject, ip, details, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, event.ID, event.Timestamp, event.Actor, event.Action, event.Subject, event.IP, event.Details,
+		event.PrevHash, event.Hash)
+	if err != nil {
+		logrus.Errorf("Error inserting audit event: %v", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Query returns audit events matching the filter ordered by id, use AfterID to page through the results
+func (repository *Audit) Query(filter model.AuditEventFilter) ([]model.AuditEvent, error) {
+	query := `
+		SELECT id, timestamp, actor, action, subject, ip, details, prev_hash, hash
+		FROM audit_event
+		WHERE id > $1
+	`
+	args := []any{filter.AfterID}
+
+	addCondition := func(condition string, value any) {
+		args = append(args, value)
+		query += fmt.Sprintf(" AND %s $%d", condition, len(args))
+	}
+
+	if filter.Actor != "" {
+		addCondition("actor =", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("action =", filter.Action)
+	}
+	if filter.Subject != "" {
+		addCondition("subject =", filter.Subject)
+	}
+	if filter.IP != "" {
+		addCondition("ip =", filter.IP)
+	}
+	if !filter.From.IsZero() {
+		addCondition("timestamp >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp <", filter.To)
+	}
+
+	args = append(args, pageSize(filter.Limit, defaultAuditQueryLimit, maximumAuditQueryLimit))
+	query += fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching audit events: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []model.AuditEvent{}
+	for rows.Next() {
+		var event model.AuditEvent
+		if err = scanAuditEvent(rows, &event); err != nil {
+			logrus.Errorf("Error scanning audit event: %v", err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over audit events: %v", err)
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Verify walks the whole chain in order and recomputes every hash.
+// Ids are handed out without gaps, so a missing id means an entry was deleted.
+func (repository *Audit) Verify() (model.AuditVerification, error) {
+	result := model.AuditVerification{Valid: true}
+
+	rows, err := repository.db.Query(`
+		SELECT id, timestamp, actor, action, subject, ip, details, prev_hash, hash
+		FROM audit_event
+		ORDER BY id
+	`)
+	if err != nil {
+		logrus.Errorf("Error fetching audit events: %v", err)
+		return result, err
+	}
+	defer rows.Close()
+
+	var expectedID int64 = 1
+	previousHash := ""
+	for rows.Next() {
+		var event model.AuditEvent
+		if err = scanAuditEvent(rows, &event); err != nil {
+			logrus.Errorf("Error scanning audit event: %v", err)
+			return result, err
+		}
+
+		switch {
+		case event.ID != expectedID:
+			return brokenChain(result, expectedID, fmt.Sprintf("expected event %d, found %d", expectedID, event.ID)), nil
+		case event.PrevHash != previousHash:
+			return brokenChain(result, event.ID, "previous hash does not match the preceding event"), nil
+		case internal.HashAuditEvent(&event) != event.Hash:
+			return brokenChain(result, event.ID, "event hash does not match its contents"), nil
+		}
+
+		result.CheckedEvents++
+		expectedID++
+		previousHash = event.Hash
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over audit events: %v", err)
+		return result, err
+	}
+
+	return result, nil
+}
+
+func brokenChain(result model.AuditVerification, id int64, reason string) model.AuditVerification {
+	result.Valid = false
+	result.BrokenAtID = id
+	result.Reason = reason
+	return result
+}
+
+func scanAuditEvent(rows *sql.Rows, event *model.AuditEvent) error {
+	return rows.Scan(
+		&event.ID,
+		&event.Timestamp,
+		&event.Actor,
+		&event.Action,
+		&event.Subject,
+		&event.IP,
+		&event.Details,
+		&event.PrevHash,
+		&event.Hash,
+	)
+}
@@ -2,11 +2,26 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	"main/internal"
 	"main/model"
+	"strconv"
 	"time"
 )
 
+var (
+	ErrChallengeChanged = errors.New("challenge is not waiting for this anymore")
+	ErrTooManyCounters  = errors.New("no counter-offers left on this challenge")
+)
+
+const (
+	defaultChallengePageSize = 50
+	maximumChallengePageSize = 500
+)
+
 type Challenger struct {
 	db *sql.DB
 }
@@ -15,18 +30,173 @@ func NewChallengeRepository(db *sql.DB) *Challenger {
 	return &Challenger{db: db}
 }
 
-// CreateChallenge inserts a new challenge into the database and returns its id
-func (repository *Challenger) CreateChallenge(challenger string, opponent string, choice int, bet int) (int, error) {
+// PlaceChallenge creates a challenge and holds the challenger's bet until the challenge is settled, declined or
+// expires, ErrInsufficientBalance when their available balance does not cover it. A drawn move keeps the seed pair
+// and nonce it was drawn with.
+func (repository *Challenger) PlaceChallenge(challengerID int, opponentID int, request model.ChallengeRequest) (int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var seedID, nonce any
+	if request.SeedID != 0 {
+		seedID, nonce = request.SeedID, request.Nonce
+	}
+
+	var challengeID int
+	err = tx.QueryRow(`
+		INSERT INTO challenge (challenger_id, opponent_id, choice, bet, state, rule_set, currency, seed_id, nonce)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING challenge_id
+	`, challengerID, opponentID, request.Choice, request.Bet.Amount, model.ChallengePending, request.RuleSet,
+		request.Bet.Currency, seedID, nonce).Scan(&challengeID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert challenge: %v", err)
+	}
+
+	if err = placeHold(tx, challengerID, challengeID, request.Bet); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit challenge: %v", err)
+	}
+	return challengeID, nil
+}
+
+// SettleChallenge books the outcome of a pending challenge in a single transaction: the challenger's held bet is
+// captured, the opponent's bet is taken, the pot is paid out and the challenge is marked settled.
+// ErrChallengeChanged when it is not pending at the settled bet anymore, ErrInsufficientBalance when the opponent
+// cannot cover the bet.
+func (repository *Challenger) SettleChallenge(challengeID int, settlement model.ChallengeSettlement, houseID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE challenge
+		SET state = $1, time_settled = $2, winner_id = NULLIF($3, 0), opponent_choice = $4, counter_bet = NULL
+		WHERE challenge_id = $5 AND state = $6 AND challenger_id = $7 AND opponent_id = $8 AND bet = $9 AND currency = $10
+	`, model.ChallengeSettled, time.Now(), settlement.WinnerID, settlement.OpponentChoice, challengeID,
+		model.ChallengePending, settlement.ChallengerID, settlement.OpponentID, settlement.Bet.Amount, settlement.Bet.Currency)
+	if err != nil {
+		return fmt.Errorf("failed to settle challenge %d: %v", challengeID, err)
+	}
+	if settled, _ := result.RowsAffected(); settled == 0 {
+		return ErrChallengeChanged
+	}
+
+	if err = captureHold(tx, challengeID); err != nil {
+		return err
+	}
+	if err = debitWallet(tx, settlement.OpponentID, settlement.Bet, model.ReasonBet, challengeID); err != nil {
+		return err
+	}
+	if err = payOutChallenge(tx, challengeID, settlement, houseID); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit settlement: %v", err)
+	}
+	return nil
+}
+
+// PlayChallenge records a challenge whose bets were both taken before it existed, like those of two matchmaking
+// tickets, and books its outcome in a single transaction. The bet transactions are linked to the new challenge.
+func (repository *Challenger) PlayChallenge(settlement model.ChallengeSettlement, betTransactionIDs []int,
+	houseID int) (int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var challengeID int
+	err = tx.QueryRow(`
+		INSERT INTO challenge (challenger_id, opponent_id, choice, bet, state, rule_set, currency, time_settled,
+		                       winner_id, opponent_choice)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10) RETURNING challenge_id
+	`, settlement.ChallengerID, settlement.OpponentID, settlement.Choice, settlement.Bet.Amount, model.ChallengeSettled,
+		settlement.RuleSet, settlement.Bet.Currency, time.Now(), settlement.WinnerID, settlement.OpponentChoice,
+	).Scan(&challengeID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert challenge: %v", err)
+	}
+
+	_, err = tx.Exec("UPDATE transaction SET challenge_id = $1 WHERE id = ANY($2) AND challenge_id IS NULL",
+		challengeID, pq.Array(betTransactionIDs))
+	if err != nil {
+		return 0, fmt.Errorf("failed to link bets to challenge %d: %v", challengeID, err)
+	}
+
+	if err = payOutChallenge(tx, challengeID, settlement, houseID); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit challenge: %v", err)
+	}
+	return challengeID, nil
+}
+
+// payOutChallenge pays the pot of a challenge whose bets are both taken within tx: the winner gets both bets less
+// the rake, which goes to the house, and a draw gives both players their bet back
+func payOutChallenge(tx *sql.Tx, challengeID int, settlement model.ChallengeSettlement, houseID int) error {
+	bet := settlement.Bet
+	if settlement.WinnerID == 0 {
+		if err := creditWallet(tx, settlement.ChallengerID, bet, model.ReasonRefund, challengeID); err != nil {
+			return err
+		}
+		return creditWallet(tx, settlement.OpponentID, bet, model.ReasonRefund, challengeID)
+	}
+
+	pot := model.Money{Currency: bet.Currency, Amount: bet.Amount*2 - settlement.Rake}
+	if err := creditWallet(tx, settlement.WinnerID, pot, model.ReasonWin, challengeID); err != nil {
+		return err
+	}
+	return creditWallet(tx, houseID, model.Money{Currency: bet.Currency, Amount: settlement.Rake}, model.ReasonRake,
+		challengeID)
+}
+
+// DeclineChallenge declines an open challenge and releases the challenger's held bet,
+// ErrChallengeChanged when it is not open anymore
+func (repository *Challenger) DeclineChallenge(challengeID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	declined, err := releasePendingChallenges(tx, model.ChallengeDeclined, "challenge_id = $3", challengeID)
+	if err != nil {
+		return err
+	}
+	if len(declined) == 0 {
+		return ErrChallengeChanged
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit decline: %v", err)
+	}
+	return nil
+}
+
+// CreateTournamentChallenge inserts the challenge of a tournament match, the entry fee is the stake so it has no bet
+func (repository *Challenger) CreateTournamentChallenge(tournamentID int, challengerID int, opponentID int, choice int) (int, error) {
 	query := `
-        INSERT INTO challenge (challenger, opponent, choice, bet, state)
-        VALUES ($1, $2, $3, $4, $5) RETURNING challenge_id
+        INSERT INTO challenge (challenger_id, opponent_id, choice, bet, state, tournament_id)
+        VALUES ($1, $2, $3, 0, $4, $5) RETURNING challenge_id
     `
 
 	var challengeId int
 
-	err := repository.db.QueryRow(query, challenger, opponent, choice, bet, model.ChallengePending).Scan(&challengeId)
+	err := repository.db.QueryRow(query, challengerID, opponentID, choice, model.ChallengePending, tournamentID).Scan(&challengeId)
 	if err != nil {
-		logrus.Errorf("Error inserting challenge: %v", err)
+		logrus.Errorf("Error inserting tournament challenge: %v", err)
 		return 0, err
 	}
 
@@ -36,22 +206,43 @@ func (repository *Challenger) CreateChallenge(challenger string, opponent string
 // GetChallengeByID retrieves a challenge by its ID
 func (repository *Challenger) GetChallengeByID(challengeID string) (*model.Challenge, error) {
 	query := `
-        SELECT challenge_id, challenger, opponent, choice, bet, state, time_created, time_settled
+        SELECT challenge.challenge_id, challenge.challenger_id, challenger.username,
+               challenge.opponent_id, opponent.username, challenge.choice, challenge.bet, challenge.state,
+               challenge.time_created, challenge.time_settled, challenge.winner_id, winner.username,
+               challenge.opponent_choice, challenge.tournament_id, challenge.counter_bet, challenge.rule_set,
+               challenge.currency
         FROM challenge
-        WHERE challenge_id = $1
+        JOIN player challenger ON challenger.id = challenge.challenger_id
+        JOIN player opponent ON opponent.id = challenge.opponent_id
+        LEFT JOIN player winner ON winner.id = challenge.winner_id
+        WHERE challenge.challenge_id = $1
     `
 
 	var challenge model.Challenge
 	var timeSettled sql.NullTime
+	var winnerID sql.NullInt64
+	var winner sql.NullString
+	var opponentChoice sql.NullInt64
+	var tournamentID sql.NullInt64
+	var counterBet sql.NullInt64
 	err := repository.db.QueryRow(query, challengeID).Scan(
 		&challenge.ChallengeId,
+		&challenge.ChallengerID,
 		&challenge.Challenger,
+		&challenge.OpponentID,
 		&challenge.Opponent,
 		&challenge.Choice,
-		&challenge.Bet,
+		&challenge.Bet.Amount,
 		&challenge.State,
 		&challenge.TimeCreated,
 		&timeSettled,
+		&winnerID,
+		&winner,
+		&opponentChoice,
+		&tournamentID,
+		&counterBet,
+		&challenge.RuleSet,
+		&challenge.Bet.Currency,
 	)
 
 	if err != nil {
@@ -64,19 +255,26 @@ func (repository *Challenger) GetChallengeByID(challengeID string) (*model.Chall
 	} else {
 		challenge.TimeSettled = time.Time{}
 	}
+	challenge.WinnerID = int(winnerID.Int64)
+	challenge.Winner = winner.String
+	challenge.OpponentChoice = int(opponentChoice.Int64)
+	challenge.TournamentID = int(tournamentID.Int64)
+	challenge.CounterBet = counterOffer(challenge.Bet, counterBet)
 
 	return &challenge, nil
 }
 
-// GetPendingChallenges retrieves all pending challenges where the user is listed as an opponent
-func (repository *Challenger) GetPendingChallenges(username string) ([]model.PendingChallenge, error) {
+// GetPendingChallenges retrieves all pending challenges where the player is listed as an opponent,
+// tournament challenges are answered through their tournament and left out
+func (repository *Challenger) GetPendingChallenges(playerID int) ([]model.PendingChallenge, error) {
 	query := `
-        SELECT challenge_id, challenger, bet, time_created
+        SELECT challenge.challenge_id, challenger.username, challenge.bet, challenge.currency, challenge.time_created
         FROM challenge
-        WHERE opponent = $1 AND challenge.state = 'pending'
+        JOIN player challenger ON challenger.id = challenge.challenger_id
+        WHERE challenge.opponent_id = $1 AND challenge.state = 'pending' AND challenge.tournament_id IS NULL
     `
 
-	rows, err := repository.db.Query(query, username)
+	rows, err := repository.db.Query(query, playerID)
 	if err != nil {
 		logrus.Errorf("Error fetching challenges: %v", err)
 		return nil, err
@@ -89,7 +287,8 @@ func (repository *Challenger) GetPendingChallenges(username string) ([]model.Pen
 		err = rows.Scan(
 			&challenge.ChallengeId,
 			&challenge.Challenger,
-			&challenge.Bet,
+			&challenge.Bet.Amount,
+			&challenge.Bet.Currency,
 			&challenge.TimeCreated,
 		)
 		if err != nil {
@@ -107,15 +306,16 @@ func (repository *Challenger) GetPendingChallenges(username string) ([]model.Pen
 	return challenges, nil
 }
 
-// UpdateChallenge updates the status and time_settled of a challenge
-func (repository *Challenger) UpdateChallenge(state string, winner string, challengeId string) error {
+// UpdateChallenge updates the status and time_settled of a challenge, a winnerID of 0 means there is no winner
+// and an opponentChoice of 0 means the opponent did not play
+func (repository *Challenger) UpdateChallenge(state string, winnerID int, opponentChoice int, challengeId string) error {
 	query := `
         UPDATE challenge
-        SET state = $1, time_settled = $2, winner = $3
-        WHERE challenge_id = $4
+        SET state = $1, time_settled = $2, winner_id = NULLIF($3, 0), opponent_choice = NULLIF($4, 0), counter_bet = NULL
+        WHERE challenge_id = $5
     `
 
-	_, err := repository.db.Exec(query, state, time.Now(), winner, challengeId)
+	_, err := repository.db.Exec(query, state, time.Now(), winnerID, opponentChoice, challengeId)
 	if err != nil {
 		logrus.Errorf("Error updating challenge: %v", err)
 		return err
@@ -123,3 +323,395 @@ func (repository *Challenger) UpdateChallenge(state string, winner string, chall
 
 	return nil
 }
+
+// GetLastMoves returns the moves of the latest settled challenge between the two players,
+// zeros when they have not played each other yet
+func (repository *Challenger) GetLastMoves(playerID int, opponentID int) (own int, theirs int, err error) {
+	query := `
+        SELECT CASE WHEN challenger_id = $1 THEN choice ELSE opponent_choice END,
+               CASE WHEN challenger_id = $1 THEN opponent_choice ELSE choice END
+        FROM challenge
+        WHERE state = $3 AND opponent_choice IS NOT NULL
+          AND ((challenger_id = $1 AND opponent_id = $2) OR (challenger_id = $2 AND opponent_id = $1))
+        ORDER BY time_settled DESC, challenge_id DESC
+        LIMIT 1
+    `
+
+	err = repository.db.QueryRow(query, playerID, opponentID, model.ChallengeSettled).Scan(&own, &theirs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		logrus.Errorf("Error fetching last moves: %v", err)
+		return 0, 0, err
+	}
+
+	return own, theirs, nil
+}
+
+// CountPendingChallengesBetween counts the open challenges the challenger has sent to the opponent
+func (repository *Challenger) CountPendingChallengesBetween(challengerID int, opponentID int) (int, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM challenge
+        WHERE challenger_id = $1 AND opponent_id = $2 AND state = ANY($3) AND tournament_id IS NULL
+    `
+
+	var count int
+	err := repository.db.QueryRow(query, challengerID, opponentID, pq.Array(model.OpenChallengeStates)).Scan(&count)
+	if err != nil {
+		logrus.Errorf("Error counting pending challenges: %v", err)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetChallengesPage returns one page of the challenges the player sent or received, newest first
+func (repository *Challenger) GetChallengesPage(playerID int, filter model.ChallengeFilter) (*model.ChallengePage, error) {
+	query := `
+        SELECT challenge.challenge_id, challenge.challenger_id, challenger.username,
+               challenge.opponent_id, opponent.username, challenge.bet, challenge.state,
+               challenge.time_created, challenge.time_settled, winner.username, challenge.counter_bet,
+               challenge.rule_set, challenge.currency
+        FROM challenge
+        JOIN player challenger ON challenger.id = challenge.challenger_id
+        JOIN player opponent ON opponent.id = challenge.opponent_id
+        LEFT JOIN player winner ON winner.id = challenge.winner_id
+        WHERE (challenge.challenger_id = $1 OR challenge.opponent_id = $1)
+    `
+	args := []any{playerID}
+
+	addCondition := func(condition string, value any) {
+		args = append(args, value)
+		query += fmt.Sprintf(" AND %s $%d", condition, len(args))
+	}
+
+	switch filter.Role {
+	case model.ChallengeRoleSent:
+		query += " AND challenge.challenger_id = $1"
+	case model.ChallengeRoleReceived:
+		query += " AND challenge.opponent_id = $1"
+	}
+	if len(filter.States) > 0 {
+		addCondition("challenge.state = ANY(", pq.Array(filter.States))
+		query += ")"
+	}
+	if filter.Opponent != "" {
+		// The opponent is whoever is on the other side, whichever role the player had
+		addCondition("CASE WHEN challenge.challenger_id = $1 THEN opponent.username ELSE challenger.username END =",
+			filter.Opponent)
+	}
+	if !filter.From.IsZero() {
+		addCondition("challenge.time_created >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("challenge.time_created <", filter.To)
+	}
+
+	if filter.Cursor != "" {
+		var cursor model.ChallengeCursor
+		err := internal.DecodeCursor(filter.Cursor, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		// time_created has no time zone, compare the cursor by its wall clock as it was read
+		args = append(args, cursor.TimeCreated, cursor.ID)
+		query += fmt.Sprintf(" AND (challenge.time_created, challenge.challenge_id) < ($%d::timestamp, $%d)",
+			len(args)-1, len(args))
+	}
+
+	limit := pageSize(filter.Limit, defaultChallengePageSize, maximumChallengePageSize)
+	// One extra row tells whether there is another page
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY challenge.time_created DESC, challenge.challenge_id DESC LIMIT $%d", len(args))
+
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching challenges: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &model.ChallengePage{Challenges: []model.ChallengeSummary{}}
+	for rows.Next() {
+		var challenge model.ChallengeSummary
+		var timeSettled sql.NullTime
+		var winner sql.NullString
+		var counterBet sql.NullInt64
+		if err = rows.Scan(
+			&challenge.ChallengeId,
+			&challenge.ChallengerID,
+			&challenge.Challenger,
+			&challenge.OpponentID,
+			&challenge.Opponent,
+			&challenge.Bet.Amount,
+			&challenge.State,
+			&challenge.TimeCreated,
+			&timeSettled,
+			&winner,
+			&counterBet,
+			&challenge.RuleSet,
+			&challenge.Bet.Currency,
+		); err != nil {
+			logrus.Errorf("Error scanning challenge: %v", err)
+			return nil, err
+		}
+		if timeSettled.Valid {
+			challenge.TimeSettled = &timeSettled.Time
+		}
+		challenge.Winner = winner.String
+		challenge.CounterBet = counterOffer(challenge.Bet, counterBet)
+		challenge.Role = model.ChallengeRoleReceived
+		if challenge.ChallengerID == playerID {
+			challenge.Role = model.ChallengeRoleSent
+		}
+		page.Challenges = append(page.Challenges, challenge)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over challenges: %v", err)
+		return nil, err
+	}
+
+	if len(page.Challenges) > limit {
+		page.Challenges = page.Challenges[:limit]
+		last := page.Challenges[limit-1]
+		id, _ := strconv.Atoi(last.ChallengeId)
+		page.NextCursor, err = internal.EncodeCursor(model.ChallengeCursor{TimeCreated: last.TimeCreated, ID: id})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// CounterChallenge proposes a different bet on behalf of the opponent, the challenger's bet stays held
+// until they accept or reject it. The opponent can counter at most maxCounters times.
+func (repository *Challenger) CounterChallenge(challengeID int, opponentID int, bet int, maxCounters int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start counter-offer: %v", err)
+	}
+	defer tx.Rollback()
+
+	var counters int
+	err = tx.QueryRow("SELECT COUNT(*) FROM challenge_offer WHERE challenge_id = $1 AND action = $2",
+		challengeID, model.OfferCounter).Scan(&counters)
+	if err != nil {
+		return fmt.Errorf("failed to count counter-offers: %v", err)
+	}
+	if counters >= maxCounters {
+		return ErrTooManyCounters
+	}
+
+	var previousBet int
+	err = tx.QueryRow(`
+		UPDATE challenge SET state = $1, counter_bet = $2
+		WHERE challenge_id = $3 AND opponent_id = $4 AND state = $5
+		RETURNING bet
+	`, model.ChallengeCountered, bet, challengeID, opponentID, model.ChallengePending).Scan(&previousBet)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrChallengeChanged
+	}
+	if err != nil {
+		return fmt.Errorf("failed to counter challenge: %v", err)
+	}
+
+	if err = addOffer(tx, challengeID, opponentID, model.OfferCounter, bet, previousBet); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AcceptCounter makes the countered bet the bet of the challenge and holds it instead of the previous bet,
+// it returns the new bet
+func (repository *Challenger) AcceptCounter(challengeID int, challengerID int) (int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start accepting counter-offer: %v", err)
+	}
+	defer tx.Rollback()
+
+	var previousBet, bet int
+	err = tx.QueryRow(`
+		SELECT bet, counter_bet FROM challenge
+		WHERE challenge_id = $1 AND challenger_id = $2 AND state = $3
+		FOR UPDATE
+	`, challengeID, challengerID, model.ChallengeCountered).Scan(&previousBet, &bet)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrChallengeChanged
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find countered challenge: %v", err)
+	}
+
+	if err = resizeHold(tx, challengeID, bet); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE challenge SET state = $1, bet = counter_bet, counter_bet = NULL WHERE challenge_id = $2",
+		model.ChallengePending, challengeID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to accept counter-offer: %v", err)
+	}
+
+	if err = addOffer(tx, challengeID, challengerID, model.OfferAccepted, bet, previousBet); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit counter-offer: %v", err)
+	}
+	return bet, nil
+}
+
+// RejectCounter drops the countered bet, the challenge is pending at its bet again
+func (repository *Challenger) RejectCounter(challengeID int, challengerID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start rejecting counter-offer: %v", err)
+	}
+	defer tx.Rollback()
+
+	var bet, rejectedBet int
+	err = tx.QueryRow(`
+		SELECT bet, counter_bet FROM challenge
+		WHERE challenge_id = $1 AND challenger_id = $2 AND state = $3
+		FOR UPDATE
+	`, challengeID, challengerID, model.ChallengeCountered).Scan(&bet, &rejectedBet)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrChallengeChanged
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find countered challenge: %v", err)
+	}
+
+	_, err = tx.Exec("UPDATE challenge SET state = $1, counter_bet = NULL WHERE challenge_id = $2",
+		model.ChallengePending, challengeID)
+	if err != nil {
+		return fmt.Errorf("failed to reject counter-offer: %v", err)
+	}
+
+	if err = addOffer(tx, challengeID, challengerID, model.OfferRejected, rejectedBet, bet); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetNegotiation returns the counter-offers of a challenge and the replies to them, oldest first
+func (repository *Challenger) GetNegotiation(challengeID int) ([]model.ChallengeOffer, error) {
+	rows, err := repository.db.Query(`
+		SELECT challenge_offer.id, player.username, challenge_offer.action, challenge_offer.bet,
+		       challenge_offer.previous_bet, challenge.currency, challenge_offer.created_at
+		FROM challenge_offer
+		JOIN challenge ON challenge.challenge_id = challenge_offer.challenge_id
+		JOIN player ON player.id = challenge_offer.player_id
+		WHERE challenge_offer.challenge_id = $1
+		ORDER BY challenge_offer.id
+	`, challengeID)
+	if err != nil {
+		logrus.Errorf("Error fetching challenge negotiation: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := []model.ChallengeOffer{}
+	for rows.Next() {
+		var offer model.ChallengeOffer
+		if err = rows.Scan(&offer.ID, &offer.Username, &offer.Action, &offer.Bet.Amount, &offer.PreviousBet.Amount,
+			&offer.Bet.Currency, &offer.CreatedAt); err != nil {
+			logrus.Errorf("Error scanning challenge offer: %v", err)
+			return nil, err
+		}
+		offer.PreviousBet.Currency = offer.Bet.Currency
+		offers = append(offers, offer)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over challenge negotiation: %v", err)
+		return nil, err
+	}
+
+	return offers, nil
+}
+
+// counterOffer is the countered bet in the currency of the bet, nil when there is no counter-offer
+func counterOffer(bet model.Money, counterBet sql.NullInt64) *model.Money {
+	if !counterBet.Valid {
+		return nil
+	}
+	return &model.Money{Currency: bet.Currency, Amount: int(counterBet.Int64)}
+}
+
+func addOffer(tx *sql.Tx, challengeID int, playerID int, action string, bet int, previousBet int) error {
+	_, err := tx.Exec(`
+		INSERT INTO challenge_offer (challenge_id, player_id, action, bet, previous_bet)
+		VALUES ($1, $2, $3, $4, $5)
+	`, challengeID, playerID, action, bet, previousBet)
+	if err != nil {
+		return fmt.Errorf("failed to record %s of challenge %d: %v", action, challengeID, err)
+	}
+	return nil
+}
+
+// ExpirePendingChallenges expires the challenges that have been pending for longer than maxAge
+// and releases the challengers' held bets, it returns the ids of the expired challenges.
+// Tournament challenges are bound by their match deadline instead.
+func (repository *Challenger) ExpirePendingChallenges(maxAge time.Duration) ([]int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start challenge expiry: %v", err)
+	}
+	defer tx.Rollback()
+
+	expiredIDs, err := releasePendingChallenges(tx, model.ChallengeExpired,
+		"time_created < LOCALTIMESTAMP - $3 * INTERVAL '1 second' AND tournament_id IS NULL", int64(maxAge.Seconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire challenges: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit challenge expiry: %v", err)
+	}
+
+	return expiredIDs, nil
+}
+
+// releasePendingChallenges moves the open challenges matching condition to state and releases the bets they hold
+// within tx, it returns the ids of the challenges. Placeholders in condition start at $3.
+func releasePendingChallenges(tx *sql.Tx, state string, condition string, args ...any) ([]int, error) {
+	rows, err := tx.Query(`
+		UPDATE challenge SET state = $1, time_settled = CURRENT_TIMESTAMP, counter_bet = NULL
+		WHERE state = ANY($2) AND (`+condition+`)
+		RETURNING challenge_id
+	`, append([]any{state, pq.Array(model.OpenChallengeStates)}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update pending challenges: %v", err)
+	}
+
+	challengeIDs := []int{}
+	for rows.Next() {
+		var challengeID int
+		if err = rows.Scan(&challengeID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan challenge: %v", err)
+		}
+		challengeIDs = append(challengeIDs, challengeID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to update pending challenges: %v", err)
+	}
+
+	for _, challengeID := range challengeIDs {
+		if err = releaseHold(tx, challengeID); err != nil {
+			return nil, fmt.Errorf("failed to release bet of challenge %d: %v", challengeID, err)
+		}
+	}
+
+	return challengeIDs, nil
+}
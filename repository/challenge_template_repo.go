@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+// ErrTemplateNameTaken is returned when the player already has a template with the name
+var ErrTemplateNameTaken = errors.New("a template with this name already exists")
+
+type ChallengeTemplate struct {
+	db *sql.DB
+}
+
+func NewChallengeTemplateRepository(db *sql.DB) *ChallengeTemplate {
+	return &ChallengeTemplate{
+		db: db,
+	}
+}
+
+// CreateTemplate saves the template of the player and fills in its id
+func (repository *ChallengeTemplate) CreateTemplate(playerID int, template *model.ChallengeTemplate) error {
+	err := repository.db.QueryRow(`
+		INSERT INTO challenge_template (player_id, name, opponent_id, bet, rule_set, strategy, choice)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0))
+		ON CONFLICT (player_id, name) DO NOTHING
+		RETURNING id, created_at
+	`, playerID, template.Name, template.OpponentID, template.Bet, template.RuleSet, template.Strategy, template.Choice,
+	).Scan(&template.ID, &template.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTemplateNameTaken
+	}
+	if err != nil {
+		logrus.Errorf("Error inserting challenge template: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetTemplates returns the templates of the player by name
+func (repository *ChallengeTemplate) GetTemplates(playerID int) ([]model.ChallengeTemplate, error) {
+	return repository.queryTemplates("WHERE challenge_template.player_id = $1", playerID)
+}
+
+// GetTemplate returns a template of the player, nil if they have no such template
+func (repository *ChallengeTemplate) GetTemplate(playerID int, templateID int) (*model.ChallengeTemplate, error) {
+	templates, err := repository.queryTemplates(
+		"WHERE challenge_template.player_id = $1 AND challenge_template.id = $2", playerID, templateID)
+	if err != nil || len(templates) == 0 {
+		return nil, err
+	}
+	return &templates[0], nil
+}
+
+// CountTemplates counts the templates of the player
+func (repository *ChallengeTemplate) CountTemplates(playerID int) (int, error) {
+	var count int
+	err := repository.db.QueryRow("SELECT COUNT(*) FROM challenge_template WHERE player_id = $1", playerID).Scan(&count)
+	if err != nil {
+		logrus.Errorf("Error counting challenge templates: %v", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteTemplate removes a template of the player, false if they have no such template
+func (repository *ChallengeTemplate) DeleteTemplate(playerID int, templateID int) (bool, error) {
+	result, err := repository.db.Exec("DELETE FROM challenge_template WHERE player_id = $1 AND id = $2",
+		playerID, templateID)
+	if err != nil {
+		logrus.Errorf("Error deleting challenge template: %v", err)
+		return false, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted > 0, nil
+}
+
+func (repository *ChallengeTemplate) queryTemplates(condition string, args ...any) ([]model.ChallengeTemplate, error) {
+	rows, err := repository.db.Query(`
+		SELECT challenge_template.id, challenge_template.name, challenge_template.opponent_id, opponent.username,
+		       challenge_template.bet, challenge_template.rule_set, challenge_template.strategy,
+		       COALESCE(challenge_template.choice, 0), challenge_template.created_at
+		FROM challenge_template
+		JOIN player opponent ON opponent.id = challenge_template.opponent_id
+		`+condition+`
+		ORDER BY challenge_template.name
+	`, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching challenge templates: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []model.ChallengeTemplate{}
+	for rows.Next() {
+		var template model.ChallengeTemplate
+		if err = rows.Scan(&template.ID, &template.Name, &template.OpponentID, &template.Opponent, &template.Bet,
+			&template.RuleSet, &template.Strategy, &template.Choice, &template.CreatedAt); err != nil {
+			logrus.Errorf("Error scanning challenge template: %v", err)
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over challenge templates: %v", err)
+		return nil, err
+	}
+
+	return templates, nil
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+type Fairness struct {
+	db *sql.DB
+}
+
+func NewFairnessRepository(db *sql.DB) *Fairness {
+	return &Fairness{
+		db: db,
+	}
+}
+
+// CreateSeed starts the player's seed pair unless they already have one in use
+func (repository *Fairness) CreateSeed(playerID int, serverSeed string, serverSeedHash string, clientSeed string) error {
+	_, err := repository.db.Exec(`
+		INSERT INTO fairness_seed (player_id, server_seed, server_seed_hash, client_seed)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (player_id) WHERE revealed_at IS NULL DO NOTHING
+	`, playerID, serverSeed, serverSeedHash, clientSeed)
+	if err != nil {
+		logrus.Errorf("Error creating seed pair: %v", err)
+		return err
+	}
+	return nil
+}
+
+// GetActiveSeed returns the seed pair in use with its server seed, nil if the player has none yet
+func (repository *Fairness) GetActiveSeed(playerID int) (*model.FairnessSeed, error) {
+	seed, err := scanSeed(repository.db.QueryRow(`
+		SELECT id, player_id, server_seed, server_seed_hash, client_seed, nonce, created_at, revealed_at
+		FROM fairness_seed
+		WHERE player_id = $1 AND revealed_at IS NULL
+	`, playerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Error fetching seed pair: %v", err)
+		return nil, err
+	}
+	return seed, nil
+}
+
+// NextNonce draws the next nonce of the seed pair in use, the returned pair carries the nonce to draw with.
+// Concurrent draws never get the same nonce. It returns nil if the player has no seed pair yet.
+func (repository *Fairness) NextNonce(playerID int) (*model.FairnessSeed, error) {
+	seed, err := scanSeed(repository.db.QueryRow(`
+		UPDATE fairness_seed SET nonce = nonce + 1
+		WHERE player_id = $1 AND revealed_at IS NULL
+		RETURNING id, player_id, server_seed, server_seed_hash, client_seed, nonce - 1, created_at, revealed_at
+	`, playerID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logrus.Errorf("Error drawing nonce: %v", err)
+		return nil, err
+	}
+	return seed, nil
+}
+
+// RotateSeed reveals the seed pair in use and replaces it with a new one. The revealed pair is nil when the
+// player did not have one yet.
+func (repository *Fairness) RotateSeed(playerID int, serverSeed string, serverSeedHash string, clientSeed string) (*model.FairnessRotation, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start rotating seed pair: %v", err)
+	}
+	defer tx.Rollback()
+
+	rotation := &model.FairnessRotation{}
+	revealed, err := scanSeed(tx.QueryRow(`
+		UPDATE fairness_seed SET revealed_at = CURRENT_TIMESTAMP
+		WHERE player_id = $1 AND revealed_at IS NULL
+		RETURNING id, player_id, server_seed, server_seed_hash, client_seed, nonce, created_at, revealed_at
+	`, playerID))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to reveal seed pair: %v", err)
+	}
+	if revealed != nil {
+		rotation.Revealed = *revealed
+	}
+
+	active, err := scanSeed(tx.QueryRow(`
+		INSERT INTO fairness_seed (player_id, server_seed, server_seed_hash, client_seed)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, player_id, server_seed, server_seed_hash, client_seed, nonce, created_at, revealed_at
+	`, playerID, serverSeed, serverSeedHash, clientSeed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert seed pair: %v", err)
+	}
+	rotation.Active = *active
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit seed rotation: %v", err)
+	}
+
+	// The new server seed stays secret until the next rotation
+	rotation.Active.ServerSeed = ""
+	return rotation, nil
+}
+
+// GetSeeds returns the player's seed pairs, newest first, server seeds only once they are revealed
+func (repository *Fairness) GetSeeds(playerID int) ([]model.FairnessSeed, error) {
+	rows, err := repository.db.Query(`
+		SELECT id, player_id, server_seed, server_seed_hash, client_seed, nonce, created_at, revealed_at
+		FROM fairness_seed
+		WHERE player_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, playerID)
+	if err != nil {
+		logrus.Errorf("Error fetching seed pairs: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	seeds := []model.FairnessSeed{}
+	for rows.Next() {
+		seed, err := scanSeed(rows)
+		if err != nil {
+			logrus.Errorf("Error scanning seed pair: %v", err)
+			return nil, err
+		}
+		if seed.RevealedAt == nil {
+			seed.ServerSeed = ""
+		}
+		seeds = append(seeds, *seed)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over seed pairs: %v", err)
+		return nil, err
+	}
+
+	return seeds, nil
+}
+
+// GetChallengeDraw returns the commitment, client seed and nonce the move of one of the player's challenges was drawn
+// with and the move, nil if the player has no such challenge or chose its move themselves
+func (repository *Fairness) GetChallengeDraw(playerID int, challengeID int) (*model.FairnessSeed, int, error) {
+	var seed model.FairnessSeed
+	var choice int
+	err := repository.db.QueryRow(`
+		SELECT fairness_seed.id, fairness_seed.server_seed_hash, fairness_seed.client_seed, challenge.nonce,
+		       challenge.choice
+		FROM challenge
+		JOIN fairness_seed ON fairness_seed.id = challenge.seed_id
+		WHERE challenge.challenge_id = $1 AND challenge.challenger_id = $2
+	`, challengeID, playerID).Scan(&seed.ID, &seed.ServerSeedHash, &seed.ClientSeed, &seed.Nonce, &choice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		logrus.Errorf("Error fetching challenge draw: %v", err)
+		return nil, 0, err
+	}
+	seed.PlayerID = playerID
+	return &seed, choice, nil
+}
+
+// scanSeed reads a seed pair from a row of id, player_id, server_seed, server_seed_hash, client_seed, nonce,
+// created_at and revealed_at
+func scanSeed(row interface{ Scan(...any) error }) (*model.FairnessSeed, error) {
+	var seed model.FairnessSeed
+	var revealedAt sql.NullTime
+	err := row.Scan(&seed.ID, &seed.PlayerID, &seed.ServerSeed, &seed.ServerSeedHash, &seed.ClientSeed, &seed.Nonce,
+		&seed.CreatedAt, &revealedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revealedAt.Valid {
+		seed.RevealedAt = &revealedAt.Time
+	}
+	return &seed, nil
+}
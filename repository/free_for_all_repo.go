@@ -0,0 +1,283 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"time"
+)
+
+var (
+	ErrFreeForAllNotFound = errors.New("round not found")
+	ErrFreeForAllClosed   = errors.New("round is not open anymore")
+	ErrFreeForAllFull     = errors.New("round is full")
+	ErrAlreadyInRound     = errors.New("already playing in this round")
+)
+
+type FreeForAll struct {
+	db *sql.DB
+}
+
+func NewFreeForAllRepository(db *sql.DB) *FreeForAll {
+	return &FreeForAll{
+		db: db,
+	}
+}
+
+// CreateFreeForAll opens the round and joins the creator with their move, it fills in the id of the round
+func (repository *FreeForAll) CreateFreeForAll(round *model.FreeForAll, creatorID int, choice int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start creating round: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO ffa_round (creator_id, stake, min_players, max_players, rule, state, deadline)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, creatorID, round.Stake, round.MinPlayers, round.MaxPlayers, round.Rule, model.FreeForAllOpen, round.Deadline,
+	).Scan(&round.ID, &round.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert round: %v", err)
+	}
+
+	if err = joinFreeForAll(tx, round.ID, round.Stake, creatorID, choice); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit round: %v", err)
+	}
+
+	round.State = model.FreeForAllOpen
+	round.Players = 1
+	return nil
+}
+
+// JoinFreeForAll holds the stake and adds the player with their move, it returns the number of players afterwards
+func (repository *FreeForAll) JoinFreeForAll(roundID int, playerID int, choice int) (int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start joining round: %v", err)
+	}
+	defer tx.Rollback()
+
+	round, err := lockFreeForAll(tx, roundID)
+	if err != nil {
+		return 0, err
+	}
+	if round.State != model.FreeForAllOpen || !time.Now().Before(round.Deadline) {
+		return 0, ErrFreeForAllClosed
+	}
+
+	var players int
+	var alreadyJoined bool
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(BOOL_OR(player_id = $2), FALSE) FROM ffa_participant WHERE round_id = $1
+	`, roundID, playerID).Scan(&players, &alreadyJoined)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count players: %v", err)
+	}
+	if alreadyJoined {
+		return 0, ErrAlreadyInRound
+	}
+	if players >= round.MaxPlayers {
+		return 0, ErrFreeForAllFull
+	}
+
+	if err = joinFreeForAll(tx, roundID, round.Stake, playerID, choice); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit joining round: %v", err)
+	}
+
+	return players + 1, nil
+}
+
+// GetFreeForAll returns the round with its participants and their moves, nil if there is no such round
+func (repository *FreeForAll) GetFreeForAll(roundID int) (*model.FreeForAll, error) {
+	rounds, err := repository.queryFreeForAlls("WHERE ffa_round.id = $1", roundID)
+	if err != nil || len(rounds) == 0 {
+		return nil, err
+	}
+	round := &rounds[0]
+
+	rows, err := repository.db.Query(`
+		SELECT ffa_participant.player_id, player.username, ffa_participant.choice,
+		       COALESCE(ffa_participant.result, ''), ffa_participant.payout, ffa_participant.joined_at
+		FROM ffa_participant
+		JOIN player ON player.id = ffa_participant.player_id
+		WHERE ffa_participant.round_id = $1
+		ORDER BY ffa_participant.joined_at, ffa_participant.player_id
+	`, roundID)
+	if err != nil {
+		logrus.Errorf("Error fetching round participants: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	round.Participants = []model.FreeForAllParticipant{}
+	for rows.Next() {
+		var participant model.FreeForAllParticipant
+		if err = rows.Scan(&participant.PlayerID, &participant.Username, &participant.Choice,
+			&participant.Result, &participant.Payout, &participant.JoinedAt); err != nil {
+			logrus.Errorf("Error scanning round participant: %v", err)
+			return nil, err
+		}
+		participant.Move = model.ChoiceToString(participant.Choice)
+		round.Participants = append(round.Participants, participant)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over round participants: %v", err)
+		return nil, err
+	}
+
+	return round, nil
+}
+
+// GetFreeForAlls returns the rounds in the given state, or all of them for an empty state, newest first
+func (repository *FreeForAll) GetFreeForAlls(state string) ([]model.FreeForAll, error) {
+	return repository.queryFreeForAlls("WHERE $1 = '' OR ffa_round.state = $1", state)
+}
+
+// GetDueFreeForAlls returns the ids of the open rounds whose deadline has passed
+func (repository *FreeForAll) GetDueFreeForAlls() ([]int, error) {
+	rows, err := repository.db.Query("SELECT id FROM ffa_round WHERE state = $1 AND deadline <= CURRENT_TIMESTAMP",
+		model.FreeForAllOpen)
+	if err != nil {
+		logrus.Errorf("Error fetching due rounds: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roundIDs []int
+	for rows.Next() {
+		var roundID int
+		if err = rows.Scan(&roundID); err != nil {
+			logrus.Errorf("Error scanning due round: %v", err)
+			return nil, err
+		}
+		roundIDs = append(roundIDs, roundID)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over due rounds: %v", err)
+		return nil, err
+	}
+
+	return roundIDs, nil
+}
+
+func (repository *FreeForAll) queryFreeForAlls(condition string, args ...any) ([]model.FreeForAll, error) {
+	rows, err := repository.db.Query(`
+		SELECT ffa_round.id, creator.username, ffa_round.stake, ffa_round.min_players, ffa_round.max_players,
+		       ffa_round.rule, ffa_round.state, ffa_round.deadline, ffa_round.created_at, ffa_round.settled_at,
+		       (SELECT COUNT(*) FROM ffa_participant WHERE ffa_participant.round_id = ffa_round.id)
+		FROM ffa_round
+		JOIN player creator ON creator.id = ffa_round.creator_id
+		`+condition+`
+		ORDER BY ffa_round.id DESC
+	`, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching rounds: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rounds := []model.FreeForAll{}
+	for rows.Next() {
+		var round model.FreeForAll
+		var settledAt sql.NullTime
+		if err = rows.Scan(&round.ID, &round.Creator, &round.Stake, &round.MinPlayers, &round.MaxPlayers,
+			&round.Rule, &round.State, &round.Deadline, &round.CreatedAt, &settledAt, &round.Players); err != nil {
+			logrus.Errorf("Error scanning round: %v", err)
+			return nil, err
+		}
+		if settledAt.Valid {
+			round.SettledAt = &settledAt.Time
+		}
+		rounds = append(rounds, round)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over rounds: %v", err)
+		return nil, err
+	}
+
+	return rounds, nil
+}
+
+// SettleFreeForAll stores the result and payout of every participant, pays them out and closes the round in state.
+// Winners are paid as wins, everything else paid back is a refund.
+func (repository *FreeForAll) SettleFreeForAll(roundID int, state string, participants []model.FreeForAllParticipant) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start settling round: %v", err)
+	}
+	defer tx.Rollback()
+
+	round, err := lockFreeForAll(tx, roundID)
+	if err != nil {
+		return err
+	}
+	if round.State != model.FreeForAllOpen {
+		return ErrFreeForAllClosed
+	}
+
+	for _, participant := range participants {
+		_, err = tx.Exec("UPDATE ffa_participant SET result = $1, payout = $2 WHERE round_id = $3 AND player_id = $4",
+			participant.Result, participant.Payout, roundID, participant.PlayerID)
+		if err != nil {
+			return fmt.Errorf("failed to store result of player %d: %v", participant.PlayerID, err)
+		}
+
+		reason := model.ReasonRefund
+		if participant.Result == model.FreeForAllResultWin {
+			reason = model.ReasonWin
+		}
+		if err = creditPlayer(tx, participant.PlayerID, participant.Payout, reason, 0); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE ffa_round SET state = $1, settled_at = CURRENT_TIMESTAMP WHERE id = $2", state, roundID)
+	if err != nil {
+		return fmt.Errorf("failed to close round: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// lockFreeForAll reads the round and locks it until the end of tx
+func lockFreeForAll(tx *sql.Tx, roundID int) (*model.FreeForAll, error) {
+	var round model.FreeForAll
+	err := tx.QueryRow("SELECT id, stake, max_players, state, deadline FROM ffa_round WHERE id = $1 FOR UPDATE",
+		roundID).Scan(&round.ID, &round.Stake, &round.MaxPlayers, &round.State, &round.Deadline)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFreeForAllNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find round %d: %v", roundID, err)
+	}
+	return &round, nil
+}
+
+// joinFreeForAll holds the stake of the player and stores their move within tx
+func joinFreeForAll(tx *sql.Tx, roundID int, stake int, playerID int, choice int) error {
+	if err := debitPlayer(tx, playerID, stake, model.ReasonBet, 0); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("INSERT INTO ffa_participant (round_id, player_id, choice) VALUES ($1, $2, $3)",
+		roundID, playerID, choice)
+	if err != nil {
+		return fmt.Errorf("failed to add player %d to round: %v", playerID, err)
+	}
+	return nil
+}
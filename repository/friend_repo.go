@@ -0,0 +1,256 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+// Friend stores friendships and the block list. A friendship is a single row from the requester
+// to the addressee, it is pending until the addressee accepts it.
+type Friend struct {
+	db *sql.DB
+}
+
+func NewFriendRepository(db *sql.DB) *Friend {
+	return &Friend{
+		db: db,
+	}
+}
+
+// RequestFriendship sends a friend request. If the other player already asked, their request is accepted instead.
+// It returns the resulting state as seen from the requester.
+func (repository *Friend) RequestFriendship(requesterID int, addresseeID int) (string, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		logrus.Errorf("Error starting friend request: %v", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE friendship SET accepted_at = CURRENT_TIMESTAMP
+		WHERE requester_id = $1 AND addressee_id = $2 AND accepted_at IS NULL
+	`, addresseeID, requesterID)
+	if err != nil {
+		logrus.Errorf("Error accepting friend request: %v", err)
+		return "", err
+	}
+	if accepted, _ := result.RowsAffected(); accepted == 1 {
+		return model.FriendAccepted, tx.Commit()
+	}
+
+	// Either direction already being there means there is nothing to send
+	var accepted sql.NullTime
+	err = tx.QueryRow(`
+		SELECT accepted_at FROM friendship
+		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
+	`, requesterID, addresseeID).Scan(&accepted)
+	if err == nil {
+		if accepted.Valid {
+			return model.FriendAccepted, nil
+		}
+		return model.FriendOutgoing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		logrus.Errorf("Error fetching friendship: %v", err)
+		return "", err
+	}
+
+	_, err = tx.Exec("INSERT INTO friendship (requester_id, addressee_id) VALUES ($1, $2)", requesterID, addresseeID)
+	if err != nil {
+		logrus.Errorf("Error inserting friend request: %v", err)
+		return "", err
+	}
+
+	return model.FriendOutgoing, tx.Commit()
+}
+
+// AcceptFriendship accepts the pending request the requester sent to the addressee, false if there is none
+func (repository *Friend) AcceptFriendship(addresseeID int, requesterID int) (bool, error) {
+	result, err := repository.db.Exec(`
+		UPDATE friendship SET accepted_at = CURRENT_TIMESTAMP
+		WHERE requester_id = $1 AND addressee_id = $2 AND accepted_at IS NULL
+	`, requesterID, addresseeID)
+	if err != nil {
+		logrus.Errorf("Error accepting friend request: %v", err)
+		return false, err
+	}
+
+	accepted, err := result.RowsAffected()
+	return accepted == 1, err
+}
+
+// RemoveFriendship removes a friend, or cancels or declines a friend request, false if there was none
+func (repository *Friend) RemoveFriendship(playerID int, otherID int) (bool, error) {
+	result, err := repository.db.Exec(`
+		DELETE FROM friendship
+		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
+	`, playerID, otherID)
+	if err != nil {
+		logrus.Errorf("Error removing friendship: %v", err)
+		return false, err
+	}
+
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// GetFriends returns the friends and the pending friend requests of the player
+func (repository *Friend) GetFriends(playerID int) ([]model.Friend, error) {
+	query := `
+        SELECT other.username,
+               CASE WHEN friendship.accepted_at IS NOT NULL THEN $2
+                    WHEN friendship.requester_id = $1 THEN $3
+                    ELSE $4 END,
+               COALESCE(friendship.accepted_at, friendship.created_at)
+        FROM friendship
+        JOIN player other ON other.id = CASE WHEN friendship.requester_id = $1
+                                             THEN friendship.addressee_id ELSE friendship.requester_id END
+        WHERE (friendship.requester_id = $1 OR friendship.addressee_id = $1) AND other.closed_at IS NULL
+        ORDER BY other.username
+    `
+
+	rows, err := repository.db.Query(query, playerID, model.FriendAccepted, model.FriendOutgoing, model.FriendIncoming)
+	if err != nil {
+		logrus.Errorf("Error fetching friends: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	friends := []model.Friend{}
+	for rows.Next() {
+		var friend model.Friend
+		if err = rows.Scan(&friend.Username, &friend.State, &friend.Since); err != nil {
+			logrus.Errorf("Error scanning friend: %v", err)
+			return nil, err
+		}
+		friends = append(friends, friend)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over friends: %v", err)
+		return nil, err
+	}
+
+	return friends, nil
+}
+
+// AreFriends tells whether the two players are friends, pending requests do not count
+func (repository *Friend) AreFriends(playerID int, otherID int) (bool, error) {
+	var friends bool
+	err := repository.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM friendship
+			WHERE ((requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1))
+			  AND accepted_at IS NOT NULL
+		)
+	`, playerID, otherID).Scan(&friends)
+	if err != nil {
+		logrus.Errorf("Error checking friendship: %v", err)
+		return false, err
+	}
+	return friends, nil
+}
+
+// Block puts the blocked player on the blocker's block list. Their friendship ends and the challenges
+// the blocked player sent the blocker are declined and their held bets released, their ids are returned.
+func (repository *Friend) Block(blockerID int, blockedID int) ([]int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start block: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO player_block (blocker_id, blocked_id) VALUES ($1, $2)
+		ON CONFLICT (blocker_id, blocked_id) DO NOTHING
+	`, blockerID, blockedID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to block player: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM friendship
+		WHERE (requester_id = $1 AND addressee_id = $2) OR (requester_id = $2 AND addressee_id = $1)
+	`, blockerID, blockedID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to remove friendship: %v", err)
+	}
+
+	declined, err := releasePendingChallenges(tx, model.ChallengeDeclined, "challenger_id = $3 AND opponent_id = $4",
+		blockedID, blockerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decline pending challenges: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit block: %v", err)
+	}
+
+	return declined, nil
+}
+
+// Unblock takes the player off the block list, false if they were not on it
+func (repository *Friend) Unblock(blockerID int, blockedID int) (bool, error) {
+	result, err := repository.db.Exec("DELETE FROM player_block WHERE blocker_id = $1 AND blocked_id = $2",
+		blockerID, blockedID)
+	if err != nil {
+		logrus.Errorf("Error unblocking player: %v", err)
+		return false, err
+	}
+
+	removed, err := result.RowsAffected()
+	return removed == 1, err
+}
+
+// GetBlockedPlayers returns the player's block list
+func (repository *Friend) GetBlockedPlayers(blockerID int) ([]model.BlockedPlayer, error) {
+	rows, err := repository.db.Query(`
+		SELECT player.username, player_block.created_at
+		FROM player_block
+		JOIN player ON player.id = player_block.blocked_id
+		WHERE player_block.blocker_id = $1
+		ORDER BY player.username
+	`, blockerID)
+	if err != nil {
+		logrus.Errorf("Error fetching blocked players: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := []model.BlockedPlayer{}
+	for rows.Next() {
+		var player model.BlockedPlayer
+		if err = rows.Scan(&player.Username, &player.Since); err != nil {
+			logrus.Errorf("Error scanning blocked player: %v", err)
+			return nil, err
+		}
+		blocked = append(blocked, player)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over blocked players: %v", err)
+		return nil, err
+	}
+
+	return blocked, nil
+}
+
+// IsBlocked tells whether either of the two players blocked the other
+func (repository *Friend) IsBlocked(playerID int, otherID int) (bool, error) {
+	var blocked bool
+	err := repository.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM player_block
+			WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1)
+		)
+	`, playerID, otherID).Scan(&blocked)
+	if err != nil {
+		logrus.Errorf("Error checking block: %v", err)
+		return false, err
+	}
+	return blocked, nil
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+var ErrHoldNotFound = errors.New("no bet is held for this challenge")
+
+// Hold keeps the bets of open challenges aside. A held bet stays in the player's balance but cannot be bet, sent
+// or withdrawn, it is only recorded as a transaction once it is captured.
+type Hold struct {
+	db *sql.DB
+}
+
+func NewHoldRepository(db *sql.DB) *Hold {
+	return &Hold{
+		db: db,
+	}
+}
+
+// GetBalance returns the player's balance in the currency with what part of it is held and the active holds
+func (repository *Hold) GetBalance(playerID int, currency string) (*model.BalanceSummary, error) {
+	var balance, held int
+	var row *sql.Row
+	if currency == model.CurrencyCoin {
+		row = repository.db.QueryRow("SELECT balance, held FROM player WHERE id = $1", playerID)
+	} else {
+		row = repository.db.QueryRow("SELECT balance, held FROM wallet WHERE player_id = $1 AND currency = $2",
+			playerID, currency)
+	}
+	err := row.Scan(&balance, &held)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to fetch %s balance: %v", currency, err)
+	}
+
+	summary := &model.BalanceSummary{
+		Balance:   model.Money{Currency: currency, Amount: balance},
+		Held:      model.Money{Currency: currency, Amount: held},
+		Available: model.Money{Currency: currency, Amount: balance - held},
+		Holds:     []model.Hold{},
+	}
+
+	rows, err := repository.db.Query(`
+		SELECT id, challenge_id, currency, amount, state, created_at, closed_at
+		FROM hold
+		WHERE player_id = $1 AND currency = $2 AND state = $3
+		ORDER BY created_at, id
+	`, playerID, currency, model.HoldActive)
+	if err != nil {
+		logrus.Errorf("Error fetching holds: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hold model.Hold
+		var closedAt sql.NullTime
+		if err = rows.Scan(&hold.ID, &hold.ChallengeID, &hold.Amount.Currency, &hold.Amount.Amount, &hold.State,
+			&hold.CreatedAt, &closedAt); err != nil {
+			logrus.Errorf("Error scanning hold: %v", err)
+			return nil, err
+		}
+		if closedAt.Valid {
+			hold.ClosedAt = &closedAt.Time
+		}
+		summary.Holds = append(summary.Holds, hold)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over holds: %v", err)
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// placeHold sets money aside from the player's available balance for the challenge within tx,
+// ErrInsufficientBalance when the available balance does not cover it
+func placeHold(tx *sql.Tx, playerID int, challengeID int, money model.Money) error {
+	if err := changeHeld(tx, playerID, money); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO hold (player_id, challenge_id, currency, amount, state) VALUES ($1, $2, $3, $4, $5)
+	`, playerID, challengeID, money.Currency, money.Amount, model.HoldActive)
+	if err != nil {
+		return fmt.Errorf("failed to hold bet of player %d: %v", playerID, err)
+	}
+	return nil
+}
+
+// releaseHold makes the bet held for the challenge available again within tx without recording a transaction,
+// a challenge without a held bet is left as it is
+func releaseHold(tx *sql.Tx, challengeID int) error {
+	_, _, err := closeHold(tx, challengeID, model.HoldReleased)
+	if errors.Is(err, ErrHoldNotFound) {
+		return nil
+	}
+	return err
+}
+
+// captureHold takes the bet held for the challenge from the player's balance and records it as a bet within tx
+func captureHold(tx *sql.Tx, challengeID int) error {
+	playerID, money, err := closeHold(tx, challengeID, model.HoldCaptured)
+	if err != nil {
+		return err
+	}
+	return debitWallet(tx, playerID, money, model.ReasonBet, challengeID)
+}
+
+// resizeHold changes the bet held for the challenge to amount within tx, ErrInsufficientBalance when the available
+// balance does not cover the difference
+func resizeHold(tx *sql.Tx, challengeID int, amount int) error {
+	var holdID, playerID int
+	var previous model.Money
+	err := tx.QueryRow(`
+		SELECT id, player_id, currency, amount FROM hold WHERE challenge_id = $1 AND state = $2 FOR UPDATE
+	`, challengeID, model.HoldActive).Scan(&holdID, &playerID, &previous.Currency, &previous.Amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrHoldNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find hold of challenge %d: %v", challengeID, err)
+	}
+
+	if err = changeHeld(tx, playerID, model.Money{Currency: previous.Currency, Amount: amount - previous.Amount}); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("UPDATE hold SET amount = $1 WHERE id = $2", amount, holdID); err != nil {
+		return fmt.Errorf("failed to update hold of challenge %d: %v", challengeID, err)
+	}
+	return nil
+}
+
+// closeHold moves the active hold of the challenge to state and takes it off the held balance, it returns whose
+// bet it was
+func closeHold(tx *sql.Tx, challengeID int, state string) (int, model.Money, error) {
+	var playerID int
+	var money model.Money
+	err := tx.QueryRow(`
+		UPDATE hold SET state = $1, closed_at = CURRENT_TIMESTAMP
+		WHERE challenge_id = $2 AND state = $3
+		RETURNING player_id, currency, amount
+	`, state, challengeID, model.HoldActive).Scan(&playerID, &money.Currency, &money.Amount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, money, ErrHoldNotFound
+	}
+	if err != nil {
+		return 0, money, fmt.Errorf("failed to close hold of challenge %d: %v", challengeID, err)
+	}
+
+	if err = changeHeld(tx, playerID, money.Negate()); err != nil {
+		return 0, money, err
+	}
+	return playerID, money, nil
+}
+
+// changeHeld changes how much of the player's balance in the currency is held, in a single statement that keeps
+// it within the balance like changeWalletBalance
+func changeHeld(db execer, playerID int, change model.Money) error {
+	var result sql.Result
+	var err error
+	if change.Currency == model.CurrencyCoin {
+		result, err = db.Exec("UPDATE player SET held = held + $1 WHERE id = $2 AND held + $1 BETWEEN 0 AND balance",
+			change.Amount, playerID)
+	} else {
+		result, err = db.Exec(`
+			UPDATE wallet SET held = held + $1, updated_at = CURRENT_TIMESTAMP
+			WHERE player_id = $2 AND currency = $3 AND held + $1 BETWEEN 0 AND balance
+		`, change.Amount, playerID, change.Currency)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update held %s balance: %v", change.Currency, err)
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return ErrInsufficientBalance
+	}
+
+	return nil
+}
@@ -0,0 +1,267 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"strings"
+)
+
+const houseGamesPageSize = 50
+
+// ErrHouseBankroll is returned when paying out the bet's largest possible win would exceed the bankroll limits
+var ErrHouseBankroll = errors.New("the house cannot cover this bet right now")
+
+type House struct {
+	db *sql.DB
+}
+
+func NewHouseRepository(db *sql.DB) *House {
+	return &House{
+		db: db,
+	}
+}
+
+// PlayHouseGame settles a game whose outcome is already decided in a single transaction. The bankroll is locked
+// and checked against maxWin, the most the house could lose on the bet, so the check does not depend on the outcome:
+// afterwards at least minBankroll has to be left and maxWin cannot be more than maxWinPercent of the bankroll.
+// It fills in the id of the game.
+func (repository *House) PlayHouseGame(houseID int, game *model.HouseGame, maxWin int, minBankroll int, maxWinPercent int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start house game: %v", err)
+	}
+	defer tx.Rollback()
+
+	var bankroll int
+	err = tx.QueryRow("SELECT balance FROM player WHERE id = $1 FOR UPDATE", houseID).Scan(&bankroll)
+	if err != nil {
+		return fmt.Errorf("failed to lock the house bankroll: %v", err)
+	}
+	if bankroll-maxWin < minBankroll || maxWin*100 > bankroll*maxWinPercent {
+		return ErrHouseBankroll
+	}
+
+	if err = debitPlayer(tx, game.PlayerID, game.Bet, model.ReasonBet, 0); err != nil {
+		return err
+	}
+	reason := model.ReasonWin
+	if game.Result == model.HouseResultDraw {
+		reason = model.ReasonRefund
+	}
+	if err = creditPlayer(tx, game.PlayerID, game.Payout, reason, 0); err != nil {
+		return err
+	}
+
+	// The house only books its net result, a draw leaves the bankroll as it was
+	if game.Bet > game.Payout {
+		err = creditPlayer(tx, houseID, game.Bet-game.Payout, model.ReasonHouseGame, 0)
+	} else {
+		err = debitPlayer(tx, houseID, game.Payout-game.Bet, model.ReasonHouseGame, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`
+		INSERT INTO house_game (player_id, bet, choice, house_choice, result, payout, seed_id, server_seed_hash, client_seed, nonce)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id, created_at
+	`, game.PlayerID, game.Bet, game.Choice, game.HouseChoice, game.Result, game.Payout, game.SeedID,
+		game.ServerSeedHash, game.ClientSeed, game.Nonce,
+	).Scan(&game.ID, &game.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert house game: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit house game: %v", err)
+	}
+	return nil
+}
+
+// GetHouseGames returns the player's latest games against the house, newest first
+func (repository *House) GetHouseGames(playerID int) ([]model.HouseGame, error) {
+	return repository.queryHouseGames("WHERE house_game.player_id = $1 ORDER BY house_game.created_at DESC, house_game.id DESC LIMIT $2",
+		playerID, houseGamesPageSize)
+}
+
+// GetHouseGame returns one of the player's games against the house, nil if they have no such game
+func (repository *House) GetHouseGame(playerID int, gameID int) (*model.HouseGame, error) {
+	games, err := repository.queryHouseGames("WHERE house_game.player_id = $1 AND house_game.id = $2", playerID, gameID)
+	if err != nil || len(games) == 0 {
+		return nil, err
+	}
+	return &games[0], nil
+}
+
+// queryHouseGames lists house games, the server seed is left empty while its seed pair is still in use
+func (repository *House) queryHouseGames(condition string, args ...any) ([]model.HouseGame, error) {
+	rows, err := repository.db.Query(`
+		SELECT house_game.id, house_game.player_id, house_game.bet, house_game.choice, house_game.house_choice,
+		       house_game.result, house_game.payout,
+		       COALESCE(house_game.server_seed,
+		                CASE WHEN fairness_seed.revealed_at IS NOT NULL THEN fairness_seed.server_seed END, ''),
+		       house_game.server_seed_hash, house_game.client_seed, house_game.nonce, house_game.created_at
+		FROM house_game
+		LEFT JOIN fairness_seed ON fairness_seed.id = house_game.seed_id
+		`+condition, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching house games: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	games := []model.HouseGame{}
+	for rows.Next() {
+		var game model.HouseGame
+		if err = rows.Scan(&game.ID, &game.PlayerID, &game.Bet, &game.Choice, &game.HouseChoice, &game.Result,
+			&game.Payout, &game.ServerSeed, &game.ServerSeedHash, &game.ClientSeed, &game.Nonce, &game.CreatedAt); err != nil {
+			logrus.Errorf("Error scanning house game: %v", err)
+			return nil, err
+		}
+		game.Move = model.ChoiceToString(game.Choice)
+		game.HouseMove = model.ChoiceToString(game.HouseChoice)
+		games = append(games, game)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over house games: %v", err)
+		return nil, err
+	}
+
+	return games, nil
+}
+
+// FundHouse moves money into the bankroll, or out of it for a negative amount, and returns the new bankroll
+func (repository *House) FundHouse(houseID int, amount int) (int, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start funding the house: %v", err)
+	}
+	defer tx.Rollback()
+
+	if amount > 0 {
+		err = creditPlayer(tx, houseID, amount, model.ReasonDeposit, 0)
+	} else {
+		err = debitPlayer(tx, houseID, -amount, model.ReasonWithdrawal, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var bankroll int
+	if err = tx.QueryRow("SELECT balance FROM player WHERE id = $1", houseID).Scan(&bankroll); err != nil {
+		return 0, fmt.Errorf("failed to fetch the house bankroll: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit funding the house: %v", err)
+	}
+	return bankroll, nil
+}
+
+// GetHouseReport sums up the games against the house played in the requested period
+func (repository *House) GetHouseReport(houseID int, request model.HouseReportRequest) (*model.HouseReport, error) {
+	report := &model.HouseReport{}
+	if err := repository.db.QueryRow("SELECT balance FROM player WHERE id = $1", houseID).Scan(&report.Bankroll); err != nil {
+		return nil, fmt.Errorf("failed to fetch the house bankroll: %v", err)
+	}
+
+	var conditions []string
+	var args []any
+	if !request.From.IsZero() {
+		args = append(args, request.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+		report.From = &request.From
+	}
+	if !request.To.IsZero() {
+		args = append(args, request.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+		report.To = &request.To
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	err := repository.db.QueryRow(fmt.Sprintf(`
+		SELECT COUNT(*),
+		       COUNT(*) FILTER (WHERE result = '%s'),
+		       COUNT(*) FILTER (WHERE result = '%s'),
+		       COUNT(*) FILTER (WHERE result = '%s'),
+		       COALESCE(SUM(bet), 0),
+		       COALESCE(SUM(payout), 0)
+		FROM house_game
+		%s
+	`, model.HouseResultWin, model.HouseResultLoss, model.HouseResultDraw, where), args...,
+	).Scan(&report.Games, &report.PlayerWins, &report.PlayerLosses, &report.Draws, &report.Wagered, &report.PaidOut)
+	if err != nil {
+		logrus.Errorf("Error building house report: %v", err)
+		return nil, err
+	}
+
+	report.ProfitAndLoss = report.Wagered - report.PaidOut
+	return report, nil
+}
+
+// GetRevenueReport sums up the rake paid to the house in the requested period
+func (repository *House) GetRevenueReport(houseID int, request model.HouseReportRequest) (*model.RevenueReport, error) {
+	report := &model.RevenueReport{ByRuleSet: map[string]int{}, Days: []model.RevenueDay{}}
+
+	args := []any{houseID, model.ReasonRake}
+	conditions := []string{"transaction.player_id = $1", "transaction.reason = $2"}
+	if !request.From.IsZero() {
+		args = append(args, request.From)
+		conditions = append(conditions, fmt.Sprintf("transaction.timestamp >= $%d", len(args)))
+		report.From = &request.From
+	}
+	if !request.To.IsZero() {
+		args = append(args, request.To)
+		conditions = append(conditions, fmt.Sprintf("transaction.timestamp < $%d", len(args)))
+		report.To = &request.To
+	}
+
+	rows, err := repository.db.Query(`
+		SELECT TO_CHAR(transaction.timestamp AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+		       COALESCE(challenge.rule_set, ''), COUNT(*), SUM(transaction.amount)
+		FROM transaction
+		LEFT JOIN challenge ON challenge.challenge_id = transaction.challenge_id
+		WHERE `+strings.Join(conditions, " AND ")+`
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, args...)
+	if err != nil {
+		logrus.Errorf("Error building revenue report: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day, ruleSet string
+		var challenges, amount int
+		if err = rows.Scan(&day, &ruleSet, &challenges, &amount); err != nil {
+			logrus.Errorf("Error scanning revenue: %v", err)
+			return nil, err
+		}
+
+		report.Total += amount
+		report.Challenges += challenges
+		report.ByRuleSet[ruleSet] += amount
+		if last := len(report.Days) - 1; last >= 0 && report.Days[last].Day == day {
+			report.Days[last].Amount += amount
+			report.Days[last].Challenges += challenges
+		} else {
+			report.Days = append(report.Days, model.RevenueDay{Day: day, Amount: amount, Challenges: challenges})
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over revenue: %v", err)
+		return nil, err
+	}
+
+	return report, nil
+}
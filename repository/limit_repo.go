@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"time"
+)
+
+// limitUsageReasons are the transactions a kind of limit is counted from, bets count the stakes placed and
+// losses the stakes less what came back
+var limitUsageReasons = map[string][]string{
+	model.LimitDeposit: {model.ReasonDeposit},
+	model.LimitBet:     {model.ReasonBet, model.ReasonEntryFee},
+	model.LimitLoss:    {model.ReasonBet, model.ReasonEntryFee, model.ReasonWin, model.ReasonRefund, model.ReasonPrize},
+}
+
+type Limit struct {
+	db *sql.DB
+}
+
+func NewLimitRepository(db *sql.DB) *Limit {
+	return &Limit{
+		db: db,
+	}
+}
+
+// GetLimits returns the player's limits, pending changes whose cooling-off period is over are applied first
+func (repository *Limit) GetLimits(playerID int) ([]model.PlayerLimit, error) {
+	_, err := repository.db.Exec(`
+		UPDATE player_limit SET amount = pending_amount, pending_amount = NULL, pending_from = NULL
+		WHERE player_id = $1 AND pending_from <= CURRENT_TIMESTAMP
+	`, playerID)
+	if err != nil {
+		logrus.Errorf("Error applying pending limits: %v", err)
+		return nil, err
+	}
+
+	rows, err := repository.db.Query(`
+		SELECT kind, period, amount, pending_amount, pending_from
+		FROM player_limit
+		WHERE player_id = $1 AND (amount > 0 OR pending_from IS NOT NULL)
+		ORDER BY kind, period
+	`, playerID)
+	if err != nil {
+		logrus.Errorf("Error fetching limits: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := []model.PlayerLimit{}
+	for rows.Next() {
+		var limit model.PlayerLimit
+		var pendingAmount sql.NullInt64
+		var pendingFrom sql.NullTime
+		if err = rows.Scan(&limit.Kind, &limit.Period, &limit.Amount, &pendingAmount, &pendingFrom); err != nil {
+			logrus.Errorf("Error scanning limit: %v", err)
+			return nil, err
+		}
+		if pendingFrom.Valid {
+			amount := int(pendingAmount.Int64)
+			limit.PendingAmount = &amount
+			limit.PendingFrom = &pendingFrom.Time
+		}
+		limits = append(limits, limit)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over limits: %v", err)
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+// SetLimit applies a stricter limit right away and schedules a looser one, including removing it with 0,
+// for after the cooling-off period. A stricter limit also drops a pending change.
+func (repository *Limit) SetLimit(playerID int, request model.LimitRequest, coolingOff time.Duration) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start setting limit: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO player_limit (player_id, kind, period, amount) VALUES ($1, $2, $3, 0)
+		ON CONFLICT (player_id, kind, period) DO NOTHING
+	`, playerID, request.Kind, request.Period)
+	if err != nil {
+		return fmt.Errorf("failed to insert limit: %v", err)
+	}
+
+	var current int
+	err = tx.QueryRow(`
+		SELECT CASE WHEN pending_from <= CURRENT_TIMESTAMP THEN pending_amount ELSE amount END
+		FROM player_limit
+		WHERE player_id = $1 AND kind = $2 AND period = $3
+		FOR UPDATE
+	`, playerID, request.Kind, request.Period).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("failed to lock limit: %v", err)
+	}
+
+	stricter := request.Amount > 0 && (current == 0 || request.Amount <= current)
+	if stricter {
+		_, err = tx.Exec(`
+			UPDATE player_limit SET amount = $4, pending_amount = NULL, pending_from = NULL, updated_at = CURRENT_TIMESTAMP
+			WHERE player_id = $1 AND kind = $2 AND period = $3
+		`, playerID, request.Kind, request.Period, request.Amount)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE player_limit SET amount = $4, pending_amount = $5, pending_from = $6, updated_at = CURRENT_TIMESTAMP
+			WHERE player_id = $1 AND kind = $2 AND period = $3
+		`, playerID, request.Kind, request.Period, current, request.Amount, time.Now().Add(coolingOff))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update limit: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit limit: %v", err)
+	}
+	return nil
+}
+
+// GetUsage sums up what counts against a kind of limit since the given time, limits are set in play coins
+func (repository *Limit) GetUsage(playerID int, kind string, since time.Time) (int, error) {
+	var usage int
+	err := repository.db.QueryRow(`
+		SELECT COALESCE(-SUM(amount), 0)
+		FROM transaction
+		WHERE player_id = $1 AND reason = ANY($2) AND timestamp >= $3 AND currency = $4
+	`, playerID, pq.Array(limitUsageReasons[kind]), since, model.CurrencyCoin).Scan(&usage)
+	if err != nil {
+		logrus.Errorf("Error summing up %s limit usage: %v", kind, err)
+		return 0, err
+	}
+
+	// Deposits are credits, the other kinds are counted from debits
+	if kind == model.LimitDeposit {
+		return -usage, nil
+	}
+
+	// Bets held by open challenges are staked already, they are recorded as transactions once captured
+	var held int
+	err = repository.db.QueryRow(`
+		SELECT COALESCE(SUM(amount), 0) FROM hold
+		WHERE player_id = $1 AND state = $2 AND created_at >= $3 AND currency = $4
+	`, playerID, model.HoldActive, since, model.CurrencyCoin).Scan(&held)
+	if err != nil {
+		logrus.Errorf("Error summing up held bets: %v", err)
+		return 0, err
+	}
+	return usage + held, nil
+}
+
+// Exclude blocks the player until the given time, an exclusion can only be extended
+func (repository *Limit) Exclude(playerID int, until time.Time) (time.Time, error) {
+	var excludedUntil time.Time
+	err := repository.db.QueryRow(`
+		UPDATE player SET excluded_until = GREATEST(COALESCE(excluded_until, $2), $2)
+		WHERE id = $1
+		RETURNING excluded_until
+	`, playerID, until).Scan(&excludedUntil)
+	if err != nil {
+		logrus.Errorf("Error excluding player: %v", err)
+		return time.Time{}, err
+	}
+	return excludedUntil, nil
+}
+
+// GetExclusion returns until when the player is excluded, nil if they are not
+func (repository *Limit) GetExclusion(playerID int) (*time.Time, error) {
+	var excludedUntil sql.NullTime
+	err := repository.db.QueryRow("SELECT excluded_until FROM player WHERE id = $1", playerID).Scan(&excludedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logrus.Errorf("Error fetching exclusion: %v", err)
+		return nil, err
+	}
+	if !excludedUntil.Valid || !excludedUntil.Time.After(time.Now()) {
+		return nil, nil
+	}
+	return &excludedUntil.Time, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"time"
+)
+
+type LoginAttempt struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttempt {
+	return &LoginAttempt{
+		db: db,
+	}
+}
+
+// GetLoginAttempt returns the failed attempts for the key, or nil if there are none
+func (repository *LoginAttempt) GetLoginAttempt(keyType, key string) (*model.LoginAttempt, error) {
+	query := `
+		SELECT key_type, key, failures, last_failure, blocked_until, locked_until
+		FROM login_attempt
+		WHERE key_type = $1 AND key = $2
+	`
+
+	attempt, err := scanLoginAttempt(repository.db.QueryRow(query, keyType, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logrus.Errorf("Error fetching login attempt: %v", err)
+		return nil, err
+	}
+
+	return attempt, nil
+}
+
+// RecordFailure increments the failure counter of the key and returns the new count.
+// Failures older than resetBefore no longer count and the counter starts over.
+func (repository *LoginAttempt) RecordFailure(keyType, key string, now, resetBefore time.Time) (int, error) {
+	query := `
+		INSERT INTO login_attempt (key_type, key, failures, last_failure)
+		VALUES ($1, $2, 1, $3)
+		ON CONFLICT (key_type, key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempt.last_failure < $4 OR login_attempt.locked_until < $3 THEN 1
+				ELSE login_attempt.failures + 1
+			END,
+			last_failure = $3
+		RETURNING failures
+	`
+
+	var failures int
+	err := repository.db.QueryRow(query, keyType, key, now, resetBefore).Scan(&failures)
+	if err != nil {
+		logrus.Errorf("Error recording login failure: %v", err)
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// Block stores until when the key has to back off and, if locked, until when it is locked out
+func (repository *LoginAttempt) Block(keyType, key string, blockedUntil time.Time, lockedUntil *time.Time) error {
+	query := `
+		UPDATE login_attempt
+		SET blocked_until = $1, locked_until = COALESCE($2, locked_until)
+		WHERE key_type = $3 AND key = $4
+	`
+
+	_, err := repository.db.Exec(query, blockedUntil, lockedUntil, keyType, key)
+	if err != nil {
+		logrus.Errorf("Error blocking login attempts: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Clear forgets all failures of the key, which also lifts any lockout
+func (repository *LoginAttempt) Clear(keyType, key string) error {
+	_, err := repository.db.Exec("DELETE FROM login_attempt WHERE key_type = $1 AND key = $2", keyType, key)
+	if err != nil {
+		logrus.Errorf("Error clearing login attempts: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetActiveLockouts lists all keys that are locked out at the given time
+func (repository *LoginAttempt) GetActiveLockouts(now time.Time) ([]model.LoginAttempt, error) {
+	query := `
+		SELECT key_type, key, failures, last_failure, blocked_until, locked_until
+		FROM login_attempt
+		WHERE locked_until > $1
+		ORDER BY locked_until
+	`
+
+	rows, err := repository.db.Query(query, now)
+	if err != nil {
+		logrus.Errorf("Error fetching lockouts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []model.LoginAttempt{}
+	for rows.Next() {
+		attempt, err := scanLoginAttempt(rows)
+		if err != nil {
+			logrus.Errorf("Error scanning lockout: %v", err)
+			return nil, err
+		}
+		lockouts = append(lockouts, *attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over lockouts: %v", err)
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLoginAttempt(row rowScanner) (*model.LoginAttempt, error) {
+	var attempt model.LoginAttempt
+	var blockedUntil, lockedUntil sql.NullTime
+	err := row.Scan(
+		&attempt.KeyType,
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailure,
+		&blockedUntil,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt.BlockedUntil = blockedUntil.Time
+	attempt.LockedUntil = lockedUntil.Time
+
+	return &attempt, nil
+}
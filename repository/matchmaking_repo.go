@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+// MatchmakingTicket persists the matchmaking queue, the queue itself is kept in memory
+type MatchmakingTicket struct {
+	db *sql.DB
+}
+
+func NewMatchmakingTicketRepository(db *sql.DB) *MatchmakingTicket {
+	return &MatchmakingTicket{
+		db: db,
+	}
+}
+
+// Queue takes the bet and stores the ticket in one database transaction, so an account closure sees either both or
+// neither. It sets the ticket's HoldTransactionID and returns ErrInsufficientBalance when the bet is not covered.
+func (repository *MatchmakingTicket) Queue(ticket *model.MatchmakingTicket) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	ticket.HoldTransactionID, err = debitUnlinked(tx, ticket.Bet, model.ReasonBet, ticket.PlayerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO matchmaking_ticket (player_id, bet, choice, rule_set, rating, joined_at, hold_transaction_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (player_id) DO UPDATE
+		SET bet = $2, choice = $3, rule_set = $4, rating = $5, joined_at = $6, hold_transaction_id = $7
+	`, ticket.PlayerID, ticket.Bet.Amount, ticket.Choice, ticket.RuleSet, ticket.Rating, ticket.JoinedAt, ticket.HoldTransactionID)
+	if err != nil {
+		logrus.Errorf("Error saving matchmaking ticket: %v", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit matchmaking ticket: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes the ticket of the player
+func (repository *MatchmakingTicket) Remove(playerID int) error {
+	_, err := repository.db.Exec("DELETE FROM matchmaking_ticket WHERE player_id = $1", playerID)
+	if err != nil {
+		logrus.Errorf("Error removing matchmaking ticket: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Load returns every stored ticket
+func (repository *MatchmakingTicket) Load() ([]model.MatchmakingTicket, error) {
+	rows, err := repository.db.Query(`
+		SELECT matchmaking_ticket.player_id, player.username, matchmaking_ticket.bet, matchmaking_ticket.choice,
+		       matchmaking_ticket.rule_set, matchmaking_ticket.rating, matchmaking_ticket.joined_at,
+		       matchmaking_ticket.hold_transaction_id
+		FROM matchmaking_ticket
+		JOIN player ON player.id = matchmaking_ticket.player_id
+	`)
+	if err != nil {
+		logrus.Errorf("Error fetching matchmaking tickets: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []model.MatchmakingTicket
+	for rows.Next() {
+		var ticket model.MatchmakingTicket
+		if err = rows.Scan(&ticket.PlayerID, &ticket.Username, &ticket.Bet.Amount, &ticket.Choice, &ticket.RuleSet,
+			&ticket.Rating, &ticket.JoinedAt, &ticket.HoldTransactionID); err != nil {
+			logrus.Errorf("Error scanning matchmaking ticket: %v", err)
+			return nil, err
+		}
+		// The queue only takes play coins
+		ticket.Bet.Currency = model.CurrencyCoin
+		tickets = append(tickets, ticket)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over matchmaking tickets: %v", err)
+		return nil, err
+	}
+
+	return tickets, nil
+}
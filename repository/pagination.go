@@ -0,0 +1,12 @@
+package repository
+
+// pageSize applies the default to a missing requested size and caps it at the maximum
+func pageSize(requested int, defaultSize int, maximumSize int) int {
+	if requested <= 0 {
+		return defaultSize
+	}
+	if requested > maximumSize {
+		return maximumSize
+	}
+	return requested
+}
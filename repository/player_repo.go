@@ -4,10 +4,25 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
-	"log"
 	"main/internal"
 	"main/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAccountInPlay is returned when closing an account whose entry fees or stakes are still in play
+	ErrAccountInPlay = errors.New("account has entry fees or stakes in tournaments or free-for-all rounds that are not over")
+	// ErrAccountQueued is returned when closing an account whose bet waits in the matchmaking queue
+	ErrAccountQueued = errors.New("leave the matchmaking queue first")
+)
+
+const (
+	defaultPlayerPageSize = 50
+	maximumPlayerPageSize = 200
 )
 
 type Player struct {
@@ -47,14 +62,29 @@ func (repository *Player) RegisterPlayer(playerRegistration *model.PlayerRegistr
 		Balance:  playerRegistration.Deposit,
 	}
 
-	_, err = repository.db.Exec(
-		"INSERT INTO player (username, password, salt, balance) VALUES ($1, $2, $3, $4)",
-		newPlayer.Username, newPlayer.Password, newPlayer.Salt, newPlayer.Balance,
-	)
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// The player starts empty and the deposit is credited with its transaction, so the ledger matches the balance
+	err = tx.QueryRow(
+		"INSERT INTO player (username, password, salt, balance) VALUES ($1, $2, $3, 0) RETURNING id",
+		newPlayer.Username, newPlayer.Password, newPlayer.Salt,
+	).Scan(&newPlayer.ID)
 	if err != nil {
 		logrus.Errorf("Failed to register player: %s", err)
 		return nil, err
 	}
+	if err = creditWallet(tx, newPlayer.ID, newPlayer.Balance, model.ReasonDeposit, 0); err != nil {
+		logrus.Errorf("Failed to credit deposit of %s: %s", newPlayer.Username, err)
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit registration: %v", err)
+	}
 
 	return newPlayer, nil
 }
@@ -62,9 +92,11 @@ func (repository *Player) RegisterPlayer(playerRegistration *model.PlayerRegistr
 func (repository *Player) FindPlayerWithDetails(username string) (*model.Player, error) {
 	var player model.Player
 	err := repository.db.QueryRow(
-		"SELECT username, password, salt, balance FROM player WHERE username = $1",
+		`SELECT id, username, password, salt, balance, COALESCE(totp_secret, ''), totp_enabled, totp_last_step
+		FROM player WHERE username = $1`,
 		username,
-	).Scan(&player.Username, &player.Password, &player.Salt, &player.Balance)
+	).Scan(&player.ID, &player.Username, &player.Password, &player.Salt, &player.Balance.Amount,
+		&player.TOTPSecret, &player.TOTPEnabled, &player.TOTPLastStep)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			logrus.Infof("Player not found: %s", username)
@@ -73,13 +105,14 @@ func (repository *Player) FindPlayerWithDetails(username string) (*model.Player,
 		logrus.Errorf("Failed to find player: %s", err)
 		return nil, err
 	}
+	player.Balance.Currency = model.CurrencyCoin
 	return &player, nil
 }
 
 func (repository *Player) Exists(username string) (bool, error) {
 	var exists bool
 	err := repository.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM player WHERE username = $1)",
+		"SELECT EXISTS(SELECT 1 FROM player WHERE username = $1 AND closed_at IS NULL)",
 		username,
 	).Scan(&exists)
 	if err != nil {
@@ -89,84 +122,378 @@ func (repository *Player) Exists(username string) (bool, error) {
 	return exists, nil
 }
 
-func (repository *Player) GetPlayerBalance(username string) (int, error) {
-	player, err := repository.FindPlayerWithDetails(username)
+// FindPlayerID resolves a username of an open account to the player id, 0 if there is no such player
+func (repository *Player) FindPlayerID(username string) (int, error) {
+	var playerID int
+	err := repository.db.QueryRow(
+		"SELECT id FROM player WHERE username = $1 AND closed_at IS NULL",
+		username,
+	).Scan(&playerID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		logrus.Errorf("Failed to find player id: %s", err)
 		return 0, err
 	}
-	return player.Balance, nil
+	return playerID, nil
+}
+
+// EnsureSystemAccount creates an account that nobody can log in to, e.g. the house, and returns its id.
+// Its empty password never matches a hash. A player registered under the name is refused, so that startup
+// does not hand the house bankroll to an account somebody can log in to.
+func (repository *Player) EnsureSystemAccount(username string) (int, error) {
+	_, err := repository.db.Exec(
+		"INSERT INTO player (username, password, salt, balance) VALUES ($1, '', '', 0) ON CONFLICT (username) DO NOTHING",
+		username,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create system account %s: %v", username, err)
+	}
+
+	var playerID int
+	var password string
+	err = repository.db.QueryRow("SELECT id, password FROM player WHERE username = $1", username).Scan(&playerID, &password)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find system account %s: %v", username, err)
+	}
+	if password != "" {
+		return 0, fmt.Errorf("%s is a registered player, not a system account, choose another name", username)
+	}
+	return playerID, nil
 }
 
-// AddPlayerBalance adds balance to the player's current balance.
-func (repository *Player) AddPlayerBalance(username string, amountToAdd int) error {
+func (repository *Player) GetPlayerBalance(playerID int) (int, error) {
+	var balance int
+	err := repository.db.QueryRow("SELECT balance FROM player WHERE id = $1", playerID).Scan(&balance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch current balance: %v", err)
+	}
+	return balance, nil
+}
 
-	// Retrieve current balance
-	var currentBalance int
-	err := repository.db.QueryRow("SELECT balance FROM player WHERE username = $1", username).Scan(&currentBalance)
+// SetTOTPSecret stores a new, not yet confirmed two factor secret
+func (repository *Player) SetTOTPSecret(playerID int, secret string) error {
+	_, err := repository.db.Exec(
+		"UPDATE player SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $2",
+		secret, playerID,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to fetch current balance: %v", err)
+		return fmt.Errorf("failed to store totp secret: %v", err)
 	}
 
-	// Calculate new balance
-	newBalance := currentBalance + amountToAdd
-	if newBalance < 0 {
-		return errors.New("insufficient balance")
+	return nil
+}
+
+// EnableTOTP turns on two factor authentication, step is the time step of the confirming code
+func (repository *Player) EnableTOTP(playerID int, step int64) error {
+	_, err := repository.db.Exec(
+		"UPDATE player SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2 AND totp_secret IS NOT NULL",
+		step, playerID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enable totp: %v", err)
 	}
 
-	// Update player balance
-	_, err = repository.db.Exec("UPDATE player SET balance = $1 WHERE username = $2", newBalance, username)
+	return nil
+}
+
+// DisableTOTP turns off two factor authentication and forgets the secret
+func (repository *Player) DisableTOTP(playerID int) error {
+	_, err := repository.db.Exec(
+		"UPDATE player SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1",
+		playerID,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to update balance: %v", err)
+		return fmt.Errorf("failed to disable totp: %v", err)
 	}
 
 	return nil
 }
 
-// SubtractPlayerBalance subtracts balance from the player's current balance.
-func (repository *Player) SubtractPlayerBalance(username string, amountToSubtract int) error {
-	// Retrieve current balance
-	var currentBalance int
-	err := repository.db.QueryRow("SELECT balance FROM player WHERE username = $1", username).Scan(&currentBalance)
+// ConsumeTOTPStep marks the time step as used, it returns false if the step or a later one was used already
+// so the same code cannot be replayed
+func (repository *Player) ConsumeTOTPStep(playerID int, step int64) (bool, error) {
+	result, err := repository.db.Exec(
+		"UPDATE player SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1",
+		step, playerID,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to fetch current balance: %v", err)
+		return false, fmt.Errorf("failed to consume totp step: %v", err)
 	}
 
-	// Calculate new balance
-	newBalance := currentBalance - amountToSubtract
-	if newBalance < 0 {
-		return errors.New("insufficient balance")
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
 	}
 
-	// Update player balance
-	_, err = repository.db.Exec("UPDATE player SET balance = $1 WHERE username = $2", newBalance, username)
+	return affected == 1, nil
+}
+
+// GetSession resolves the username of a token to the player id and the time before which the player's tokens
+// are revoked, found is false if there is no such player anymore
+func (repository *Player) GetSession(username string) (playerID int, validAfter time.Time, found bool, err error) {
+	var tokensValidAfter sql.NullTime
+	err = repository.db.QueryRow(
+		"SELECT id, tokens_valid_after FROM player WHERE username = $1 AND closed_at IS NULL",
+		username,
+	).Scan(&playerID, &tokensValidAfter)
 	if err != nil {
-		return fmt.Errorf("failed to update balance: %v", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, false, nil
+		}
+		return 0, time.Time{}, false, fmt.Errorf("failed to fetch session: %v", err)
+	}
+
+	return playerID, tokensValidAfter.Time, true, nil
+}
+
+// ChangePassword stores the new password hash and revokes every token issued before validAfter
+func (repository *Player) ChangePassword(playerID int, hashedPassword string, salt string, validAfter time.Time) error {
+	_, err := repository.db.Exec(
+		"UPDATE player SET password = $1, salt = $2, tokens_valid_after = $3 WHERE id = $4",
+		hashedPassword, salt, validAfter, playerID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to change password: %v", err)
+	}
+
+	return nil
+}
+
+// RenamePlayer changes the username and revokes every token issued before validAfter,
+// tokens carry the username so the old ones would not resolve anymore anyway
+func (repository *Player) RenamePlayer(playerID int, newUsername string, validAfter time.Time) error {
+	_, err := repository.db.Exec(
+		"UPDATE player SET username = $1, tokens_valid_after = $2 WHERE id = $3",
+		newUsername, validAfter, playerID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rename player: %v", err)
 	}
 
 	return nil
 }
 
-func (repository *Player) GetAllPlayerUsernames() ([]string, error) {
+// ClosePlayerAccount declines the player's pending challenges releasing their bets, forfeits the bonuses still being
+// wagered, pays out the remaining balance and anonymises the account. Challenges and transactions are kept and show the anonymised username afterwards.
+func (repository *Player) ClosePlayerAccount(playerID int) (*model.AccountClosure, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start account closure: %v", err)
+	}
+	defer tx.Rollback()
+
+	var lockedID int
+	err = tx.QueryRow(
+		"SELECT id FROM player WHERE id = $1 AND closed_at IS NULL FOR UPDATE",
+		playerID,
+	).Scan(&lockedID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find player: %v", err)
+	}
+
+	// A queued bet is only paid back once the ticket leaves the queue. Joining the queue debits the locked player row
+	// together with storing the ticket, so no ticket can appear until the closure is done.
+	var queued bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM matchmaking_ticket WHERE player_id = $1)", playerID).Scan(&queued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check matchmaking queue: %v", err)
+	}
+	if queued {
+		return nil, ErrAccountQueued
+	}
+
+	// Entry fees and stakes already in a prize pool cannot be paid out, so the tournaments and rounds have to be over
+	var inPlay bool
+	err = tx.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM tournament_player
+			JOIN tournament ON tournament.id = tournament_player.tournament_id
+			WHERE tournament_player.player_id = $1 AND tournament.state = ANY($2)
+		) OR EXISTS(
+			SELECT 1 FROM ffa_participant
+			JOIN ffa_round ON ffa_round.id = ffa_participant.round_id
+			WHERE ffa_participant.player_id = $1 AND ffa_round.state = $3
+		)
+	`, playerID, pq.Array([]string{model.TournamentRegistration, model.TournamentRunning}), model.FreeForAllOpen,
+	).Scan(&inPlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check tournaments and rounds: %v", err)
+	}
+	if inPlay {
+		return nil, ErrAccountInPlay
+	}
+
+	closure := &model.AccountClosure{
+		DeclinedChallenges: []string{},
+		AnonymisedUsername: fmt.Sprintf("%s%d", model.DeletedUsernamePrefix, playerID),
+	}
+
+	// Every pending challenge is declined and its held bet is released
+	declined, err := releasePendingChallenges(tx, model.ChallengeDeclined, "challenger_id = $3 OR opponent_id = $3", playerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decline pending challenges: %v", err)
+	}
+	for _, challengeID := range declined {
+		closure.DeclinedChallenges = append(closure.DeclinedChallenges, strconv.Itoa(challengeID))
+	}
+
+	// Bonuses that are wagered by now are kept, the ones still being wagered are forfeited and not paid out
+	if _, err = refreshBonuses(tx, playerID); err != nil {
+		return nil, err
+	}
+	var forfeit int
+	err = tx.QueryRow(`
+		WITH forfeited AS (
+			UPDATE bonus SET state = $1, closed_at = CURRENT_TIMESTAMP
+			WHERE player_id = $2 AND state = $3
+			RETURNING amount
+		)
+		SELECT COALESCE(SUM(amount), 0) FROM forfeited
+	`, model.BonusForfeited, playerID, model.BonusActive).Scan(&forfeit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to forfeit bonuses: %v", err)
+	}
+
+	// Pay out whatever is left, including the bets the player's own challenges held
+	err = tx.QueryRow("SELECT balance FROM player WHERE id = $1", playerID).Scan(&closure.Payout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch balance: %v", err)
+	}
+	if forfeit > closure.Payout {
+		forfeit = closure.Payout
+	}
+	if err = debitPlayer(tx, playerID, forfeit, model.ReasonBonusForfeited, 0); err != nil {
+		return nil, err
+	}
+	closure.Payout -= forfeit
+	if closure.Payout > 0 {
+		_, err = tx.Exec("INSERT INTO transaction (amount, reason, player_id) VALUES ($1, $2, $3)",
+			-closure.Payout, model.ReasonWithdrawal, playerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to log payout: %v", err)
+		}
+	}
+
+	// Wallets of other currencies are not paid out, they are emptied and logged like the payout
+	_, err = tx.Exec(`
+		WITH emptied AS (
+			UPDATE wallet SET balance = 0, held = 0, updated_at = CURRENT_TIMESTAMP
+			FROM wallet previous
+			WHERE wallet.player_id = previous.player_id AND wallet.currency = previous.currency
+			  AND wallet.player_id = $1 AND previous.balance > 0
+			RETURNING wallet.currency, previous.balance
+		)
+		INSERT INTO transaction (amount, currency, reason, player_id)
+		SELECT -balance, currency, $2, $1 FROM emptied
+	`, playerID, model.ReasonWithdrawal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to empty wallets: %v", err)
+	}
+
+	// The password can never match an empty hash, so nobody can log in anymore
+	_, err = tx.Exec(`
+		UPDATE player
+		SET username = $1, password = '', salt = '', balance = 0, held = 0,
+			totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0,
+			closed_at = CURRENT_TIMESTAMP, tokens_valid_after = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`, closure.AnonymisedUsername, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymise player: %v", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE player_id = $1", playerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to close account: %v", err)
+	}
+
+	return closure, nil
+}
+
+// SearchPlayers returns one page of open accounts whose username starts with or resembles the query.
+// Players who blocked the viewer are left out.
+func (repository *Player) SearchPlayers(viewerID int, request model.PlayerSearchRequest) (*model.PlayerPage, error) {
 	query := `
-        SELECT username
+        SELECT id, username, rating, last_seen_at
         FROM player
+        WHERE closed_at IS NULL
+          AND NOT EXISTS (SELECT 1 FROM player_block WHERE blocker_id = player.id AND blocked_id = $1)
     `
+	args := []any{viewerID}
+
+	if request.Query != "" {
+		args = append(args, escapeLike(request.Query), request.Query)
+		// Prefix matches use the username index, % is the trigram similarity operator of pg_trgm
+		query += fmt.Sprintf(` AND (username ILIKE $%d || '%%' OR username %% $%d)`, len(args)-1, len(args))
+	}
+
+	sort := request.Sort
+	if sort == "" {
+		sort = model.PlayerSortUsername
+	}
+
+	if request.Cursor != "" {
+		var cursor model.PlayerCursor
+		err := internal.DecodeCursor(request.Cursor, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != sort {
+			return nil, fmt.Errorf("invalid cursor: it belongs to sort %s", cursor.Sort)
+		}
+		switch sort {
+		case model.PlayerSortRating:
+			args = append(args, cursor.Rating, cursor.ID)
+			query += fmt.Sprintf(" AND (rating, id) < ($%d, $%d)", len(args)-1, len(args))
+		case model.PlayerSortActivity:
+			args = append(args, cursor.LastSeen, cursor.ID)
+			query += fmt.Sprintf(" AND (COALESCE(last_seen_at, 'epoch'::timestamptz), id) < ($%d, $%d)", len(args)-1, len(args))
+		default:
+			args = append(args, cursor.Username)
+			query += fmt.Sprintf(" AND username > $%d", len(args))
+		}
+	}
+
+	switch sort {
+	case model.PlayerSortRating:
+		query += " ORDER BY rating DESC, id DESC"
+	case model.PlayerSortActivity:
+		query += " ORDER BY COALESCE(last_seen_at, 'epoch'::timestamptz) DESC, id DESC"
+	default:
+		query += " ORDER BY username"
+	}
 
-	rows, err := repository.db.Query(query)
+	limit := pageSize(request.Limit, defaultPlayerPageSize, maximumPlayerPageSize)
+	// One extra row tells whether there is another page
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" LIMIT $%d", len(args))
+
+	rows, err := repository.db.Query(query, args...)
 	if err != nil {
-		log.Printf("Error fetching usernames: %v", err)
+		logrus.Errorf("Error searching players: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var usernames []string
+	page := &model.PlayerPage{Players: []model.PlayerSummary{}}
 	for rows.Next() {
-		var username string
-		if err = rows.Scan(&username); err != nil {
-			logrus.Errorf("Error scanning username: %v", err)
+		var player model.PlayerSummary
+		var lastSeen sql.NullTime
+		if err = rows.Scan(&player.ID, &player.Username, &player.Rating, &lastSeen); err != nil {
+			logrus.Errorf("Error scanning player: %v", err)
 			return nil, err
 		}
-		usernames = append(usernames, username)
+		if lastSeen.Valid {
+			player.LastSeen = &lastSeen.Time
+		}
+		page.Players = append(page.Players, player)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -174,15 +501,175 @@ func (repository *Player) GetAllPlayerUsernames() ([]string, error) {
 		return nil, err
 	}
 
-	return usernames, nil
+	if len(page.Players) > limit {
+		page.Players = page.Players[:limit]
+		last := page.Players[limit-1]
+		cursor := model.PlayerCursor{Sort: sort, Username: last.Username, Rating: last.Rating, ID: last.ID,
+			LastSeen: time.Unix(0, 0).UTC()}
+		if last.LastSeen != nil {
+			cursor.LastSeen = *last.LastSeen
+		}
+		page.NextCursor, err = internal.EncodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// GetPlayerProfile returns the public profile of an open account, nil if there is no such player
+// or they blocked the viewer. Games are the settled challenges, a settled challenge without a winner is a draw.
+func (repository *Player) GetPlayerProfile(viewerID int, username string) (*model.PlayerProfile, error) {
+	query := `
+        SELECT player.username, player.created_at, player.rating, player.last_seen_at,
+               COUNT(challenge.challenge_id),
+               COUNT(challenge.challenge_id) FILTER (WHERE challenge.winner_id = player.id),
+               COUNT(challenge.challenge_id) FILTER (WHERE challenge.winner_id IS NULL)
+        FROM player
+        LEFT JOIN challenge ON (challenge.challenger_id = player.id OR challenge.opponent_id = player.id)
+                           AND challenge.state = $2
+        WHERE player.username = $1 AND player.closed_at IS NULL
+          AND NOT EXISTS (SELECT 1 FROM player_block WHERE blocker_id = player.id AND blocked_id = $3)
+        GROUP BY player.id
+    `
+
+	var profile model.PlayerProfile
+	var joinedAt, lastSeen sql.NullTime
+	err := repository.db.QueryRow(query, username, model.ChallengeSettled, viewerID).Scan(
+		&profile.Username,
+		&joinedAt,
+		&profile.Rating,
+		&lastSeen,
+		&profile.GamesPlayed,
+		&profile.Wins,
+		&profile.Draws,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		logrus.Errorf("Error fetching player profile: %v", err)
+		return nil, err
+	}
+
+	if joinedAt.Valid {
+		profile.JoinedAt = &joinedAt.Time
+	}
+	if lastSeen.Valid {
+		profile.LastSeen = &lastSeen.Time
+	}
+	profile.Losses = profile.GamesPlayed - profile.Wins - profile.Draws
+	if profile.GamesPlayed > 0 {
+		profile.WinRate = float64(profile.Wins) / float64(profile.GamesPlayed)
+	}
+
+	return &profile, nil
+}
+
+// GetRating returns the player's current rating
+func (repository *Player) GetRating(playerID int) (int, error) {
+	var rating int
+	err := repository.db.QueryRow("SELECT rating FROM player WHERE id = $1", playerID).Scan(&rating)
+	if err != nil {
+		logrus.Errorf("Error fetching rating: %v", err)
+		return 0, err
+	}
+	return rating, nil
+}
+
+// SetFriendsOnlyChallenges sets whether only friends can challenge the player
+func (repository *Player) SetFriendsOnlyChallenges(playerID int, friendsOnly bool) error {
+	_, err := repository.db.Exec("UPDATE player SET friends_only_challenges = $1 WHERE id = $2", friendsOnly, playerID)
+	if err != nil {
+		logrus.Errorf("Error updating challenge settings: %v", err)
+		return err
+	}
+	return nil
+}
+
+// AcceptsChallengesFromFriendsOnly tells whether only friends can challenge the player
+func (repository *Player) AcceptsChallengesFromFriendsOnly(playerID int) (bool, error) {
+	var friendsOnly bool
+	err := repository.db.QueryRow("SELECT friends_only_challenges FROM player WHERE id = $1", playerID).Scan(&friendsOnly)
+	if err != nil {
+		logrus.Errorf("Error fetching challenge settings: %v", err)
+		return false, err
+	}
+	return friendsOnly, nil
+}
+
+// TouchPlayer records that the player is active, the row is written at most once a minute
+func (repository *Player) TouchPlayer(playerID int) error {
+	_, err := repository.db.Exec(`
+		UPDATE player SET last_seen_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND (last_seen_at IS NULL OR last_seen_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+	`, playerID)
+	if err != nil {
+		logrus.Errorf("Error updating last seen: %v", err)
+		return err
+	}
+	return nil
 }
 
+// UpdateRatings applies the result of a game between two players to their ratings,
+// challengerScore is 1 when the challenger won, 0.5 on a draw and 0 when the opponent won
+func (repository *Player) UpdateRatings(challengerID int, opponentID int, challengerScore float64) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start rating update: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock both rows in id order so that two games between the same players cannot deadlock
+	ratings := map[int]int{}
+	rows, err := tx.Query("SELECT id, rating FROM player WHERE id IN ($1, $2) ORDER BY id FOR UPDATE",
+		challengerID, opponentID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch ratings: %v", err)
+	}
+	for rows.Next() {
+		var id, rating int
+		if err = rows.Scan(&id, &rating); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan rating: %v", err)
+		}
+		ratings[id] = rating
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch ratings: %v", err)
+	}
+
+	challengerRating, opponentRating := internal.EloRatings(ratings[challengerID], ratings[opponentID], challengerScore)
+	for id, rating := range map[int]int{challengerID: challengerRating, opponentID: opponentRating} {
+		_, err = tx.Exec("UPDATE player SET rating = $1 WHERE id = $2", rating, id)
+		if err != nil {
+			return fmt.Errorf("failed to update rating of player %d: %v", id, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// escapeLike escapes the LIKE wildcards so that user input only matches literally
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func (repository *Player) validatePlayerRegistration(playerRegistration *model.PlayerRegistrationRequest) error {
 	err := internal.ValidatePlayerUsername(playerRegistration.Username)
 	if err != nil {
 		return err
 	}
 
+	err = internal.ValidateUnprivilegedUsername(playerRegistration.Username)
+	if err != nil {
+		return err
+	}
+
 	err = internal.ValidatePlayerPassword(playerRegistration.Password)
 	if err != nil {
 		return err
@@ -0,0 +1,654 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"main/internal"
+	"main/model"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCampaignNotFound   = errors.New("campaign not found")
+	ErrPromoCodeTaken     = errors.New("promo code is used by another campaign")
+	ErrPromoCodeInvalid   = errors.New("promo code is not valid")
+	ErrPromoCodeRedeemed  = errors.New("promo code already redeemed")
+	ErrPromoCodeExhausted = errors.New("promo code has been fully redeemed")
+)
+
+// wageringReasons are the transactions counted as stakes towards a bonus, refunded stakes count back
+var wageringReasons = []string{model.ReasonBet, model.ReasonEntryFee, model.ReasonRefund}
+
+// runningCampaign is the condition of a campaign that started and did not end yet
+const runningCampaign = "campaign.starts_at <= CURRENT_TIMESTAMP AND (campaign.ends_at IS NULL OR campaign.ends_at > CURRENT_TIMESTAMP)"
+
+type Promotion struct {
+	db *sql.DB
+}
+
+func NewPromotionRepository(db *sql.DB) *Promotion {
+	return &Promotion{
+		db: db,
+	}
+}
+
+// CreateCampaign stores a campaign, promo codes are kept in upper case and have to be unique
+func (repository *Promotion) CreateCampaign(request model.CampaignRequest) (*model.Campaign, error) {
+	code := strings.ToUpper(request.Code)
+	if code != "" {
+		var taken bool
+		err := repository.db.QueryRow("SELECT EXISTS(SELECT 1 FROM campaign WHERE code = $1)", code).Scan(&taken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check promo code: %v", err)
+		}
+		if taken {
+			return nil, ErrPromoCodeTaken
+		}
+	}
+
+	var campaignID int
+	err := repository.db.QueryRow(`
+		INSERT INTO campaign (name, kind, code, amount, referee_amount, wagering_multiplier, bonus_days,
+		                      required_games, max_redemptions, starts_at, ends_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, COALESCE($10, CURRENT_TIMESTAMP), $11)
+		RETURNING id
+	`, request.Name, request.Kind, code, request.Amount, request.RefereeAmount, request.WageringMultiplier,
+		request.BonusDays, request.RequiredGames, request.MaxRedemptions, request.StartsAt, request.EndsAt).Scan(&campaignID)
+	if err != nil {
+		logrus.Errorf("Error inserting campaign: %v", err)
+		return nil, err
+	}
+
+	campaigns, err := queryCampaigns(repository.db, "campaign.id = $1", campaignID)
+	if err != nil || len(campaigns) == 0 {
+		return nil, fmt.Errorf("failed to fetch campaign %d: %v", campaignID, err)
+	}
+	return &campaigns[0], nil
+}
+
+// GetCampaigns returns every campaign, newest first
+func (repository *Promotion) GetCampaigns() ([]model.Campaign, error) {
+	campaigns, err := queryCampaigns(repository.db, "TRUE")
+	if err != nil {
+		logrus.Errorf("Error fetching campaigns: %v", err)
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// EndCampaign stops a campaign now, bonuses it already credited and referrals it recorded are kept
+func (repository *Promotion) EndCampaign(campaignID int) error {
+	result, err := repository.db.Exec(`
+		UPDATE campaign SET ends_at = LEAST(COALESCE(ends_at, CURRENT_TIMESTAMP), CURRENT_TIMESTAMP)
+		WHERE id = $1
+	`, campaignID)
+	if err != nil {
+		logrus.Errorf("Error ending campaign: %v", err)
+		return err
+	}
+	if ended, _ := result.RowsAffected(); ended == 0 {
+		return ErrCampaignNotFound
+	}
+	return nil
+}
+
+// CreditSignupBonus credits the bonus of the running sign-up campaign, nothing when there is none or it has
+// been fully redeemed
+func (repository *Promotion) CreditSignupBonus(playerID int) (*model.Bonus, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	campaign, err := findRunningCampaign(tx, "campaign.kind = $1", model.CampaignSignup)
+	if err != nil || campaign == nil {
+		return nil, err
+	}
+	if campaign.MaxRedemptions > 0 && campaign.Redemptions >= campaign.MaxRedemptions {
+		return nil, nil
+	}
+
+	bonus, err := creditBonus(tx, playerID, campaign, campaign.Amount, model.CampaignSignup)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit sign-up bonus: %v", err)
+	}
+	return bonus, nil
+}
+
+// RedeemPromoCode credits the bonus of the running promo campaign with the code, once per player
+func (repository *Promotion) RedeemPromoCode(playerID int, code string) (*model.Bonus, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	campaign, err := findRunningCampaign(tx, "campaign.kind = $1 AND campaign.code = $2", model.CampaignPromo,
+		strings.ToUpper(code))
+	if err != nil {
+		return nil, err
+	}
+	if campaign == nil {
+		return nil, ErrPromoCodeInvalid
+	}
+	if campaign.MaxRedemptions > 0 && campaign.Redemptions >= campaign.MaxRedemptions {
+		return nil, ErrPromoCodeExhausted
+	}
+
+	var redeemed bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM bonus WHERE campaign_id = $1 AND player_id = $2)",
+		campaign.ID, playerID).Scan(&redeemed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check redemptions: %v", err)
+	}
+	if redeemed {
+		return nil, ErrPromoCodeRedeemed
+	}
+
+	bonus, err := creditBonus(tx, playerID, campaign, campaign.Amount, model.CampaignPromo)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit promo code: %v", err)
+	}
+	return bonus, nil
+}
+
+// CreateReferral records that the referee registered with the referral code under the running referral campaign,
+// it tells whether the code matched another player and there is a campaign
+func (repository *Promotion) CreateReferral(refereeID int, code string) (bool, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var referrerID int
+	err = tx.QueryRow("SELECT id FROM player WHERE referral_code = $1 AND closed_at IS NULL",
+		strings.ToUpper(code)).Scan(&referrerID)
+	if errors.Is(err, sql.ErrNoRows) || referrerID == refereeID {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to find referrer: %v", err)
+	}
+
+	campaign, err := findRunningCampaign(tx, "campaign.kind = $1", model.CampaignReferral)
+	if err != nil || campaign == nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO referral (referrer_id, referee_id, campaign_id, state) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (referee_id) DO NOTHING
+	`, referrerID, refereeID, campaign.ID, model.ReferralPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to record referral: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit referral: %v", err)
+	}
+	return true, nil
+}
+
+// GetReferrals returns the player's referral code, created the first time it is asked for, and the players
+// who registered with it
+func (repository *Promotion) GetReferrals(playerID int) (*model.ReferralSummary, error) {
+	summary := &model.ReferralSummary{Referrals: []model.Referral{}}
+
+	var code sql.NullString
+	err := repository.db.QueryRow("SELECT referral_code FROM player WHERE id = $1", playerID).Scan(&code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referral code: %v", err)
+	}
+	if !code.Valid {
+		generated, err := internal.GenerateReferralCode()
+		if err != nil {
+			return nil, err
+		}
+		// Another request may have created it first, whichever code was stored is returned
+		err = repository.db.QueryRow(`
+			UPDATE player SET referral_code = COALESCE(referral_code, $1) WHERE id = $2 RETURNING referral_code
+		`, generated, playerID).Scan(&code)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create referral code: %v", err)
+		}
+	}
+	summary.Code = code.String
+
+	err = repository.db.QueryRow("SELECT COALESCE(MAX(required_games), 0) FROM campaign WHERE kind = $1 AND "+
+		runningCampaign, model.CampaignReferral).Scan(&summary.RequiredGames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch referral campaign: %v", err)
+	}
+
+	summary.Referrals, err = queryReferrals(repository.db, "referral.referrer_id = $2", playerID)
+	if err != nil {
+		logrus.Errorf("Error fetching referrals: %v", err)
+		return nil, err
+	}
+	return summary, nil
+}
+
+// RewardReferrals credits both players of every pending referral whose referee played the games its campaign
+// requires and returns the rewarded referrals
+func (repository *Promotion) RewardReferrals() ([]model.Referral, error) {
+	due, err := queryReferrals(repository.db, `referral.state = $2 AND referrer.closed_at IS NULL
+		AND referee.closed_at IS NULL AND games.count >= campaign.required_games`, model.ReferralPending)
+	if err != nil {
+		logrus.Errorf("Error fetching due referrals: %v", err)
+		return nil, err
+	}
+
+	rewarded := []model.Referral{}
+	for _, referral := range due {
+		ok, err := repository.rewardReferral(referral)
+		if err != nil {
+			logrus.Errorf("Unable to reward referral of %s by %s: %v", referral.Referee, referral.Referrer, err)
+			continue
+		}
+		if ok {
+			rewarded = append(rewarded, referral)
+		}
+	}
+	return rewarded, nil
+}
+
+func (repository *Promotion) rewardReferral(referral model.Referral) (bool, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Only one run rewards a referral
+	result, err := tx.Exec("UPDATE referral SET state = $1, rewarded_at = CURRENT_TIMESTAMP WHERE id = $2 AND state = $3",
+		model.ReferralRewarded, referral.ID, model.ReferralPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to update referral: %v", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return false, nil
+	}
+
+	campaigns, err := queryCampaigns(tx, "campaign.id = $1", referral.CampaignID)
+	if err != nil || len(campaigns) == 0 {
+		return false, fmt.Errorf("failed to fetch campaign %d: %v", referral.CampaignID, err)
+	}
+	campaign := &campaigns[0]
+
+	if _, err = creditBonus(tx, referral.ReferrerID, campaign, campaign.Amount, model.CampaignReferral); err != nil {
+		return false, err
+	}
+	if campaign.RefereeAmount > 0 {
+		if _, err = creditBonus(tx, referral.RefereeID, campaign, campaign.RefereeAmount, model.CampaignReferral); err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit referral reward: %v", err)
+	}
+	return true, nil
+}
+
+// GetBonuses returns every bonus of the player, oldest first
+func (repository *Promotion) GetBonuses(playerID int) ([]model.Bonus, error) {
+	bonuses, err := queryBonuses(repository.db, playerID)
+	if err != nil {
+		logrus.Errorf("Error fetching bonuses: %v", err)
+		return nil, err
+	}
+	return bonuses, nil
+}
+
+// GetPlayersWithActiveBonuses returns the players who have a bonus that is still being wagered
+func (repository *Promotion) GetPlayersWithActiveBonuses() ([]int, error) {
+	rows, err := repository.db.Query("SELECT DISTINCT player_id FROM bonus WHERE state = $1", model.BonusActive)
+	if err != nil {
+		logrus.Errorf("Error fetching active bonuses: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	playerIDs := []int{}
+	for rows.Next() {
+		var playerID int
+		if err = rows.Scan(&playerID); err != nil {
+			logrus.Errorf("Error scanning active bonus: %v", err)
+			return nil, err
+		}
+		playerIDs = append(playerIDs, playerID)
+	}
+	return playerIDs, rows.Err()
+}
+
+// RefreshBonuses completes the player's bonuses that are wagered and expires the overdue ones, taking back what
+// is left of them. It returns the bonuses that expired.
+func (repository *Promotion) RefreshBonuses(playerID int) ([]model.Bonus, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	expired, err := refreshBonuses(tx, playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit bonuses: %v", err)
+	}
+	return expired, nil
+}
+
+// refreshBonuses is RefreshBonuses within tx, the player is locked so bets and bonuses do not interleave
+func refreshBonuses(tx *sql.Tx, playerID int) ([]model.Bonus, error) {
+	// Bets held by open challenges cannot be taken back
+	var balance int
+	err := tx.QueryRow("SELECT balance - held FROM player WHERE id = $1 FOR UPDATE", playerID).Scan(&balance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock player %d: %v", playerID, err)
+	}
+
+	// Closed bonuses are needed as well, the stakes they took do not count towards the active ones
+	bonuses, err := queryBonuses(tx, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch bonuses: %v", err)
+	}
+
+	expired := []model.Bonus{}
+	now := time.Now()
+	for _, bonus := range bonuses {
+		state := ""
+		switch {
+		case bonus.State != model.BonusActive:
+			continue
+		case bonus.Wagered >= bonus.WageringRequired:
+			state = model.BonusCompleted
+		case !bonus.ExpiresAt.After(now):
+			state = model.BonusExpired
+			// Whatever was lost of the bonus in play is gone already
+			forfeit := bonus.Amount
+			if forfeit > balance {
+				forfeit = balance
+			}
+			if err = debitPlayer(tx, playerID, forfeit, model.ReasonBonusForfeited, 0); err != nil {
+				return nil, err
+			}
+			balance -= forfeit
+			expired = append(expired, bonus)
+		default:
+			continue
+		}
+
+		_, err = tx.Exec("UPDATE bonus SET state = $1, closed_at = CURRENT_TIMESTAMP WHERE id = $2", state, bonus.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update bonus %d: %v", bonus.ID, err)
+		}
+	}
+
+	return expired, nil
+}
+
+// findRunningCampaign locks the latest running campaign matching condition, nil when there is none
+func findRunningCampaign(tx *sql.Tx, condition string, args ...any) (*model.Campaign, error) {
+	var campaignID int
+	err := tx.QueryRow(`
+		SELECT id FROM campaign
+		WHERE `+condition+` AND `+runningCampaign+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1
+		FOR UPDATE
+	`, args...).Scan(&campaignID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find campaign: %v", err)
+	}
+
+	campaigns, err := queryCampaigns(tx, "campaign.id = $1", campaignID)
+	if err != nil || len(campaigns) == 0 {
+		return nil, fmt.Errorf("failed to fetch campaign %d: %v", campaignID, err)
+	}
+	return &campaigns[0], nil
+}
+
+// creditBonus credits amount as a bonus of the campaign within tx, it has to be wagered the campaign's
+// multiplier times before it expires
+func creditBonus(tx *sql.Tx, playerID int, campaign *model.Campaign, amount int, source string) (*model.Bonus, error) {
+	bonus := &model.Bonus{
+		PlayerID:         playerID,
+		CampaignID:       campaign.ID,
+		Campaign:         campaign.Name,
+		Source:           source,
+		Amount:           amount,
+		WageringRequired: amount * campaign.WageringMultiplier,
+		State:            model.BonusActive,
+	}
+
+	err := tx.QueryRow(`
+		INSERT INTO bonus (player_id, campaign_id, source, amount, wagering_required, state, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + $7 * INTERVAL '1 day')
+		RETURNING id, created_at, expires_at
+	`, playerID, campaign.ID, source, amount, bonus.WageringRequired, bonus.State, campaign.BonusDays).Scan(
+		&bonus.ID, &bonus.CreatedAt, &bonus.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert bonus: %v", err)
+	}
+
+	if err = creditPlayer(tx, playerID, amount, model.ReasonBonus, 0); err != nil {
+		return nil, err
+	}
+	return bonus, nil
+}
+
+// queryCampaigns returns the campaigns matching condition with the number of bonuses they credited, newest first
+func queryCampaigns(db queryer, condition string, args ...any) ([]model.Campaign, error) {
+	rows, err := db.Query(`
+		SELECT campaign.id, campaign.name, campaign.kind, COALESCE(campaign.code, ''), campaign.amount,
+		       campaign.referee_amount, campaign.wagering_multiplier, campaign.bonus_days, campaign.required_games,
+		       campaign.max_redemptions, campaign.starts_at, campaign.ends_at, campaign.created_at,
+		       (SELECT COUNT(*) FROM bonus WHERE bonus.campaign_id = campaign.id)
+		FROM campaign
+		WHERE `+condition+`
+		ORDER BY campaign.created_at DESC, campaign.id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []model.Campaign{}
+	for rows.Next() {
+		var campaign model.Campaign
+		var endsAt sql.NullTime
+		if err = rows.Scan(&campaign.ID, &campaign.Name, &campaign.Kind, &campaign.Code, &campaign.Amount,
+			&campaign.RefereeAmount, &campaign.WageringMultiplier, &campaign.BonusDays, &campaign.RequiredGames,
+			&campaign.MaxRedemptions, &campaign.StartsAt, &endsAt, &campaign.CreatedAt, &campaign.Redemptions); err != nil {
+			return nil, err
+		}
+		if endsAt.Valid {
+			campaign.EndsAt = &endsAt.Time
+		}
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns, rows.Err()
+}
+
+// queryBonuses returns every bonus of the player, oldest first, with the stakes allocated to them
+func queryBonuses(db queryer, playerID int) ([]model.Bonus, error) {
+	rows, err := db.Query(`
+		SELECT bonus.id, bonus.player_id, bonus.campaign_id, campaign.name, bonus.source, bonus.amount,
+		       bonus.wagering_required, bonus.state, bonus.created_at, bonus.expires_at, bonus.closed_at
+		FROM bonus
+		JOIN campaign ON campaign.id = bonus.campaign_id
+		WHERE bonus.player_id = $1
+		ORDER BY bonus.created_at, bonus.id
+	`, playerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bonuses := []model.Bonus{}
+	for rows.Next() {
+		var bonus model.Bonus
+		var closedAt sql.NullTime
+		if err = rows.Scan(&bonus.ID, &bonus.PlayerID, &bonus.CampaignID, &bonus.Campaign, &bonus.Source,
+			&bonus.Amount, &bonus.WageringRequired, &bonus.State, &bonus.CreatedAt, &bonus.ExpiresAt,
+			&closedAt); err != nil {
+			return nil, err
+		}
+		if closedAt.Valid {
+			bonus.ClosedAt = &closedAt.Time
+		}
+		bonuses = append(bonuses, bonus)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(bonuses) == 0 {
+		return bonuses, nil
+	}
+
+	wagers, err := queryWagers(db, playerID, bonuses[0].CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	allocateWagers(bonuses, wagers)
+	return bonuses, nil
+}
+
+// wager is a stake of play coins counted towards bonuses, a refunded stake is negative
+type wager struct {
+	amount    int
+	timestamp time.Time
+}
+
+// queryWagers returns the player's stakes of play coins placed from since on, oldest first
+func queryWagers(db queryer, playerID int, since time.Time) ([]wager, error) {
+	rows, err := db.Query(`
+		SELECT -amount, timestamp FROM transaction
+		WHERE player_id = $1 AND reason = ANY($2) AND currency = $3 AND timestamp >= $4
+		ORDER BY timestamp, id
+	`, playerID, pq.Array(wageringReasons), model.CurrencyCoin, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var wagers []wager
+	for rows.Next() {
+		var stake wager
+		if err = rows.Scan(&stake.amount, &stake.timestamp); err != nil {
+			return nil, err
+		}
+		wagers = append(wagers, stake)
+	}
+	return wagers, rows.Err()
+}
+
+// allocateWagers fills in what is wagered of the bonuses, oldest first. A stake counts towards the oldest bonus that
+// was active when it was placed until that bonus is wagered in full and the rest of it goes to the next one, so no
+// stake counts twice. A refunded stake is taken back from the newest bonus active at the time of the refund.
+func allocateWagers(bonuses []model.Bonus, wagers []wager) {
+	for _, stake := range wagers {
+		if stake.amount >= 0 {
+			left := stake.amount
+			for i := 0; i < len(bonuses) && left > 0; i++ {
+				if !wageringOpen(&bonuses[i], stake.timestamp) {
+					continue
+				}
+				counted := bonuses[i].WageringRequired - bonuses[i].Wagered
+				if counted > left {
+					counted = left
+				}
+				if counted > 0 {
+					bonuses[i].Wagered += counted
+					left -= counted
+				}
+			}
+			continue
+		}
+
+		refunded := -stake.amount
+		for i := len(bonuses) - 1; i >= 0 && refunded > 0; i-- {
+			if !wageringOpen(&bonuses[i], stake.timestamp) {
+				continue
+			}
+			taken := bonuses[i].Wagered
+			if taken > refunded {
+				taken = refunded
+			}
+			bonuses[i].Wagered -= taken
+			refunded -= taken
+		}
+	}
+}
+
+// wageringOpen tells whether stakes placed at the given time count towards the bonus, from its credit until it
+// expired or was closed
+func wageringOpen(bonus *model.Bonus, at time.Time) bool {
+	end := bonus.ExpiresAt
+	if bonus.ClosedAt != nil && bonus.ClosedAt.Before(end) {
+		end = *bonus.ClosedAt
+	}
+	return !at.Before(bonus.CreatedAt) && at.Before(end)
+}
+
+// queryReferrals returns the referrals matching condition with the games their referee played, newest first.
+// Placeholders in condition start at $2.
+func queryReferrals(db queryer, condition string, args ...any) ([]model.Referral, error) {
+	rows, err := db.Query(`
+		SELECT referral.id, referral.referrer_id, referrer.username, referral.referee_id, referee.username,
+		       referral.campaign_id, referral.state, games.count, referral.created_at, referral.rewarded_at
+		FROM referral
+		JOIN player referrer ON referrer.id = referral.referrer_id
+		JOIN player referee ON referee.id = referral.referee_id
+		JOIN campaign ON campaign.id = referral.campaign_id
+		CROSS JOIN LATERAL (
+		    SELECT COUNT(*) AS count FROM challenge
+		    WHERE challenge.state = $1
+		      AND (challenge.challenger_id = referral.referee_id OR challenge.opponent_id = referral.referee_id)
+		) games
+		WHERE `+condition+`
+		ORDER BY referral.created_at DESC, referral.id DESC
+	`, append([]any{model.ChallengeSettled}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrals := []model.Referral{}
+	for rows.Next() {
+		var referral model.Referral
+		var rewardedAt sql.NullTime
+		if err = rows.Scan(&referral.ID, &referral.ReferrerID, &referral.Referrer, &referral.RefereeID,
+			&referral.Referee, &referral.CampaignID, &referral.State, &referral.Games, &referral.CreatedAt,
+			&rewardedAt); err != nil {
+			return nil, err
+		}
+		if rewardedAt.Valid {
+			referral.RewardedAt = &rewardedAt.Time
+		}
+		referrals = append(referrals, referral)
+	}
+
+	return referrals, rows.Err()
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+// queryer runs a query on the database or within a transaction
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// Reconciliation compares the recorded balances with what the transactions and open challenges add up to
+type Reconciliation struct {
+	db *sql.DB
+}
+
+func NewReconciliationRepository(db *sql.DB) *Reconciliation {
+	return &Reconciliation{
+		db: db,
+	}
+}
+
+// FindDiscrepancies checks every wallet, play coin balances included, and returns the ones that do not add up
+// together with the number of wallets checked
+func (repository *Reconciliation) FindDiscrepancies() ([]model.BalanceDiscrepancy, int, error) {
+	discrepancies, checked, err := reconcileWallets(repository.db, 0, "")
+	if err != nil {
+		logrus.Errorf("Error reconciling balances: %v", err)
+		return nil, 0, err
+	}
+	return discrepancies, checked, nil
+}
+
+// Adjust writes an adjustment entry making the ledger of the wallet add up to its balance, the balance itself
+// is left as it is. The wallet is checked again within the transaction and only adjusted when it is still off
+// by the same difference, so funds moving since the discrepancy was found are not adjusted for. Held balances
+// that do not match are left for an admin to look into.
+func (repository *Reconciliation) Adjust(discrepancy model.BalanceDiscrepancy) (bool, error) {
+	if discrepancy.Difference == 0 {
+		return false, nil
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Balance changes wait for the adjustment, the player row is locked for every currency
+	_, err = tx.Exec("SELECT id FROM player WHERE id = $1 FOR UPDATE", discrepancy.PlayerID)
+	if err != nil {
+		return false, fmt.Errorf("failed to lock player %d: %v", discrepancy.PlayerID, err)
+	}
+
+	current, _, err := reconcileWallets(tx, discrepancy.PlayerID, discrepancy.Currency)
+	if err != nil {
+		return false, err
+	}
+	if len(current) == 0 || current[0].Difference != discrepancy.Difference {
+		return false, nil
+	}
+
+	adjustment := model.Money{Currency: discrepancy.Currency, Amount: discrepancy.Difference}
+	if err = logTransaction(tx, discrepancy.PlayerID, adjustment, model.ReasonAdjustment, 0); err != nil {
+		return false, fmt.Errorf("failed to log adjustment of player %d: %v", discrepancy.PlayerID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit adjustment: %v", err)
+	}
+	return true, nil
+}
+
+// reconcileWallets recomputes the balance of the player's wallet in the currency, of every wallet when playerID
+// is 0 and currency empty, and returns the ones that do not add up with the number of wallets checked
+func reconcileWallets(db queryer, playerID int, currency string) ([]model.BalanceDiscrepancy, int, error) {
+	rows, err := db.Query(`
+		WITH balances AS (
+			SELECT id AS player_id, $1::varchar AS currency, balance, held FROM player
+			UNION ALL
+			SELECT player_id, currency, balance, held FROM wallet
+		),
+		ledger AS (
+			SELECT player_id, currency, SUM(amount) AS amount
+			FROM transaction
+			GROUP BY player_id, currency
+		),
+		holds AS (
+			SELECT player_id, currency, SUM(amount) AS amount
+			FROM hold
+			WHERE state = $2
+			GROUP BY player_id, currency
+		),
+		pending AS (
+			SELECT challenger_id AS player_id, currency, SUM(bet) AS amount
+			FROM challenge
+			WHERE state = ANY($3)
+			GROUP BY challenger_id, currency
+		)
+		SELECT balances.player_id, player.username, balances.currency, balances.balance, balances.held,
+		       COALESCE(ledger.amount, 0), COALESCE(holds.amount, 0), COALESCE(pending.amount, 0)
+		FROM balances
+		JOIN player ON player.id = balances.player_id
+		LEFT JOIN ledger ON ledger.player_id = balances.player_id AND ledger.currency = balances.currency
+		LEFT JOIN holds ON holds.player_id = balances.player_id AND holds.currency = balances.currency
+		LEFT JOIN pending ON pending.player_id = balances.player_id AND pending.currency = balances.currency
+		WHERE ($4 = 0 OR balances.player_id = $4) AND ($5 = '' OR balances.currency = $5)
+		ORDER BY balances.player_id, balances.currency
+	`, model.CurrencyCoin, model.HoldActive, pq.Array(model.OpenChallengeStates), playerID, currency)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to recompute balances: %v", err)
+	}
+	defer rows.Close()
+
+	discrepancies := []model.BalanceDiscrepancy{}
+	checked := 0
+	for rows.Next() {
+		var wallet model.BalanceDiscrepancy
+		if err = rows.Scan(&wallet.PlayerID, &wallet.Username, &wallet.Currency, &wallet.Balance, &wallet.Held,
+			&wallet.Ledger, &wallet.Holds, &wallet.PendingBets); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan balance: %v", err)
+		}
+		checked++
+
+		wallet.Difference = wallet.Balance - wallet.Ledger
+		if wallet.Difference != 0 || !wallet.HoldsConsistent() {
+			discrepancies = append(discrepancies, wallet)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to recompute balances: %v", err)
+	}
+
+	return discrepancies, checked, nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"database/sql"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+type RecoveryCode struct {
+	db *sql.DB
+}
+
+func NewRecoveryCodeRepository(db *sql.DB) *RecoveryCode {
+	return &RecoveryCode{
+		db: db,
+	}
+}
+
+// ReplaceRecoveryCodes removes all codes of the player and stores the new ones
+func (repository *RecoveryCode) ReplaceRecoveryCodes(playerID int, codes []model.RecoveryCode) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		logrus.Errorf("Error starting recovery code transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_code WHERE player_id = $1", playerID)
+	if err != nil {
+		logrus.Errorf("Error deleting recovery codes: %v", err)
+		return err
+	}
+
+	for _, code := range codes {
+		_, err = tx.Exec("INSERT INTO recovery_code (player_id, code_hash, salt) VALUES ($1, $2, $3)",
+			playerID, code.Hash, code.Salt)
+		if err != nil {
+			logrus.Errorf("Error inserting recovery code: %v", err)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetUnusedRecoveryCodes returns the codes of the player that were not used yet
+func (repository *RecoveryCode) GetUnusedRecoveryCodes(playerID int) ([]model.RecoveryCode, error) {
+	rows, err := repository.db.Query(
+		"SELECT id, player_id, code_hash, salt FROM recovery_code WHERE player_id = $1 AND used_at IS NULL",
+		playerID,
+	)
+	if err != nil {
+		logrus.Errorf("Error fetching recovery codes: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []model.RecoveryCode
+	for rows.Next() {
+		var code model.RecoveryCode
+		if err = rows.Scan(&code.ID, &code.PlayerID, &code.Hash, &code.Salt); err != nil {
+			logrus.Errorf("Error scanning recovery code: %v", err)
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over recovery codes: %v", err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks the code as used, it returns false if it was used concurrently
+func (repository *RecoveryCode) UseRecoveryCode(id int) (bool, error) {
+	result, err := repository.db.Exec(
+		"UPDATE recovery_code SET used_at = CURRENT_TIMESTAMP WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		logrus.Errorf("Error using recovery code: %v", err)
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// DeleteRecoveryCodes removes all codes of the player
+func (repository *RecoveryCode) DeleteRecoveryCodes(playerID int) error {
+	_, err := repository.db.Exec("DELETE FROM recovery_code WHERE player_id = $1", playerID)
+	if err != nil {
+		logrus.Errorf("Error deleting recovery codes: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,491 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"time"
+)
+
+var (
+	ErrTournamentNotFound       = errors.New("tournament not found")
+	ErrTournamentNotOpen        = errors.New("tournament is not open for registration")
+	ErrTournamentFull           = errors.New("tournament is full")
+	ErrAlreadyRegistered        = errors.New("already registered for the tournament")
+	ErrNotRegistered            = errors.New("not registered for the tournament")
+	ErrTournamentNotCancellable = errors.New("tournament is already over")
+)
+
+type Tournament struct {
+	db *sql.DB
+}
+
+func NewTournamentRepository(db *sql.DB) *Tournament {
+	return &Tournament{
+		db: db,
+	}
+}
+
+// CreateTournament stores a new tournament open for registration and fills in its id
+func (repository *Tournament) CreateTournament(tournament *model.Tournament) error {
+	err := repository.db.QueryRow(`
+		INSERT INTO tournament (name, format, rule_set, entry_fee, max_players, match_deadline_minutes, prize_split, state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at
+	`, tournament.Name, tournament.Format, tournament.RuleSet, tournament.EntryFee, tournament.MaxPlayers,
+		tournament.MatchDeadlineMinutes, pq.Array(tournament.PrizeSplit), model.TournamentRegistration,
+	).Scan(&tournament.ID, &tournament.CreatedAt)
+	if err != nil {
+		logrus.Errorf("Error inserting tournament: %v", err)
+		return err
+	}
+
+	tournament.State = model.TournamentRegistration
+	return nil
+}
+
+// GetTournament returns the tournament with its number of players, nil if there is no such tournament
+func (repository *Tournament) GetTournament(tournamentID int) (*model.Tournament, error) {
+	tournaments, err := repository.queryTournaments("WHERE tournament.id = $1", tournamentID)
+	if err != nil || len(tournaments) == 0 {
+		return nil, err
+	}
+	return &tournaments[0], nil
+}
+
+// GetTournaments returns the tournaments in the given state, or all of them for an empty state, newest first
+func (repository *Tournament) GetTournaments(state string) ([]model.Tournament, error) {
+	return repository.queryTournaments("WHERE $1 = '' OR tournament.state = $1", state)
+}
+
+func (repository *Tournament) queryTournaments(condition string, args ...any) ([]model.Tournament, error) {
+	rows, err := repository.db.Query(`
+		SELECT tournament.id, tournament.name, tournament.format, tournament.rule_set, tournament.entry_fee,
+		       tournament.max_players, tournament.match_deadline_minutes, tournament.prize_split, tournament.state,
+		       tournament.round, tournament.created_at, tournament.started_at, tournament.finished_at,
+		       (SELECT COUNT(*) FROM tournament_player WHERE tournament_player.tournament_id = tournament.id)
+		FROM tournament
+		`+condition+`
+		ORDER BY tournament.id DESC
+	`, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching tournaments: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tournaments := []model.Tournament{}
+	for rows.Next() {
+		var tournament model.Tournament
+		var prizeSplit pq.Int64Array
+		var startedAt, finishedAt sql.NullTime
+		if err = rows.Scan(&tournament.ID, &tournament.Name, &tournament.Format, &tournament.RuleSet,
+			&tournament.EntryFee, &tournament.MaxPlayers, &tournament.MatchDeadlineMinutes, &prizeSplit,
+			&tournament.State, &tournament.Round, &tournament.CreatedAt, &startedAt, &finishedAt,
+			&tournament.Players); err != nil {
+			logrus.Errorf("Error scanning tournament: %v", err)
+			return nil, err
+		}
+		for _, share := range prizeSplit {
+			tournament.PrizeSplit = append(tournament.PrizeSplit, int(share))
+		}
+		if startedAt.Valid {
+			tournament.StartedAt = &startedAt.Time
+		}
+		if finishedAt.Valid {
+			tournament.FinishedAt = &finishedAt.Time
+		}
+		tournaments = append(tournaments, tournament)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over tournaments: %v", err)
+		return nil, err
+	}
+
+	return tournaments, nil
+}
+
+// JoinTournament registers the player and holds the entry fee until the tournament finishes or is cancelled
+func (repository *Tournament) JoinTournament(tournamentID int, playerID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start registration: %v", err)
+	}
+	defer tx.Rollback()
+
+	tournament, err := lockTournament(tx, tournamentID)
+	if err != nil {
+		return err
+	}
+	if tournament.State != model.TournamentRegistration {
+		return ErrTournamentNotOpen
+	}
+
+	var registered int
+	var alreadyRegistered bool
+	err = tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(BOOL_OR(player_id = $2), FALSE) FROM tournament_player WHERE tournament_id = $1
+	`, tournamentID, playerID).Scan(&registered, &alreadyRegistered)
+	if err != nil {
+		return fmt.Errorf("failed to count players: %v", err)
+	}
+	if alreadyRegistered {
+		return ErrAlreadyRegistered
+	}
+	if registered >= tournament.MaxPlayers {
+		return ErrTournamentFull
+	}
+
+	err = debitPlayer(tx, playerID, tournament.EntryFee, model.ReasonEntryFee, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO tournament_player (tournament_id, player_id) VALUES ($1, $2)", tournamentID, playerID)
+	if err != nil {
+		return fmt.Errorf("failed to register player: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// LeaveTournament unregisters the player before the tournament starts and refunds the entry fee
+func (repository *Tournament) LeaveTournament(tournamentID int, playerID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start leaving: %v", err)
+	}
+	defer tx.Rollback()
+
+	tournament, err := lockTournament(tx, tournamentID)
+	if err != nil {
+		return err
+	}
+	if tournament.State != model.TournamentRegistration {
+		return ErrTournamentNotOpen
+	}
+
+	result, err := tx.Exec("DELETE FROM tournament_player WHERE tournament_id = $1 AND player_id = $2",
+		tournamentID, playerID)
+	if err != nil {
+		return fmt.Errorf("failed to unregister player: %v", err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return ErrNotRegistered
+	}
+
+	err = creditPlayer(tx, playerID, tournament.EntryFee, model.ReasonRefund, 0)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// StartTournament seeds the players by rating and marks the tournament as running, it returns the players by seed
+func (repository *Tournament) StartTournament(tournamentID int) ([]model.TournamentPlayer, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start tournament: %v", err)
+	}
+	defer tx.Rollback()
+
+	tournament, err := lockTournament(tx, tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	if tournament.State != model.TournamentRegistration {
+		return nil, ErrTournamentNotOpen
+	}
+
+	_, err = tx.Exec(`
+		UPDATE tournament_player SET seed = ranked.seed
+		FROM (
+			SELECT tournament_player.player_id,
+			       ROW_NUMBER() OVER (ORDER BY player.rating DESC, tournament_player.joined_at) AS seed
+			FROM tournament_player
+			JOIN player ON player.id = tournament_player.player_id
+			WHERE tournament_player.tournament_id = $1
+		) ranked
+		WHERE tournament_player.tournament_id = $1 AND tournament_player.player_id = ranked.player_id
+	`, tournamentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seed players: %v", err)
+	}
+
+	_, err = tx.Exec("UPDATE tournament SET state = $1, started_at = CURRENT_TIMESTAMP WHERE id = $2",
+		model.TournamentRunning, tournamentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start tournament: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit tournament start: %v", err)
+	}
+
+	return repository.GetTournamentPlayers(tournamentID)
+}
+
+// GetTournamentPlayers returns the registered players by seed, or by registration before the start
+func (repository *Tournament) GetTournamentPlayers(tournamentID int) ([]model.TournamentPlayer, error) {
+	rows, err := repository.db.Query(`
+		SELECT tournament_player.player_id, player.username, COALESCE(tournament_player.seed, 0),
+		       COALESCE(tournament_player.place, 0), tournament_player.prize
+		FROM tournament_player
+		JOIN player ON player.id = tournament_player.player_id
+		WHERE tournament_player.tournament_id = $1
+		ORDER BY tournament_player.seed, tournament_player.joined_at
+	`, tournamentID)
+	if err != nil {
+		logrus.Errorf("Error fetching tournament players: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	players := []model.TournamentPlayer{}
+	for rows.Next() {
+		var player model.TournamentPlayer
+		if err = rows.Scan(&player.PlayerID, &player.Username, &player.Seed, &player.Place, &player.Prize); err != nil {
+			logrus.Errorf("Error scanning tournament player: %v", err)
+			return nil, err
+		}
+		players = append(players, player)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over tournament players: %v", err)
+		return nil, err
+	}
+
+	return players, nil
+}
+
+// CreateRound stores the matches of the next round and moves the tournament to it.
+// Byes are stored as already decided.
+func (repository *Tournament) CreateRound(tournamentID int, round int, matches []model.TournamentMatch, deadline time.Time) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start round: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, match := range matches {
+		_, err = tx.Exec(`
+			INSERT INTO tournament_match (tournament_id, round, position, player_a_id, player_b_id, winner_id, state, deadline)
+			VALUES ($1, $2, $3, $4, NULLIF($5, 0), NULLIF($6, 0), $7, $8)
+		`, tournamentID, round, match.Position, match.PlayerAID, match.PlayerBID, match.WinnerID, match.State, deadline)
+		if err != nil {
+			return fmt.Errorf("failed to create match: %v", err)
+		}
+	}
+
+	_, err = tx.Exec("UPDATE tournament SET round = $1 WHERE id = $2", round, tournamentID)
+	if err != nil {
+		return fmt.Errorf("failed to advance round: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetMatches returns every match of the tournament by round and position
+func (repository *Tournament) GetMatches(tournamentID int) ([]model.TournamentMatch, error) {
+	return repository.queryMatches("WHERE tournament_match.tournament_id = $1", tournamentID)
+}
+
+// GetMatch returns a single match, nil if there is no such match
+func (repository *Tournament) GetMatch(matchID int) (*model.TournamentMatch, error) {
+	matches, err := repository.queryMatches("WHERE tournament_match.id = $1", matchID)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	return &matches[0], nil
+}
+
+// GetOverdueMatches returns the undecided matches of running tournaments whose deadline has passed
+func (repository *Tournament) GetOverdueMatches() ([]model.TournamentMatch, error) {
+	return repository.queryMatches(`
+		JOIN tournament ON tournament.id = tournament_match.tournament_id
+		WHERE tournament.state = $1 AND tournament_match.state = $2 AND tournament_match.deadline < CURRENT_TIMESTAMP
+	`, model.TournamentRunning, model.MatchPending)
+}
+
+func (repository *Tournament) queryMatches(condition string, args ...any) ([]model.TournamentMatch, error) {
+	rows, err := repository.db.Query(`
+		SELECT tournament_match.id, tournament_match.tournament_id, tournament_match.round, tournament_match.position,
+		       tournament_match.player_a_id, player_a.username,
+		       COALESCE(tournament_match.player_b_id, 0), COALESCE(player_b.username, ''),
+		       tournament_match.challenge_id, COALESCE(tournament_match.winner_id, 0), COALESCE(winner.username, ''),
+		       tournament_match.state, tournament_match.deadline
+		FROM tournament_match
+		JOIN player player_a ON player_a.id = tournament_match.player_a_id
+		LEFT JOIN player player_b ON player_b.id = tournament_match.player_b_id
+		LEFT JOIN player winner ON winner.id = tournament_match.winner_id
+		`+condition+`
+		ORDER BY tournament_match.round, tournament_match.position
+	`, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching tournament matches: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []model.TournamentMatch{}
+	for rows.Next() {
+		var match model.TournamentMatch
+		var challengeID sql.NullInt64
+		var deadline sql.NullTime
+		if err = rows.Scan(&match.ID, &match.TournamentID, &match.Round, &match.Position,
+			&match.PlayerAID, &match.PlayerA, &match.PlayerBID, &match.PlayerB,
+			&challengeID, &match.WinnerID, &match.Winner, &match.State, &deadline); err != nil {
+			logrus.Errorf("Error scanning tournament match: %v", err)
+			return nil, err
+		}
+		if challengeID.Valid {
+			id := int(challengeID.Int64)
+			match.ChallengeID = &id
+		}
+		if deadline.Valid {
+			match.Deadline = &deadline.Time
+		}
+		matches = append(matches, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over tournament matches: %v", err)
+		return nil, err
+	}
+
+	return matches, nil
+}
+
+// SetMatchChallenge points the match at the challenge of its current attempt, 0 clears it for a replay
+func (repository *Tournament) SetMatchChallenge(matchID int, challengeID int) error {
+	_, err := repository.db.Exec("UPDATE tournament_match SET challenge_id = NULLIF($1, 0) WHERE id = $2",
+		challengeID, matchID)
+	if err != nil {
+		logrus.Errorf("Error updating tournament match: %v", err)
+		return err
+	}
+	return nil
+}
+
+// DecideMatch records the result of a pending match, a winnerID of 0 means both players lost
+func (repository *Tournament) DecideMatch(matchID int, state string, winnerID int) error {
+	_, err := repository.db.Exec(`
+		UPDATE tournament_match SET state = $1, winner_id = NULLIF($2, 0)
+		WHERE id = $3 AND state = $4
+	`, state, winnerID, matchID, model.MatchPending)
+	if err != nil {
+		logrus.Errorf("Error deciding tournament match: %v", err)
+		return err
+	}
+	return nil
+}
+
+// FinishTournament stores the final places and pays out the prizes
+func (repository *Tournament) FinishTournament(tournamentID int, standings []model.TournamentPlayer) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start finishing tournament: %v", err)
+	}
+	defer tx.Rollback()
+
+	tournament, err := lockTournament(tx, tournamentID)
+	if err != nil {
+		return err
+	}
+	if tournament.State != model.TournamentRunning {
+		return fmt.Errorf("tournament %d is not running", tournamentID)
+	}
+
+	for _, player := range standings {
+		_, err = tx.Exec("UPDATE tournament_player SET place = $1, prize = $2 WHERE tournament_id = $3 AND player_id = $4",
+			player.Place, player.Prize, tournamentID, player.PlayerID)
+		if err != nil {
+			return fmt.Errorf("failed to store place of player %d: %v", player.PlayerID, err)
+		}
+		if err = creditPlayer(tx, player.PlayerID, player.Prize, model.ReasonPrize, 0); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE tournament SET state = $1, finished_at = CURRENT_TIMESTAMP WHERE id = $2",
+		model.TournamentFinished, tournamentID)
+	if err != nil {
+		return fmt.Errorf("failed to finish tournament: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// CancelTournament refunds every entry fee and declines the challenges still open
+func (repository *Tournament) CancelTournament(tournamentID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start cancelling tournament: %v", err)
+	}
+	defer tx.Rollback()
+
+	tournament, err := lockTournament(tx, tournamentID)
+	if err != nil {
+		return err
+	}
+	if tournament.State != model.TournamentRegistration && tournament.State != model.TournamentRunning {
+		return ErrTournamentNotCancellable
+	}
+
+	rows, err := tx.Query("SELECT player_id FROM tournament_player WHERE tournament_id = $1", tournamentID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch players: %v", err)
+	}
+	var playerIDs []int
+	for rows.Next() {
+		var playerID int
+		if err = rows.Scan(&playerID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan player: %v", err)
+		}
+		playerIDs = append(playerIDs, playerID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("failed to fetch players: %v", err)
+	}
+
+	for _, playerID := range playerIDs {
+		if err = creditPlayer(tx, playerID, tournament.EntryFee, model.ReasonRefund, 0); err != nil {
+			return err
+		}
+	}
+
+	_, err = releasePendingChallenges(tx, model.ChallengeDeclined, "tournament_id = $3", tournamentID)
+	if err != nil {
+		return fmt.Errorf("failed to decline tournament challenges: %v", err)
+	}
+
+	_, err = tx.Exec("UPDATE tournament SET state = $1, finished_at = CURRENT_TIMESTAMP WHERE id = $2",
+		model.TournamentCancelled, tournamentID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel tournament: %v", err)
+	}
+
+	return tx.Commit()
+}
+
+// lockTournament reads the tournament and locks it until the end of tx
+func lockTournament(tx *sql.Tx, tournamentID int) (*model.Tournament, error) {
+	var tournament model.Tournament
+	err := tx.QueryRow("SELECT id, state, entry_fee, max_players FROM tournament WHERE id = $1 FOR UPDATE",
+		tournamentID).Scan(&tournament.ID, &tournament.State, &tournament.EntryFee, &tournament.MaxPlayers)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTournamentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tournament %d: %v", tournamentID, err)
+	}
+	return &tournament, nil
+}
@@ -2,12 +2,23 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
-	"log"
+	"main/internal"
 	"main/model"
 	"time"
 )
 
+const (
+	defaultTransactionPageSize = 50
+	maximumTransactionPageSize = 500
+)
+
+// ErrInsufficientBalance is returned when a debit is larger than the balance
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
 type Transaction struct {
 	db *sql.DB
 }
@@ -18,56 +29,305 @@ func NewTransactionRepository(db *sql.DB) *Transaction {
 	}
 }
 
-func (repository *Transaction) AddTransaction(amount int, reason, user string) error {
-	query := `
-		INSERT INTO transaction (amount, reason, username)
-		VALUES ($1, $2, $3)
-		RETURNING id, timestamp
-	`
+// Credit adds money to the player's wallet and records it in a single transaction
+func (repository *Transaction) Credit(money model.Money, reason string, playerID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err = creditWallet(tx, playerID, money, reason, 0); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %v", reason, err)
+	}
+	return nil
+}
+
+// Debit takes money from the player's wallet and records it in a single transaction, ErrInsufficientBalance when
+// the available balance does not cover the amount
+func (repository *Transaction) Debit(money model.Money, reason string, playerID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err = debitWallet(tx, playerID, money, reason, 0); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit %s: %v", reason, err)
+	}
+	return nil
+}
 
+// debitUnlinked takes money from the player's wallet and records it within tx in a transaction whose challenge does
+// not exist yet, PlayChallenge links it later. It returns the id of the transaction, ErrInsufficientBalance when the
+// available balance does not cover the amount.
+func debitUnlinked(tx *sql.Tx, money model.Money, reason string, playerID int) (int, error) {
+	if err := changeWalletBalance(tx, playerID, money.Negate()); err != nil {
+		return 0, err
+	}
 	var id int
-	var timestamp time.Time
-	err := repository.db.QueryRow(query, amount, reason, user).Scan(&id, &timestamp)
+	err := tx.QueryRow("INSERT INTO transaction (amount, currency, reason, player_id) VALUES ($1, $2, $3, $4) RETURNING id",
+		-money.Amount, money.Currency, reason, playerID).Scan(&id)
 	if err != nil {
-		logrus.Errorf("Error inserting transaction: %v", err)
+		return 0, fmt.Errorf("failed to log %s of player %d: %v", reason, playerID, err)
+	}
+	return id, nil
+}
+
+// debitPlayer takes amount play coins from the player's balance and records it within tx, the balance is checked
+// by the same statement so concurrent debits cannot overdraw it. A challengeID of 0 records it without a challenge.
+func debitPlayer(tx *sql.Tx, playerID int, amount int, reason string, challengeID int) error {
+	return debitWallet(tx, playerID, model.Coins(amount), reason, challengeID)
+}
+
+// creditPlayer adds amount play coins to the player's balance and records it within tx, like debitPlayer
+func creditPlayer(tx *sql.Tx, playerID int, amount int, reason string, challengeID int) error {
+	return creditWallet(tx, playerID, model.Coins(amount), reason, challengeID)
+}
+
+// GetTransactionsPage returns one page of the player's transactions matching the filter.
+// The running balance is the sum of all of the player's transactions in the row's currency up to and including
+// the row, so it is not affected by the filter.
+func (repository *Transaction) GetTransactionsPage(playerID int, filter model.TransactionFilter) (*model.TransactionPage, error) {
+	query := `
+        WITH ledger AS (
+            SELECT transaction.id, transaction.timestamp, transaction.amount, transaction.currency, transaction.reason,
+                   transaction.player_id, player.username, transaction.challenge_id,
+                   SUM(transaction.amount) OVER (PARTITION BY transaction.currency
+                                                 ORDER BY transaction.timestamp, transaction.id) AS running_balance
+            FROM transaction
+            JOIN player ON player.id = transaction.player_id
+            WHERE transaction.player_id = $1
+        )
+        SELECT id, timestamp, amount, currency, reason, player_id, username, challenge_id, running_balance
+        FROM ledger
+        WHERE TRUE
+    `
+	args := []any{playerID}
+
+	addCondition := func(condition string, value any) {
+		args = append(args, value)
+		query += fmt.Sprintf(" AND %s $%d", condition, len(args))
+	}
+
+	if filter.Currency != "" {
+		addCondition("currency =", filter.Currency)
+	}
+	if len(filter.Reasons) > 0 {
+		addCondition("reason = ANY(", pq.Array(filter.Reasons))
+		query += ")"
+	}
+	if filter.MinAmount != nil {
+		addCondition("amount >=", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		addCondition("amount <=", *filter.MaxAmount)
+	}
+	if !filter.From.IsZero() {
+		addCondition("timestamp >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("timestamp <", filter.To)
+	}
+
+	direction, comparison := "DESC", "<"
+	if filter.Sort == model.SortAscending {
+		direction, comparison = "ASC", ">"
+	}
+
+	if filter.Cursor != "" {
+		var cursor model.TransactionCursor
+		err := internal.DecodeCursor(filter.Cursor, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, cursor.Timestamp, cursor.ID)
+		query += fmt.Sprintf(" AND (timestamp, id) %s ($%d, $%d)", comparison, len(args)-1, len(args))
+	}
+
+	limit := pageSize(filter.Limit, defaultTransactionPageSize, maximumTransactionPageSize)
+	// One extra row tells whether there is another page
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY timestamp %s, id %s LIMIT $%d", direction, direction, len(args))
+
+	rows, err := repository.db.Query(query, args...)
+	if err != nil {
+		logrus.Errorf("Error fetching transactions: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &model.TransactionPage{Transactions: []model.Transaction{}}
+	for rows.Next() {
+		var transaction model.Transaction
+		var challengeID sql.NullInt64
+		if err = rows.Scan(
+			&transaction.ID,
+			&transaction.Timestamp,
+			&transaction.Amount.Amount,
+			&transaction.Amount.Currency,
+			&transaction.Reason,
+			&transaction.PlayerID,
+			&transaction.Username,
+			&challengeID,
+			&transaction.RunningBalance.Amount,
+		); err != nil {
+			logrus.Errorf("Error scanning transaction: %v", err)
+			return nil, err
+		}
+		if challengeID.Valid {
+			id := int(challengeID.Int64)
+			transaction.ChallengeID = &id
+		}
+		transaction.RunningBalance.Currency = transaction.Amount.Currency
+		page.Transactions = append(page.Transactions, transaction)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	if len(page.Transactions) > limit {
+		page.Transactions = page.Transactions[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor, err = internal.EncodeCursor(model.TransactionCursor{Timestamp: last.Timestamp, ID: last.ID})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// GetBalanceBefore sums up the transactions in the currency before the given time, a playerID of 0 sums up all players
+func (repository *Transaction) GetBalanceBefore(playerID int, currency string, before time.Time) (int, error) {
+	query := `
+        SELECT COALESCE(SUM(amount), 0)
+        FROM transaction
+        WHERE ($1 = 0 OR player_id = $1) AND currency = $2 AND timestamp < $3
+    `
+
+	var balance int
+	err := repository.db.QueryRow(query, playerID, currency, before).Scan(&balance)
+	if err != nil {
+		logrus.Errorf("Error fetching balance: %v", err)
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// StreamStatement passes the transactions in the currency in [from, to) one by one to handle in time order, without
+// loading them all into memory. The balance of every entry continues from openingBalance. A playerID of 0 streams
+// all players.
+func (repository *Transaction) StreamStatement(playerID int, currency string, from, to time.Time, openingBalance int,
+	handle func(entry model.StatementEntry) error) error {
+	query := `
+        SELECT transaction.id, transaction.timestamp, player.username, transaction.reason, transaction.amount,
+               transaction.challenge_id, COALESCE(challenge.state, ''),
+               COALESCE(challenger.username, ''), COALESCE(opponent.username, '')
+        FROM transaction
+        JOIN player ON player.id = transaction.player_id
+        LEFT JOIN challenge ON challenge.challenge_id = transaction.challenge_id
+        LEFT JOIN player challenger ON challenger.id = challenge.challenger_id
+        LEFT JOIN player opponent ON opponent.id = challenge.opponent_id
+        WHERE ($1 = 0 OR transaction.player_id = $1) AND transaction.currency = $2
+          AND transaction.timestamp >= $3 AND transaction.timestamp < $4
+        ORDER BY transaction.timestamp, transaction.id
+    `
+
+	rows, err := repository.db.Query(query, playerID, currency, from, to)
+	if err != nil {
+		logrus.Errorf("Error fetching statement: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	balance := openingBalance
+	for rows.Next() {
+		var entry model.StatementEntry
+		var challengeID sql.NullInt64
+		if err = rows.Scan(
+			&entry.TransactionID,
+			&entry.Timestamp,
+			&entry.Username,
+			&entry.Reason,
+			&entry.Amount,
+			&challengeID,
+			&entry.ChallengeState,
+			&entry.Challenger,
+			&entry.Opponent,
+		); err != nil {
+			logrus.Errorf("Error scanning statement entry: %v", err)
+			return err
+		}
+		if challengeID.Valid {
+			id := int(challengeID.Int64)
+			entry.ChallengeID = &id
+		}
+
+		balance += entry.Amount
+		entry.Balance = balance
+
+		if err = handle(entry); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over statement: %v", err)
 		return err
 	}
 
-	logrus.Printf("Inserted transaction with ID %d and timestamp %v", id, timestamp)
 	return nil
 }
 
-func (repository *Transaction) GetTransactionsByUsername(username string) ([]model.Transaction, error) {
+// GetChallengeTransactions returns the transactions of both participants caused by the challenge, oldest first
+func (repository *Transaction) GetChallengeTransactions(challengeID int) ([]model.ChallengeTransaction, error) {
 	query := `
-        SELECT username, amount, reason, timestamp
+        SELECT transaction.id, transaction.timestamp, player.username, transaction.reason, transaction.amount,
+               transaction.currency
         FROM transaction
-        WHERE username = $1
+        JOIN player ON player.id = transaction.player_id
+        WHERE transaction.challenge_id = $1
+        ORDER BY transaction.timestamp, transaction.id
     `
 
-	rows, err := repository.db.Query(query, username)
+	rows, err := repository.db.Query(query, challengeID)
 	if err != nil {
-		log.Printf("Error fetching transactions: %v", err)
+		logrus.Errorf("Error fetching challenge transactions: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	var transactions []model.Transaction
+	transactions := []model.ChallengeTransaction{}
 	for rows.Next() {
-		var transaction model.Transaction
+		var transaction model.ChallengeTransaction
 		if err = rows.Scan(
+			&transaction.ID,
+			&transaction.Timestamp,
 			&transaction.Username,
-			&transaction.Amount,
 			&transaction.Reason,
-			&transaction.Timestamp,
+			&transaction.Amount.Amount,
+			&transaction.Amount.Currency,
 		); err != nil {
-			log.Printf("Error scanning transaction: %v", err)
+			logrus.Errorf("Error scanning challenge transaction: %v", err)
 			return nil, err
 		}
 		transactions = append(transactions, transaction)
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Printf("Error iterating over rows: %v", err)
+		logrus.Errorf("Error iterating over challenge transactions: %v", err)
 		return nil, err
 	}
 
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"time"
+)
+
+const transfersPageSize = 100
+
+var (
+	ErrTransferNotFound   = errors.New("transfer not found")
+	ErrTransferNotPending = errors.New("transfer is not waiting for confirmation")
+	ErrTransferLimit      = errors.New("daily transfer limit reached")
+)
+
+type Transfer struct {
+	db *sql.DB
+}
+
+func NewTransferRepository(db *sql.DB) *Transfer {
+	return &Transfer{
+		db: db,
+	}
+}
+
+// CreatePendingTransfer records a transfer that waits for the sender's confirmation, nothing is moved yet.
+// It fills in the id of the transfer.
+func (repository *Transfer) CreatePendingTransfer(transfer *model.Transfer) error {
+	err := repository.db.QueryRow(`
+		INSERT INTO transfer (sender_id, recipient_id, amount, memo, state, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, transfer.SenderID, transfer.RecipientID, transfer.Amount.Amount, transfer.Memo, model.TransferPending, transfer.ExpiresAt,
+	).Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		logrus.Errorf("Error creating transfer: %v", err)
+		return err
+	}
+
+	transfer.State = model.TransferPending
+	return nil
+}
+
+// SendTransfer moves the amount from the sender to the recipient right away, see completeTransfer.
+// It fills in the id of the transfer.
+func (repository *Transfer) SendTransfer(transfer *model.Transfer, dailyLimit int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transfer: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO transfer (sender_id, recipient_id, amount, memo, state, completed_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		RETURNING id, created_at, completed_at
+	`, transfer.SenderID, transfer.RecipientID, transfer.Amount.Amount, transfer.Memo, model.TransferCompleted,
+	).Scan(&transfer.ID, &transfer.CreatedAt, &transfer.CompletedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert transfer: %v", err)
+	}
+
+	if err = completeTransfer(tx, transfer, dailyLimit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transfer: %v", err)
+	}
+
+	transfer.State = model.TransferCompleted
+	return nil
+}
+
+// ConfirmTransfer completes a pending transfer of the sender before it expires
+func (repository *Transfer) ConfirmTransfer(transferID int, senderID int, dailyLimit int) (*model.Transfer, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start confirming transfer: %v", err)
+	}
+	defer tx.Rollback()
+
+	transfer, err := lockPendingTransfer(tx, transferID, senderID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(`
+		UPDATE transfer SET state = $2, completed_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING completed_at
+	`, transferID, model.TransferCompleted).Scan(&transfer.CompletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete transfer: %v", err)
+	}
+
+	if err = completeTransfer(tx, transfer, dailyLimit); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transfer: %v", err)
+	}
+
+	transfer.State = model.TransferCompleted
+	return transfer, nil
+}
+
+// CancelTransfer drops a pending transfer of the sender
+func (repository *Transfer) CancelTransfer(transferID int, senderID int) error {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start cancelling transfer: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = lockPendingTransfer(tx, transferID, senderID); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE transfer SET state = $2 WHERE id = $1", transferID, model.TransferCancelled); err != nil {
+		return fmt.Errorf("failed to cancel transfer: %v", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cancelling transfer: %v", err)
+	}
+	return nil
+}
+
+// GetTransfers returns the transfers the player sent or received, newest first
+func (repository *Transfer) GetTransfers(playerID int) ([]model.Transfer, error) {
+	rows, err := repository.db.Query(`
+		SELECT transfer.id, transfer.sender_id, sender.username, transfer.recipient_id, recipient.username,
+		       transfer.amount, transfer.memo,
+		       CASE WHEN transfer.state = $2 AND transfer.expires_at <= CURRENT_TIMESTAMP THEN $3 ELSE transfer.state END,
+		       transfer.created_at, transfer.expires_at, transfer.completed_at
+		FROM transfer
+		JOIN player sender ON sender.id = transfer.sender_id
+		JOIN player recipient ON recipient.id = transfer.recipient_id
+		WHERE (transfer.sender_id = $1 OR (transfer.recipient_id = $1 AND transfer.state = $4))
+		ORDER BY transfer.created_at DESC, transfer.id DESC
+		LIMIT $5
+	`, playerID, model.TransferPending, model.TransferExpired, model.TransferCompleted, transfersPageSize)
+	if err != nil {
+		logrus.Errorf("Error fetching transfers: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []model.Transfer{}
+	for rows.Next() {
+		var transfer model.Transfer
+		var expiresAt, completedAt sql.NullTime
+		if err = rows.Scan(&transfer.ID, &transfer.SenderID, &transfer.Sender, &transfer.RecipientID, &transfer.Recipient,
+			&transfer.Amount.Amount, &transfer.Memo, &transfer.State, &transfer.CreatedAt, &expiresAt, &completedAt); err != nil {
+			logrus.Errorf("Error scanning transfer: %v", err)
+			return nil, err
+		}
+		transfer.Amount.Currency = model.CurrencyCoin
+		if expiresAt.Valid {
+			transfer.ExpiresAt = &expiresAt.Time
+		}
+		if completedAt.Valid {
+			transfer.CompletedAt = &completedAt.Time
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over transfers: %v", err)
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+// completeTransfer moves the money of a transfer within tx. The sender's row is locked first so that their
+// transfers are checked against the daily limit one at a time, the debit itself cannot overdraw the balance
+// even when bets are taken from it concurrently.
+func completeTransfer(tx *sql.Tx, transfer *model.Transfer, dailyLimit int) error {
+	if _, err := tx.Exec("SELECT id FROM player WHERE id = $1 FOR UPDATE", transfer.SenderID); err != nil {
+		return fmt.Errorf("failed to lock sender: %v", err)
+	}
+
+	if dailyLimit > 0 {
+		var sent int
+		err := tx.QueryRow(`
+			SELECT COALESCE(-SUM(amount), 0) FROM transaction
+			WHERE player_id = $1 AND reason = $2 AND timestamp > $3
+		`, transfer.SenderID, model.ReasonTransferOut, time.Now().Add(-24*time.Hour)).Scan(&sent)
+		if err != nil {
+			return fmt.Errorf("failed to sum up transfers: %v", err)
+		}
+		if sent+transfer.Amount.Amount > dailyLimit {
+			return ErrTransferLimit
+		}
+	}
+
+	if err := debitWallet(tx, transfer.SenderID, transfer.Amount, model.ReasonTransferOut, 0); err != nil {
+		return err
+	}
+	return creditWallet(tx, transfer.RecipientID, transfer.Amount, model.ReasonTransferIn, 0)
+}
+
+// GetPendingTransfer returns a transfer of the sender that can still be confirmed or cancelled
+func (repository *Transfer) GetPendingTransfer(transferID int, senderID int) (*model.Transfer, error) {
+	return scanPendingTransfer(repository.db.QueryRow(pendingTransferQuery, transferID, senderID))
+}
+
+// lockPendingTransfer locks a transfer of the sender that can still be confirmed or cancelled
+func lockPendingTransfer(tx *sql.Tx, transferID int, senderID int) (*model.Transfer, error) {
+	return scanPendingTransfer(tx.QueryRow(pendingTransferQuery+" FOR UPDATE", transferID, senderID))
+}
+
+const pendingTransferQuery = `
+	SELECT id, sender_id, recipient_id, amount, memo, state, created_at, expires_at
+	FROM transfer
+	WHERE id = $1 AND sender_id = $2
+`
+
+func scanPendingTransfer(row *sql.Row) (*model.Transfer, error) {
+	var transfer model.Transfer
+	var expiresAt sql.NullTime
+	err := row.Scan(&transfer.ID, &transfer.SenderID, &transfer.RecipientID, &transfer.Amount.Amount,
+		&transfer.Memo, &transfer.State, &transfer.CreatedAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTransferNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transfer: %v", err)
+	}
+	// An expired transfer keeps its pending state, it is only shown as expired
+	if transfer.State != model.TransferPending || (expiresAt.Valid && !expiresAt.Time.After(time.Now())) {
+		return nil, ErrTransferNotPending
+	}
+	if expiresAt.Valid {
+		transfer.ExpiresAt = &expiresAt.Time
+	}
+	transfer.Amount.Currency = model.CurrencyCoin
+	return &transfer, nil
+}
@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+)
+
+var (
+	ErrConversionNotAllowed = errors.New("conversion between these currencies is not allowed")
+	ErrConversionTooSmall   = errors.New("amount is too small to convert")
+)
+
+// execer runs a statement on the database or within a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Wallet keeps the balances a player holds in currencies other than play coins, which stay in player.balance,
+// and the rules converting between currencies
+type Wallet struct {
+	db *sql.DB
+}
+
+func NewWalletRepository(db *sql.DB) *Wallet {
+	return &Wallet{
+		db: db,
+	}
+}
+
+// GetBalances returns the player's balance in every currency they hold, play coins included
+func (repository *Wallet) GetBalances(playerID int) ([]model.Money, error) {
+	query := `
+        SELECT $2::varchar, balance FROM player WHERE id = $1
+        UNION ALL
+        SELECT currency, balance FROM wallet WHERE player_id = $1
+    `
+
+	rows, err := repository.db.Query(query, playerID, model.CurrencyCoin)
+	if err != nil {
+		logrus.Errorf("Error fetching wallets: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []model.Money{}
+	for rows.Next() {
+		var balance model.Money
+		if err = rows.Scan(&balance.Currency, &balance.Amount); err != nil {
+			logrus.Errorf("Error scanning wallet: %v", err)
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over wallets: %v", err)
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+// GetAvailableBalance returns the player's balance in the currency less the bets held by open challenges,
+// nothing when they never held any
+func (repository *Wallet) GetAvailableBalance(playerID int, currency string) (model.Money, error) {
+	balance := model.Money{Currency: currency}
+
+	var row *sql.Row
+	if currency == model.CurrencyCoin {
+		row = repository.db.QueryRow("SELECT balance - held FROM player WHERE id = $1", playerID)
+	} else {
+		row = repository.db.QueryRow("SELECT balance - held FROM wallet WHERE player_id = $1 AND currency = $2",
+			playerID, currency)
+	}
+
+	err := row.Scan(&balance.Amount)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return balance, fmt.Errorf("failed to fetch %s balance: %v", currency, err)
+	}
+	return balance, nil
+}
+
+// Add credits money to the player's wallet of its currency
+func (repository *Wallet) Add(playerID int, money model.Money) error {
+	return changeWalletBalance(repository.db, playerID, money)
+}
+
+// Subtract debits money from the player's wallet of its currency, ErrInsufficientBalance when it does not cover it
+func (repository *Wallet) Subtract(playerID int, money model.Money) error {
+	return changeWalletBalance(repository.db, playerID, money.Negate())
+}
+
+// AddTransaction records a change of the player's wallet, a challengeID of 0 records it without a challenge
+func (repository *Wallet) AddTransaction(money model.Money, reason string, playerID int, challengeID int) error {
+	if err := logTransaction(repository.db, playerID, money, reason, challengeID); err != nil {
+		logrus.Errorf("Error inserting transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Convert exchanges amount into the to currency at the enabled conversion rule, both wallets change together
+func (repository *Wallet) Convert(playerID int, amount model.Money, to string) (*model.Conversion, error) {
+	tx, err := repository.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rule := model.ConversionRule{From: amount.Currency, To: to}
+	err = tx.QueryRow(`
+		SELECT numerator, denominator, min_amount FROM conversion_rule
+		WHERE from_currency = $1 AND to_currency = $2 AND enabled
+	`, rule.From, rule.To).Scan(&rule.Numerator, &rule.Denominator, &rule.MinAmount)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrConversionNotAllowed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch conversion rule: %v", err)
+	}
+
+	conversion := &model.Conversion{
+		Debited:  amount,
+		Credited: model.Money{Currency: to, Amount: rule.Convert(amount.Amount)},
+	}
+	if amount.Amount < rule.MinAmount || conversion.Credited.Amount <= 0 {
+		return nil, ErrConversionTooSmall
+	}
+
+	if err = debitWallet(tx, playerID, conversion.Debited, model.ReasonConversionOut, 0); err != nil {
+		return nil, err
+	}
+	if err = creditWallet(tx, playerID, conversion.Credited, model.ReasonConversionIn, 0); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit conversion: %v", err)
+	}
+	return conversion, nil
+}
+
+// GetConversionRules returns every conversion rule, disabled ones included
+func (repository *Wallet) GetConversionRules() ([]model.ConversionRule, error) {
+	rows, err := repository.db.Query(`
+		SELECT from_currency, to_currency, numerator, denominator, min_amount, enabled, updated_at
+		FROM conversion_rule
+		ORDER BY from_currency, to_currency
+	`)
+	if err != nil {
+		logrus.Errorf("Error fetching conversion rules: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []model.ConversionRule{}
+	for rows.Next() {
+		var rule model.ConversionRule
+		if err = rows.Scan(&rule.From, &rule.To, &rule.Numerator, &rule.Denominator, &rule.MinAmount,
+			&rule.Enabled, &rule.UpdatedAt); err != nil {
+			logrus.Errorf("Error scanning conversion rule: %v", err)
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		logrus.Errorf("Error iterating over conversion rules: %v", err)
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// SetConversionRule creates or replaces the rule converting rule.From into rule.To
+func (repository *Wallet) SetConversionRule(rule model.ConversionRule) error {
+	_, err := repository.db.Exec(`
+		INSERT INTO conversion_rule (from_currency, to_currency, numerator, denominator, min_amount, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (from_currency, to_currency) DO UPDATE
+		SET numerator = EXCLUDED.numerator, denominator = EXCLUDED.denominator, min_amount = EXCLUDED.min_amount,
+		    enabled = EXCLUDED.enabled, updated_at = CURRENT_TIMESTAMP
+	`, rule.From, rule.To, rule.Numerator, rule.Denominator, rule.MinAmount, rule.Enabled)
+	if err != nil {
+		logrus.Errorf("Error saving conversion rule: %v", err)
+		return err
+	}
+	return nil
+}
+
+// debitWallet takes money from the player's wallet of its currency and records it within tx, like debitPlayer
+func debitWallet(tx *sql.Tx, playerID int, money model.Money, reason string, challengeID int) error {
+	if money.Amount <= 0 {
+		return nil
+	}
+
+	if err := changeWalletBalance(tx, playerID, money.Negate()); err != nil {
+		return err
+	}
+	if err := logTransaction(tx, playerID, money.Negate(), reason, challengeID); err != nil {
+		return fmt.Errorf("failed to log %s of player %d: %v", reason, playerID, err)
+	}
+	return nil
+}
+
+// creditWallet adds money to the player's wallet of its currency and records it within tx, like creditPlayer
+func creditWallet(tx *sql.Tx, playerID int, money model.Money, reason string, challengeID int) error {
+	if money.Amount <= 0 {
+		return nil
+	}
+
+	if err := changeWalletBalance(tx, playerID, money); err != nil {
+		return err
+	}
+	if err := logTransaction(tx, playerID, money, reason, challengeID); err != nil {
+		return fmt.Errorf("failed to log %s of player %d: %v", reason, playerID, err)
+	}
+	return nil
+}
+
+// changeWalletBalance applies the change in a single statement that also checks the balance, so concurrent
+// debits cannot overdraw it nor take bets held by open challenges. Play coins change player.balance, a wallet
+// of another currency is created with its first credit.
+func changeWalletBalance(db execer, playerID int, change model.Money) error {
+	var result sql.Result
+	var err error
+	switch {
+	case change.Currency == model.CurrencyCoin:
+		result, err = db.Exec("UPDATE player SET balance = balance + $1 WHERE id = $2 AND balance - held + $1 >= 0",
+			change.Amount, playerID)
+	case change.Amount > 0:
+		result, err = db.Exec(`
+			INSERT INTO wallet (player_id, currency, balance) VALUES ($1, $2, $3)
+			ON CONFLICT (player_id, currency) DO UPDATE
+			SET balance = wallet.balance + EXCLUDED.balance, updated_at = CURRENT_TIMESTAMP
+		`, playerID, change.Currency, change.Amount)
+	default:
+		result, err = db.Exec(`
+			UPDATE wallet SET balance = balance + $1, updated_at = CURRENT_TIMESTAMP
+			WHERE player_id = $2 AND currency = $3 AND balance - held + $1 >= 0
+		`, change.Amount, playerID, change.Currency)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s balance: %v", change.Currency, err)
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return ErrInsufficientBalance
+	}
+
+	return nil
+}
+
+func logTransaction(db execer, playerID int, money model.Money, reason string, challengeID int) error {
+	_, err := db.Exec(`
+		INSERT INTO transaction (amount, currency, reason, player_id, challenge_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0))
+	`, money.Amount, money.Currency, reason, playerID, challengeID)
+	return err
+}
@@ -1,20 +1,36 @@
 package services
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
 	"main/config"
+	"main/repository"
 	"net/http"
 	"strings"
 	"time"
 )
 
-func AuthenticateUser(context *gin.Context) {
+const (
+	// SubjectKey is the gin context key under which AuthenticateUser stores the authenticated username
+	SubjectKey = "subject"
+	// PlayerIDKey is the gin context key under which AuthenticateUser stores the authenticated player's id
+	PlayerIDKey = "player_id"
+)
+
+// AuthenticateUser validates the bearer token and rejects tokens of players that revoked their sessions or no longer exist
+func AuthenticateUser(players *repository.Player) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		authenticate(context, players)
+	}
+}
+
+func authenticate(context *gin.Context, players *repository.Player) {
 
 	tokenString := GetTokenFromContext(context)
 	if tokenString == "" {
-		context.JSON(http.StatusUnauthorized, gin.H{"error": "token is empty or malformed"})
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token is empty or malformed"})
 		return
 	}
 
@@ -35,6 +51,12 @@ func AuthenticateUser(context *gin.Context) {
 		return
 	}
 
+	// Pre-auth tokens only prove the password, they cannot be used before the second factor is verified
+	if isPreAuthToken(token) {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Two factor authentication required"})
+		return
+	}
+
 	// Check if the token is still valid
 	expiration, err := token.Claims.GetExpirationTime()
 	if err != nil {
@@ -46,6 +68,40 @@ func AuthenticateUser(context *gin.Context) {
 		return
 	}
 
+	// Tokens issued before the last password change, rename or account closure are revoked
+	playerID, validAfter, found, err := players.GetSession(subject)
+	if err != nil {
+		logrus.Errorf("Unable to check token validity: %v", err)
+		context.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Unable to check token"})
+		return
+	}
+	if !found {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		return
+	}
+	issuedAt, err := token.Claims.GetIssuedAt()
+	if err != nil || issuedAt == nil || issuedAt.Before(validAfter) {
+		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token was revoked"})
+		return
+	}
+
+	context.Set(SubjectKey, subject)
+	context.Set(PlayerIDKey, playerID)
+
+	// Last seen drives online status and activity sorting, failing to record it must not fail the request
+	_ = players.TouchPlayer(playerID)
+}
+
+// Token timestamps carry microseconds like the database does, so tokens issued within the same second as a revocation
+// are still told apart from it
+func init() {
+	jwt.TimePrecision = time.Microsecond
+}
+
+// RevocationTime returns the cut-off to store when revoking a player's tokens. It lies just past every token issued
+// so far, the token handed out after the revocation is written is issued at or after it.
+func RevocationTime() time.Time {
+	return time.Now().Truncate(jwt.TimePrecision).Add(jwt.TimePrecision)
 }
 
 func GenerateJWT(username string) (string, error) {
@@ -54,6 +110,7 @@ func GenerateJWT(username string) (string, error) {
 	// Create the claims
 	claims := jwt.RegisteredClaims{
 		ExpiresAt: jwt.NewNumericDate(expirationTime),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
 		Subject:   username,
 	}
 
@@ -69,6 +126,57 @@ func GenerateJWT(username string) (string, error) {
 	return tokenString, nil
 }
 
+// PreAuthAudience marks tokens issued after a correct password for players that still have to pass two factor authentication
+const PreAuthAudience = "pre-auth"
+
+// GeneratePreAuthJWT creates a short-lived token that can only be exchanged for a full token with a second factor
+func GeneratePreAuthJWT(username string) (string, error) {
+	expirationTime := time.Now().Add(time.Duration(config.Settings.PreAuthTokenLifeMinutes) * time.Minute)
+
+	claims := jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expirationTime),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		Subject:   username,
+		Audience:  jwt.ClaimStrings{PreAuthAudience},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	return token.SignedString([]byte(config.Settings.SecretKey))
+}
+
+// ParsePreAuthToken validates a pre-auth token and returns its subject and when it was issued. The caller checks the
+// issue time against the player's session, like AuthenticateUser does for full tokens.
+func ParsePreAuthToken(tokenString string) (string, time.Time, error) {
+	claims := &jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return []byte(config.Settings.SecretKey), nil
+	}, jwt.WithAudience(PreAuthAudience), jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if claims.IssuedAt == nil {
+		return "", time.Time{}, errors.New("pre-auth token has no issue time")
+	}
+
+	return claims.Subject, claims.IssuedAt.Time, nil
+}
+
+func isPreAuthToken(token *jwt.Token) bool {
+	audience, err := token.Claims.GetAudience()
+	if err != nil {
+		return true
+	}
+
+	for _, value := range audience {
+		if value == PreAuthAudience {
+			return true
+		}
+	}
+
+	return false
+}
+
 func GetTokenFromContext(context *gin.Context) string {
 	// Check if token exists on the request
 	tokenString := context.GetHeader("Authorization")
@@ -120,6 +228,11 @@ func GetSubjectFromContext(context *gin.Context) string {
 	return subject
 }
 
+// GetPlayerIDFromContext returns the id of the player authenticated by AuthenticateUser
+func GetPlayerIDFromContext(context *gin.Context) int {
+	return context.GetInt(PlayerIDKey)
+}
+
 func ParseToken(tokenString string) (*jwt.Token, error) {
 	claims := &jwt.RegisteredClaims{}
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
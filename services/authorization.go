@@ -0,0 +1,32 @@
+package services
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"net/http"
+)
+
+// AuthorizeAdmin only lets through users listed as admins in the config, it expects AuthenticateUser to run first
+func AuthorizeAdmin(context *gin.Context) {
+	subject := context.GetString(SubjectKey)
+	if !IsAdmin(subject) {
+		logrus.Warningf("User %s attempted to access admin route %s", subject, context.FullPath())
+		context.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+		return
+	}
+}
+
+func IsAdmin(username string) bool {
+	if username == "" {
+		return false
+	}
+
+	for _, admin := range config.Settings.AdminUsernames {
+		if admin == username {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"github.com/sirupsen/logrus"
+	"main/repository"
+	"time"
+)
+
+// challengeExpiryInterval is how often pending challenges are checked for expiry
+const challengeExpiryInterval = time.Minute
+
+// ExpireChallenges periodically expires challenges that stayed pending for longer than maxAge
+// and refunds their bets. It blocks, so run it in its own goroutine.
+func ExpireChallenges(challenges *repository.Challenger, maxAge time.Duration) {
+	ticker := time.NewTicker(challengeExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := challenges.ExpirePendingChallenges(maxAge)
+		if err != nil {
+			logrus.Errorf("Unable to expire challenges: %v", err)
+			continue
+		}
+		if len(expired) > 0 {
+			logrus.Infof("Expired challenges %v", expired)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"main/internal"
+	"main/model"
+	"main/repository"
+)
+
+// Fairness draws the moves the server plays from the player's seed pair. A pair is committed to by publishing
+// the hash of its server seed before its first move, the player picks the client seed and every move uses the
+// next nonce. Rotating the pair reveals the server seed so that the player can recompute every move.
+type Fairness struct {
+	seeds *repository.Fairness
+}
+
+func NewFairness(seeds *repository.Fairness) *Fairness {
+	return &Fairness{
+		seeds: seeds,
+	}
+}
+
+// Seed returns the player's seed pair in use without its server seed, the first one is started on demand
+func (fairness *Fairness) Seed(playerID int) (*model.FairnessSeed, error) {
+	seed, err := fairness.seeds.GetActiveSeed(playerID)
+	if err == nil && seed == nil {
+		if err = fairness.startSeed(playerID); err != nil {
+			return nil, err
+		}
+		seed, err = fairness.seeds.GetActiveSeed(playerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if seed == nil {
+		return nil, errors.New("seed pair was not started")
+	}
+
+	seed.ServerSeed = ""
+	return seed, nil
+}
+
+// NextMove draws the server's next move for the player, the returned pair holds the nonce it was drawn with
+func (fairness *Fairness) NextMove(playerID int) (*model.FairnessSeed, int, error) {
+	seed, err := fairness.seeds.NextNonce(playerID)
+	if err == nil && seed == nil {
+		if err = fairness.startSeed(playerID); err != nil {
+			return nil, 0, err
+		}
+		seed, err = fairness.seeds.NextNonce(playerID)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if seed == nil {
+		return nil, 0, errors.New("seed pair was not started")
+	}
+
+	return seed, internal.FairMove(seed.ServerSeed, seed.ClientSeed, seed.Nonce), nil
+}
+
+// Rotate reveals the player's server seed and commits to a new one, the client seed is random when left empty
+func (fairness *Fairness) Rotate(playerID int, clientSeed string) (*model.FairnessRotation, error) {
+	serverSeed, err := internal.GenerateServerSeed()
+	if err != nil {
+		return nil, err
+	}
+	if clientSeed == "" {
+		if clientSeed, err = internal.GenerateRandomSalt(); err != nil {
+			return nil, err
+		}
+	}
+
+	return fairness.seeds.RotateSeed(playerID, serverSeed, internal.HashServerSeed(serverSeed), clientSeed)
+}
+
+// startSeed commits to a first server seed with a random client seed
+func (fairness *Fairness) startSeed(playerID int) error {
+	serverSeed, err := internal.GenerateServerSeed()
+	if err != nil {
+		return err
+	}
+	clientSeed, err := internal.GenerateRandomSalt()
+	if err != nil {
+		return err
+	}
+
+	return fairness.seeds.CreateSeed(playerID, serverSeed, internal.HashServerSeed(serverSeed), clientSeed)
+}
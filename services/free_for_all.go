@@ -0,0 +1,186 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	freeForAllInterval = 5 * time.Second
+	// freeForAllActor is the audit actor of rounds played without a request
+	freeForAllActor = "free_for_all"
+)
+
+// FreeForAllRounds plays free-for-all rounds once they are full or their deadline has passed.
+// Rounds that did not get enough players by the deadline are cancelled and refunded.
+type FreeForAllRounds struct {
+	mutex  sync.Mutex
+	rounds *repository.FreeForAll
+	audits *repository.Audit
+}
+
+func NewFreeForAllRounds(rounds *repository.FreeForAll, audits *repository.Audit) *FreeForAllRounds {
+	return &FreeForAllRounds{
+		rounds: rounds,
+		audits: audits,
+	}
+}
+
+// Create opens a round and joins the creator with their move
+func (service *FreeForAllRounds) Create(playerID int, request model.FreeForAllRequest) (*model.FreeForAll, error) {
+	round := &model.FreeForAll{
+		Stake:      request.Stake,
+		MinPlayers: request.MinPlayers,
+		MaxPlayers: request.MaxPlayers,
+		Rule:       request.Rule,
+		Deadline:   time.Now().Add(time.Duration(request.DeadlineMinutes) * time.Minute),
+	}
+	if err := service.rounds.CreateFreeForAll(round, playerID, request.Choice); err != nil {
+		return nil, err
+	}
+	return round, nil
+}
+
+// Join adds the player with their move and plays the round right away when it is full
+func (service *FreeForAllRounds) Join(playerID int, roundID int, choice int) error {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	players, err := service.rounds.JoinFreeForAll(roundID, playerID, choice)
+	if err != nil {
+		return err
+	}
+
+	round, err := service.rounds.GetFreeForAll(roundID)
+	if err != nil {
+		return err
+	}
+	if players >= round.MaxPlayers {
+		if err = service.settle(round); err != nil {
+			logrus.Errorf("Unable to play full round %d: %v", roundID, err)
+		}
+	}
+	return nil
+}
+
+// Run plays the rounds whose deadline has passed until the process ends, run it in its own goroutine
+func (service *FreeForAllRounds) Run() {
+	ticker := time.NewTicker(freeForAllInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		service.settleDueRounds()
+	}
+}
+
+func (service *FreeForAllRounds) settleDueRounds() {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	roundIDs, err := service.rounds.GetDueFreeForAlls()
+	if err != nil {
+		return
+	}
+
+	for _, roundID := range roundIDs {
+		round, err := service.rounds.GetFreeForAll(roundID)
+		if err != nil || round == nil {
+			continue
+		}
+		if err = service.settle(round); err != nil {
+			logrus.Errorf("Unable to play round %d: %v", roundID, err)
+		}
+	}
+}
+
+// settle plays all moves of the round against each other and pays out the pot. The winners share the pot evenly,
+// the players who joined first get what is left over from the division. A draw refunds every stake.
+func (service *FreeForAllRounds) settle(round *model.FreeForAll) error {
+	participants := round.Participants
+	state := model.FreeForAllSettled
+
+	if len(participants) < round.MinPlayers {
+		state = model.FreeForAllCancelled
+		for i := range participants {
+			participants[i].Result = model.FreeForAllResultRefunded
+			participants[i].Payout = round.Stake
+		}
+	} else {
+		choices := make([]int, 0, len(participants))
+		for _, participant := range participants {
+			choices = append(choices, participant.Choice)
+		}
+		winningMoves := model.DetermineFreeForAllWinners(choices, round.Rule)
+
+		winners := 0
+		for _, participant := range participants {
+			if winningMoves[participant.Choice] {
+				winners++
+			}
+		}
+
+		if winners == 0 {
+			state = model.FreeForAllDraw
+			for i := range participants {
+				participants[i].Result = model.FreeForAllResultDraw
+				participants[i].Payout = round.Stake
+			}
+		} else {
+			pot := round.Stake * len(participants)
+			share, leftover := pot/winners, pot%winners
+			for i := range participants {
+				participants[i].Result = model.FreeForAllResultLoss
+				participants[i].Payout = 0
+				if !winningMoves[participants[i].Choice] {
+					continue
+				}
+				participants[i].Result = model.FreeForAllResultWin
+				participants[i].Payout = share
+				if leftover > 0 {
+					participants[i].Payout++
+					leftover--
+				}
+			}
+		}
+	}
+
+	if err := service.rounds.SettleFreeForAll(round.ID, state, participants); err != nil {
+		return err
+	}
+
+	results := make([]map[string]any, 0, len(participants))
+	for _, participant := range participants {
+		results = append(results, map[string]any{
+			"username": participant.Username,
+			"move":     participant.Move,
+			"result":   participant.Result,
+			"payout":   participant.Payout,
+		})
+	}
+	service.audit(model.AuditFreeForAllSettled, strconv.Itoa(round.ID), map[string]any{
+		"state":        state,
+		"stake":        round.Stake,
+		"rule":         round.Rule,
+		"participants": results,
+	})
+	return nil
+}
+
+// audit records rounds played without a request, so the ip is left empty
+func (service *FreeForAllRounds) audit(action string, subject string, details map[string]any) {
+	encodedDetails, _ := json.Marshal(details)
+	err := service.audits.Append(&model.AuditEvent{
+		Actor:   freeForAllActor,
+		Action:  action,
+		Subject: subject,
+		Details: string(encodedDetails),
+	})
+	if err != nil {
+		logrus.Errorf("Unable to record audit event %s: %v", action, err)
+	}
+}
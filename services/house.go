@@ -0,0 +1,73 @@
+package services
+
+import (
+	"main/config"
+	"main/model"
+	"main/repository"
+)
+
+// HouseGames plays players against the house account, the house's move is drawn from the player's seed pair
+type HouseGames struct {
+	games    *repository.House
+	fairness *Fairness
+	houseID  int
+}
+
+func NewHouseGames(games *repository.House, fairness *Fairness, houseID int) *HouseGames {
+	return &HouseGames{
+		games:    games,
+		fairness: fairness,
+		houseID:  houseID,
+	}
+}
+
+// HouseID is the player id of the house account
+func (houseGames *HouseGames) HouseID() int {
+	return houseGames.houseID
+}
+
+// Play decides the house's move, pays out and records the game
+func (houseGames *HouseGames) Play(playerID int, request model.HouseGameRequest) (*model.HouseGame, error) {
+	// A nonce drawn for a game that is refused later is skipped, the seed pair never reuses it
+	seed, houseChoice, err := houseGames.fairness.NextMove(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	game := &model.HouseGame{
+		PlayerID:       playerID,
+		Bet:            request.Bet,
+		Choice:         request.Choice,
+		HouseChoice:    houseChoice,
+		SeedID:         seed.ID,
+		ServerSeedHash: seed.ServerSeedHash,
+		ClientSeed:     seed.ClientSeed,
+		Nonce:          seed.Nonce,
+	}
+	game.Move = model.ChoiceToString(game.Choice)
+	game.HouseMove = model.ChoiceToString(game.HouseChoice)
+
+	winnings := HouseWinnings(request.Bet)
+	switch model.DetermineWinner(game.Choice, game.HouseChoice) {
+	case "challenger":
+		game.Result = model.HouseResultWin
+		game.Payout = game.Bet + winnings
+	case "opponent":
+		game.Result = model.HouseResultLoss
+	default:
+		game.Result = model.HouseResultDraw
+		game.Payout = game.Bet
+	}
+
+	err = houseGames.games.PlayHouseGame(houseGames.houseID, game, winnings,
+		config.Settings.HouseMinBankroll, config.Settings.HouseMaxWinPercent)
+	if err != nil {
+		return nil, err
+	}
+	return game, nil
+}
+
+// HouseWinnings is what a player wins on top of their bet when they beat the house, the edge is kept back
+func HouseWinnings(bet int) int {
+	return bet * (10000 - config.Settings.HouseEdgeBasisPoints) / 10000
+}
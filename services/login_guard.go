@@ -0,0 +1,132 @@
+package services
+
+import (
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"time"
+)
+
+// LoginGuard tracks failed logins per username and per client ip. Every failure makes the key back off
+// exponentially and reaching the configured threshold locks it out for a while.
+type LoginGuard struct {
+	attempts *repository.LoginAttempt
+}
+
+func NewLoginGuard(attempts *repository.LoginAttempt) *LoginGuard {
+	return &LoginGuard{attempts: attempts}
+}
+
+// RetryAfter returns how long the username or ip has to wait before it may try to log in again, zero if it may now
+func (guard *LoginGuard) RetryAfter(username, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+
+	for keyType, key := range loginGuardKeys(username, ip) {
+		attempt, err := guard.attempts.GetLoginAttempt(keyType, key)
+		if err != nil {
+			return 0, err
+		}
+		if attempt == nil {
+			continue
+		}
+
+		for _, until := range []time.Time{attempt.BlockedUntil, attempt.LockedUntil} {
+			if remaining := until.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+
+	return wait, nil
+}
+
+// RecordFailure counts a failed login for both the username and the ip and returns the keys that got locked out
+func (guard *LoginGuard) RecordFailure(username, ip string) ([]string, error) {
+	now := time.Now()
+	resetBefore := now.Add(-time.Duration(config.Settings.LoginFailureWindowMinutes) * time.Minute)
+
+	var locked []string
+	for keyType, key := range loginGuardKeys(username, ip) {
+		failures, err := guard.attempts.RecordFailure(keyType, key, now, resetBefore)
+		if err != nil {
+			return locked, err
+		}
+
+		blockedUntil := now.Add(loginBackoff(failures))
+
+		var lockedUntil *time.Time
+		if failures >= loginFailureThreshold(keyType) {
+			until := now.Add(time.Duration(config.Settings.LoginLockoutMinutes) * time.Minute)
+			lockedUntil = &until
+			locked = append(locked, keyType)
+			logrus.Warningf("Locked out %s %s after %d failed logins", keyType, key, failures)
+		}
+
+		err = guard.attempts.Block(keyType, key, blockedUntil, lockedUntil)
+		if err != nil {
+			return locked, err
+		}
+	}
+
+	return locked, nil
+}
+
+// RecordSuccess forgets previous failures of the username. The failures of the ip are kept, otherwise logging into
+// an own account every few tries would keep an ip guessing other accounts' passwords from ever being locked out,
+// they expire with the failure window or are lifted by an admin.
+func (guard *LoginGuard) RecordSuccess(username string) error {
+	return guard.Unlock(username, "")
+}
+
+// Unlock lifts the lockout of the username and/or the ip, empty values are skipped
+func (guard *LoginGuard) Unlock(username, ip string) error {
+	for keyType, key := range loginGuardKeys(username, ip) {
+		err := guard.attempts.Clear(keyType, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ActiveLockouts lists every username and ip that is currently locked out
+func (guard *LoginGuard) ActiveLockouts() ([]model.LoginAttempt, error) {
+	return guard.attempts.GetActiveLockouts(time.Now())
+}
+
+func loginGuardKeys(username, ip string) map[string]string {
+	keys := map[string]string{}
+	if username != "" {
+		keys[model.LoginAttemptByUsername] = username
+	}
+	if ip != "" {
+		keys[model.LoginAttemptByIP] = ip
+	}
+	return keys
+}
+
+func loginFailureThreshold(keyType string) int {
+	if keyType == model.LoginAttemptByIP {
+		return config.Settings.LoginMaxFailuresPerIP
+	}
+	return config.Settings.LoginMaxFailures
+}
+
+// loginBackoff doubles the wait with every consecutive failure, up to the configured maximum
+func loginBackoff(failures int) time.Duration {
+	base := time.Duration(config.Settings.LoginBackoffBaseSeconds) * time.Second
+	maximum := time.Duration(config.Settings.LoginBackoffMaxSeconds) * time.Second
+
+	backoff := base
+	for i := 1; i < failures && backoff < maximum; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maximum {
+		return maximum
+	}
+	return backoff
+}
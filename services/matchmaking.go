@@ -0,0 +1,317 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrAlreadyQueued       = errors.New("already in the matchmaking queue")
+	ErrInsufficientBalance = errors.New("not enough balance to place bet")
+)
+
+const (
+	matchmakingInterval = time.Second
+	// matchmakingActor is the audit actor of challenges the matchmaker creates
+	matchmakingActor = "matchmaking"
+)
+
+// MatchmakingStore persists the queue so that queued players and their held bets survive a restart.
+// The queue lives in memory, the store is written through and only read on start. Queue takes the bet with the ticket.
+type MatchmakingStore interface {
+	Queue(ticket *model.MatchmakingTicket) error
+	Remove(playerID int) error
+	Load() ([]model.MatchmakingTicket, error)
+}
+
+// Matchmaker pairs queued players with the same bet and rule set and a similar rating, then plays
+// their game. The rating window starts narrow and widens the longer a player waits.
+type Matchmaker struct {
+	mutex        sync.Mutex
+	tickets      map[int]*model.MatchmakingTicket
+	results      map[int]model.MatchmakingStatus
+	store        MatchmakingStore
+	players      *repository.Player
+	challenges   *repository.Challenger
+	transactions *repository.Transaction
+	friends      *repository.Friend
+	audits       *repository.Audit
+	// houseID is the account the rake is paid to
+	houseID int
+}
+
+func NewMatchmaker(store MatchmakingStore, players *repository.Player, challenges *repository.Challenger,
+	transactions *repository.Transaction, friends *repository.Friend, audits *repository.Audit, houseID int) *Matchmaker {
+	matchmaker := &Matchmaker{
+		tickets:      map[int]*model.MatchmakingTicket{},
+		results:      map[int]model.MatchmakingStatus{},
+		store:        store,
+		players:      players,
+		challenges:   challenges,
+		transactions: transactions,
+		friends:      friends,
+		audits:       audits,
+		houseID:      houseID,
+	}
+
+	tickets, err := store.Load()
+	if err != nil {
+		logrus.Errorf("Unable to load the matchmaking queue: %v", err)
+	}
+	for i := range tickets {
+		matchmaker.tickets[tickets[i].PlayerID] = &tickets[i]
+	}
+
+	return matchmaker
+}
+
+// Join holds the bet and puts the player in the queue
+func (matchmaker *Matchmaker) Join(playerID int, username string, request model.MatchmakingJoinRequest) (model.MatchmakingStatus, error) {
+	matchmaker.mutex.Lock()
+	defer matchmaker.mutex.Unlock()
+
+	if _, queued := matchmaker.tickets[playerID]; queued {
+		return model.MatchmakingStatus{}, ErrAlreadyQueued
+	}
+
+	rating, err := matchmaker.players.GetRating(playerID)
+	if err != nil {
+		return model.MatchmakingStatus{}, err
+	}
+
+	ticket := &model.MatchmakingTicket{
+		PlayerID: playerID,
+		Username: username,
+		Bet:      request.Bet,
+		Choice:   request.Choice,
+		RuleSet:  request.RuleSet,
+		Rating:   rating,
+		JoinedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	// The debit checks the available balance, so bets held by the player's open challenges cannot be staked
+	err = matchmaker.store.Queue(ticket)
+	if errors.Is(err, repository.ErrInsufficientBalance) {
+		return model.MatchmakingStatus{}, ErrInsufficientBalance
+	}
+	if err != nil {
+		return model.MatchmakingStatus{}, err
+	}
+
+	matchmaker.tickets[playerID] = ticket
+	delete(matchmaker.results, playerID)
+
+	return matchmaker.queuedStatus(ticket, time.Now()), nil
+}
+
+// Leave takes the player out of the queue and refunds the bet, false if they were not queued
+func (matchmaker *Matchmaker) Leave(playerID int) (bool, error) {
+	matchmaker.mutex.Lock()
+	defer matchmaker.mutex.Unlock()
+
+	ticket, queued := matchmaker.tickets[playerID]
+	if !queued {
+		return false, nil
+	}
+
+	err := matchmaker.refund(ticket)
+	if err != nil {
+		return false, err
+	}
+	matchmaker.removeTicket(playerID)
+
+	return true, nil
+}
+
+// Status returns the queue position of the player, or the outcome of their last ticket
+func (matchmaker *Matchmaker) Status(playerID int) model.MatchmakingStatus {
+	matchmaker.mutex.Lock()
+	defer matchmaker.mutex.Unlock()
+
+	if ticket, queued := matchmaker.tickets[playerID]; queued {
+		return matchmaker.queuedStatus(ticket, time.Now())
+	}
+	if result, ok := matchmaker.results[playerID]; ok {
+		return result
+	}
+	return model.MatchmakingStatus{State: model.MatchmakingIdle}
+}
+
+// Run times out and pairs queued players until the process ends, run it in its own goroutine
+func (matchmaker *Matchmaker) Run() {
+	ticker := time.NewTicker(matchmakingInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		matchmaker.tick(now)
+	}
+}
+
+func (matchmaker *Matchmaker) tick(now time.Time) {
+	matchmaker.mutex.Lock()
+	defer matchmaker.mutex.Unlock()
+
+	timeout := time.Duration(config.Settings.MatchmakingTimeoutSeconds) * time.Second
+	var waiting []*model.MatchmakingTicket
+	for playerID, ticket := range matchmaker.tickets {
+		if timeout > 0 && now.Sub(ticket.JoinedAt) > timeout {
+			if err := matchmaker.refund(ticket); err != nil {
+				logrus.Errorf("Unable to refund timed out matchmaking bet of %s: %v", ticket.Username, err)
+				continue
+			}
+			matchmaker.removeTicket(playerID)
+			matchmaker.results[playerID] = model.MatchmakingStatus{State: model.MatchmakingTimedOut}
+			continue
+		}
+		waiting = append(waiting, ticket)
+	}
+
+	// Whoever waited longest gets paired first
+	sort.Slice(waiting, func(i, j int) bool {
+		if waiting[i].JoinedAt.Equal(waiting[j].JoinedAt) {
+			return waiting[i].PlayerID < waiting[j].PlayerID
+		}
+		return waiting[i].JoinedAt.Before(waiting[j].JoinedAt)
+	})
+
+	paired := map[int]bool{}
+	for i, first := range waiting {
+		if paired[first.PlayerID] {
+			continue
+		}
+		for _, second := range waiting[i+1:] {
+			if paired[second.PlayerID] || !matchmaker.compatible(first, second, now) {
+				continue
+			}
+			paired[first.PlayerID] = true
+			paired[second.PlayerID] = true
+			matchmaker.play(first, second)
+			break
+		}
+	}
+}
+
+// compatible pairs tickets with the same bet and rule set whose ratings are within both players' windows
+func (matchmaker *Matchmaker) compatible(first *model.MatchmakingTicket, second *model.MatchmakingTicket, now time.Time) bool {
+	if first.Bet != second.Bet || first.RuleSet != second.RuleSet {
+		return false
+	}
+
+	difference := first.Rating - second.Rating
+	if difference < 0 {
+		difference = -difference
+	}
+	if difference > ratingWindow(first, now) || difference > ratingWindow(second, now) {
+		return false
+	}
+
+	blocked, err := matchmaker.friends.IsBlocked(first.PlayerID, second.PlayerID)
+	return err == nil && !blocked
+}
+
+// play records the challenge between the two tickets settled with their committed moves.
+// Both bets are already taken, so the winner gets both less the rake and a draw gives each their bet back.
+// The queue only takes play coins.
+func (matchmaker *Matchmaker) play(challenger *model.MatchmakingTicket, opponent *model.MatchmakingTicket) {
+	settlement := model.ChallengeSettlement{
+		ChallengerID:   challenger.PlayerID,
+		OpponentID:     opponent.PlayerID,
+		Choice:         challenger.Choice,
+		OpponentChoice: opponent.Choice,
+		RuleSet:        challenger.RuleSet,
+		Bet:            challenger.Bet,
+	}
+	winner := model.DetermineWinner(challenger.Choice, opponent.Choice)
+	winnerName, challengerScore := "", 0.5
+	switch winner {
+	case "challenger":
+		settlement.WinnerID, winnerName, challengerScore = challenger.PlayerID, challenger.Username, 1
+	case "opponent":
+		settlement.WinnerID, winnerName, challengerScore = opponent.PlayerID, opponent.Username, 0
+	}
+	if settlement.WinnerID != 0 {
+		settlement.Rake = Rake(challenger.RuleSet, challenger.Bet.Amount)
+	}
+
+	// The challenge, the payout and the links to both bets are booked together, on failure both stay queued
+	challengeID, err := matchmaker.challenges.PlayChallenge(settlement,
+		[]int{challenger.HoldTransactionID, opponent.HoldTransactionID}, matchmaker.houseID)
+	if err != nil {
+		logrus.Errorf("Unable to play matchmaking challenge for %s and %s: %v", challenger.Username, opponent.Username, err)
+		return
+	}
+	matchmaker.removeTicket(challenger.PlayerID)
+	matchmaker.removeTicket(opponent.PlayerID)
+
+	err = matchmaker.players.UpdateRatings(challenger.PlayerID, opponent.PlayerID, challengerScore)
+	if err != nil {
+		logrus.Errorf("Unable to update ratings for challenge %d: %v", challengeID, err)
+	}
+
+	matchmaker.audit(model.AuditChallengeSettled, strconv.Itoa(challengeID), map[string]any{
+		"challenger": challenger.Username,
+		"opponent":   opponent.Username,
+		"bet":        challenger.Bet,
+		"winner":     winnerName,
+		"rake":       settlement.Rake,
+	})
+
+	matchmaker.results[challenger.PlayerID] = model.MatchmakingStatus{State: model.MatchmakingMatched,
+		ChallengeID: challengeID, Opponent: opponent.Username, Winner: winnerName}
+	matchmaker.results[opponent.PlayerID] = model.MatchmakingStatus{State: model.MatchmakingMatched,
+		ChallengeID: challengeID, Opponent: challenger.Username, Winner: winnerName}
+}
+
+// refund gives the taken bet back to a player leaving the queue without a game
+func (matchmaker *Matchmaker) refund(ticket *model.MatchmakingTicket) error {
+	return matchmaker.transactions.Credit(ticket.Bet, model.ReasonRefund, ticket.PlayerID)
+}
+
+func (matchmaker *Matchmaker) removeTicket(playerID int) {
+	delete(matchmaker.tickets, playerID)
+	if err := matchmaker.store.Remove(playerID); err != nil {
+		logrus.Errorf("Unable to remove persisted matchmaking ticket of player %d: %v", playerID, err)
+	}
+}
+
+func (matchmaker *Matchmaker) queuedStatus(ticket *model.MatchmakingTicket, now time.Time) model.MatchmakingStatus {
+	return model.MatchmakingStatus{
+		State:        model.MatchmakingQueued,
+		Ticket:       ticket,
+		RatingWindow: ratingWindow(ticket, now),
+	}
+}
+
+// audit records events of the matchmaker, there is no request so the ip is left empty
+func (matchmaker *Matchmaker) audit(action string, subject string, details map[string]any) {
+	encodedDetails, _ := json.Marshal(details)
+	err := matchmaker.audits.Append(&model.AuditEvent{
+		Actor:   matchmakingActor,
+		Action:  action,
+		Subject: subject,
+		Details: string(encodedDetails),
+	})
+	if err != nil {
+		logrus.Errorf("Unable to record audit event %s: %v", action, err)
+	}
+}
+
+// ratingWindow is the largest rating difference the ticket accepts, it grows every step of waiting up to the maximum
+func ratingWindow(ticket *model.MatchmakingTicket, now time.Time) int {
+	window := config.Settings.MatchmakingRatingWindow
+	if step := config.Settings.MatchmakingWindowStepSeconds; step > 0 {
+		steps := int(now.Sub(ticket.JoinedAt).Seconds()) / step
+		window += steps * config.Settings.MatchmakingWindowGrowth
+	}
+	if window > config.Settings.MatchmakingMaxRatingWindow {
+		window = config.Settings.MatchmakingMaxRatingWindow
+	}
+	return window
+}
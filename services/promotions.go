@@ -0,0 +1,155 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"strconv"
+	"time"
+)
+
+const (
+	// promotionsInterval is how often bonuses are checked for completion and expiry and referrals for rewards
+	promotionsInterval = time.Minute
+	// promotionsActor is the audit actor of bonuses credited and taken back without a request
+	promotionsActor = "promotions"
+)
+
+// Promotions credits campaign bonuses and keeps the part of the balance they lock from being withdrawn or sent
+// until it is wagered
+type Promotions struct {
+	promotions *repository.Promotion
+	holds      *repository.Hold
+	audits     *repository.Audit
+}
+
+func NewPromotions(promotions *repository.Promotion, holds *repository.Hold, audits *repository.Audit) *Promotions {
+	return &Promotions{
+		promotions: promotions,
+		holds:      holds,
+		audits:     audits,
+	}
+}
+
+// Status returns the player's bonuses, brought up to date first, and how much of the balance they lock
+func (promotions *Promotions) Status(playerID int) (*model.BonusStatus, error) {
+	if _, err := promotions.refresh(playerID); err != nil {
+		return nil, err
+	}
+
+	bonuses, err := promotions.promotions.GetBonuses(playerID)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := promotions.holds.GetBalance(playerID, model.CurrencyCoin)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &model.BonusStatus{Balance: balance.Balance.Amount, Held: balance.Held.Amount, Bonuses: bonuses}
+	for _, bonus := range bonuses {
+		if bonus.State == model.BonusActive {
+			status.Locked += bonus.Amount
+		}
+	}
+	// Bonus coins lost in play or held by open challenges lock nothing more
+	if status.Locked > balance.Available.Amount {
+		status.Locked = balance.Available.Amount
+	}
+	status.Withdrawable = balance.Available.Amount - status.Locked
+	return status, nil
+}
+
+// Withdrawable returns how many of the player's play coins are not locked by bonuses
+func (promotions *Promotions) Withdrawable(playerID int) (int, error) {
+	status, err := promotions.Status(playerID)
+	if err != nil {
+		return 0, err
+	}
+	return status.Withdrawable, nil
+}
+
+// CreditSignupBonus credits a new player with the bonus of the running sign-up campaign, if there is one
+func (promotions *Promotions) CreditSignupBonus(playerID int) (*model.Bonus, error) {
+	return promotions.promotions.CreditSignupBonus(playerID)
+}
+
+// Refer records that a new player registered with a referral code, a code matching no one is ignored
+func (promotions *Promotions) Refer(refereeID int, code string) (bool, error) {
+	return promotions.promotions.CreateReferral(refereeID, code)
+}
+
+// Run completes and expires bonuses and rewards referrals every interval.
+// It blocks, so run it in its own goroutine.
+func (promotions *Promotions) Run() {
+	ticker := time.NewTicker(promotionsInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		promotions.refreshAll()
+		promotions.rewardReferrals()
+	}
+}
+
+func (promotions *Promotions) refreshAll() {
+	playerIDs, err := promotions.promotions.GetPlayersWithActiveBonuses()
+	if err != nil {
+		logrus.Errorf("Unable to fetch active bonuses: %v", err)
+		return
+	}
+
+	for _, playerID := range playerIDs {
+		if _, err = promotions.refresh(playerID); err != nil {
+			logrus.Errorf("Unable to refresh bonuses of player %d: %v", playerID, err)
+		}
+	}
+}
+
+// refresh completes and expires the player's bonuses and audits the expired ones
+func (promotions *Promotions) refresh(playerID int) ([]model.Bonus, error) {
+	expired, err := promotions.promotions.RefreshBonuses(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bonus := range expired {
+		promotions.audit(model.AuditBonusExpired, strconv.Itoa(playerID), map[string]any{
+			"bonus":    bonus.ID,
+			"campaign": bonus.Campaign,
+			"amount":   bonus.Amount,
+			"wagered":  bonus.Wagered,
+		})
+	}
+	return expired, nil
+}
+
+func (promotions *Promotions) rewardReferrals() {
+	rewarded, err := promotions.promotions.RewardReferrals()
+	if err != nil {
+		logrus.Errorf("Unable to reward referrals: %v", err)
+		return
+	}
+
+	for _, referral := range rewarded {
+		promotions.audit(model.AuditReferralRewarded, referral.Referrer, map[string]any{
+			"referee":  referral.Referee,
+			"campaign": referral.CampaignID,
+			"games":    referral.Games,
+		})
+	}
+}
+
+// audit records an event of the promotions, there is no request so the ip is left empty
+func (promotions *Promotions) audit(action string, subject string, details map[string]any) {
+	encodedDetails, _ := json.Marshal(details)
+	err := promotions.audits.Append(&model.AuditEvent{
+		Actor:   promotionsActor,
+		Action:  action,
+		Subject: subject,
+		Details: string(encodedDetails),
+	})
+	if err != nil {
+		logrus.Errorf("Unable to record audit event %s: %v", action, err)
+	}
+}
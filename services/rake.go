@@ -0,0 +1,35 @@
+package services
+
+import "main/config"
+
+// Rake is the commission on the pot of a challenge won with the rule set and bet. The highest tier the pot reaches
+// applies, rake_by_rule_set replaces the tiers of rake for its rule sets. The winner always gets their own bet back.
+func Rake(ruleSet string, bet int) int {
+	tiers, found := config.Settings.RakeByRuleSet[ruleSet]
+	if !found {
+		tiers = config.Settings.Rake
+	}
+
+	pot := bet * 2
+	var tier *config.RakeTier
+	for i := range tiers {
+		if pot >= tiers[i].MinPot && (tier == nil || tiers[i].MinPot > tier.MinPot) {
+			tier = &tiers[i]
+		}
+	}
+	if tier == nil {
+		return 0
+	}
+
+	rake := pot * tier.BasisPoints / 10000
+	if rake < tier.Min {
+		rake = tier.Min
+	}
+	if tier.Max > 0 && rake > tier.Max {
+		rake = tier.Max
+	}
+	if rake > bet {
+		rake = bet
+	}
+	return rake
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitResult describes the bucket of a key after a request has been counted
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	// ResetAfter is the time until the bucket is full again
+	ResetAfter time.Duration
+}
+
+// RateLimitStore keeps token buckets per key. The in-memory store is enough for a single instance,
+// several instances behind a load balancer need a shared implementation.
+type RateLimitStore interface {
+	Take(key string, limit config.RateLimit, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimit limits requests per authenticated username, or per client ip for anonymous requests,
+// with the token bucket configured for the group. Groups without configuration are not limited.
+func RateLimit(store RateLimitStore, group string) gin.HandlerFunc {
+	limit, ok := config.Settings.RateLimits[group]
+	if !ok || limit.RequestsPerMinute <= 0 || limit.Burst <= 0 {
+		logrus.Warningf("No rate limit configured for %s", group)
+		return func(context *gin.Context) {}
+	}
+
+	return func(context *gin.Context) {
+		key := group + ":ip:" + context.ClientIP()
+		if subject := context.GetString(SubjectKey); subject != "" {
+			key = group + ":user:" + subject
+		}
+
+		result, err := store.Take(key, limit, time.Now())
+		if err != nil {
+			// A broken store should not take the whole api down with it
+			logrus.Errorf("Unable to check rate limit for %s: %v", key, err)
+			return
+		}
+
+		context.Header("X-RateLimit-Limit", fmt.Sprint(limit.Burst))
+		context.Header("X-RateLimit-Remaining", fmt.Sprint(result.Remaining))
+		context.Header("X-RateLimit-Reset", fmt.Sprint(ceilSeconds(result.ResetAfter)))
+
+		if !result.Allowed {
+			logrus.Warningf("Rate limited %s", key)
+			context.Header("Retry-After", fmt.Sprint(ceilSeconds(result.RetryAfter)))
+			context.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+			return
+		}
+	}
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+// MemoryRateLimitStore keeps the buckets in process memory, full buckets are dropped periodically
+type MemoryRateLimitStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+const rateLimitSweepInterval = 10 * time.Minute
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (store *MemoryRateLimitStore) Take(key string, limit config.RateLimit, now time.Time) (RateLimitResult, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if now.Sub(store.lastSweep) > rateLimitSweepInterval {
+		store.sweep(now)
+	}
+
+	capacity := float64(limit.Burst)
+	perSecond := limit.RequestsPerMinute / 60
+
+	bucket, ok := store.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		store.buckets[key] = bucket
+	}
+
+	// Refill for the time that passed since the last request
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - bucket.tokens) / perSecond)
+	}
+
+	result.Remaining = int(bucket.tokens)
+	result.ResetAfter = secondsToDuration((capacity - bucket.tokens) / perSecond)
+	bucket.full = now.Add(result.ResetAfter)
+
+	return result, nil
+}
+
+// sweep drops buckets that are full again, a new bucket starts out full anyway
+func (store *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, bucket := range store.buckets {
+		if !now.Before(bucket.full) {
+			delete(store.buckets, key)
+		}
+	}
+	store.lastSweep = now
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
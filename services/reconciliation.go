@@ -0,0 +1,93 @@
+package services
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"time"
+)
+
+// reconciliationActor is who adjustments are recorded by in the audit log
+const reconciliationActor = "reconciliation"
+
+// Reconciler recomputes every balance from the transactions and checks the held balances against the holds and
+// the bets of open challenges
+type Reconciler struct {
+	reconciliation *repository.Reconciliation
+	audits         *repository.Audit
+}
+
+func NewReconciler(reconciliation *repository.Reconciliation, audits *repository.Audit) *Reconciler {
+	return &Reconciler{
+		reconciliation: reconciliation,
+		audits:         audits,
+	}
+}
+
+// Reconcile checks every wallet and reports the ones that do not add up. With fix every difference from the
+// ledger gets an adjustment entry, unless the wallet changed in the meantime, and each adjustment is audited.
+func (reconciler *Reconciler) Reconcile(fix bool) (*model.ReconciliationReport, error) {
+	report := &model.ReconciliationReport{StartedAt: time.Now().UTC(), Fix: fix}
+
+	discrepancies, checked, err := reconciler.reconciliation.FindDiscrepancies()
+	if err != nil {
+		return nil, err
+	}
+	report.Wallets = checked
+	report.Discrepancies = discrepancies
+
+	report.Consistent = true
+	for i := range report.Discrepancies {
+		discrepancy := &report.Discrepancies[i]
+		if fix {
+			discrepancy.Adjusted, err = reconciler.reconciliation.Adjust(*discrepancy)
+			if err != nil {
+				logrus.Errorf("Unable to adjust %s balance of %s: %v", discrepancy.Currency, discrepancy.Username, err)
+			}
+			if discrepancy.Adjusted {
+				reconciler.audit(discrepancy)
+			}
+		}
+		// Held balances are not adjusted, they are reported until the holds are sorted out
+		if !discrepancy.Adjusted || !discrepancy.HoldsConsistent() {
+			report.Consistent = false
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// Run reconciles every interval and logs the report when something does not add up.
+// It blocks, so run it in its own goroutine.
+func (reconciler *Reconciler) Run(interval time.Duration, fix bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := reconciler.Reconcile(fix)
+		if err != nil {
+			logrus.Errorf("Unable to reconcile balances: %v", err)
+			continue
+		}
+		if len(report.Discrepancies) > 0 {
+			encoded, _ := json.Marshal(report)
+			logrus.Warnf("Balances do not add up: %s", encoded)
+		}
+	}
+}
+
+// audit records an adjustment, there is no request so the ip is left empty
+func (reconciler *Reconciler) audit(discrepancy *model.BalanceDiscrepancy) {
+	encodedDetails, _ := json.Marshal(discrepancy)
+	err := reconciler.audits.Append(&model.AuditEvent{
+		Actor:   reconciliationActor,
+		Action:  model.AuditBalanceAdjusted,
+		Subject: discrepancy.Username,
+		Details: string(encodedDetails),
+	})
+	if err != nil {
+		logrus.Errorf("Unable to record audit event %s: %v", model.AuditBalanceAdjusted, err)
+	}
+}
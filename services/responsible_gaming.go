@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"main/config"
+	"main/model"
+	"main/repository"
+	"time"
+)
+
+// ErrSelfExcluded is returned for bets and deposits of a player who excluded themselves
+var ErrSelfExcluded = errors.New("self-excluded from betting and deposits")
+
+// limitWindows are the rolling windows the limit periods are counted over
+var limitWindows = map[string]time.Duration{
+	model.LimitDaily:   24 * time.Hour,
+	model.LimitWeekly:  7 * 24 * time.Hour,
+	model.LimitMonthly: 30 * 24 * time.Hour,
+}
+
+// LimitError is returned when a deposit or a bet would go over one of the player's limits
+type LimitError struct {
+	Kind   string
+	Period string
+	Limit  int
+	Used   int
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("%s %s limit of %d reached, %d used", err.Period, err.Kind, err.Limit, err.Used)
+}
+
+// Code tells the kind of limit apart for clients, e.g. bet_limit_exceeded
+func (err *LimitError) Code() string {
+	return err.Kind + "_limit_exceeded"
+}
+
+// ResponsibleGaming enforces the limits players set themselves and their self-exclusion
+type ResponsibleGaming struct {
+	limits *repository.Limit
+}
+
+func NewResponsibleGaming(limits *repository.Limit) *ResponsibleGaming {
+	return &ResponsibleGaming{
+		limits: limits,
+	}
+}
+
+// CheckBet tells whether the player may stake amount, a stake counts against the bet limits and, as it can be
+// lost entirely, against the loss limits
+func (responsibleGaming *ResponsibleGaming) CheckBet(playerID int, amount int) error {
+	return responsibleGaming.check(playerID, amount, model.LimitBet, model.LimitLoss)
+}
+
+// CheckDeposit tells whether the player may deposit amount
+func (responsibleGaming *ResponsibleGaming) CheckDeposit(playerID int, amount int) error {
+	return responsibleGaming.check(playerID, amount, model.LimitDeposit)
+}
+
+// Status returns the player's limits with what they used of them and their self-exclusion
+func (responsibleGaming *ResponsibleGaming) Status(playerID int) (*model.ResponsibleGaming, error) {
+	limits, err := responsibleGaming.usedLimits(playerID)
+	if err != nil {
+		return nil, err
+	}
+	excludedUntil, err := responsibleGaming.limits.GetExclusion(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.ResponsibleGaming{Limits: limits, ExcludedUntil: excludedUntil}, nil
+}
+
+// SetLimit changes a limit, loosening it waits for limit_cooling_off_hours
+func (responsibleGaming *ResponsibleGaming) SetLimit(playerID int, request model.LimitRequest) error {
+	coolingOff := time.Duration(config.Settings.LimitCoolingOffHours) * time.Hour
+	return responsibleGaming.limits.SetLimit(playerID, request, coolingOff)
+}
+
+// Exclude blocks the player's bets and deposits for the given number of days
+func (responsibleGaming *ResponsibleGaming) Exclude(playerID int, days int) (time.Time, error) {
+	return responsibleGaming.limits.Exclude(playerID, time.Now().AddDate(0, 0, days))
+}
+
+// check refuses anything from an excluded player and amounts going over a limit of the given kinds
+func (responsibleGaming *ResponsibleGaming) check(playerID int, amount int, kinds ...string) error {
+	excludedUntil, err := responsibleGaming.limits.GetExclusion(playerID)
+	if err != nil {
+		return err
+	}
+	if excludedUntil != nil {
+		return fmt.Errorf("%w until %s", ErrSelfExcluded, excludedUntil.Format(time.RFC3339))
+	}
+
+	limits, err := responsibleGaming.usedLimits(playerID, kinds...)
+	if err != nil {
+		return err
+	}
+	for _, limit := range limits {
+		if limit.Amount > 0 && limit.Used+amount > limit.Amount {
+			return &LimitError{Kind: limit.Kind, Period: limit.Period, Limit: limit.Amount, Used: limit.Used}
+		}
+	}
+	return nil
+}
+
+// usedLimits returns the player's limits of the given kinds, or all of them, with their usage filled in
+func (responsibleGaming *ResponsibleGaming) usedLimits(playerID int, kinds ...string) ([]model.PlayerLimit, error) {
+	limits, err := responsibleGaming.limits.GetLimits(playerID)
+	if err != nil {
+		return nil, err
+	}
+
+	used := []model.PlayerLimit{}
+	for _, limit := range limits {
+		if len(kinds) > 0 && !containsString(kinds, limit.Kind) {
+			continue
+		}
+		if limit.Amount > 0 {
+			limit.Used, err = responsibleGaming.limits.GetUsage(playerID, limit.Kind, time.Now().Add(-limitWindows[limit.Period]))
+			if err != nil {
+				return nil, err
+			}
+		}
+		used = append(used, limit)
+	}
+	return used, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, known := range values {
+		if known == value {
+			return true
+		}
+	}
+	return false
+}
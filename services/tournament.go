@@ -0,0 +1,507 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"main/model"
+	"main/repository"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotEnoughPlayers    = errors.New("a tournament needs at least two players")
+	ErrMatchNotFound       = errors.New("match not found")
+	ErrNotInMatch          = errors.New("not a player of this match")
+	ErrMatchDecided        = errors.New("match is already decided")
+	ErrAlreadyMoved        = errors.New("waiting for the opponent's move")
+	ErrTournamentNotActive = errors.New("tournament is not running")
+)
+
+const (
+	tournamentInterval = 30 * time.Second
+	// tournamentActor is the audit actor of results decided by deadlines
+	tournamentActor = "tournament"
+)
+
+// Tournaments runs the brackets: it pairs the players round by round, plays every match as a challenge
+// without a bet, forfeits matches past their deadline and pays out the pot when the last round is decided.
+type Tournaments struct {
+	mutex       sync.Mutex
+	tournaments *repository.Tournament
+	challenges  *repository.Challenger
+	players     *repository.Player
+	audits      *repository.Audit
+}
+
+func NewTournaments(tournaments *repository.Tournament, challenges *repository.Challenger, players *repository.Player,
+	audits *repository.Audit) *Tournaments {
+	return &Tournaments{
+		tournaments: tournaments,
+		challenges:  challenges,
+		players:     players,
+		audits:      audits,
+	}
+}
+
+// Detail returns the tournament with its current standings and every match
+func (service *Tournaments) Detail(tournamentID int) (*model.TournamentDetail, error) {
+	tournament, err := service.tournaments.GetTournament(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	if tournament == nil {
+		return nil, repository.ErrTournamentNotFound
+	}
+
+	players, err := service.tournaments.GetTournamentPlayers(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := service.tournaments.GetMatches(tournamentID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TournamentDetail{
+		Tournament: *tournament,
+		Standings:  standings(tournament, players, matches),
+		Matches:    matches,
+	}, nil
+}
+
+// Start closes the registration, seeds the players by rating and creates the first round
+func (service *Tournaments) Start(tournamentID int) error {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	tournament, err := service.tournaments.GetTournament(tournamentID)
+	if err != nil {
+		return err
+	}
+	if tournament == nil {
+		return repository.ErrTournamentNotFound
+	}
+	if tournament.State == model.TournamentRegistration && tournament.Players < 2 {
+		return ErrNotEnoughPlayers
+	}
+
+	players, err := service.tournaments.StartTournament(tournamentID)
+	if err != nil {
+		return err
+	}
+
+	var matches []model.TournamentMatch
+	if tournament.Format == model.TournamentSingleElimination {
+		matches = firstBracketRound(players)
+	} else {
+		matches = roundRobinRound(players, 1)
+	}
+
+	return service.tournaments.CreateRound(tournamentID, 1, matches, matchDeadline(tournament))
+}
+
+// Move plays the player's move in a match. The first move opens the match challenge, the second one
+// settles it. A draw is replayed with a new challenge, any other result decides the match.
+func (service *Tournaments) Move(playerID int, tournamentID int, matchID int, choice int) (model.TournamentMoveResponse, error) {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	match, err := service.tournaments.GetMatch(matchID)
+	if err != nil {
+		return model.TournamentMoveResponse{}, err
+	}
+	if match == nil || match.TournamentID != tournamentID {
+		return model.TournamentMoveResponse{}, ErrMatchNotFound
+	}
+	if match.PlayerAID != playerID && match.PlayerBID != playerID {
+		return model.TournamentMoveResponse{}, ErrNotInMatch
+	}
+	if match.State != model.MatchPending {
+		return model.TournamentMoveResponse{}, ErrMatchDecided
+	}
+
+	tournament, err := service.tournaments.GetTournament(match.TournamentID)
+	if err != nil {
+		return model.TournamentMoveResponse{}, err
+	}
+	if tournament.State != model.TournamentRunning {
+		return model.TournamentMoveResponse{}, ErrTournamentNotActive
+	}
+
+	opponentID, opponent := match.PlayerBID, match.PlayerB
+	if match.PlayerBID == playerID {
+		opponentID, opponent = match.PlayerAID, match.PlayerA
+	}
+
+	if match.ChallengeID == nil {
+		challengeID, err := service.challenges.CreateTournamentChallenge(tournament.ID, playerID, opponentID, choice)
+		if err != nil {
+			return model.TournamentMoveResponse{}, err
+		}
+		if err = service.tournaments.SetMatchChallenge(match.ID, challengeID); err != nil {
+			return model.TournamentMoveResponse{}, err
+		}
+		return model.TournamentMoveResponse{
+			ChallengeID: challengeID,
+			State:       model.MatchPending,
+			Message:     fmt.Sprintf("Waiting for %s to move", opponent),
+		}, nil
+	}
+
+	challenge, err := service.challenges.GetChallengeByID(strconv.Itoa(*match.ChallengeID))
+	if err != nil {
+		return model.TournamentMoveResponse{}, err
+	}
+	if challenge.ChallengerID == playerID {
+		return model.TournamentMoveResponse{}, ErrAlreadyMoved
+	}
+
+	winner := model.DetermineWinner(challenge.Choice, choice)
+	winnerID, winnerName, challengerScore := 0, "", 0.5
+	switch winner {
+	case "challenger":
+		winnerID, winnerName, challengerScore = challenge.ChallengerID, challenge.Challenger, 1
+	case "opponent":
+		winnerID, winnerName, challengerScore = playerID, challenge.Opponent, 0
+	}
+
+	err = service.challenges.UpdateChallenge(model.ChallengeSettled, winnerID, choice, challenge.ChallengeId)
+	if err != nil {
+		return model.TournamentMoveResponse{}, err
+	}
+	err = service.players.UpdateRatings(challenge.ChallengerID, playerID, challengerScore)
+	if err != nil {
+		logrus.Errorf("Unable to update ratings for challenge %s: %v", challenge.ChallengeId, err)
+	}
+
+	response := model.TournamentMoveResponse{ChallengeID: *match.ChallengeID}
+	if winner == "draw" {
+		if err = service.tournaments.SetMatchChallenge(match.ID, 0); err != nil {
+			return model.TournamentMoveResponse{}, err
+		}
+		response.State = model.MatchPending
+		response.Message = fmt.Sprintf("Draw both players picked %s, play the match again", model.ChoiceToString(choice))
+		return response, nil
+	}
+
+	if err = service.tournaments.DecideMatch(match.ID, model.MatchPlayed, winnerID); err != nil {
+		return model.TournamentMoveResponse{}, err
+	}
+	if err = service.advance(tournament); err != nil {
+		logrus.Errorf("Unable to advance tournament %d: %v", tournament.ID, err)
+	}
+
+	response.State = model.MatchPlayed
+	response.Winner = winnerName
+	response.Message = fmt.Sprintf("Winner :%s with %s against %s", winnerName,
+		model.ChoiceToString(challenge.Choice), model.ChoiceToString(choice))
+	return response, nil
+}
+
+// Run forfeits matches past their deadline until the process ends, run it in its own goroutine
+func (service *Tournaments) Run() {
+	ticker := time.NewTicker(tournamentInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		service.forfeitOverdueMatches()
+	}
+}
+
+// forfeitOverdueMatches decides the matches nobody finished in time. A player who moved wins against one who
+// did not. When neither moved the better seed goes through in a bracket, in a round robin both lose.
+func (service *Tournaments) forfeitOverdueMatches() {
+	service.mutex.Lock()
+	defer service.mutex.Unlock()
+
+	matches, err := service.tournaments.GetOverdueMatches()
+	if err != nil {
+		return
+	}
+
+	touched := map[int]bool{}
+	for _, match := range matches {
+		tournament, err := service.tournaments.GetTournament(match.TournamentID)
+		if err != nil || tournament == nil {
+			continue
+		}
+
+		winnerID := 0
+		if match.ChallengeID != nil {
+			challenge, err := service.challenges.GetChallengeByID(strconv.Itoa(*match.ChallengeID))
+			if err != nil {
+				continue
+			}
+			winnerID = challenge.ChallengerID
+			err = service.challenges.UpdateChallenge(model.ChallengeExpired, 0, 0, challenge.ChallengeId)
+			if err != nil {
+				logrus.Errorf("Unable to expire tournament challenge %s: %v", challenge.ChallengeId, err)
+			}
+		} else if tournament.Format == model.TournamentSingleElimination {
+			// Players are listed by seed, the first one of the match found is the better seed
+			players, err := service.tournaments.GetTournamentPlayers(tournament.ID)
+			if err != nil {
+				continue
+			}
+			for _, player := range players {
+				if player.PlayerID == match.PlayerAID || player.PlayerID == match.PlayerBID {
+					winnerID = player.PlayerID
+					break
+				}
+			}
+		}
+
+		if err = service.tournaments.DecideMatch(match.ID, model.MatchForfeit, winnerID); err != nil {
+			continue
+		}
+		service.audit(model.AuditTournamentForfeit, strconv.Itoa(tournament.ID), map[string]any{
+			"match":  match.ID,
+			"winner": winnerID,
+		})
+		touched[tournament.ID] = true
+	}
+
+	for tournamentID := range touched {
+		tournament, err := service.tournaments.GetTournament(tournamentID)
+		if err != nil || tournament == nil {
+			continue
+		}
+		if err = service.advance(tournament); err != nil {
+			logrus.Errorf("Unable to advance tournament %d: %v", tournamentID, err)
+		}
+	}
+}
+
+// advance creates the next round once every match of the current one is decided, or finishes the tournament
+func (service *Tournaments) advance(tournament *model.Tournament) error {
+	matches, err := service.tournaments.GetMatches(tournament.ID)
+	if err != nil {
+		return err
+	}
+
+	var current []model.TournamentMatch
+	for _, match := range matches {
+		if match.Round != tournament.Round {
+			continue
+		}
+		if match.State == model.MatchPending {
+			return nil
+		}
+		current = append(current, match)
+	}
+
+	players, err := service.tournaments.GetTournamentPlayers(tournament.ID)
+	if err != nil {
+		return err
+	}
+
+	var next []model.TournamentMatch
+	if tournament.Format == model.TournamentSingleElimination {
+		if len(current) > 1 {
+			next = nextBracketRound(current)
+		}
+	} else if tournament.Round < roundRobinRounds(len(players)) {
+		next = roundRobinRound(players, tournament.Round+1)
+	}
+
+	if len(next) > 0 {
+		return service.tournaments.CreateRound(tournament.ID, tournament.Round+1, next, matchDeadline(tournament))
+	}
+	return service.finish(tournament, players, matches)
+}
+
+// finish ranks the players and pays the pot out by the prize split, the rounding remainder goes to the winner
+func (service *Tournaments) finish(tournament *model.Tournament, players []model.TournamentPlayer, matches []model.TournamentMatch) error {
+	ranked := standings(tournament, players, matches)
+
+	pot := tournament.EntryFee * len(ranked)
+	paid := 0
+	for i := range ranked {
+		ranked[i].Prize = 0
+		if i < len(tournament.PrizeSplit) {
+			ranked[i].Prize = pot * tournament.PrizeSplit[i] / 100
+			paid += ranked[i].Prize
+		}
+	}
+	if len(ranked) > 0 {
+		ranked[0].Prize += pot - paid
+	}
+
+	if err := service.tournaments.FinishTournament(tournament.ID, ranked); err != nil {
+		return err
+	}
+
+	results := make([]map[string]any, 0, len(ranked))
+	for _, player := range ranked {
+		results = append(results, map[string]any{"place": player.Place, "username": player.Username, "prize": player.Prize})
+	}
+	service.audit(model.AuditTournamentEnded, strconv.Itoa(tournament.ID), map[string]any{
+		"pot":       pot,
+		"standings": results,
+	})
+	return nil
+}
+
+// audit records results decided without a request, so the ip is left empty
+func (service *Tournaments) audit(action string, subject string, details map[string]any) {
+	encodedDetails, _ := json.Marshal(details)
+	err := service.audits.Append(&model.AuditEvent{
+		Actor:   tournamentActor,
+		Action:  action,
+		Subject: subject,
+		Details: string(encodedDetails),
+	})
+	if err != nil {
+		logrus.Errorf("Unable to record audit event %s: %v", action, err)
+	}
+}
+
+func matchDeadline(tournament *model.Tournament) time.Time {
+	return time.Now().Add(time.Duration(tournament.MatchDeadlineMinutes) * time.Minute)
+}
+
+// standings counts wins and losses and ranks the players. A bracket is ranked by the round a player
+// got to, a round robin by wins, ties go to the better seed.
+func standings(tournament *model.Tournament, players []model.TournamentPlayer, matches []model.TournamentMatch) []model.TournamentPlayer {
+	ranked := make([]model.TournamentPlayer, len(players))
+	copy(ranked, players)
+	index := map[int]int{}
+	for i, player := range ranked {
+		index[player.PlayerID] = i
+	}
+
+	for _, match := range matches {
+		for _, playerID := range []int{match.PlayerAID, match.PlayerBID} {
+			if i, ok := index[playerID]; ok && match.Round > ranked[i].Reached {
+				ranked[i].Reached = match.Round
+			}
+		}
+		if match.State != model.MatchPlayed && match.State != model.MatchForfeit {
+			continue
+		}
+		for _, playerID := range []int{match.PlayerAID, match.PlayerBID} {
+			i := index[playerID]
+			if playerID == match.WinnerID {
+				ranked[i].Wins++
+			} else {
+				ranked[i].Losses++
+			}
+		}
+		// Winning a match counts as reaching the next round, so the champion ends up above the finalist
+		if i, ok := index[match.WinnerID]; ok && match.Round+1 > ranked[i].Reached {
+			ranked[i].Reached = match.Round + 1
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if tournament.Format == model.TournamentSingleElimination {
+			if ranked[i].Reached != ranked[j].Reached {
+				return ranked[i].Reached > ranked[j].Reached
+			}
+		} else {
+			if ranked[i].Wins != ranked[j].Wins {
+				return ranked[i].Wins > ranked[j].Wins
+			}
+			if ranked[i].Losses != ranked[j].Losses {
+				return ranked[i].Losses < ranked[j].Losses
+			}
+		}
+		return ranked[i].Seed < ranked[j].Seed
+	})
+
+	for i := range ranked {
+		ranked[i].Place = i + 1
+	}
+	return ranked
+}
+
+// firstBracketRound pairs the seeds in the standard bracket order, so the top seeds can only meet in the
+// late rounds. The bracket is filled up to a power of two with byes for the top seeds.
+func firstBracketRound(players []model.TournamentPlayer) []model.TournamentMatch {
+	size := 2
+	for size < len(players) {
+		size *= 2
+	}
+
+	order := []int{1, 2}
+	for len(order) < size {
+		expanded := make([]int, 0, len(order)*2)
+		for _, seed := range order {
+			expanded = append(expanded, seed, len(order)*2+1-seed)
+		}
+		order = expanded
+	}
+
+	var matches []model.TournamentMatch
+	for position := 0; position < size/2; position++ {
+		playerA := players[order[position*2]-1]
+		match := model.TournamentMatch{Position: position + 1, PlayerAID: playerA.PlayerID, State: model.MatchPending}
+		if seed := order[position*2+1]; seed <= len(players) {
+			match.PlayerBID = players[seed-1].PlayerID
+		} else {
+			match.State = model.MatchBye
+			match.WinnerID = playerA.PlayerID
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// nextBracketRound pairs the winners of neighbouring matches
+func nextBracketRound(current []model.TournamentMatch) []model.TournamentMatch {
+	var matches []model.TournamentMatch
+	for i := 0; i+1 < len(current); i += 2 {
+		matches = append(matches, model.TournamentMatch{
+			Position:  i/2 + 1,
+			PlayerAID: current[i].WinnerID,
+			PlayerBID: current[i+1].WinnerID,
+			State:     model.MatchPending,
+		})
+	}
+	return matches
+}
+
+// roundRobinRounds is the number of rounds for everybody to meet everybody once
+func roundRobinRounds(players int) int {
+	if players%2 == 1 {
+		players++
+	}
+	return players - 1
+}
+
+// roundRobinRound pairs the players for the round with the circle method: the first seed stays in place
+// and the others rotate by one every round. With an odd number of players one of them sits out each round.
+func roundRobinRound(players []model.TournamentPlayer, round int) []model.TournamentMatch {
+	ids := make([]int, 0, len(players)+1)
+	for _, player := range players {
+		ids = append(ids, player.PlayerID)
+	}
+	if len(ids)%2 == 1 {
+		ids = append(ids, 0)
+	}
+
+	rest := ids[1:]
+	shift := (round - 1) % len(rest)
+	circle := append([]int{ids[0]}, rest[len(rest)-shift:]...)
+	circle = append(circle, rest[:len(rest)-shift]...)
+
+	var matches []model.TournamentMatch
+	for i := 0; i < len(circle)/2; i++ {
+		playerA, playerB := circle[i], circle[len(circle)-1-i]
+		if playerA == 0 || playerB == 0 {
+			continue
+		}
+		matches = append(matches, model.TournamentMatch{
+			Position:  len(matches) + 1,
+			PlayerAID: playerA,
+			PlayerBID: playerB,
+			State:     model.MatchPending,
+		})
+	}
+	return matches
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"main/config"
+	"main/internal"
+	"main/model"
+	"main/repository"
+	"time"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two factor enrolment was not started")
+	ErrTwoFactorInvalidCode    = errors.New("invalid two factor code")
+)
+
+// TwoFactor handles enrolment and verification of TOTP codes and recovery codes
+type TwoFactor struct {
+	players       *repository.Player
+	recoveryCodes *repository.RecoveryCode
+}
+
+func NewTwoFactor(players *repository.Player, recoveryCodes *repository.RecoveryCode) *TwoFactor {
+	return &TwoFactor{
+		players:       players,
+		recoveryCodes: recoveryCodes,
+	}
+}
+
+// Enroll creates a new secret for the player, it is only active once confirmed with a code
+func (twoFactor *TwoFactor) Enroll(player *model.Player) (*model.TwoFactorEnrollment, error) {
+	if player.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := internal.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = twoFactor.players.SetTOTPSecret(player.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TwoFactorEnrollment{
+		Secret: secret,
+		URI:    internal.TOTPURI(config.Settings.TOTPIssuer, player.Username, secret),
+	}, nil
+}
+
+// Confirm enables two factor authentication with the first code and returns fresh recovery codes
+func (twoFactor *TwoFactor) Confirm(player *model.Player, code string) ([]string, error) {
+	if player.TOTPEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if player.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := internal.MatchTOTP(player.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrTwoFactorInvalidCode
+	}
+
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = twoFactor.recoveryCodes.ReplaceRecoveryCodes(player.ID, hashedCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = twoFactor.players.EnableTOTP(player.ID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Disable turns two factor authentication off, it requires a valid code
+func (twoFactor *TwoFactor) Disable(player *model.Player, code string) error {
+	err := twoFactor.VerifyCode(player, code)
+	if err != nil {
+		return err
+	}
+
+	err = twoFactor.recoveryCodes.DeleteRecoveryCodes(player.ID)
+	if err != nil {
+		return err
+	}
+
+	return twoFactor.players.DisableTOTP(player.ID)
+}
+
+// VerifyCode checks a code from the authenticator app, every code can only be used once
+func (twoFactor *TwoFactor) VerifyCode(player *model.Player, code string) error {
+	step, ok := internal.MatchTOTP(player.TOTPSecret, code, time.Now())
+	if !ok {
+		return ErrTwoFactorInvalidCode
+	}
+
+	fresh, err := twoFactor.players.ConsumeTOTPStep(player.ID, step)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		logrus.Warningf("Replayed two factor code for %s", player.Username)
+		return ErrTwoFactorInvalidCode
+	}
+
+	return nil
+}
+
+// VerifyRecoveryCode checks the code against the unused recovery codes of the player and uses it up
+func (twoFactor *TwoFactor) VerifyRecoveryCode(player *model.Player, code string) error {
+	codes, err := twoFactor.recoveryCodes.GetUnusedRecoveryCodes(player.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, recoveryCode := range codes {
+		hash, err := internal.HashPassword(code, recoveryCode.Salt)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(recoveryCode.Hash)) != 1 {
+			continue
+		}
+
+		used, err := twoFactor.recoveryCodes.UseRecoveryCode(recoveryCode.ID)
+		if err != nil {
+			return err
+		}
+		if used {
+			logrus.Infof("Player %s used a recovery code", player.Username)
+			return nil
+		}
+	}
+
+	return ErrTwoFactorInvalidCode
+}
+
+func generateRecoveryCodes() ([]string, []model.RecoveryCode, error) {
+	codes, err := internal.GenerateRecoveryCodes(config.Settings.RecoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashedCodes := make([]model.RecoveryCode, 0, len(codes))
+	for _, code := range codes {
+		salt, err := internal.GenerateRandomSalt()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hash, err := internal.HashPassword(code, salt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hashedCodes = append(hashedCodes, model.RecoveryCode{Hash: hash, Salt: salt})
+	}
+
+	return codes, hashedCodes, nil
+}